@@ -37,46 +37,54 @@ func pidMapKeys(m map[PID]struct{}) []PID {
 }
 
 type antagonistBuilder struct {
-	pid            PID
+	// The PIDs of the victim threads.
+	pids           map[PID]struct{}
 	startTimestamp trace.Timestamp
 	endTimestamp   trace.Timestamp
 	stringTable    *stringTable
 	victims        map[string]*Thread
 	antagonisms    []*Antagonism
+	// If true, each antagonism records the victim it antagonized.
+	recordVictims bool
 }
 
-func newAntagonistBuilder(pid PID, startTimestamp, endTimestamp trace.Timestamp, sTbl *stringTable) *antagonistBuilder {
-	return &antagonistBuilder{
-		pid:            pid,
+func newAntagonistBuilder(pids []PID, startTimestamp, endTimestamp trace.Timestamp, sTbl *stringTable) *antagonistBuilder {
+	ab := &antagonistBuilder{
+		pids:           map[PID]struct{}{},
 		startTimestamp: startTimestamp,
 		endTimestamp:   endTimestamp,
 		stringTable:    sTbl,
 		victims:        make(map[string]*Thread),
 		antagonisms:    []*Antagonism{},
 	}
+	for _, pid := range pids {
+		ab.pids[pid] = struct{}{}
+	}
+	return ab
 }
 
 // addVictim adds a victim thread to the builder's victims list.
 func (ab *antagonistBuilder) addVictim(span *threadSpan) error {
-	if span.pid != ab.pid {
-		return fmt.Errorf("'victim' span has wrong pid. want: %d, got: %d", ab.pid, span.pid)
+	if _, ok := ab.pids[span.pid]; !ok {
+		return fmt.Errorf("'victim' span has wrong pid. want one of: %v, got: %d", pidMapKeys(ab.pids), span.pid)
 	}
 	cmd, err := ab.stringTable.stringByID(span.command)
 	if err != nil {
 		return fmt.Errorf("could not get victim command with string ID %d", span.command)
 	}
-	ab.victims[fmt.Sprintf("%d:%s", span.pid, cmd)] = &Thread{
-		Priority: span.priority,
-		Command:  cmd,
-		PID:      span.pid,
+	ab.victims[fmt.Sprintf("%d:%d:%s", span.pid, span.generation, cmd)] = &Thread{
+		Priority:   span.priority,
+		Command:    cmd,
+		PID:        span.pid,
+		Generation: span.generation,
 	}
 	return nil
 }
 
 // RecordAntagonism saves a new antagonist into the builder state.
 func (ab *antagonistBuilder) RecordAntagonism(waiting, antagonist *threadSpan) error {
-	if antagonist.pid == ab.pid {
-		return fmt.Errorf("PID %d is improperly antagonizing itself", ab.pid)
+	if _, ok := ab.pids[antagonist.pid]; ok {
+		return fmt.Errorf("PID %d is improperly antagonizing itself", antagonist.pid)
 	}
 	cmd, err := ab.stringTable.stringByID(antagonist.command)
 	if err != nil {
@@ -103,16 +111,30 @@ func (ab *antagonistBuilder) RecordAntagonism(waiting, antagonist *threadSpan) e
 		return nil
 	}
 
-	ab.antagonisms = append(ab.antagonisms, &Antagonism{
+	antagonism := &Antagonism{
 		RunningThread: &Thread{
-			PID:      antagonist.pid,
-			Command:  cmd,
-			Priority: antagonist.priority,
+			PID:        antagonist.pid,
+			Command:    cmd,
+			Priority:   antagonist.priority,
+			Generation: antagonist.generation,
 		},
 		CPU:            antagonist.cpu,
 		StartTimestamp: startTimestamp,
 		EndTimestamp:   endTimestamp,
-	})
+	}
+	if ab.recordVictims {
+		victimCmd, err := ab.stringTable.stringByID(waiting.command)
+		if err != nil {
+			return fmt.Errorf("could not find victim command: %s", err)
+		}
+		antagonism.Victim = &Thread{
+			PID:        waiting.pid,
+			Command:    victimCmd,
+			Priority:   waiting.priority,
+			Generation: waiting.generation,
+		}
+	}
+	ab.antagonisms = append(ab.antagonisms, antagonism)
 	return nil
 }
 
@@ -122,6 +144,16 @@ func (ab *antagonistBuilder) Antagonists() Antagonists {
 	for _, v := range ab.victims {
 		victims = append(victims, v)
 	}
+	// Sort victims for deterministic ordering.
+	sort.Slice(victims, func(i, j int) bool {
+		if victims[i].PID != victims[j].PID {
+			return victims[i].PID < victims[j].PID
+		}
+		if victims[i].Generation != victims[j].Generation {
+			return victims[i].Generation < victims[j].Generation
+		}
+		return victims[i].Command < victims[j].Command
+	})
 	return Antagonists{
 		Victims:        victims,
 		Antagonisms:    ab.antagonisms,
@@ -139,6 +171,8 @@ func (ab *antagonistBuilder) Antagonists() Antagonists {
 // FILTERS:
 //   PIDs: Antagonists expects a single PID to be filtered in; this PID is the
 //       victim thread.  All threads are considered as antagonists.
+//   Generations: If specified, only the filtered-in generations of the victim
+//       PID are considered as victims.
 //   TimeRange, StartTimestamp, EndTimestamp: Antagonists are evaluated over
 //       the filtered-in time range.
 func (c *Collection) Antagonists(filters ...Filter) (Antagonists, error) {
@@ -155,8 +189,56 @@ func (c *Collection) Antagonists(filters ...Filter) (Antagonists, error) {
 		return Antagonists{}, errors.New("antagonist analysis not available for PID 0")
 	}
 
-	ab := newAntagonistBuilder(pid, f.startTimestamp, f.endTimestamp, c.stringTable)
+	ab := newAntagonistBuilder([]PID{pid}, f.startTimestamp, f.endTimestamp, c.stringTable)
+	if err := c.recordAntagonisms(ab, f, pid); err != nil {
+		return Antagonists{}, err
+	}
+	return ab.Antagonists(), nil
+}
+
+// ProcessAntagonists analyzes the threads of a single provided victim
+// process, or thread group, over a provided interval, returning a list of
+// antagonisms -- intervals where threads of other processes ran on a victim
+// thread's core while that victim thread was waiting.  Each antagonism
+// records the victim thread it antagonized.  The victim process's threads
+// are not considered as antagonists of one another.
+// FILTERS:
+//   Processes: ProcessAntagonists expects a single TGID to be filtered in;
+//       this process is the victim.  All threads of other processes are
+//       considered as antagonists.
+//   PIDs: If specified, only the filtered-in threads of the victim process
+//       are considered as victims.
+//   Generations: If specified, only the filtered-in generations of the victim
+//       threads are considered as victims.
+//   TimeRange, StartTimestamp, EndTimestamp: Antagonists are evaluated over
+//       the filtered-in time range.
+func (c *Collection) ProcessAntagonists(filters ...Filter) (Antagonists, error) {
+	f := buildFilter(c, filters)
+	tgids := pidMapKeys(f.processes)
+	if len(tgids) != 1 {
+		return Antagonists{}, errors.New("can only collect antagonists of a single process")
+	}
+	if tgids[0] == 0 {
+		return Antagonists{}, errors.New("antagonist analysis not available for PID 0")
+	}
+	pids := pidMapKeys(f.pids)
+	sort.Slice(pids, func(i, j int) bool {
+		return pids[i] < pids[j]
+	})
+	ab := newAntagonistBuilder(pids, f.startTimestamp, f.endTimestamp, c.stringTable)
+	ab.recordVictims = true
+	for _, pid := range pids {
+		if err := c.recordAntagonisms(ab, f, pid); err != nil {
+			return Antagonists{}, err
+		}
+	}
+	return ab.Antagonists(), nil
+}
 
+// recordAntagonisms records the provided PID as a victim in the provided
+// antagonistBuilder, along with its antagonisms in the filtered-in range.
+// Threads among the builder's victims are not recorded as antagonists.
+func (c *Collection) recordAntagonisms(ab *antagonistBuilder, f *filter, pid PID) error {
 	pidSpans := c.spansByPID[pid]
 	pidStart := sort.Search(len(pidSpans), func(i int) bool {
 		return pidSpans[i].endTimestamp >= f.startTimestamp
@@ -166,9 +248,12 @@ func (c *Collection) Antagonists(filters ...Filter) (Antagonists, error) {
 		if pidSpan.startTimestamp > f.endTimestamp {
 			break
 		}
+		if !f.generationFilteredIn(pid, pidSpan.generation) {
+			continue
+		}
 		// Victim Thread is recorded even if the thread is never victimized (i.e. had to wait)
 		if err := ab.addVictim(pidSpan); err != nil {
-			return Antagonists{}, err
+			return err
 		}
 		// If this span is waiting, get a list of all running spans on the same cpu.
 		if pidSpan.state == WaitingState {
@@ -182,21 +267,20 @@ func (c *Collection) Antagonists(filters ...Filter) (Antagonists, error) {
 					break
 				}
 				if antagonist.state != RunningState {
-					return Antagonists{}, fmt.Errorf("antagonist %v was not running", antagonist)
+					return fmt.Errorf("antagonist %v was not running", antagonist)
 				}
-				if antagonist.pid == pid {
-					// a thread can not antagonize itself
+				if _, ok := ab.pids[antagonist.pid]; ok {
+					// a thread can not antagonize itself, or its fellow victims
 					continue
 				}
 
 				if err := ab.RecordAntagonism(pidSpan, antagonist); err != nil {
-					return Antagonists{}, err
+					return err
 				}
 			}
 		}
 	}
-
-	return ab.Antagonists(), nil
+	return nil
 }
 
 // Utilization groups together several metrics describing the utilization or over-utilization of
@@ -340,6 +424,14 @@ type ThreadStatistics struct {
 	// Total time spent by the requested threads sleeping on the requested CPUs
 	// over the requested interval.
 	SleepTime Duration
+	// The portion of WaitTime that the requested threads spent waiting after
+	// being preempted, and the portions of SleepTime they spent sleeping in each
+	// task state.
+	PreemptedWaitTime        Duration
+	InterruptibleSleepTime   Duration
+	UninterruptibleSleepTime Duration
+	StoppedTime              Duration
+	IdleTime                 Duration
 	// Total number of wakeups of the requested threads on the requested CPUs
 	// over the requested interval.  Only wakeups contained *within* the
 	// requested interval are counted; those lying on the boundary are not.
@@ -356,10 +448,39 @@ func (a *ThreadStatistics) add(b *ThreadStatistics) {
 	a.PostWakeupWaitTime += b.PostWakeupWaitTime
 	a.RunTime += b.RunTime
 	a.SleepTime += b.SleepTime
+	a.PreemptedWaitTime += b.PreemptedWaitTime
+	a.InterruptibleSleepTime += b.InterruptibleSleepTime
+	a.UninterruptibleSleepTime += b.UninterruptibleSleepTime
+	a.StoppedTime += b.StoppedTime
+	a.IdleTime += b.IdleTime
 	a.Wakeups += b.Wakeups
 	a.Migrations += b.Migrations
 }
 
+// ProcessStats returns ThreadStatistics aggregated across the filtered-in
+// trace separately for each process, or thread group, keyed by TGID.  Each
+// process's threads are found as by ThreadGroups; of a reused PID, only the
+// generations that belonged to the process are aggregated with it.
+// FILTERS:
+//   ProcessStats honors the same filters as ThreadStats, in the same ways,
+//   calling ThreadStats for the filtered-in threads of each process.
+func (c *Collection) ProcessStats(filters ...Filter) (map[PID]*ThreadStatistics, error) {
+	f := buildFilter(c, filters)
+	threadGroups, err := c.ThreadGroups(duplicateFilter(f))
+	if err != nil {
+		return nil, err
+	}
+	ret := map[PID]*ThreadStatistics{}
+	for tgid, pids := range threadGroups {
+		stats, err := c.ThreadStats(duplicateFilter(f), PIDs(pids...), Processes(tgid))
+		if err != nil {
+			return nil, err
+		}
+		ret[tgid] = stats
+	}
+	return ret, nil
+}
+
 // ThreadStats returns ThreadStatistics aggregated across the filtered-in
 // trace.
 // FILTERS:
@@ -368,12 +489,14 @@ func (a *ThreadStatistics) add(b *ThreadStatistics) {
 //   CPUs: Intervals are restricted to only the specified CPUs.
 //   TimeRange, StartTimestamp, EndTimestamp: ThreadIntervals are produced for
 //       the filtered-in time range.
-//   ThreadState: Intervals are restricted to only the specified thread states.
+//   ThreadState: Intervals are restricted to only the specified thread states,
+//       and task states.
 //   TruncateToTimeRange: If true, returned intervals will be clipped to the
 //       filtered time range.
 //   However, it overrides or specially handles some filters:
-//   PIDs: ThreadStats calls ThreadIntervals for each individual thread in the
-//       filtered-in PID set.
+//   PIDs, Processes: ThreadStats calls ThreadIntervals for each individual
+//       thread in the filtered-in PID set, and finds migrations and wakeups
+//       within each generation of it.
 //   MinimumIntervalDuration: ThreadStats overrides the minimum interval
 //       duration to 0.
 //   TruncateToTimeRange: ThreadStat truncates to the filtered-in time range.
@@ -394,6 +517,7 @@ func (c *Collection) ThreadStats(filters ...Filter) (*ThreadStatistics, error) {
 				isPostWakeup := false
 				lastCPU := UnknownCPU
 				lastState := AnyState
+				lastGeneration := 0
 				for _, ival := range ivals {
 					// Since the minimum interval duration is 0, there should be only one
 					// thread residency.
@@ -401,6 +525,13 @@ func (c *Collection) ThreadStats(filters ...Filter) (*ThreadStatistics, error) {
 						return status.Errorf(codes.Internal, "expected 1 thread residency for PID %d at time %s; got %d", pid, ival.StartTimestamp, (ival.ThreadResidencies))
 					}
 					res := ival.ThreadResidencies[0]
+					// Migrations and wakeups are found within each generation of the PID.
+					if res.Thread.Generation != lastGeneration {
+						isPostWakeup = false
+						lastCPU = UnknownCPU
+						lastState = AnyState
+						lastGeneration = res.Thread.Generation
+					}
 					if isPostWakeup && res.State != WaitingState {
 						isPostWakeup = false
 					}
@@ -421,6 +552,18 @@ func (c *Collection) ThreadStats(filters ...Filter) (*ThreadStatistics, error) {
 					case WaitingState:
 						stats.WaitTime += res.Duration
 					}
+					switch res.TaskState {
+					case TaskPreempted:
+						stats.PreemptedWaitTime += res.Duration
+					case TaskInterruptible:
+						stats.InterruptibleSleepTime += res.Duration
+					case TaskUninterruptible:
+						stats.UninterruptibleSleepTime += res.Duration
+					case TaskStopped:
+						stats.StoppedTime += res.Duration
+					case TaskIdle:
+						stats.IdleTime += res.Duration
+					}
 					if isPostWakeup {
 						stats.PostWakeupWaitTime += res.Duration
 					}
@@ -26,6 +26,29 @@ import (
 	"github.com/google/schedviz/tracedata/trace"
 )
 
+func TestThreadStats_TaskStates(t *testing.T) {
+	coll := newTaskStateCollection(t)
+	got, err := coll.ThreadStats(PIDs(10, 20, 30))
+	if err != nil {
+		t.Fatalf("ThreadStats yielded unexpected error %s", err)
+	}
+	want := &ThreadStatistics{
+		WaitTime:                 20, // PID 10 after its wakeup, and PID 20 while preempted
+		PostWakeupWaitTime:       20, // All waits
+		RunTime:                  60,
+		SleepTime:                100,
+		PreemptedWaitTime:        10, // PID 20
+		InterruptibleSleepTime:   10, // PID 10 at the end
+		UninterruptibleSleepTime: 30, // PID 10 on IO
+		StoppedTime:              40, // PID 30
+		IdleTime:                 20, // PID 20
+		Wakeups:                  2,  // PID 10 at 1040, PID 20 at 1020
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ThreadStats(): Diff -want +got:\n%s", diff)
+	}
+}
+
 func TestAntagonists(t *testing.T) {
 	c, err := NewCollection(
 		testeventsetbuilder.TestProtobuf(t,
@@ -387,12 +410,13 @@ func TestThreadStats(t *testing.T) {
 		startTimestamp: trace.UnknownTimestamp,
 		endTimestamp:   trace.UnknownTimestamp,
 		wantThreadStats: &ThreadStatistics{
-			WaitTime:           80,  // 10 (PID 100) + 60 (PID 200) + 10 (PID 300)
-			PostWakeupWaitTime: 80,  // All waits
-			RunTime:            200, // All the time on CPUs 1 and 2
-			SleepTime:          120, // 40 (PID 200) + 80 (PID 300)
-			Wakeups:            5,   // PID 100 at start and end, PID 200 at 1040, PID 300 at 1090, PID 400 at end
-			Migrations:         1,   // PID 200
+			WaitTime:               80,  // 10 (PID 100) + 60 (PID 200) + 10 (PID 300)
+			PostWakeupWaitTime:     80,  // All waits
+			RunTime:                200, // All the time on CPUs 1 and 2
+			SleepTime:              120, // 40 (PID 200) + 80 (PID 300)
+			InterruptibleSleepTime: 120, // All sleeps
+			Wakeups:                5,   // PID 100 at start and end, PID 200 at 1040, PID 300 at 1090, PID 400 at end
+			Migrations:             1,   // PID 200
 		},
 	}, {
 		description:    "CPU 2",
@@ -413,24 +437,26 @@ func TestThreadStats(t *testing.T) {
 		startTimestamp: trace.UnknownTimestamp,
 		endTimestamp:   trace.UnknownTimestamp,
 		wantThreadStats: &ThreadStatistics{
-			WaitTime:           60,
-			PostWakeupWaitTime: 60,
-			RunTime:            0,
-			SleepTime:          40,
-			Wakeups:            1, // At 1040
-			Migrations:         1,
+			WaitTime:               60,
+			PostWakeupWaitTime:     60,
+			RunTime:                0,
+			SleepTime:              40,
+			InterruptibleSleepTime: 40,
+			Wakeups:                1, // At 1040
+			Migrations:             1,
 		},
 	}, {
 		description:    "Time filtered",
 		startTimestamp: 1045,
 		endTimestamp:   1090,
 		wantThreadStats: &ThreadStatistics{
-			WaitTime:           45, // PID 200
-			PostWakeupWaitTime: 45, // All waits
-			RunTime:            90, // all the time on CPUs 1 and 2
-			SleepTime:          45, // PID 300
-			Wakeups:            2,  // PID 200 at its initial point, PID 300 at 1090
-			Migrations:         1,  // PID 200
+			WaitTime:               45, // PID 200
+			PostWakeupWaitTime:     45, // All waits
+			RunTime:                90, // all the time on CPUs 1 and 2
+			SleepTime:              45, // PID 300
+			InterruptibleSleepTime: 45,
+			Wakeups:                2, // PID 200 at its initial point, PID 300 at 1090
+			Migrations:             1, // PID 200
 		},
 	}}
 	for _, test := range tests {
@@ -22,9 +22,13 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	"github.com/Workiva/go-datastructures/augmentedtree"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	elpb "github.com/google/schedviz/analysis/event_loaders_go_proto"
+	"github.com/google/schedviz/tracedata/clipping"
+	"github.com/google/schedviz/tracedata/multihost"
 	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
 	"github.com/google/schedviz/tracedata/trace"
 )
@@ -66,22 +70,86 @@ type Collection struct {
 	droppedEventCountsByID map[int]int
 	// A count of the number of synthetic transitions inserted in the collection.
 	syntheticTransitionCount int
+	// Windows during which events were lost, with unnormalized timestamps.
+	lostEvents []*LostEvents
+	// The lifetimes of each PID, in temporal order, with unnormalized
+	// timestamps.
+	lifetimesByPID map[PID][]*ThreadLifetime
+	// The TGID of each PID generation whose thread group is known.
+	tgidByGeneration map[pidGeneration]PID
+	// Threads whose forks reported their TGIDs, until their generations are
+	// known.
+	forkedThreads []forkedThread
 }
 
 // NewCollection builds and returns a new sched.Collection based on the ktrace
 // event set in es, or nil and an error if one could not be created.  If the
 // normalizeTimestamps argument is true, all valid, unclipped, sched event
 // timestamps will be normalized to the first valid, unclipped, sched event's.
+// If es was recorded with a trace clock that doesn't produce ns, the
+// collection is built from a copy of es whose timestamps are converted to ns;
+// es itself is not modified.  If they can't be converted, an error is
+// returned.
 func NewCollection(es *eventpb.EventSet, options ...Option) (*Collection, error) {
+	c, err := newCollection(es.GetDefaultLoadersType(), options...)
+	if err != nil {
+		return nil, err
+	}
+	// All analysis is in ns, so convert timestamps from other trace clocks.
+	if trace.ClockUnits(es.GetTraceClock()) != eventpb.TraceClock_NANOSECONDS {
+		es = proto.Clone(es).(*eventpb.EventSet)
+		if err := trace.ConvertTimestampsToNs(es); err != nil {
+			return nil, err
+		}
+	}
+	coll, err := trace.NewCollection(es, trace.IndexEventCPUs(c.eventIndexCPUs, cpuPropertyEventNames...))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.build(coll); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewCollectionFromSource builds and returns a new sched.Collection based on
+// the events read from src, such as a columnar collection file.  Events are
+// read as they are needed, rather than held in memory.  src's timestamps must
+// already be in ns.
+func NewCollectionFromSource(src trace.EventSource, options ...Option) (*Collection, error) {
+	header := src.Header()
+	if units := trace.ClockUnits(header.GetTraceClock()); units != eventpb.TraceClock_NANOSECONDS {
+		return nil, status.Errorf(codes.FailedPrecondition, "event source's timestamps must be in ns, but trace clock %q has units %s", header.GetTraceClock().GetName(), units)
+	}
+	c, err := newCollection(header.GetDefaultLoadersType(), options...)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := trace.NewCollectionFromSource(src, trace.IndexEventCPUs(c.eventIndexCPUs, cpuPropertyEventNames...))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.build(coll); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newCollection returns a new, empty, sched.Collection with the provided
+// options.  If no event loaders are specified, those of the provided type are
+// used.
+func newCollection(elt elpb.LoadersType, options ...Option) (*Collection, error) {
 	c := &Collection{
 		normalizationOffset:    Unknown,
 		runningSpansByCPU:      make(map[CPUID][]*threadSpan),
 		sleepingSpansByCPU:     make(map[CPUID]augmentedtree.Tree),
 		waitingSpansByCPU:      make(map[CPUID]augmentedtree.Tree),
-		options:                &collectionOptions{},
+		options:                &collectionOptions{pidReuseAbsence: DefaultPIDReuseAbsence},
 		cpus:                   map[CPUID]struct{}{},
 		pids:                   map[PID]struct{}{},
 		droppedEventCountsByID: map[int]int{},
+		lifetimesByPID:         map[PID][]*ThreadLifetime{},
+		tgidByGeneration:       map[pidGeneration]PID{},
 	}
 	for _, option := range options {
 		if err := option(c.options); err != nil {
@@ -90,7 +158,6 @@ func NewCollection(es *eventpb.EventSet, options ...Option) (*Collection, error)
 	}
 	// If no EventLoaders was specified, use the event set's default.
 	if c.options.loaders == nil {
-		elt := es.GetDefaultLoadersType()
 		log.Infof("Using default event loader type %s", elt)
 		el, err := EventLoader(elt)
 		if err != nil {
@@ -98,40 +165,60 @@ func NewCollection(es *eventpb.EventSet, options ...Option) (*Collection, error)
 		}
 		c.options.loaders = el
 	}
-	if err := c.buildSpansByPID(es, c.options.loaders); err != nil {
-		return nil, err
-	}
-	if err := c.buildSpansByCPU(); err != nil {
-		return nil, err
+	if c.options.wakeupEvent == SchedWakingEvent {
+		c.options.loaders = c.options.loaders.markingWakeupsWithWaking()
 	}
 	return c, nil
 }
 
-// buildSpansByPID loads the events in the provided EventSet as
+// build loads the events of the provided trace collection into the receiver.
+func (c *Collection) build(coll *trace.Collection) error {
+	if err := c.buildSpansByPID(coll, c.options.loaders); err != nil {
+		return err
+	}
+	return c.buildSpansByCPU()
+}
+
+// buildSpansByPID loads the events in the provided trace collection as
 // threadTransitions,, infers any CPU or state information they are missing,
 // and convolutes them into threadSpans.
-func (c *Collection) buildSpansByPID(es *eventpb.EventSet, eventLoaders map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error) error {
+func (c *Collection) buildSpansByPID(coll *trace.Collection, eventLoaders map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error) error {
 	stringBank := newStringBank()
 	c.stringTable = stringBank.stringTable
 	eventLoader, err := newEventLoader(eventLoaders, stringBank)
 	if err != nil {
 		return fmt.Errorf("failed to use eventLoaders: %s", err)
 	}
-	coll, err := trace.NewCollection(es)
-	if err != nil {
-		return err
-	}
+	eventLoader.namespaceByHost = c.options.namespaceByHost
 	c.TraceCollection = coll
 	var ts *threadSpanSet
+	// The timestamp of the last event seen on each CPU, used to determine the
+	// windows in which events were lost.
+	lastTimestampByCPU := map[CPUID]trace.Timestamp{}
 	for eventIndex := 0; eventIndex < coll.EventCount(); eventIndex++ {
 		ev, err := coll.EventByIndex(eventIndex)
 		if err != nil {
 			return err
 		}
+		if ev.Name == clipping.LostEventsEventName {
+			le := &LostEvents{
+				CPU:            CPUID(ev.CPU),
+				StartTimestamp: UnknownTimestamp,
+				EndTimestamp:   ev.Timestamp,
+				Count:          ev.NumberProperties[clipping.LostEventsCountProperty],
+			}
+			if lastTimestamp, ok := lastTimestampByCPU[le.CPU]; ok {
+				le.StartTimestamp = lastTimestamp
+			}
+			c.lostEvents = append(c.lostEvents, le)
+			continue
+		}
+		lastTimestampByCPU[CPUID(ev.CPU)] = ev.Timestamp
 		// Bypass clipped events.
 		if ev.Clipped {
 			continue
 		}
+		c.recordLifecycleEvent(ev)
 		// Translate the event into ThreadTransitions.
 		tts, err := eventLoader.threadTransitions(ev)
 		if err != nil {
@@ -167,6 +254,7 @@ func (c *Collection) buildSpansByPID(es *eventpb.EventSet, eventLoaders map[stri
 	if ts == nil {
 		return status.Errorf(codes.InvalidArgument, "no usable events in collection")
 	}
+	c.recordThreadGroups(coll.Header(), ts)
 	c.spansByPID, err = ts.threadSpans(c.endTimestamp)
 	c.droppedEventCountsByID = ts.droppedEventCountsByID
 	c.syntheticTransitionCount = ts.syntheticTransitionCount
@@ -218,7 +306,7 @@ func cpuLookupFunc(ev *trace.Event) ([]CPUID, error) {
 			return nil, status.Errorf(codes.Internal, "sched_migrate_task lacks orig_cpu field")
 		}
 		return []CPUID{CPUID(ev.CPU), CPUID(prevCPU)}, nil
-	case "sched_wakeup", "sched_wakeup_new":
+	case "sched_wakeup", "sched_wakeup_new", "sched_waking":
 		targetCPU, ok := ev.NumberProperties["target_cpu"]
 		if !ok {
 			return nil, status.Errorf(codes.Internal, "%s lacks target_cpu field", ev.Name)
@@ -228,17 +316,93 @@ func cpuLookupFunc(ev *trace.Event) ([]CPUID, error) {
 	return []CPUID{CPUID(ev.CPU)}, nil
 }
 
-// GetRawEvents returns the raw events in this collection
+// hostCPULookupFunc is as cpuLookupFunc, but for collections namespaced by
+// host: CPUs taken from event properties are namespaced by the host of the
+// event's CPU.
+func hostCPULookupFunc(ev *trace.Event) ([]CPUID, error) {
+	cpus, err := cpuLookupFunc(ev)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := multihost.SplitCPU(ev.CPU)
+	for i, cpu := range cpus {
+		if int64(cpu) < multihost.HostCPUStride {
+			cpus[i] = CPUID(multihost.HostCPU(host, int64(cpu)))
+		}
+	}
+	return cpus, nil
+}
+
+// cpuPropertyEventNames lists the events which cpuLookupFunc associates with
+// CPUs taken from their properties, rather than with their reporting CPU.
+var cpuPropertyEventNames = []string{"sched_migrate_task", "sched_wakeup", "sched_wakeup_new", "sched_waking"}
+
+// eventIndexCPUs returns the CPUs with which the provided event is associated
+// in the trace collection's event index: those returned by cpuLookupFunc, or
+// by hostCPULookupFunc for collections namespaced by host.
+func (c *Collection) eventIndexCPUs(ev *trace.Event) ([]int64, error) {
+	lookup := cpuLookupFunc
+	if c.options.namespaceByHost {
+		lookup = hostCPULookupFunc
+	}
+	cpus, err := lookup(ev)
+	if err != nil {
+		return nil, err
+	}
+	var ret []int64
+	for _, cpu := range cpus {
+		ret = append(ret, int64(cpu))
+	}
+	return ret, nil
+}
+
+// GetRawEvents returns the raw events in this collection, in increasing
+// temporal order.  Clipped events are not returned.
 // Timestamps are normalized if timestamp normalization is enabled on the collection.
+// FILTERS:
+//   TimeRange, StartTimestamp, EndTimestamp: Only events within the filtered-in
+//       range are returned.  Defaults to the collection's interval.
+//   CPUs: Only events associated with the filtered-in CPUs are returned.
+//       sched_migrate_task events are associated with their reporting and
+//       original CPUs, and sched_wakeup, sched_wakeup_new, and sched_waking
+//       events with their target CPU; other events are associated with their
+//       reporting CPU.
+//   EventTypes: Only events of the filtered-in types are returned.
 func (c *Collection) GetRawEvents(filters ...Filter) ([]*trace.Event, error) {
-	perCPUColl, err := NewPerCPUCollection(c, cpuLookupFunc)
+	f := &filter{
+		startTimestamp: UnknownTimestamp,
+		endTimestamp:   UnknownTimestamp,
+		eventTypes:     map[string]struct{}{},
+		cpus:           map[CPUID]struct{}{},
+	}
+	for _, filterFunc := range filters {
+		filterFunc(f)
+	}
+	if f.startTimestamp == UnknownTimestamp {
+		f.startTimestamp = c.startTimestamp
+	}
+	if f.endTimestamp == UnknownTimestamp {
+		f.endTimestamp = c.endTimestamp
+	}
+	var cpus []int64
+	for cpu := range f.cpus {
+		cpus = append(cpus, int64(cpu))
+	}
+	var eventTypes []string
+	for eventType := range f.eventTypes {
+		eventTypes = append(eventTypes, eventType)
+	}
+	// The trace collection's timestamps are not normalized.
+	eventIndices, err := c.TraceCollection.EventIndices(
+		f.startTimestamp+c.normalizationOffset, f.endTimestamp+c.normalizationOffset,
+		cpus, eventTypes)
 	if err != nil {
 		return nil, err
 	}
 
 	var events = []*trace.Event{}
 
-	for _, eventIndex := range perCPUColl.EventIndices(filters...) {
+	for _, eventIndex := range eventIndices {
 		ev, err := c.TraceCollection.EventByIndex(eventIndex)
 		if err != nil {
 			return nil, err
@@ -251,6 +415,38 @@ func (c *Collection) GetRawEvents(filters ...Filter) ([]*trace.Event, error) {
 	return events, nil
 }
 
+// LostEvents returns, in increasing temporal order, the windows during which
+// the kernel reported that events were lost.  Events on all CPUs within these
+// windows were clipped, so analysis results overlapping them may be
+// incomplete.  Windows that start at the beginning of the trace have an
+// UnknownTimestamp StartTimestamp.
+// Timestamps are normalized if timestamp normalization is enabled on the
+// collection.
+// FILTERS:
+//   CPUs: Only windows on the filtered-in CPUs are returned.
+//   TimeRange, StartTimestamp, EndTimestamp: Only windows overlapping the
+//       filtered-in range are returned.
+func (c *Collection) LostEvents(filters ...Filter) []*LostEvents {
+	f := buildFilter(c, filters)
+	ret := []*LostEvents{}
+	for _, le := range c.lostEvents {
+		if _, ok := f.cpus[le.CPU]; !ok {
+			continue
+		}
+		normalized := *le
+		normalized.EndTimestamp -= c.normalizationOffset
+		if normalized.StartTimestamp != UnknownTimestamp {
+			normalized.StartTimestamp -= c.normalizationOffset
+		}
+		if normalized.EndTimestamp < f.startTimestamp ||
+			(normalized.StartTimestamp != UnknownTimestamp && normalized.StartTimestamp > f.endTimestamp) {
+			continue
+		}
+		ret = append(ret, &normalized)
+	}
+	return ret
+}
+
 // Interval returns the first and last timestamps of the events present in this
 // Collection.  Only valid if tc.Valid() is true.
 // FILTERS:
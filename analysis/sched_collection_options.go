@@ -17,6 +17,8 @@
 package sched
 
 import (
+	"fmt"
+
 	log "github.com/golang/glog"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -36,6 +38,16 @@ type collectionOptions struct {
 	precisePriorities bool
 	// The event loaders to use with this collection.
 	loaders EventLoaders
+	// If true, the collection combines several hosts' traces, as produced by
+	// multihost.Combine.
+	namespaceByHost bool
+	// The minimum duration for which a PID must be unobserved before a change in
+	// its command name is taken to mean that the PID was reused by a new thread.
+	// If 0, PID reuse is only detected from forks.
+	pidReuseAbsence Duration
+	// The event that marks a thread's wakeup, its transition from Sleeping to
+	// Waiting state.
+	wakeupEvent WakeupEvent
 }
 
 // Option specifies an option that may be specified for a Collection at its
@@ -106,3 +118,74 @@ func UsingEventLoaders(el EventLoaders) Option {
 		return nil
 	}
 }
+
+// DefaultPIDReuseAbsence is the PIDReuseAbsence used if none is specified.
+const DefaultPIDReuseAbsence Duration = 1000000000
+
+// PIDReuseAbsence specifies how long a PID must go unobserved before a change
+// in its command name is taken to mean that the PID was reused by a new
+// thread, whose fork wasn't traced.  PIDs reused by traced forks are always
+// detected.  Called with 0, command name changes are not used to detect PID
+// reuse.
+// If unspecified, DefaultPIDReuseAbsence is used.
+func PIDReuseAbsence(absence Duration) Option {
+	return func(o *collectionOptions) error {
+		if absence < 0 {
+			return status.Errorf(codes.InvalidArgument, "negative argument to PIDReuseAbsence: %d", absence)
+		}
+		o.pidReuseAbsence = absence
+		return nil
+	}
+}
+
+// NamespaceByHost specifies whether the collection combines the traces of
+// several hosts, as produced by multihost.Combine.  Called with true, the PIDs
+// and CPUs that events refer to are namespaced by the host of the event's CPU,
+// so that threads on different hosts are distinct.
+// If unspecified, PIDs and CPUs are not namespaced.
+func NamespaceByHost(b bool) Option {
+	return func(o *collectionOptions) error {
+		o.namespaceByHost = b
+		return nil
+	}
+}
+
+// WakeupEvent identifies an event that may mark a thread's wakeup.
+type WakeupEvent int
+
+const (
+	// SchedWakeupEvent is sched_wakeup, reported once a woken thread is enqueued
+	// on its CPU's runqueue.
+	SchedWakeupEvent WakeupEvent = iota
+	// SchedWakingEvent is sched_waking, reported when a thread's wakeup is
+	// requested, before its CPU is selected.  Recent kernels report both, and
+	// Perfetto traces often contain only sched_waking.
+	SchedWakingEvent
+)
+
+func (we WakeupEvent) String() string {
+	switch we {
+	case SchedWakeupEvent:
+		return "sched_wakeup"
+	case SchedWakingEvent:
+		return "sched_waking"
+	default:
+		return fmt.Sprintf("unknown wakeup event %d", int(we))
+	}
+}
+
+// WakeupsMarkedBy specifies which event marks a thread's wakeup, its
+// transition from Sleeping to Waiting state.  With SchedWakingEvent, the time
+// between a wakeup's request and its enqueueing is spent Waiting, rather than
+// Sleeping; sched_wakeups then mark wakeups only where no sched_waking does.
+// Event loaders that don't load sched_wakeup are unaffected.
+// If unspecified, SchedWakeupEvent is used.
+func WakeupsMarkedBy(we WakeupEvent) Option {
+	return func(o *collectionOptions) error {
+		if we != SchedWakeupEvent && we != SchedWakingEvent {
+			return status.Errorf(codes.InvalidArgument, "invalid argument to WakeupsMarkedBy: %s", we)
+		}
+		o.wakeupEvent = we
+		return nil
+	}
+}
@@ -25,17 +25,18 @@ import (
 	"github.com/google/schedviz/tracedata/trace"
 )
 
-// PIDsAndComms returns the PIDs and their commands observed in the filtered-in
-// portion of the collection.
+// ThreadGenerations returns the threads observed in the filtered-in portion
+// of the collection, sorted by PID and then by generation.  Each time a PID is
+// reused by a new thread within the trace, its generation increments.
 // FILTERS:
 //   PIDs: Only the filtered-in PIDs are included.
+//   Generations: Only the filtered-in generations are included.
 //   TimeRange, StartTimestamp, EndTimestamp: Only threads observed in the
 //       filtered-in range are returned.
-func (c *Collection) PIDsAndComms(filters ...Filter) (map[PID][]string, error) {
+func (c *Collection) ThreadGenerations(filters ...Filter) ([]*ThreadGeneration, error) {
 	f := buildFilter(c, filters)
-	pidToCommSet := map[PID]map[string]struct{}{}
+	ret := []*ThreadGeneration{}
 	for pid := range f.pids {
-		pidToCommSet[pid] = map[string]struct{}{}
 		ts := c.spansByPID[pid]
 		// Binary search the filter time range to quickly find the subsequence of
 		// spans we care about.
@@ -45,15 +46,72 @@ func (c *Collection) PIDsAndComms(filters ...Filter) (map[PID][]string, error) {
 		endIdx := sort.Search(len(ts), func(i int) bool {
 			return ts[i].startTimestamp > f.endTimestamp
 		})
+		var tg *ThreadGeneration
+		var commSet map[string]struct{}
 		for _, span := range ts[startIdx:endIdx] {
-			if !f.spanFilteredIn(span) || span.command == UnknownCommand {
+			if !f.spanFilteredIn(span) {
+				continue
+			}
+			if tg == nil || tg.Generation != span.generation {
+				tg = &ThreadGeneration{
+					PID:            pid,
+					Generation:     span.generation,
+					Commands:       []string{},
+					StartTimestamp: span.startTimestamp,
+				}
+				commSet = map[string]struct{}{}
+				ret = append(ret, tg)
+			}
+			tg.EndTimestamp = span.endTimestamp
+			// Spans run 1ns beyond the end of the trace; see addSpan.
+			if tg.EndTimestamp > c.endTimestamp {
+				tg.EndTimestamp = c.endTimestamp
+			}
+			if span.command == UnknownCommand {
 				continue
 			}
 			comm, err := c.LookupCommand(span.command)
 			if err != nil {
 				return nil, err
 			}
-			pidToCommSet[pid][comm] = struct{}{}
+			if _, ok := commSet[comm]; !ok {
+				commSet[comm] = struct{}{}
+				tg.Commands = append(tg.Commands, comm)
+				sort.Strings(tg.Commands)
+			}
+		}
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		if ret[a].PID != ret[b].PID {
+			return ret[a].PID < ret[b].PID
+		}
+		return ret[a].Generation < ret[b].Generation
+	})
+	return ret, nil
+}
+
+// PIDsAndComms returns the PIDs and their commands observed in the filtered-in
+// portion of the collection.  The commands of all of a PID's generations are
+// merged; ThreadGenerations reports them separately.
+// FILTERS:
+//   PIDs: Only the filtered-in PIDs are included.
+//   Generations: Only the commands of the filtered-in generations are
+//       included.
+//   TimeRange, StartTimestamp, EndTimestamp: Only threads observed in the
+//       filtered-in range are returned.
+func (c *Collection) PIDsAndComms(filters ...Filter) (map[PID][]string, error) {
+	f := buildFilter(c, filters)
+	tgs, err := c.ThreadGenerations(duplicateFilter(f))
+	if err != nil {
+		return nil, err
+	}
+	pidToCommSet := map[PID]map[string]struct{}{}
+	for pid := range f.pids {
+		pidToCommSet[pid] = map[string]struct{}{}
+	}
+	for _, tg := range tgs {
+		for _, comm := range tg.Commands {
+			pidToCommSet[tg.PID][comm] = struct{}{}
 		}
 	}
 	ret := map[PID][]string{}
@@ -68,6 +126,13 @@ func (c *Collection) PIDsAndComms(filters ...Filter) (map[PID][]string, error) {
 	return ret, nil
 }
 
+// residencyKey identifies the ThreadResidencies of a thread interval, which
+// keeps time spent in different task states apart.
+type residencyKey struct {
+	state     ThreadState
+	taskState TaskState
+}
+
 type threadIntervalBuilder struct {
 	c                   *Collection
 	f                   *filter
@@ -75,7 +140,7 @@ type threadIntervalBuilder struct {
 	duration            Duration
 	thread              *Thread
 	cpu                 CPUID
-	threadResidencies   map[ThreadState]*ThreadResidency
+	threadResidencies   map[residencyKey]*ThreadResidency
 	mergedIntervalCount int
 }
 
@@ -99,9 +164,18 @@ func (tib *threadIntervalBuilder) fetchAndReset() *Interval {
 			ThreadResidencies:   []*ThreadResidency{},
 		}
 		// Add residencies in a fixed order.
-		for _, state := range []ThreadState{RunningState, SleepingState, UnknownState, WaitingState} {
-			if tr, ok := tib.threadResidencies[state]; ok {
-				ret.ThreadResidencies = append(ret.ThreadResidencies, tr)
+		for _, state := range []ThreadState{RunningState, SleepingState, UnknownState, WaitingState, DeadState} {
+			var taskStates []TaskState
+			for key := range tib.threadResidencies {
+				if key.state == state {
+					taskStates = append(taskStates, key.taskState)
+				}
+			}
+			sort.Slice(taskStates, func(a, b int) bool {
+				return taskStates[a] < taskStates[b]
+			})
+			for _, taskState := range taskStates {
+				ret.ThreadResidencies = append(ret.ThreadResidencies, tib.threadResidencies[residencyKey{state, taskState}])
 			}
 		}
 
@@ -113,7 +187,7 @@ func (tib *threadIntervalBuilder) fetchAndReset() *Interval {
 		Priority: UnknownPriority,
 	}
 	tib.cpu = UnknownCPU
-	tib.threadResidencies = map[ThreadState]*ThreadResidency{}
+	tib.threadResidencies = map[residencyKey]*ThreadResidency{}
 	tib.mergedIntervalCount = 0
 	return ret
 }
@@ -123,9 +197,11 @@ func (tib *threadIntervalBuilder) addSpan(span *threadSpan) (*Interval, error) {
 	if !tib.f.spanFilteredIn(span) {
 		return nil, nil
 	}
-	// If the current interval is already long enough, emit it.
+	// If the current interval is already long enough, or belongs to a different
+	// generation of the PID, emit it.
 	if (tib.duration != UnknownDuration && tib.duration+span.duration() > tib.f.minIntervalDuration) ||
-		(tib.cpu != UnknownCPU && tib.cpu != span.cpu) {
+		(tib.cpu != UnknownCPU && tib.cpu != span.cpu) ||
+		(tib.startTimestamp != UnknownTimestamp && tib.thread.Generation != span.generation) {
 		ret = tib.fetchAndReset()
 	}
 	// Adjust start and end timestamps according to the filter.
@@ -154,6 +230,7 @@ func (tib *threadIntervalBuilder) addSpan(span *threadSpan) (*Interval, error) {
 		tib.thread.PID = span.pid
 		tib.thread.Command = comm
 		tib.thread.Priority = span.priority
+		tib.thread.Generation = span.generation
 		tib.startTimestamp = startTimestamp
 		tib.duration = 0
 		tib.cpu = span.cpu
@@ -161,13 +238,15 @@ func (tib *threadIntervalBuilder) addSpan(span *threadSpan) (*Interval, error) {
 	if tib.thread.PID != span.pid {
 		return nil, status.Errorf(codes.Internal, "can't merge a threadSpan from PID %d into a Interval with PID %d", span.pid, tib.thread.PID)
 	}
-	ti, ok := tib.threadResidencies[span.state]
+	key := residencyKey{span.state, span.taskState}
+	ti, ok := tib.threadResidencies[key]
 	if !ok {
 		ti = &ThreadResidency{
-			Thread: tib.thread,
-			State:  span.state,
+			Thread:    tib.thread,
+			State:     span.state,
+			TaskState: span.taskState,
 		}
-		tib.threadResidencies[span.state] = ti
+		tib.threadResidencies[key] = ti
 	}
 	dur := duration(startTimestamp, endTimestamp)
 	ti.Duration = ti.Duration.add(dur)
@@ -180,7 +259,8 @@ func (tib *threadIntervalBuilder) addSpan(span *threadSpan) (*Interval, error) {
 
 // ThreadIntervals returns a slice of Intervals representing, in increasing
 // temporal order, the filtered-in scheduling intervals pertaining to a single
-// thread.  Intervals are split by a change of thread state or CPU.
+// thread.  Intervals are split by a change of thread state, task state, CPU,
+// or PID generation.
 // If the filter specifies to truncate to time range, intervals spanning one
 // or both extremities of the filtered-in time range will be truncated to the
 // extremities; otherwise they will be left whole.  The latter can be useful
@@ -189,10 +269,13 @@ func (tib *threadIntervalBuilder) addSpan(span *threadSpan) (*Interval, error) {
 // FILTERS:
 //   PIDs: ThreadIntervals expects a single PID to be filtered in; this PID is
 //       the one for which intervals will be returned.
+//   Generations: Intervals are restricted to only the specified generations of
+//       the PID.
 //   CPUs: Intervals are restricted to only the specified CPUs.
 //   TimeRange, StartTimestamp, EndTimestamp: ThreadIntervals are produced for
 //       the filtered-in time range.
-//   ThreadState: Intervals are restricted to only the specified thread states.
+//   ThreadState: Intervals are restricted to only the specified thread states,
+//       and task states.
 //   TruncateToTimeRange: If true, returned intervals will be clipped to the
 //       filtered time range.
 //   MinIntervalDuration: adjacent thread intervals are aggregated until they
@@ -269,7 +352,7 @@ func (cib *cpuIntervalBuilder) fetchAndReset() *Interval {
 			ThreadResidencies:   []*ThreadResidency{},
 		}
 		// Add residencies in a fixed order.
-		for _, state := range []ThreadState{RunningState, SleepingState, UnknownState, WaitingState} {
+		for _, state := range []ThreadState{RunningState, SleepingState, UnknownState, WaitingState, DeadState} {
 			var pids = []PID{}
 			for pid := range cib.threadResidencies[state] {
 				pids = append(pids, pid)
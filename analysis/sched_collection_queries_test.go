@@ -17,10 +17,20 @@
 package sched
 
 import (
+	"bytes"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/columnar"
+	"github.com/google/schedviz/tracedata/eventsetbuilder"
+	"github.com/google/schedviz/tracedata/multihost"
+	"github.com/google/schedviz/tracedata/scenario"
 	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
 	"github.com/google/schedviz/tracedata/testeventsetbuilder"
 
@@ -118,6 +128,12 @@ func syntheticThreadResidency(thread *Thread, duration Duration, threadState Thr
 	}
 }
 
+// withTaskState sets the receiver's TaskState, and returns it.
+func (tr *ThreadResidency) withTaskState(taskState TaskState) *ThreadResidency {
+	tr.TaskState = taskState
+	return tr
+}
+
 func TestThreadInterval(t *testing.T) {
 	coll, err := NewCollection(schedtestcommon.TestTrace1(t),
 		PreciseCommands(true),
@@ -149,7 +165,7 @@ func TestThreadInterval(t *testing.T) {
 			),
 			interval(
 				1, trace.Timestamp(1000), Duration(40), CPUID(1),
-				threadResidency(thread2, Duration(40), SleepingState),
+				threadResidency(thread2, Duration(40), SleepingState).withTaskState(TaskInterruptible),
 			),
 			interval(
 				1, trace.Timestamp(1040), Duration(40), CPUID(1),
@@ -170,7 +186,7 @@ func TestThreadInterval(t *testing.T) {
 		wantIntervals: []*Interval{
 			interval(
 				1, trace.Timestamp(1000), Duration(40), CPUID(1),
-				threadResidency(thread2, Duration(40), SleepingState),
+				threadResidency(thread2, Duration(40), SleepingState).withTaskState(TaskInterruptible),
 			),
 			interval(
 				1, trace.Timestamp(1040), Duration(40), CPUID(1),
@@ -199,7 +215,7 @@ func TestThreadInterval(t *testing.T) {
 			),
 			interval(
 				1, trace.Timestamp(1100), Duration(0), CPUID(1),
-				threadResidency(thread1, Duration(0), WaitingState),
+				threadResidency(thread1, Duration(0), WaitingState).withTaskState(TaskPreempted),
 			),
 		},
 	}, {
@@ -209,7 +225,7 @@ func TestThreadInterval(t *testing.T) {
 			interval(
 				2, trace.Timestamp(1000), Duration(40), CPUID(1),
 				threadResidency(thread2, Duration(0), RunningState),
-				threadResidency(thread2, Duration(40), SleepingState),
+				threadResidency(thread2, Duration(40), SleepingState).withTaskState(TaskInterruptible),
 			),
 			interval(
 				1, trace.Timestamp(1040), Duration(40), CPUID(1),
@@ -443,11 +459,11 @@ func TestSwitchOnly(t *testing.T) {
 				// synthetic transitions.
 				interval(
 					1, trace.Timestamp(1000), Duration(15), CPUID(0),
-					syntheticThreadResidency(thread1, Duration(15), SleepingState),
+					syntheticThreadResidency(thread1, Duration(15), SleepingState).withTaskState(TaskInterruptible),
 				),
 				interval(
 					1, trace.Timestamp(1015), Duration(15), CPUID(1),
-					syntheticThreadResidency(thread1, Duration(15), SleepingState),
+					syntheticThreadResidency(thread1, Duration(15), SleepingState).withTaskState(TaskInterruptible),
 				),
 				interval(
 					1, trace.Timestamp(1030), Duration(0), CPUID(1),
@@ -467,7 +483,7 @@ func TestSwitchOnly(t *testing.T) {
 				),
 				interval(
 					1, trace.Timestamp(1020), Duration(10), CPUID(0),
-					threadResidency(thread2, Duration(10), SleepingState),
+					threadResidency(thread2, Duration(10), SleepingState).withTaskState(TaskInterruptible),
 				),
 			},
 		}, {
@@ -478,14 +494,15 @@ func TestSwitchOnly(t *testing.T) {
 					threadResidency(thread3, Duration(10), RunningState),
 				),
 				// The two intervals around the synthetic migration include
-				// synthetic transitions.
+				// synthetic transitions, and both keep the task state PID 300 was
+				// switched out in.
 				interval(
 					1, trace.Timestamp(1010), Duration(5), CPUID(1),
-					syntheticThreadResidency(thread3, Duration(5), WaitingState),
+					syntheticThreadResidency(thread3, Duration(5), WaitingState).withTaskState(TaskPreempted),
 				),
 				interval(
 					1, trace.Timestamp(1015), Duration(5), CPUID(0),
-					syntheticThreadResidency(thread3, Duration(5), WaitingState),
+					syntheticThreadResidency(thread3, Duration(5), WaitingState).withTaskState(TaskPreempted),
 				),
 				interval(
 					1, trace.Timestamp(1020), Duration(10), CPUID(0),
@@ -505,7 +522,7 @@ func TestSwitchOnly(t *testing.T) {
 				),
 				interval(
 					1, trace.Timestamp(1030), Duration(0), CPUID(1),
-					threadResidency(thread4, Duration(0), WaitingState),
+					threadResidency(thread4, Duration(0), WaitingState).withTaskState(TaskPreempted),
 				),
 			},
 		}}
@@ -559,3 +576,307 @@ func TestSwitchOnly(t *testing.T) {
 		})
 	}
 }
+
+// PID 10, a database, sleeps on IO, and after it is woken, sleeps on an
+// event.  PID 20, a kernel worker, is preempted, and then goes idle.  PID 30,
+// a tool, is stopped.
+const taskStateScenario = `
+1000 cpu0 switch prev=0:R next=10 next_comm=db
+1010 cpu0 switch prev=10:D prev_comm=db next=20 next_comm=kworker
+1020 cpu0 switch prev=20:R+ prev_comm=kworker next=30 next_comm=tool
+1030 cpu0 switch prev=30:T prev_comm=tool next=20 next_comm=kworker
+1040 cpu1 wakeup pid=10 comm=db target_cpu=0
+1050 cpu0 switch prev=20:I prev_comm=kworker next=10 next_comm=db
+1060 cpu0 switch prev=10:S prev_comm=db next=0
+1070 cpu1 switch prev=0:R next=0
+`
+
+func newTaskStateCollection(t *testing.T) *Collection {
+	t.Helper()
+	es, err := scenario.Parse(strings.NewReader(taskStateScenario))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	coll, err := NewCollection(es, NormalizeTimestamps(false))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	return coll
+}
+
+// describeTaskStates renders the thread states and task states of intervals
+// as strings.
+func describeTaskStates(intervals []*Interval) []string {
+	var ret []string
+	for _, interval := range intervals {
+		for _, tr := range interval.ThreadResidencies {
+			ret = append(ret, fmt.Sprintf("%d+%d PID %d %s (%s)", interval.StartTimestamp, tr.Duration, tr.Thread.PID, tr.State, tr.TaskState))
+		}
+	}
+	return ret
+}
+
+func TestThreadIntervals_TaskStates(t *testing.T) {
+	coll := newTaskStateCollection(t)
+	tests := []struct {
+		description string
+		filters     []Filter
+		want        []string
+	}{{
+		description: "all states",
+		filters:     []Filter{PIDs(10)},
+		want: []string{
+			"1000+0 PID 10 Unknown (no task state)",
+			"1000+10 PID 10 Running (no task state)",
+			"1010+30 PID 10 Sleeping (Uninterruptible)",
+			"1040+10 PID 10 Waiting (no task state)",
+			"1050+10 PID 10 Running (no task state)",
+			"1060+10 PID 10 Sleeping (Interruptible)",
+		},
+	}, {
+		description: "preempted and idle",
+		filters:     []Filter{PIDs(20)},
+		want: []string{
+			"1000+10 PID 20 Unknown (no task state)",
+			"1010+10 PID 20 Running (no task state)",
+			"1020+10 PID 20 Waiting (Preempted)",
+			"1030+20 PID 20 Running (no task state)",
+			"1050+20 PID 20 Sleeping (Idle)",
+		},
+	}, {
+		description: "sleeping in a task state",
+		filters:     []Filter{PIDs(10), ThreadStates(SleepingState, TaskUninterruptible|TaskStopped)},
+		want: []string{
+			"1010+30 PID 10 Sleeping (Uninterruptible)",
+		},
+	}, {
+		description: "task states only restrict the states they refine",
+		filters:     []Filter{PIDs(10), ThreadStates(RunningState|WaitingState|SleepingState, TaskInterruptible)},
+		want: []string{
+			"1000+10 PID 10 Running (no task state)",
+			"1040+10 PID 10 Waiting (no task state)",
+			"1050+10 PID 10 Running (no task state)",
+			"1060+10 PID 10 Sleeping (Interruptible)",
+		},
+	}, {
+		description: "preempted",
+		filters:     []Filter{PIDs(20), ThreadStates(WaitingState, TaskPreempted)},
+		want: []string{
+			"1020+10 PID 20 Waiting (Preempted)",
+		},
+	}, {
+		description: "task states are kept apart when merged",
+		filters:     []Filter{PIDs(10), ThreadStates(SleepingState), MinIntervalDuration(100)},
+		want: []string{
+			"1010+10 PID 10 Sleeping (Interruptible)",
+			"1010+30 PID 10 Sleeping (Uninterruptible)",
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			intervals, err := coll.ThreadIntervals(append(test.filters, TruncateToTimeRange(true))...)
+			if err != nil {
+				t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, describeTaskStates(intervals)); diff != "" {
+				t.Errorf("ThreadIntervals(): Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLostEvents(t *testing.T) {
+	es := testeventsetbuilder.TestProtobuf(t, schedtestcommon.UnpopulatedBuilder().
+		WithEventDescriptor(
+			"lost_events",
+			eventsetbuilder.Number("count")).
+		WithEvent("sched_switch", 0, 1000, false,
+			100, "Process1", 50, schedtestcommon.Interruptible,
+			200, "Process2", 50).
+		WithEvent("sched_switch", 1, 1010, false,
+			300, "Process3", 50, schedtestcommon.Interruptible,
+			400, "Process4", 50).
+		// This event falls within CPU 0's lost events window, so it is clipped.
+		WithEvent("sched_switch", 1, 1020, false,
+			400, "Process4", 50, schedtestcommon.Interruptible,
+			300, "Process3", 50).
+		WithEvent("lost_events", 0, 1050, false, 5).
+		WithEvent("sched_switch", 0, 1060, false,
+			200, "Process2", 50, schedtestcommon.Interruptible,
+			100, "Process1", 50).
+		WithEvent("sched_switch", 1, 1150, false,
+			300, "Process3", 50, schedtestcommon.Interruptible,
+			400, "Process4", 50).
+		WithEvent("lost_events", 1, 1200, false, -1).
+		WithEvent("sched_switch", 1, 1210, false,
+			400, "Process4", 50, schedtestcommon.Interruptible,
+			300, "Process3", 50))
+	coll, err := NewCollection(es, NormalizeTimestamps(true))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	cpu0Window := &LostEvents{CPU: 0, StartTimestamp: 0, EndTimestamp: 50, Count: 5}
+	cpu1Window := &LostEvents{CPU: 1, StartTimestamp: 150, EndTimestamp: 200, Count: -1}
+	tests := []struct {
+		description string
+		filters     []Filter
+		want        []*LostEvents
+	}{{
+		description: "unfiltered",
+		want:        []*LostEvents{cpu0Window, cpu1Window},
+	}, {
+		description: "CPU filter",
+		filters:     []Filter{CPUs(1)},
+		want:        []*LostEvents{cpu1Window},
+	}, {
+		description: "time range between windows",
+		filters:     []Filter{TimeRange(60, 140)},
+		want:        []*LostEvents{},
+	}, {
+		description: "time range overlapping windows",
+		filters:     []Filter{TimeRange(40, 160)},
+		want:        []*LostEvents{cpu0Window, cpu1Window},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got := coll.LostEvents(test.filters...)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("LostEvents() returned unexpected windows: Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTraceClockConversion(t *testing.T) {
+	newEventSet := func(clock *eventpb.TraceClock) *eventpb.EventSet {
+		es := testeventsetbuilder.TestProtobuf(t, schedtestcommon.UnpopulatedBuilder().
+			WithEvent("sched_switch", 0, 2000, false,
+				100, "Process1", 50, schedtestcommon.Interruptible,
+				200, "Process2", 50).
+			WithEvent("sched_switch", 0, 4000, false,
+				200, "Process2", 50, schedtestcommon.Interruptible,
+				100, "Process1", 50))
+		es.TraceClock = clock
+		return es
+	}
+	// A 2GHz TSC ticks twice per ns.
+	es := newEventSet(&eventpb.TraceClock{Name: "x86-tsc", FrequencyKhz: 2000000})
+	want := proto.Clone(es)
+	coll, err := NewCollection(es)
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	if start, end := coll.Interval(); start != 1000 || end != 2000 {
+		t.Errorf("Interval() = (%d, %d), want (1000, 2000)", start, end)
+	}
+	// The conversion must not modify the caller's EventSet.
+	if !proto.Equal(es, want) {
+		t.Errorf("NewCollection() modified its EventSet: got %v, want %v", es, want)
+	}
+	if _, err := NewCollection(newEventSet(&eventpb.TraceClock{Name: "counter"})); err == nil {
+		t.Errorf("NewCollection() with a counter trace clock yielded no error, want an error")
+	}
+}
+
+func TestNamespaceByHost(t *testing.T) {
+	es, err := multihost.Combine(
+		&multihost.Host{EventSet: schedtestcommon.TestTrace1(t)},
+		&multihost.Host{EventSet: schedtestcommon.TestTrace1(t), ClockOffsetNs: 5})
+	if err != nil {
+		t.Fatalf("Unexpected error combining hosts: %s", err)
+	}
+	// Without namespacing, the hosts' threads would collide.
+	if _, err := NewCollection(es); err == nil {
+		t.Errorf("NewCollection() without NamespaceByHost yielded no error, want an error")
+	}
+	coll, err := NewCollection(es, NamespaceByHost(true))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	host0, err := coll.ThreadIntervals(PIDs(200), TimeRange(1010, 1090), TruncateToTimeRange(true))
+	if err != nil {
+		t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+	}
+	host1, err := coll.ThreadIntervals(PIDs(PID(multihost.HostPID(1, 200))), TimeRange(1015, 1095), TruncateToTimeRange(true))
+	if err != nil {
+		t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+	}
+	var want []string
+	for _, interval := range host0 {
+		for _, tr := range interval.ThreadResidencies {
+			want = append(want, fmt.Sprintf("%d+%d %s PID %d %s", interval.StartTimestamp+5, interval.Duration, CPUID(multihost.HostCPU(1, int64(interval.CPU))), multihost.HostPID(1, int64(tr.Thread.PID)), tr.State))
+		}
+	}
+	if diff := cmp.Diff(want, describeIntervals(host1)); diff != "" {
+		t.Errorf("ThreadIntervals on host 1: Diff -want +got:\n%s", diff)
+	}
+	events, err := coll.PerThreadEventSeries(PID(multihost.HostPID(1, 200)), 0, 2000)
+	if err != nil {
+		t.Fatalf("PerThreadEventSeries yielded unexpected error %s", err)
+	}
+	for _, ev := range events {
+		if host, _ := multihost.SplitCPU(ev.CPU); host != 1 {
+			t.Errorf("PerThreadEventSeries for a host 1 PID returned an event on host %d", host)
+		}
+	}
+	if len(events) == 0 {
+		t.Errorf("PerThreadEventSeries for a host 1 PID returned no events")
+	}
+}
+
+func TestNewCollectionFromSource(t *testing.T) {
+	want, err := NewCollection(schedtestcommon.TestTrace1(t), NormalizeTimestamps(true))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	var buf bytes.Buffer
+	if err := columnar.Write(&buf, &eventpb.Collection{EventSet: schedtestcommon.TestTrace1(t)}, columnar.EventsPerTimeRange(3)); err != nil {
+		t.Fatalf("Unexpected columnar write error %s", err)
+	}
+	r, err := columnar.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Unexpected columnar read error %s", err)
+	}
+	got, err := NewCollectionFromSource(r, NormalizeTimestamps(true))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error from source %s", err)
+	}
+	for _, pid := range []PID{100, 200, 300} {
+		wantIntervals, err := want.ThreadIntervals(PIDs(pid))
+		if err != nil {
+			t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+		}
+		gotIntervals, err := got.ThreadIntervals(PIDs(pid))
+		if err != nil {
+			t.Fatalf("ThreadIntervals on collection from source yielded unexpected error %s", err)
+		}
+		if diff := cmp.Diff(wantIntervals, gotIntervals); diff != "" {
+			t.Errorf("ThreadIntervals(PID %d) on collection from source: Diff -want +got:\n%s", pid, diff)
+		}
+	}
+	wantEvents, err := want.GetRawEvents(CPUs(1))
+	if err != nil {
+		t.Fatalf("GetRawEvents yielded unexpected error %s", err)
+	}
+	gotEvents, err := got.GetRawEvents(CPUs(1))
+	if err != nil {
+		t.Fatalf("GetRawEvents on collection from source yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(wantEvents, gotEvents); diff != "" {
+		t.Errorf("GetRawEvents on collection from source: Diff -want +got:\n%s", diff)
+	}
+
+	// Sources must already be in ns.
+	es := schedtestcommon.TestTrace1(t)
+	es.TraceClock = &eventpb.TraceClock{Name: "counter"}
+	buf.Reset()
+	if err := columnar.Write(&buf, &eventpb.Collection{EventSet: es}); err != nil {
+		t.Fatalf("Unexpected columnar write error %s", err)
+	}
+	if r, err = columnar.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatalf("Unexpected columnar read error %s", err)
+	}
+	if _, err := NewCollectionFromSource(r); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("NewCollectionFromSource with counter clock yielded %v, want FailedPrecondition", err)
+	}
+}
@@ -349,7 +349,8 @@ func (b *elementaryCPUIntervalBuilder) threadFromThreadSpan(ts *threadSpan) (*Th
 		return nil, nil
 	}
 	ret := &Thread{
-		PID: ts.pid,
+		PID:        ts.pid,
+		Generation: ts.generation,
 	}
 	comm, err := b.c.LookupCommand(ts.command)
 	if err != nil {
@@ -69,10 +69,10 @@ func TestElementaryCPUIntervals(t *testing.T) {
 			Waiting:   waiting,
 		}
 	}
-	thread1 := &Thread{PID(100), "Process1", Priority(50)}
-	thread2 := &Thread{PID(200), "Process2", Priority(50)}
-	thread3 := &Thread{PID(300), "Process3", Priority(50)}
-	thread4 := &Thread{PID(400), "Process4", Priority(50)}
+	thread1 := &Thread{PID(100), "Process1", Priority(50), 0}
+	thread2 := &Thread{PID(200), "Process2", Priority(50), 0}
+	thread3 := &Thread{PID(300), "Process3", Priority(50), 0}
+	thread4 := &Thread{PID(400), "Process4", Priority(50), 0}
 	tests := []struct {
 		description                string
 		filters                    []Filter
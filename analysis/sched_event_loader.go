@@ -18,9 +18,11 @@ package sched
 
 import (
 	"errors"
+	"strings"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/multihost"
 	"github.com/google/schedviz/tracedata/trace"
 )
 
@@ -37,6 +39,9 @@ import (
 type eventLoader struct {
 	stringBank *stringBank
 	loaders    map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error
+	// If true, events' CPUs are namespaced by host, and the PIDs and CPUs of
+	// the generated threadTransitions are namespaced by their event's host.
+	namespaceByHost bool
 }
 
 // newEventLoader returns a new, empty, eventLoader.
@@ -64,7 +69,22 @@ func (el *eventLoader) threadTransitions(ev *trace.Event) ([]*threadTransition,
 	if err := loader(ev, ttsb); err != nil {
 		return nil, err
 	}
-	return ttsb.transitions()
+	tts, err := ttsb.transitions()
+	if err != nil || !el.namespaceByHost {
+		return tts, err
+	}
+	host, _ := multihost.SplitCPU(ev.CPU)
+	for _, tt := range tts {
+		tt.PID = PID(multihost.HostPID(host, int64(tt.PID)))
+		// Loaders take CPUs either from the event itself, which are already
+		// namespaced, or from its properties, which are not.
+		for _, cpu := range []*CPUID{&tt.PrevCPU, &tt.NextCPU} {
+			if *cpu != UnknownCPU && int64(*cpu) < multihost.HostCPUStride {
+				*cpu = CPUID(multihost.HostCPU(host, int64(*cpu)))
+			}
+		}
+	}
+	return tts, nil
 }
 
 // MissingFieldError is used to report a missing field.
@@ -117,6 +137,9 @@ type SwitchData struct {
 	NextComm, PrevComm         string
 	NextPriority, PrevPriority Priority
 	PrevState                  ThreadState
+	// The task state the switched-out thread was left in, refining PrevState,
+	// or 0 if it has none.
+	PrevTaskState TaskState
 }
 
 // LoadSwitchData loads the data from a sched_switch event, converting all
@@ -147,16 +170,156 @@ func LoadSwitchData(ev *trace.Event) (*SwitchData, error) {
 	}
 	// The new PID's state is assumed to be RUNNING_STATE, and the old PID's task
 	// state will reveal whether it's WAITING_STATE (prev_state == 0,
-	// TASK_RUNNING, or 256, TASK_REPORT_MAX, which can signify a preemption) or
-	// SLEEPING_STATE (otherwise); The possible values of prevTaskState are
-	// defined in sched.h in the kernel.
+	// TASK_RUNNING, or 256, TASK_REPORT_MAX, which can signify a preemption),
+	// DEAD_STATE (an exiting thread's final switch, with EXIT_DEAD or
+	// EXIT_ZOMBIE set), or SLEEPING_STATE (otherwise); The possible values of
+	// prevTaskState are defined in sched.h in the kernel.
 	prevTaskState, ok := ev.NumberProperties["prev_state"]
 	if !ok {
 		return nil, MissingFieldError("prev_state", ev)
 	}
-	ret.PrevState = WaitingState
-	if prevTaskState != 0 && prevTaskState != 256 {
+	switch {
+	case prevTaskState == 0 || prevTaskState == 256:
+		ret.PrevState = WaitingState
+		ret.PrevTaskState = TaskPreempted
+	case prevTaskState&exitedTaskStates != 0:
+		ret.PrevState = DeadState
+	default:
 		ret.PrevState = SleepingState
+		ret.PrevTaskState = sleepingTaskState(prevTaskState)
+	}
+	return ret, nil
+}
+
+// sleepingTaskState returns the TaskState of a thread switched out sleeping
+// with the provided prev_state, or 0 if the prev_state isn't recognized.
+// Idle threads are checked first, since older kernels also report them as
+// uninterruptible.  TASK_REPORT_IDLE only marks an idle thread when no other
+// state is reported with it; otherwise it is an older kernel's TASK_WAKEKILL.
+func sleepingTaskState(prevTaskState int64) TaskState {
+	switch {
+	case prevTaskState&taskNoloadBits != 0,
+		prevTaskState&taskReportIdleBits != 0 && prevTaskState&taskReportedStateBits == 0:
+		return TaskIdle
+	case prevTaskState&taskUninterruptibleBits != 0:
+		return TaskUninterruptible
+	case prevTaskState&taskStoppedBits != 0:
+		return TaskStopped
+	case prevTaskState&taskInterruptibleBits != 0:
+		return TaskInterruptible
+	default:
+		return 0
+	}
+}
+
+// exitedTaskStates are the bits of sched_switch's prev_state, EXIT_DEAD and
+// EXIT_ZOMBIE, that the kernel reports on an exiting thread's final switch.
+const exitedTaskStates = 0x10 | 0x20
+
+// ForkData comprises the data extracted from a raw sched_process_fork event.
+type ForkData struct {
+	ParentPID, ChildPID   PID
+	ParentComm, ChildComm string
+	// The TGID of the child's thread group, or UnknownPID if the event doesn't
+	// report it.  The kernel's sched_process_fork has no such field, so this is
+	// only known for events recorded by a nonstandard tracer that adds a
+	// child_tgid property; neither trace.sh nor the eBPF collector does.
+	ChildTGID PID
+}
+
+// LoadForkData loads the data from a sched_process_fork event, converting all
+// fields to suitable types, and returns a ForkData struct.
+func LoadForkData(ev *trace.Event) (*ForkData, error) {
+	ret := &ForkData{}
+	parentPID, ok := ev.NumberProperties["parent_pid"]
+	if !ok {
+		return nil, MissingFieldError("parent_pid", ev)
+	}
+	ret.ParentPID = PID(parentPID)
+	ret.ParentComm = ev.TextProperties["parent_comm"]
+	childPID, ok := ev.NumberProperties["child_pid"]
+	if !ok {
+		return nil, MissingFieldError("child_pid", ev)
+	}
+	ret.ChildPID = PID(childPID)
+	ret.ChildComm = ev.TextProperties["child_comm"]
+	ret.ChildTGID = UnknownPID
+	// The idle task is in no thread group, so a zero child_tgid is unknown.
+	if childTGID, ok := ev.NumberProperties["child_tgid"]; ok && childTGID != 0 {
+		ret.ChildTGID = PID(childTGID)
+	}
+	return ret, nil
+}
+
+// ExecData comprises the data extracted from a raw sched_process_exec event.
+type ExecData struct {
+	// The PID of the thread after the exec.  This differs from OldPID if a
+	// thread other than its thread group's leader called exec, in which case it
+	// takes over the leader's PID.
+	PID, OldPID PID
+	Filename    string
+}
+
+// LoadExecData loads the data from a sched_process_exec event, converting all
+// fields to suitable types, and returns an ExecData struct.
+func LoadExecData(ev *trace.Event) (*ExecData, error) {
+	ret := &ExecData{}
+	pid, ok := ev.NumberProperties["pid"]
+	if !ok {
+		return nil, MissingFieldError("pid", ev)
+	}
+	ret.PID = PID(pid)
+	// The idle task never execs, so a zero old_pid, as in hand-written traces
+	// that omit it, is taken to mean that the thread's PID didn't change.
+	ret.OldPID = ret.PID
+	if oldPID, ok := ev.NumberProperties["old_pid"]; ok && oldPID != 0 {
+		ret.OldPID = PID(oldPID)
+	}
+	ret.Filename = ev.TextProperties["filename"]
+	return ret, nil
+}
+
+// Command returns the command name a thread takes on when it execs the
+// receiver's file: the file's base name, truncated as the kernel truncates
+// command names.
+func (ed *ExecData) Command() string {
+	comm := ed.Filename
+	if idx := strings.LastIndex(comm, "/"); idx >= 0 {
+		comm = comm[idx+1:]
+	}
+	if len(comm) > maxCommandLength {
+		comm = comm[:maxCommandLength]
+	}
+	return comm
+}
+
+// maxCommandLength is the length of the longest command name the kernel
+// records, TASK_COMM_LEN less its terminating NUL.
+const maxCommandLength = 15
+
+// ProcessData comprises the data extracted from a raw sched_process_exit or
+// sched_process_free event.
+type ProcessData struct {
+	PID      PID
+	Comm     string
+	Priority Priority
+}
+
+// LoadProcessData loads the data from a sched_process_exit or
+// sched_process_free event, converting all fields to suitable types, and
+// returns a ProcessData struct.
+func LoadProcessData(ev *trace.Event) (*ProcessData, error) {
+	ret := &ProcessData{}
+	pid, ok := ev.NumberProperties["pid"]
+	if !ok {
+		return nil, MissingFieldError("pid", ev)
+	}
+	ret.PID = PID(pid)
+	ret.Comm = ev.TextProperties["comm"]
+	prio, ok := ev.NumberProperties["prio"]
+	ret.Priority = Priority(prio)
+	if !ok {
+		ret.Priority = UnknownPriority
 	}
 	return ret, nil
 }
@@ -224,13 +224,14 @@ func TestTestTrace1Transitions(t *testing.T) {
 			withPriorities(50, 50).
 			withCPUs(1, 1).
 			withCPUPropagatesThrough(true).
-			withStates(SleepingState|WaitingState, RunningState),
+			withStates(SleepingState|WaitingState|DeadState, RunningState),
 		emptyTransition(2, 1000, 200).
 			withCommands(process2ID, process2ID).
 			withPriorities(50, 50).
 			withCPUs(1, 1).
 			withCPUPropagatesThrough(true).
-			withStates(RunningState, SleepingState),
+			withStates(RunningState, SleepingState).
+			withNextTaskState(TaskInterruptible),
 		emptyTransition(3, 1000, 100).
 			withCommands(process1ID, process1ID).
 			withPriorities(50, 50).
@@ -244,13 +245,14 @@ func TestTestTrace1Transitions(t *testing.T) {
 			withPriorities(50, 50).
 			withCPUs(1, 1).
 			withCPUPropagatesThrough(true).
-			withStates(SleepingState|WaitingState, RunningState),
+			withStates(SleepingState|WaitingState|DeadState, RunningState),
 		emptyTransition(4, 1010, 300).
 			withCommands(process3ID, process3ID).
 			withPriorities(50, 50).
 			withCPUs(1, 1).
 			withCPUPropagatesThrough(true).
-			withStates(RunningState, SleepingState),
+			withStates(RunningState, SleepingState).
+			withNextTaskState(TaskInterruptible),
 		emptyTransition(5, 1040, 200).
 			withCommands(process2ID, process2ID).
 			withPriorities(50, 50).
@@ -278,25 +280,27 @@ func TestTestTrace1Transitions(t *testing.T) {
 			withPriorities(50, 50).
 			withCPUs(2, 2).
 			withCPUPropagatesThrough(true).
-			withStates(SleepingState|WaitingState, RunningState),
+			withStates(SleepingState|WaitingState|DeadState, RunningState),
 		emptyTransition(8, 1100, 400).
 			withCommands(process4ID, process4ID).
 			withPriorities(50, 50).
 			withCPUs(2, 2).
 			withCPUPropagatesThrough(true).
-			withStates(RunningState, WaitingState),
+			withStates(RunningState, WaitingState).
+			withNextTaskState(TaskPreempted),
 		emptyTransition(9, 1100, 300).
 			withCommands(process3ID, process3ID).
 			withPriorities(50, 50).
 			withCPUs(1, 1).
 			withCPUPropagatesThrough(true).
-			withStates(SleepingState|WaitingState, RunningState),
+			withStates(SleepingState|WaitingState|DeadState, RunningState),
 		emptyTransition(9, 1100, 100).
 			withCommands(process1ID, process1ID).
 			withPriorities(50, 50).
 			withCPUs(1, 1).
 			withCPUPropagatesThrough(true).
-			withStates(RunningState, WaitingState),
+			withStates(RunningState, WaitingState).
+			withNextTaskState(TaskPreempted),
 	}
 	if len(tts) != len(wantTts) {
 		for _, tt := range tts {
@@ -311,3 +315,46 @@ func TestTestTrace1Transitions(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadSwitchData_TaskStates(t *testing.T) {
+	tests := []struct {
+		prevState     int64
+		wantState     ThreadState
+		wantTaskState TaskState
+	}{
+		{0x0, WaitingState, TaskPreempted},
+		{0x100, WaitingState, TaskPreempted},
+		{0x1, SleepingState, TaskInterruptible},
+		{0x2, SleepingState, TaskUninterruptible},
+		{0x4, SleepingState, TaskStopped},
+		{0x8, SleepingState, TaskStopped},
+		{0x80, SleepingState, TaskIdle},
+		// Kernels before 4.14 report idle threads as uninterruptible and noload.
+		{0x402, SleepingState, TaskIdle},
+		// On those kernels, 0x80 is TASK_WAKEKILL, which killable and stopped
+		// threads also report.
+		{0x82, SleepingState, TaskUninterruptible},
+		{0x84, SleepingState, TaskStopped},
+		{0x88, SleepingState, TaskStopped},
+		// Parked threads have no TaskState.
+		{0x40, SleepingState, 0},
+		{0x10, DeadState, 0},
+	}
+	for _, test := range tests {
+		ev := &trace.Event{
+			Name: "sched_switch",
+			NumberProperties: map[string]int64{
+				"prev_pid":   10,
+				"next_pid":   20,
+				"prev_state": test.prevState,
+			},
+		}
+		sd, err := LoadSwitchData(ev)
+		if err != nil {
+			t.Fatalf("LoadSwitchData yielded unexpected error %s", err)
+		}
+		if sd.PrevState != test.wantState || sd.PrevTaskState != test.wantTaskState {
+			t.Errorf("LoadSwitchData() with prev_state %#x = (%s, %s), want (%s, %s)", test.prevState, sd.PrevState, sd.PrevTaskState, test.wantState, test.wantTaskState)
+		}
+	}
+}
@@ -72,10 +72,11 @@ func LoadSchedSwitch(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
 	}
 	// sched:sched_switch produces two thread transitions:
 	// * The next PID backwards and forwards on the reporting CPU and forwards in
-	//   Running state,
+	//   Running state.  Backwards, it may have been Dead, if its PID was reused
+	//   by a thread whose fork was not recorded.
 	// * The previous PID backwards and forwards on the reporting CPU, backwards
-	//   in Running state, and forwards in Sleeping or Waiting state, depending on
-	//   its prev_state.
+	//   in Running state, and forwards in Sleeping, Waiting, or Dead state, and
+	//   in the task state refining it, depending on its prev_state.
 	ttsb.WithTransition(ev.Index, ev.Timestamp, sd.NextPID).
 		WithPrevCommand(sd.NextComm).
 		WithNextCommand(sd.NextComm).
@@ -84,7 +85,7 @@ func LoadSchedSwitch(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
 		WithPrevCPU(CPUID(ev.CPU)).
 		WithNextCPU(CPUID(ev.CPU)).
 		WithCPUPropagatesThrough(true).
-		WithPrevState(WaitingState | SleepingState).
+		WithPrevState(WaitingState | SleepingState | DeadState).
 		WithNextState(RunningState)
 	ttsb.WithTransition(ev.Index, ev.Timestamp, sd.PrevPID).
 		WithPrevCommand(sd.PrevComm).
@@ -95,7 +96,8 @@ func LoadSchedSwitch(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
 		WithNextCPU(CPUID(ev.CPU)).
 		WithCPUPropagatesThrough(true).
 		WithPrevState(RunningState).
-		WithNextState(sd.PrevState)
+		WithNextState(sd.PrevState).
+		WithNextTaskState(sd.PrevTaskState)
 	return nil
 }
 
@@ -143,6 +145,201 @@ func LoadSchedWakeup(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
 	return nil
 }
 
+// LoadSchedWaking loads a sched::sched_waking event, for collections in which
+// sched_waking, rather than sched_wakeup, marks wakeups.
+func LoadSchedWaking(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	pid, ok := ev.NumberProperties["pid"]
+	if !ok {
+		return MissingFieldError("pid", ev)
+	}
+	comm := ev.TextProperties["comm"]
+	prio, ok := ev.NumberProperties["prio"]
+	priority := Priority(prio)
+	if !ok {
+		priority = UnknownPriority
+	}
+	targetCPU, ok := ev.NumberProperties["target_cpu"]
+	if !ok {
+		return MissingFieldError("target_cpu", ev)
+	}
+	// sched:sched_waking is reported when a wakeup is requested, before the
+	// woken thread's CPU is selected and before it is enqueued there, and
+	// produces a single thread transition from Sleeping to Waiting state.  Its
+	// target CPU is the CPU the thread last ran on; if the thread is placed
+	// elsewhere, a sched_migrate_task follows.
+	//
+	// sched_waking is as prone to misbehavior as sched_wakeup, and may also be
+	// reported for a thread that is still running on its CPU, about to sleep.
+	// Therefore, as with sched_wakeup, all of its assertions are relaxed, such
+	// that sched_wakings that disagree with other events are dropped.
+	ttsb.WithTransition(ev.Index, ev.Timestamp, PID(pid)).
+		WithPrevCommand(comm).
+		WithNextCommand(comm).
+		WithPrevPriority(priority).
+		WithNextPriority(priority).
+		WithPrevCPU(CPUID(targetCPU)).
+		WithNextCPU(CPUID(targetCPU)).
+		WithCPUPropagatesThrough(true).
+		WithPrevState(SleepingState).
+		WithNextState(WaitingState).
+		OnBackwardsCPUConflict(Drop).
+		OnForwardsCPUConflict(Drop).
+		OnBackwardsStateConflict(Drop).
+		OnForwardsStateConflict(Drop)
+	return nil
+}
+
+// LoadSchedWakeupAfterWaking loads a sched::sched_wakeup event, for
+// collections in which sched_waking, rather than sched_wakeup, marks wakeups.
+func LoadSchedWakeupAfterWaking(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	pid, ok := ev.NumberProperties["pid"]
+	if !ok {
+		return MissingFieldError("pid", ev)
+	}
+	comm := ev.TextProperties["comm"]
+	prio, ok := ev.NumberProperties["prio"]
+	priority := Priority(prio)
+	if !ok {
+		priority = UnknownPriority
+	}
+	targetCPU, ok := ev.NumberProperties["target_cpu"]
+	if !ok {
+		return MissingFieldError("target_cpu", ev)
+	}
+	// A sched:sched_wakeup following the thread's sched_waking finds it already
+	// Waiting, and only places it on its target CPU.  If its sched_waking was
+	// not traced, or was dropped, the sched_wakeup marks the wakeup itself, so
+	// it may be either Sleeping or Waiting beforehand.  Its assertions are
+	// relaxed as in LoadSchedWakeup.
+	ttsb.WithTransition(ev.Index, ev.Timestamp, PID(pid)).
+		WithPrevCommand(comm).
+		WithNextCommand(comm).
+		WithPrevPriority(priority).
+		WithNextPriority(priority).
+		WithPrevCPU(CPUID(targetCPU)).
+		WithNextCPU(CPUID(targetCPU)).
+		WithCPUPropagatesThrough(true).
+		WithPrevState(SleepingState | WaitingState).
+		WithNextState(WaitingState).
+		OnBackwardsCPUConflict(Drop).
+		OnForwardsCPUConflict(Drop).
+		OnBackwardsStateConflict(Drop).
+		OnForwardsStateConflict(Drop)
+	return nil
+}
+
+// LoadSchedProcessFork loads a sched::sched_process_fork event.
+func LoadSchedProcessFork(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	fd, err := LoadForkData(ev)
+	if err != nil {
+		return err
+	}
+	// sched:sched_process_fork produces a single thread transition, bringing the
+	// child PID to life: from Dead state to Sleeping state, in which it remains
+	// until its sched_wakeup_new.  The child's CPU is not known until then.  The
+	// parent, which is running on the reporting CPU, makes no transition.
+	//
+	// The child is a new thread, so if its PID was used earlier in the trace, it
+	// starts a new generation of that PID.
+	ttsb.WithTransition(ev.Index, ev.Timestamp, fd.ChildPID).
+		WithPrevCommand(fd.ChildComm).
+		WithNextCommand(fd.ChildComm).
+		WithCPUPropagatesThrough(true).
+		WithPrevState(DeadState).
+		WithNextState(SleepingState).
+		WithStartsThread(true).
+		OnBackwardsStateConflict(Drop)
+	return nil
+}
+
+// LoadSchedProcessExec loads a sched::sched_process_exec event.
+func LoadSchedProcessExec(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	ed, err := LoadExecData(ev)
+	if err != nil {
+		return err
+	}
+	// sched:sched_process_exec is reported by the exec'ing thread, which is
+	// running on the reporting CPU and takes on the executed file's command.
+	// If that thread was not its thread group's leader, it takes over the
+	// leader's PID, and its old PID ceases to exist; the leader's prior state
+	// and CPU are then unknown.  Like sched_wakeups, these transitions are
+	// dropped if they disagree with other events.
+	prevState, prevCPU, cpuPropagatesThrough := RunningState, CPUID(ev.CPU), true
+	if ed.OldPID != ed.PID {
+		ttsb.WithTransition(ev.Index, ev.Timestamp, ed.OldPID).
+			WithPrevCPU(CPUID(ev.CPU)).
+			WithNextCPU(CPUID(ev.CPU)).
+			WithCPUPropagatesThrough(true).
+			WithPrevState(RunningState).
+			WithNextState(DeadState).
+			OnBackwardsCPUConflict(Drop).
+			OnBackwardsStateConflict(Drop)
+		prevState, prevCPU, cpuPropagatesThrough = AnyState, UnknownCPU, false
+	}
+	ttsb.WithTransition(ev.Index, ev.Timestamp, ed.PID).
+		WithNextCommand(ed.Command()).
+		WithPrevCPU(prevCPU).
+		WithNextCPU(CPUID(ev.CPU)).
+		WithCPUPropagatesThrough(cpuPropagatesThrough).
+		WithPrevState(prevState).
+		WithNextState(RunningState).
+		OnBackwardsCPUConflict(Drop).
+		OnForwardsCPUConflict(Drop).
+		OnBackwardsStateConflict(Drop).
+		OnForwardsStateConflict(Drop)
+	return nil
+}
+
+// LoadSchedProcessExit loads a sched::sched_process_exit event.
+func LoadSchedProcessExit(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	pd, err := LoadProcessData(ev)
+	if err != nil {
+		return err
+	}
+	// sched:sched_process_exit is reported by the exiting thread while it is
+	// still running on the reporting CPU; it dies at its final sched_switch.
+	// Like sched_wakeups, these transitions are dropped if they disagree with
+	// other events.
+	ttsb.WithTransition(ev.Index, ev.Timestamp, pd.PID).
+		WithPrevCommand(pd.Comm).
+		WithNextCommand(pd.Comm).
+		WithPrevPriority(pd.Priority).
+		WithNextPriority(pd.Priority).
+		WithPrevCPU(CPUID(ev.CPU)).
+		WithNextCPU(CPUID(ev.CPU)).
+		WithCPUPropagatesThrough(true).
+		WithPrevState(RunningState).
+		WithNextState(RunningState).
+		WithStatePropagatesThrough(true).
+		OnBackwardsCPUConflict(Drop).
+		OnForwardsCPUConflict(Drop).
+		OnBackwardsStateConflict(Drop).
+		OnForwardsStateConflict(Drop)
+	return nil
+}
+
+// LoadSchedProcessFree loads a sched::sched_process_free event.
+func LoadSchedProcessFree(ev *trace.Event, ttsb *ThreadTransitionSetBuilder) error {
+	pd, err := LoadProcessData(ev)
+	if err != nil {
+		return err
+	}
+	// sched:sched_process_free is reported, on any CPU, when a dead thread's
+	// task is freed.  It produces a single thread transition to Dead state.
+	// Kernels that do not report EXIT_DEAD or EXIT_ZOMBIE in the final
+	// sched_switch of an exiting thread leave it in Sleeping state until then.
+	ttsb.WithTransition(ev.Index, ev.Timestamp, pd.PID).
+		WithPrevCommand(pd.Comm).
+		WithNextCommand(pd.Comm).
+		WithPrevPriority(pd.Priority).
+		WithNextPriority(pd.Priority).
+		WithCPUPropagatesThrough(true).
+		WithPrevState(SleepingState | DeadState).
+		WithNextState(DeadState).
+		OnBackwardsStateConflict(Drop)
+	return nil
+}
+
 // LoadSchedSwitchWithSynthetics loads a sched::sched_switch event from a trace
 // that lacks other events that could signal thread state or CPU changes.
 // Wherever a state or CPU transition is missing, a synthetic transition will
@@ -160,7 +357,7 @@ func LoadSchedSwitchWithSynthetics(ev *trace.Event, ttsb *ThreadTransitionSetBui
 		WithPrevCPU(CPUID(ev.CPU)).
 		WithNextCPU(CPUID(ev.CPU)).
 		WithCPUPropagatesThrough(true).
-		WithPrevState(SleepingState | WaitingState).
+		WithPrevState(SleepingState | WaitingState | DeadState).
 		WithNextState(RunningState).
 		OnForwardsCPUConflict(InsertSynthetic).
 		OnBackwardsCPUConflict(InsertSynthetic).
@@ -176,6 +373,7 @@ func LoadSchedSwitchWithSynthetics(ev *trace.Event, ttsb *ThreadTransitionSetBui
 		WithCPUPropagatesThrough(true).
 		WithPrevState(RunningState).
 		WithNextState(sd.PrevState).
+		WithNextTaskState(sd.PrevTaskState).
 		OnForwardsCPUConflict(InsertSynthetic).
 		OnBackwardsCPUConflict(InsertSynthetic).
 		OnForwardsStateConflict(InsertSynthetic).
@@ -188,11 +386,17 @@ func LoadSchedSwitchWithSynthetics(ev *trace.Event, ttsb *ThreadTransitionSetBui
 type EventLoaders map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error
 
 // DefaultEventLoaders is a set of default event loaders operating on
-// sched_migrate_task, sched_switch, sched_wakeup, and sched_wakeup_new.
-// sched_wakeup events that cannot be reconciled are dropped.
+// sched_migrate_task, sched_switch, sched_wakeup, sched_wakeup_new, and the
+// thread lifecycle events sched_process_fork, sched_process_exec,
+// sched_process_exit, and sched_process_free.  sched_wakeup events that cannot
+// be reconciled are dropped.
 func DefaultEventLoaders() EventLoaders {
 	return map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error{
 		"sched_migrate_task": LoadSchedMigrateTask,
+		"sched_process_exec": LoadSchedProcessExec,
+		"sched_process_exit": LoadSchedProcessExit,
+		"sched_process_fork": LoadSchedProcessFork,
+		"sched_process_free": LoadSchedProcessFree,
 		"sched_switch":       LoadSchedSwitch,
 		"sched_wakeup":       LoadSchedWakeup,
 		"sched_wakeup_new":   LoadSchedWakeup,
@@ -210,20 +414,43 @@ func SwitchOnlyLoaders() EventLoaders {
 }
 
 // FaultTolerantEventLoaders is a set of event loaders operating on
-// sched_migrate_task, sched_switch, sched_wakeup, and sched_wakeup_new, and
-// suitable for use on traces that may be incomplete or have out-of-order
-// events.  sched_migrate events whose CPUs cannot be reconciled are dropped;
-// sched_wakeup* events that cannot be reconciled are dropped, and unattested
-// CPU and thread state transitions between sched_switch events are inferred.
+// sched_migrate_task, sched_switch, sched_wakeup, sched_wakeup_new, and the
+// thread lifecycle events, and suitable for use on traces that may be
+// incomplete or have out-of-order events.  sched_migrate events whose CPUs
+// cannot be reconciled are dropped; sched_wakeup* events that cannot be
+// reconciled are dropped, and unattested CPU and thread state transitions
+// between sched_switch events are inferred.
 func FaultTolerantEventLoaders() EventLoaders {
 	return map[string]func(*trace.Event, *ThreadTransitionSetBuilder) error{
 		"sched_migrate_task": LoadSchedMigrateTaskWithDrops,
+		"sched_process_exec": LoadSchedProcessExec,
+		"sched_process_exit": LoadSchedProcessExit,
+		"sched_process_fork": LoadSchedProcessFork,
+		"sched_process_free": LoadSchedProcessFree,
 		"sched_switch":       LoadSchedSwitchWithSynthetics,
 		"sched_wakeup":       LoadSchedWakeup,
 		"sched_wakeup_new":   LoadSchedWakeup,
 	}
 }
 
+// markingWakeupsWithWaking returns a copy of the receiver in which
+// sched_waking, rather than sched_wakeup, marks wakeups.  Event loaders that
+// don't load sched_wakeup, such as SwitchOnlyLoaders, are returned unchanged.
+// sched_wakeup_new is never preceded by a sched_waking, so it still marks the
+// wakeups of new threads.
+func (el EventLoaders) markingWakeupsWithWaking() EventLoaders {
+	if _, ok := el["sched_wakeup"]; !ok {
+		return el
+	}
+	ret := EventLoaders{}
+	for name, loader := range el {
+		ret[name] = loader
+	}
+	ret["sched_waking"] = LoadSchedWaking
+	ret["sched_wakeup"] = LoadSchedWakeupAfterWaking
+	return ret
+}
+
 // EventLoader returns the event loader specified by the provided LoaderType.
 func EventLoader(elt elpb.LoadersType) (EventLoaders, error) {
 	switch elt {
@@ -20,16 +20,23 @@ import (
 	"sort"
 	"time"
 
+	"github.com/google/schedviz/tracedata/multihost"
 	"github.com/google/schedviz/tracedata/trace"
 )
 
-func involvesPID(ev *trace.Event, pid PID) bool {
-	nextPID, hasNextPID := ev.NumberProperties["next_pid"]
-	prevPID, hasPrevPID := ev.NumberProperties["prev_pid"]
-	normalPID, hasNormalPID := ev.NumberProperties["pid"]
-	return (hasNextPID && PID(nextPID) == pid) ||
-		(hasPrevPID && PID(prevPID) == pid) ||
-		(hasNormalPID && PID(normalPID) == pid)
+// involvesPID returns true if ev refers to pid.  If namespaceByHost is true,
+// the PIDs ev refers to are namespaced by the host of its CPU.
+func involvesPID(ev *trace.Event, pid PID, namespaceByHost bool) bool {
+	host := 0
+	if namespaceByHost {
+		host, _ = multihost.SplitCPU(ev.CPU)
+	}
+	for _, prop := range []string{"next_pid", "prev_pid", "pid", "parent_pid", "child_pid", "old_pid"} {
+		if evPID, ok := ev.NumberProperties[prop]; ok && PID(multihost.HostPID(host, evPID)) == pid {
+			return true
+		}
+	}
+	return false
 }
 
 // PerThreadEventSeries returns all events in a specified collection occurring on a specified PID
@@ -37,7 +44,8 @@ func involvesPID(ev *trace.Event, pid PID) bool {
 func (c *Collection) PerThreadEventSeries(pid PID, startTimestamp, endTimestamp time.Duration) ([]*trace.Event, error) {
 	events, err := c.GetRawEvents(
 		TimeRange(trace.Timestamp(startTimestamp), trace.Timestamp(endTimestamp)),
-		EventTypes("sched_switch", "sched_migrate_task", "sched_wakeup", "sched_wakeup_new", "sched_process_wait", "sched_wait_task"),
+		EventTypes("sched_switch", "sched_migrate_task", "sched_wakeup", "sched_wakeup_new", "sched_waking", "sched_process_wait", "sched_wait_task",
+			"sched_process_fork", "sched_process_exec", "sched_process_exit", "sched_process_free"),
 	)
 	if err != nil {
 		return nil, err
@@ -47,7 +55,7 @@ func (c *Collection) PerThreadEventSeries(pid PID, startTimestamp, endTimestamp
 	ret := []*trace.Event{}
 	for i := range events {
 		ev := events[i]
-		if involvesPID(ev, pid) {
+		if involvesPID(ev, pid, c.options.namespaceByHost) {
 			ret = append(ret, ev)
 		}
 	}
@@ -61,15 +69,12 @@ func (c *Collection) PerThreadEventSeries(pid PID, startTimestamp, endTimestamp
 //   ThreadSummaries performs its calculations over thread intervals, so it
 //   honors the same filters as ThreadIntervals, in the same ways.  However, it
 //   calls ThreadIntervals for each individual thread in the filtered-in PID
-//   set.
+//   set, and produces a separate summary for each generation of each PID.
+//   Processes: Only the threads of the filtered-in processes are summarized.
 func (c *Collection) ThreadSummaries(filters ...Filter) ([]*Metrics, error) {
 	f := buildFilter(c, filters)
-	pidsAndComms, err := c.PIDsAndComms()
-	if err != nil {
-		return nil, err
-	}
 	var pids = []PID{}
-	for pid := range pidsAndComms {
+	for pid := range f.pids {
 		// Do not attempt to summarize PID 0.
 		if pid == 0 {
 			continue
@@ -85,18 +90,25 @@ func (c *Collection) ThreadSummaries(filters ...Filter) ([]*Metrics, error) {
 	var pidMetrics = []*Metrics{}
 
 	for _, pid := range pids {
-		// Fetch ThreadIntervals for this PID, but overriding the request's CPU
-		// filtering so that migrations (which may be from or to unfiltered CPUs)
-		// aren't lost.
-		threadIntervals, err := c.ThreadIntervals(duplicateFilter(f), PIDs(pid), CPUs())
+		threadIntervals, err := c.summaryIntervals(f, pid)
 		if err != nil {
 			return nil, err
 		}
-		// Compute metrics on the thread intervals
+		// Compute metrics on the thread intervals, starting anew with each
+		// generation of the PID.
 		metric := newMetric(c, filters...)
 		var lastInterval *Interval
 
 		for _, interval := range threadIntervals {
+			if lastInterval != nil && intervalGeneration(lastInterval) != intervalGeneration(interval) {
+				if metric.intervalCount > 0 {
+					pidMetrics = append(pidMetrics, metric.finalize())
+				}
+				metric = newMetric(c, filters...)
+				lastInterval = nil
+			}
+			metric.s.Generation = intervalGeneration(interval)
+			metric.s.TGID = c.TGID(pid, metric.s.Generation)
 			if err := metric.recordInterval(f.cpus, f.startTimestamp, f.endTimestamp, lastInterval, interval); err != nil {
 				return nil, err
 			}
@@ -111,6 +123,76 @@ func (c *Collection) ThreadSummaries(filters ...Filter) ([]*Metrics, error) {
 	return pidMetrics, nil
 }
 
+// ProcessSummaries returns a summary of each process, or thread group, over a
+// specified interval, aggregating the summaries of all of its threads.  Each
+// process's threads are found as by ThreadGroups; of a reused PID, only the
+// generations that belonged to the process are summarized with it.
+// FILTERS:
+//   ProcessSummaries honors the same filters as ThreadSummaries, in the same
+//   ways, but produces a single summary for all the filtered-in threads, and
+//   all their generations, of each process.
+func (c *Collection) ProcessSummaries(filters ...Filter) ([]*Metrics, error) {
+	f := buildFilter(c, filters)
+	threadGroups, err := c.ThreadGroups(duplicateFilter(f))
+	if err != nil {
+		return nil, err
+	}
+	var tgids = []PID{}
+	for tgid := range threadGroups {
+		tgids = append(tgids, tgid)
+	}
+	sort.Slice(tgids, func(i, j int) bool {
+		return tgids[i] < tgids[j]
+	})
+
+	var processMetrics = []*Metrics{}
+
+	for _, tgid := range tgids {
+		metric := newMetric(c, filters...)
+		metric.s.TGID = tgid
+		for _, pid := range threadGroups[tgid] {
+			threadIntervals, err := c.summaryIntervals(f, pid, Processes(tgid))
+			if err != nil {
+				return nil, err
+			}
+			// Migrations and wakeups are found within each thread, and each
+			// generation of it.
+			var lastInterval *Interval
+			for _, interval := range threadIntervals {
+				if lastInterval != nil && intervalGeneration(lastInterval) != intervalGeneration(interval) {
+					lastInterval = nil
+				}
+				if err := metric.recordInterval(f.cpus, f.startTimestamp, f.endTimestamp, lastInterval, interval); err != nil {
+					return nil, err
+				}
+				lastInterval = interval
+			}
+		}
+		if metric.intervalCount > 0 {
+			processMetrics = append(processMetrics, metric.finalize())
+		}
+	}
+
+	return processMetrics, nil
+}
+
+// summaryIntervals returns the thread intervals of the provided PID to be
+// summarized under the provided filter, refined by any further filters
+// provided.  The filter's CPU filtering is overridden so that migrations
+// (which may be from or to unfiltered CPUs) aren't lost.
+func (c *Collection) summaryIntervals(f *filter, pid PID, filters ...Filter) ([]*Interval, error) {
+	return c.ThreadIntervals(append([]Filter{duplicateFilter(f), PIDs(pid), CPUs()}, filters...)...)
+}
+
+// intervalGeneration returns the PID generation of the provided single-thread
+// interval.
+func intervalGeneration(interval *Interval) int {
+	for _, tr := range interval.ThreadResidencies {
+		return tr.Thread.Generation
+	}
+	return 0
+}
+
 type metric struct {
 	filters       []Filter
 	pids          map[PID]struct{}
@@ -179,7 +261,7 @@ func (m *metric) recordInterval(filterCPUs map[CPUID]struct{}, startTimestamp, e
 		m.pids[thread.PID] = struct{}{}
 		m.priorities[thread.Priority] = struct{}{}
 		m.commands[thread.Command] = struct{}{}
-		m.recordDuration(endTimestamp-startTimestamp, tr.State)
+		m.recordDuration(endTimestamp-startTimestamp, tr.State, tr.TaskState)
 	}
 
 	if isMigration(last, curr) {
@@ -192,7 +274,7 @@ func (m *metric) recordInterval(filterCPUs map[CPUID]struct{}, startTimestamp, e
 	return nil
 }
 
-func (m *metric) recordDuration(dur trace.Timestamp, state ThreadState) {
+func (m *metric) recordDuration(dur trace.Timestamp, state ThreadState, taskState TaskState) {
 	duration := Duration(dur)
 	switch state {
 	case RunningState:
@@ -204,6 +286,18 @@ func (m *metric) recordDuration(dur trace.Timestamp, state ThreadState) {
 	default:
 		m.s.UnknownTimeNs += duration
 	}
+	switch taskState {
+	case TaskPreempted:
+		m.s.PreemptedTimeNs += duration
+	case TaskInterruptible:
+		m.s.InterruptibleSleepTimeNs += duration
+	case TaskUninterruptible:
+		m.s.UninterruptibleSleepTimeNs += duration
+	case TaskStopped:
+		m.s.StoppedTimeNs += duration
+	case TaskIdle:
+		m.s.IdleTimeNs += duration
+	}
 }
 
 func (m *metric) finalize() *Metrics {
@@ -218,13 +312,20 @@ func (m *metric) finalize() *Metrics {
 	for command := range m.commands {
 		m.s.Commands = append(m.s.Commands, command)
 	}
+	sort.Strings(m.s.Commands)
 	m.s.Pids = nil
 	for pid := range m.pids {
 		m.s.Pids = append(m.s.Pids, pid)
 	}
+	sort.Slice(m.s.Pids, func(i, j int) bool {
+		return m.s.Pids[i] < m.s.Pids[j]
+	})
 	m.s.Priorities = nil
 	for priority := range m.priorities {
 		m.s.Priorities = append(m.s.Priorities, priority)
 	}
+	sort.Slice(m.s.Priorities, func(i, j int) bool {
+		return m.s.Priorities[i] < m.s.Priorities[j]
+	})
 	return m.s
 }
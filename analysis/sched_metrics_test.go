@@ -45,6 +45,7 @@ func TestPIDSummary(t *testing.T) {
 				RunTimeNs:        90,
 				WaitTimeNs:       10,
 				Pids:             []PID{100},
+				TGID:             100,
 				Commands:         []string{"Process1"},
 				Cpus:             []CPUID{1},
 				StartTimestampNs: 0,
@@ -54,33 +55,37 @@ func TestPIDSummary(t *testing.T) {
 			},
 			{
 				// Switch-out SLEEPING at 1000, wakeup at 1040, migrate at 1080, switch-in at 1100.
-				MigrationCount:   1,
-				UnknownTimeNs:    0,
-				RunTimeNs:        0,
-				WaitTimeNs:       60,
-				SleepTimeNs:      40,
-				Pids:             []PID{200},
-				Commands:         []string{"Process2"},
-				Cpus:             []CPUID{1, 2},
-				StartTimestampNs: 0,
-				EndTimestampNs:   100,
-				WakeupCount:      1,
-				Priorities:       []Priority{50},
+				MigrationCount:           1,
+				UnknownTimeNs:            0,
+				RunTimeNs:                0,
+				WaitTimeNs:               60,
+				SleepTimeNs:              40,
+				InterruptibleSleepTimeNs: 40,
+				Pids:                     []PID{200},
+				TGID:                     200,
+				Commands:                 []string{"Process2"},
+				Cpus:                     []CPUID{1, 2},
+				StartTimestampNs:         0,
+				EndTimestampNs:           100,
+				WakeupCount:              1,
+				Priorities:               []Priority{50},
 			},
 			{
 				// Switch-in at 1000, switch-out at 1010, wakeup at 1090, switch-in at 1100.
-				MigrationCount:   0,
-				UnknownTimeNs:    0,
-				RunTimeNs:        10,
-				WaitTimeNs:       10,
-				SleepTimeNs:      80,
-				Pids:             []PID{300},
-				Commands:         []string{"Process3"},
-				Cpus:             []CPUID{1},
-				StartTimestampNs: 0,
-				EndTimestampNs:   100,
-				WakeupCount:      1,
-				Priorities:       []Priority{50},
+				MigrationCount:           0,
+				UnknownTimeNs:            0,
+				RunTimeNs:                10,
+				WaitTimeNs:               10,
+				SleepTimeNs:              80,
+				InterruptibleSleepTimeNs: 80,
+				Pids:                     []PID{300},
+				TGID:                     300,
+				Commands:                 []string{"Process3"},
+				Cpus:                     []CPUID{1},
+				StartTimestampNs:         0,
+				EndTimestampNs:           100,
+				WakeupCount:              1,
+				Priorities:               []Priority{50},
 			},
 			{
 				// Initial, switch-out at 1100.
@@ -89,6 +94,7 @@ func TestPIDSummary(t *testing.T) {
 				RunTimeNs:        100,
 				WaitTimeNs:       0,
 				Pids:             []PID{400},
+				TGID:             400,
 				Commands:         []string{"Process4"},
 				Cpus:             []CPUID{2},
 				StartTimestampNs: 0,
@@ -107,6 +113,7 @@ func TestPIDSummary(t *testing.T) {
 				RunTimeNs:        90,
 				WaitTimeNs:       10,
 				Pids:             []PID{100},
+				TGID:             100,
 				Commands:         []string{"Process1"},
 				Cpus:             []CPUID{1},
 				StartTimestampNs: 0,
@@ -116,33 +123,37 @@ func TestPIDSummary(t *testing.T) {
 			},
 			{
 				// Switch-out and wakeup
-				MigrationCount:   0, // Only migrations-in count.
-				UnknownTimeNs:    0,
-				RunTimeNs:        0,
-				WaitTimeNs:       40, // After the wakeup and before the migrate-out.
-				SleepTimeNs:      40, // Up to the wakeup
-				Pids:             []PID{200},
-				Commands:         []string{"Process2"},
-				Cpus:             []CPUID{1},
-				StartTimestampNs: 0,
-				EndTimestampNs:   100,
-				WakeupCount:      1,
-				Priorities:       []Priority{50},
+				MigrationCount:           0, // Only migrations-in count.
+				UnknownTimeNs:            0,
+				RunTimeNs:                0,
+				WaitTimeNs:               40, // After the wakeup and before the migrate-out.
+				SleepTimeNs:              40, // Up to the wakeup
+				InterruptibleSleepTimeNs: 40,
+				Pids:                     []PID{200},
+				TGID:                     200,
+				Commands:                 []string{"Process2"},
+				Cpus:                     []CPUID{1},
+				StartTimestampNs:         0,
+				EndTimestampNs:           100,
+				WakeupCount:              1,
+				Priorities:               []Priority{50},
 			},
 			{
 				// Switch-in at 1000, switch-out at 1010, wakeup at 1090, switch-in at 1100.
-				MigrationCount:   0,
-				UnknownTimeNs:    0,
-				RunTimeNs:        10,
-				WaitTimeNs:       10,
-				SleepTimeNs:      80,
-				Pids:             []PID{300},
-				Commands:         []string{"Process3"},
-				Cpus:             []CPUID{1},
-				StartTimestampNs: 0,
-				EndTimestampNs:   100,
-				WakeupCount:      1,
-				Priorities:       []Priority{50},
+				MigrationCount:           0,
+				UnknownTimeNs:            0,
+				RunTimeNs:                10,
+				WaitTimeNs:               10,
+				SleepTimeNs:              80,
+				InterruptibleSleepTimeNs: 80,
+				Pids:                     []PID{300},
+				TGID:                     300,
+				Commands:                 []string{"Process3"},
+				Cpus:                     []CPUID{1},
+				StartTimestampNs:         0,
+				EndTimestampNs:           100,
+				WakeupCount:              1,
+				Priorities:               []Priority{50},
 			},
 		},
 	}, {
@@ -156,6 +167,7 @@ func TestPIDSummary(t *testing.T) {
 				RunTimeNs:        50, // Running even though no events within the range.
 				WaitTimeNs:       0,
 				Pids:             []PID{100},
+				TGID:             100,
 				Commands:         []string{"Process1"},
 				Cpus:             []CPUID{1},
 				StartTimestampNs: 50,
@@ -169,6 +181,7 @@ func TestPIDSummary(t *testing.T) {
 				RunTimeNs:        0,
 				WaitTimeNs:       50,
 				Pids:             []PID{200},
+				TGID:             200,
 				Commands:         []string{"Process2"},
 				Cpus:             []CPUID{1, 2},
 				StartTimestampNs: 50,
@@ -177,18 +190,20 @@ func TestPIDSummary(t *testing.T) {
 			},
 			{
 				// Wakeup at 1090, Switch-in at 1100.
-				MigrationCount:   0,
-				UnknownTimeNs:    0,
-				RunTimeNs:        0,
-				WaitTimeNs:       10,
-				SleepTimeNs:      40, // Sleeping even though no events within the range.
-				Pids:             []PID{300},
-				Commands:         []string{"Process3"},
-				Cpus:             []CPUID{1},
-				StartTimestampNs: 50,
-				EndTimestampNs:   100,
-				WakeupCount:      1,
-				Priorities:       []Priority{50},
+				MigrationCount:           0,
+				UnknownTimeNs:            0,
+				RunTimeNs:                0,
+				WaitTimeNs:               10,
+				SleepTimeNs:              40, // Sleeping even though no events within the range.
+				InterruptibleSleepTimeNs: 40,
+				Pids:                     []PID{300},
+				TGID:                     300,
+				Commands:                 []string{"Process3"},
+				Cpus:                     []CPUID{1},
+				StartTimestampNs:         50,
+				EndTimestampNs:           100,
+				WakeupCount:              1,
+				Priorities:               []Priority{50},
 			},
 			{
 				// Switch-out at 1100.
@@ -197,6 +212,7 @@ func TestPIDSummary(t *testing.T) {
 				RunTimeNs:        50,
 				WaitTimeNs:       0,
 				Pids:             []PID{400},
+				TGID:             400,
 				Commands:         []string{"Process4"},
 				Cpus:             []CPUID{2},
 				StartTimestampNs: 50,
@@ -215,6 +231,7 @@ func TestPIDSummary(t *testing.T) {
 				RunTimeNs:        0,
 				WaitTimeNs:       20,
 				Pids:             []PID{200},
+				TGID:             200,
 				Commands:         []string{"Process2"},
 				Cpus:             []CPUID{2},
 				StartTimestampNs: 50,
@@ -228,6 +245,7 @@ func TestPIDSummary(t *testing.T) {
 				RunTimeNs:        50,
 				WaitTimeNs:       0,
 				Pids:             []PID{400},
+				TGID:             400,
 				Commands:         []string{"Process4"},
 				Cpus:             []CPUID{2},
 				StartTimestampNs: 50,
@@ -255,3 +273,31 @@ func TestPIDSummary(t *testing.T) {
 		})
 	}
 }
+
+func TestThreadSummaries_TaskStates(t *testing.T) {
+	coll := newTaskStateCollection(t)
+	metrics, err := coll.ThreadSummaries(PIDs(10, 20, 30))
+	if err != nil {
+		t.Fatalf("ThreadSummaries yielded unexpected error %s", err)
+	}
+	type taskStateTimes struct {
+		PID                                                  PID
+		WaitTimeNs, SleepTimeNs                              Duration
+		PreemptedTimeNs                                      Duration
+		InterruptibleSleepTimeNs, UninterruptibleSleepTimeNs Duration
+		StoppedTimeNs, IdleTimeNs                            Duration
+	}
+	var got []taskStateTimes
+	for _, m := range metrics {
+		got = append(got, taskStateTimes{m.Pids[0], m.WaitTimeNs, m.SleepTimeNs, m.PreemptedTimeNs,
+			m.InterruptibleSleepTimeNs, m.UninterruptibleSleepTimeNs, m.StoppedTimeNs, m.IdleTimeNs})
+	}
+	want := []taskStateTimes{
+		{PID: 10, WaitTimeNs: 10, SleepTimeNs: 40, InterruptibleSleepTimeNs: 10, UninterruptibleSleepTimeNs: 30},
+		{PID: 20, WaitTimeNs: 10, SleepTimeNs: 20, PreemptedTimeNs: 10, IdleTimeNs: 20},
+		{PID: 30, SleepTimeNs: 40, StoppedTimeNs: 40},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ThreadSummaries(): Diff -want +got:\n%s", diff)
+	}
+}
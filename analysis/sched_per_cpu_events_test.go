@@ -442,3 +442,145 @@ func TestSchedCollection(t *testing.T) {
 		})
 	}
 }
+
+// perCPUCollectionRawEvents returns the events GetRawEvents would return for
+// the provided filters, found by searching a PerCPUCollection.
+func perCPUCollectionRawEvents(tb testing.TB, c *Collection, filters ...Filter) []*trace.Event {
+	tb.Helper()
+	pcc, err := NewPerCPUCollection(c, cpuLookupFunc)
+	if err != nil {
+		tb.Fatalf("Failed to construct PerCPUCollection: %s", err)
+	}
+	var events = []*trace.Event{}
+	for _, eventIndex := range pcc.EventIndices(filters...) {
+		ev, err := pcc.Event(eventIndex)
+		if err != nil {
+			tb.Fatalf("Failed to get event %d: %s", eventIndex, err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestGetRawEvents(t *testing.T) {
+	tests := []struct {
+		description string
+		filters     []Filter
+	}{{
+		description: "no filters",
+	}, {
+		description: "time range",
+		filters:     []Filter{TimeRange(1000, 1090)},
+	}, {
+		description: "start timestamp",
+		filters:     []Filter{StartTimestamp(1010)},
+	}, {
+		description: "CPUs",
+		filters:     []Filter{CPUs(1)},
+	}, {
+		description: "migration's original CPU",
+		filters:     []Filter{CPUs(2), EventTypes("sched_migrate_task")},
+	}, {
+		description: "event types",
+		filters:     []Filter{EventTypes("sched_switch", "sched_wakeup")},
+	}, {
+		description: "time range, CPUs and event types",
+		filters:     []Filter{TimeRange(1010, 1100), CPUs(1, 2), EventTypes("sched_switch", "sched_migrate_task")},
+	}, {
+		description: "unknown event type",
+		filters:     []Filter{EventTypes("sched_unknown")},
+	}}
+	for _, normalizeTimestamps := range []bool{false, true} {
+		c, err := NewCollection(schedtestcommon.TestTrace1(t), NormalizeTimestamps(normalizeTimestamps))
+		if err != nil {
+			t.Fatalf("Failed to construct Collection: %s", err)
+		}
+		for _, test := range tests {
+			t.Run(test.description, func(t *testing.T) {
+				filters := test.filters
+				if normalizeTimestamps {
+					// Shift any time range to the normalized timestamps.
+					filters = append(filters, func(f *filter) {
+						if f.startTimestamp != UnknownTimestamp {
+							f.startTimestamp -= c.NormalizationOffset()
+						}
+						if f.endTimestamp != UnknownTimestamp {
+							f.endTimestamp -= c.NormalizationOffset()
+						}
+					})
+				}
+				got, err := c.GetRawEvents(filters...)
+				if err != nil {
+					t.Fatalf("GetRawEvents() returned unexpected error: %s", err)
+				}
+				want := perCPUCollectionRawEvents(t, c, filters...)
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("GetRawEvents() (normalized: %t): Diff -want +got:\n%s", normalizeTimestamps, diff)
+				}
+			})
+		}
+	}
+}
+
+// largeRawEventsCollection returns a collection of 200,000 events on 8 CPUs,
+// in which each CPU's two threads switch every 10 events, between which are
+// non-sched events.
+func largeRawEventsCollection(b *testing.B) *Collection {
+	const cpuCount, eventsPerCPU = 8, 25000
+	builder := schedtestcommon.UnpopulatedBuilder()
+	for i := 0; i < eventsPerCPU; i++ {
+		for cpu := int64(0); cpu < cpuCount; cpu++ {
+			ts := int64(i*100) + cpu
+			if i%10 != 0 {
+				builder.WithEvent("non_sched_event", cpu, ts, false)
+				continue
+			}
+			prevPID, nextPID := 100+2*cpu, 101+2*cpu
+			if i%20 != 0 {
+				prevPID, nextPID = nextPID, prevPID
+			}
+			builder.WithEvent("sched_switch", cpu, ts, false,
+				prevPID, "Prev", 50, schedtestcommon.Runnable,
+				nextPID, "Next", 50)
+		}
+	}
+	es, errs := builder.EventSet()
+	if len(errs) > 0 {
+		b.Fatalf("Errors building EventSet: %v", errs)
+	}
+	c, err := NewCollection(es, NormalizeTimestamps(false))
+	if err != nil {
+		b.Fatalf("Failed to construct Collection: %s", err)
+	}
+	return c
+}
+
+// rawEventsBenchmarkFilters requests the sched_switch events on one CPU over
+// 1% of the trace.
+var rawEventsBenchmarkFilters = []Filter{
+	TimeRange(1000000, 1025000),
+	CPUs(3),
+	EventTypes("sched_switch"),
+}
+
+func BenchmarkGetRawEvents(b *testing.B) {
+	c := largeRawEventsCollection(b)
+	// Build the event index before timing queries.
+	if _, err := c.GetRawEvents(rawEventsBenchmarkFilters...); err != nil {
+		b.Fatalf("GetRawEvents() returned unexpected error: %s", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.GetRawEvents(rawEventsBenchmarkFilters...); err != nil {
+			b.Fatalf("GetRawEvents() returned unexpected error: %s", err)
+		}
+	}
+}
+
+func BenchmarkGetRawEventsFromPerCPUCollection(b *testing.B) {
+	c := largeRawEventsCollection(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		perCPUCollectionRawEvents(b, c, rawEventsBenchmarkFilters...)
+	}
+}
@@ -35,8 +35,19 @@ type filter struct {
 	cpus map[CPUID]struct{}
 	// if empty, all PIDs.
 	pids map[PID]struct{}
-	// The thread states to be included.  Defaults to AnyState.
+	// If empty, all generations of the filtered-in PIDs.
+	generations map[int]struct{}
+	// If empty, all processes.  Otherwise, the TGIDs of the processes whose
+	// threads are filtered in.
+	processes map[PID]struct{}
+	// If processes is nonempty, the generations of the filtered-in PIDs that
+	// belonged to the filtered-in processes.  Populated by buildFilter.
+	processGenerations map[pidGeneration]struct{}
+	// The thread states to be included.  Defaults to all states but DeadState.
 	threadStates ThreadState
+	// If 0, all task states.  Otherwise, the task states to be included among
+	// the thread states they refine.
+	taskStates TaskState
 }
 
 // Filter specifies a filter to a sched collection query.  Filters can limit
@@ -116,12 +127,47 @@ func PIDs(pids ...PID) func(*filter) {
 	}
 }
 
+// Generations filters to the specified generations of the filtered-in PIDs,
+// overriding any previous generation filtering.  A PID's first thread is its
+// generation 0; each time the PID is reused by a new thread, the generation
+// increments.
+func Generations(generations ...int) func(*filter) {
+	return func(f *filter) {
+		f.generations = map[int]struct{}{}
+		for _, generation := range generations {
+			f.generations[generation] = struct{}{}
+		}
+	}
+}
+
+// Processes filters to the threads of the processes, or thread groups, with
+// the specified TGIDs, overriding any previous process filtering.  If PIDs
+// are also filtered, only the filtered-in PIDs in those processes are kept.
+// Of a reused PID, only the generations in those processes are kept.
+func Processes(tgids ...PID) func(*filter) {
+	return func(f *filter) {
+		f.processes = map[PID]struct{}{}
+		for _, tgid := range tgids {
+			f.processes[tgid] = struct{}{}
+		}
+	}
+}
+
 // ThreadStates filters to the specified ThreadStates, overriding any previous
 // thread state filtering.  Multiple ThreadStates may be specified by joining
-// with bitwise OR.
-func ThreadStates(threadStates ThreadState) func(*filter) {
+// with bitwise OR.  DeadState is only included if it is specified.
+// If any TaskStates are also specified, a thread state refined by any of
+// them is only included in those task states, so that, for instance,
+//   ThreadStates(RunningState|SleepingState, TaskUninterruptible)
+// includes running threads and threads in uninterruptible sleep.  Task states
+// only restrict thread spans, and so the queries built on them.
+func ThreadStates(threadStates ThreadState, taskStates ...TaskState) func(*filter) {
 	return func(f *filter) {
 		f.threadStates = threadStates
+		f.taskStates = 0
+		for _, taskState := range taskStates {
+			f.taskStates |= taskState
+		}
 	}
 }
 
@@ -144,7 +190,16 @@ func duplicateFilter(inF *filter) func(*filter) {
 		for pid := range inF.pids {
 			outF.pids[pid] = struct{}{}
 		}
+		outF.generations = map[int]struct{}{}
+		for generation := range inF.generations {
+			outF.generations[generation] = struct{}{}
+		}
+		outF.processes = map[PID]struct{}{}
+		for tgid := range inF.processes {
+			outF.processes[tgid] = struct{}{}
+		}
 		outF.threadStates = inF.threadStates
+		outF.taskStates = inF.taskStates
 	}
 }
 
@@ -157,6 +212,8 @@ func buildFilter(c *Collection, filtFuncs []Filter) *filter {
 		eventTypes:          map[string]struct{}{},
 		cpus:                map[CPUID]struct{}{},
 		pids:                map[PID]struct{}{},
+		generations:         map[int]struct{}{},
+		processes:           map[PID]struct{}{},
 		threadStates:        RunningState | WaitingState | SleepingState | UnknownState,
 	}
 	for _, ff := range filtFuncs {
@@ -187,6 +244,23 @@ func buildFilter(c *Collection, filtFuncs []Filter) *filter {
 			}
 		}
 	}
+	if len(f.processes) > 0 {
+		// f.pids may be the collection's own set, so don't modify it.
+		pids := map[PID]struct{}{}
+		f.processGenerations = map[pidGeneration]struct{}{}
+		for pid := range f.pids {
+			if pid == 0 {
+				continue
+			}
+			for generation := 0; generation <= c.lastGeneration(pid); generation++ {
+				if _, ok := f.processes[c.TGID(pid, generation)]; ok {
+					pids[pid] = struct{}{}
+					f.processGenerations[pidGeneration{pid, generation}] = struct{}{}
+				}
+			}
+		}
+		f.pids = pids
+	}
 	return f
 }
 
@@ -194,14 +268,42 @@ func buildFilter(c *Collection, filtFuncs []Filter) *filter {
 // To filter in a span s with filter f,
 //  * s's time range must overlap f's time range,
 //  * s's PID must be present in f's pid set,
+//  * s's generation must be present in f's generation set, if it is nonempty,
+//    and must have belonged to one of f's processes, if any,
 //  * s's CPU must be present in f's cpu set.
 //  * s's ThreadState must be among f's filtered-in states.
+//  * s's TaskState must be among f's filtered-in task states, if any of them
+//    refine s's ThreadState.
 func (f *filter) spanFilteredIn(span *threadSpan) bool {
 	_, inCPUs := f.cpus[span.cpu]
 	_, inPIDs := f.pids[span.pid]
 	return span.endTimestamp >= f.startTimestamp &&
 		span.startTimestamp <= f.endTimestamp &&
-		inCPUs && inPIDs && ((span.state & f.threadStates) == span.state)
+		inCPUs && inPIDs && f.generationFilteredIn(span.pid, span.generation) &&
+		((span.state & f.threadStates) == span.state) &&
+		f.taskStateFilteredIn(span.state, span.taskState)
+}
+
+// taskStateFilteredIn returns true if the receiver filters in the provided
+// task state of the provided thread state.
+func (f *filter) taskStateFilteredIn(threadState ThreadState, taskState TaskState) bool {
+	refining := f.taskStates & threadState.taskStates()
+	return refining == 0 || taskState&refining != 0
+}
+
+// generationFilteredIn returns true if the receiver filters in the provided
+// generation of the provided PID.
+func (f *filter) generationFilteredIn(pid PID, generation int) bool {
+	if f.processGenerations != nil {
+		if _, ok := f.processGenerations[pidGeneration{pid, generation}]; !ok {
+			return false
+		}
+	}
+	if len(f.generations) == 0 {
+		return true
+	}
+	_, ok := f.generations[generation]
+	return ok
 }
 
 func (f *filter) maxCPUID() CPUID {
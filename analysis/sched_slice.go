@@ -0,0 +1,309 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/clipping"
+	"github.com/google/schedviz/tracedata/merge"
+	"github.com/google/schedviz/tracedata/multihost"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// sliceEventInfo describes the CPUs and PIDs that an event affects.
+type sliceEventInfo struct {
+	cpus map[CPUID]struct{}
+	pids map[PID]struct{}
+	// True if the event switched a thread in to run on its CPU.
+	switchedIn bool
+	// The PIDs whose state, and whose CPU, the event determines.
+	statePIDs, cpuPIDs map[PID]struct{}
+}
+
+// sliceEventInfoFor returns the CPUs and PIDs affected by ev, according to the
+// thread transitions it produces.  Events that produce no transitions affect
+// their own CPU and, if they have one, their common_pid.
+func sliceEventInfoFor(el *eventLoader, ev *trace.Event) (*sliceEventInfo, error) {
+	info := &sliceEventInfo{
+		cpus:      map[CPUID]struct{}{CPUID(ev.CPU): {}},
+		pids:      map[PID]struct{}{},
+		statePIDs: map[PID]struct{}{},
+		cpuPIDs:   map[PID]struct{}{},
+	}
+	tts, err := el.threadTransitions(ev)
+	if err != nil {
+		return nil, err
+	}
+	if len(tts) == 0 {
+		if pid, ok := ev.NumberProperties["common_pid"]; ok {
+			if el.namespaceByHost {
+				host, _ := multihost.SplitCPU(ev.CPU)
+				pid = multihost.HostPID(host, pid)
+			}
+			info.pids[PID(pid)] = struct{}{}
+		}
+		return info, nil
+	}
+	for _, tt := range tts {
+		info.pids[tt.PID] = struct{}{}
+		for _, cpu := range []CPUID{tt.PrevCPU, tt.NextCPU} {
+			if cpu != UnknownCPU {
+				info.cpus[cpu] = struct{}{}
+			}
+		}
+		if tt.NextState.isKnown() {
+			info.statePIDs[tt.PID] = struct{}{}
+		}
+		if tt.NextCPU != UnknownCPU {
+			info.cpuPIDs[tt.PID] = struct{}{}
+		}
+		if tt.NextState == RunningState && tt.NextCPU == CPUID(ev.CPU) {
+			info.switchedIn = true
+		}
+	}
+	return info, nil
+}
+
+// cpuFilteredIn returns true if cpu is in set, or if set is empty.
+func cpuFilteredIn(cpu CPUID, set map[CPUID]struct{}) bool {
+	if len(set) == 0 {
+		return true
+	}
+	_, ok := set[cpu]
+	return ok
+}
+
+// pidFilteredIn returns true if pid is in set, or if set is empty.
+func pidFilteredIn(pid PID, set map[PID]struct{}) bool {
+	if len(set) == 0 {
+		return true
+	}
+	_, ok := set[pid]
+	return ok
+}
+
+// filteredIn returns true if the event described by info affects any of the
+// provided CPUs and PIDs.  Empty sets match any CPU or PID.
+func (info *sliceEventInfo) filteredIn(cpus map[CPUID]struct{}, pids map[PID]struct{}) bool {
+	cpuMatch, pidMatch := false, len(pids) == 0
+	for cpu := range info.cpus {
+		cpuMatch = cpuMatch || cpuFilteredIn(cpu, cpus)
+	}
+	for pid := range info.pids {
+		pidMatch = pidMatch || pidFilteredIn(pid, pids)
+	}
+	return cpuMatch && pidMatch
+}
+
+// switchProperties are the properties describing the threads switched out and
+// in by a sched_switch event, in corresponding order.
+var switchProperties = struct {
+	prev, next []string
+}{
+	prev: []string{"prev_pid", "prev_comm", "prev_prio"},
+	next: []string{"next_pid", "next_comm", "next_prio"},
+}
+
+// handOffSwitch rewrites the switch from so that it switches in the thread that
+// the switch to switches out.  Switches lacking the sched_switch properties
+// describing those threads are left unchanged.
+func handOffSwitch(es *eventpb.EventSet, from, to *eventpb.Event) {
+	propertyIndices := func(ev *eventpb.Event, names []string) ([]int, bool) {
+		if ev.EventDescriptor < 0 || ev.EventDescriptor >= int64(len(es.EventDescriptor)) {
+			return nil, false
+		}
+		byName := map[string]int{}
+		for idx, pd := range es.EventDescriptor[ev.EventDescriptor].PropertyDescriptor {
+			if pd.Name >= 0 && pd.Name < int64(len(es.StringTable)) && idx < len(ev.Property) {
+				byName[es.StringTable[pd.Name]] = idx
+			}
+		}
+		var ret []int
+		for _, name := range names {
+			idx, ok := byName[name]
+			if !ok {
+				return nil, false
+			}
+			ret = append(ret, idx)
+		}
+		return ret, true
+	}
+	nextIdxs, ok := propertyIndices(from, switchProperties.next)
+	if !ok {
+		return
+	}
+	prevIdxs, ok := propertyIndices(to, switchProperties.prev)
+	if !ok {
+		return
+	}
+	for i := range nextIdxs {
+		from.Property[nextIdxs[i]] = to.Property[prevIdxs[i]]
+	}
+}
+
+// Slice returns a new EventSet containing the events of the collection that
+// fall within the filtered-in time range and affect the filtered-in CPUs and
+// PIDs, for instance to save an interesting window of a long trace as its own
+// collection.  Timestamps in the returned EventSet are not normalized.
+// So that the same thread and CPU states are inferred at the start of the
+// slice as in the collection, the slice is preceded by context events: for
+// each filtered-in CPU, the last event before the slice that switched a thread
+// in on it, and for each filtered-in PID, the last events before the slice that
+// determined its state and its CPU.  Context events keep their order, but are
+// moved to just before the slice's start, one ns apart, so that the slice
+// doesn't span the time between the context events and the slice.  Since the
+// switches between context switches on a CPU are omitted, each context switch
+// that is followed by another on its CPU is rewritten to switch in the thread
+// that the next one switches out.  The slice
+// ends at its last event, so states after it are not inferred.
+// The returned EventSet's string table only contains the strings that its
+// events and event descriptors refer to.
+// FILTERS:
+//   TimeRange, StartTimestamp, EndTimestamp: Only events within the
+//       filtered-in range are sliced.
+//   CPUs: Only events affecting the filtered-in CPUs are sliced.  If
+//       unspecified, all CPUs are.
+//   PIDs: Only events affecting the filtered-in PIDs are sliced.  If
+//       unspecified, all PIDs are.
+func (c *Collection) Slice(filters ...Filter) (*eventpb.EventSet, error) {
+	f := buildFilter(c, filters)
+	// buildFilter populates unspecified CPU and PID sets with the collection's,
+	// which only include CPUs and PIDs with spans; an empty set matches any.
+	requested := &filter{cpus: map[CPUID]struct{}{}, pids: map[PID]struct{}{}}
+	for _, ff := range filters {
+		ff(requested)
+	}
+	if f.startTimestamp > f.endTimestamp {
+		return nil, status.Errorf(codes.InvalidArgument, "slice start %d is after its end %d", f.startTimestamp, f.endTimestamp)
+	}
+	startTS := f.startTimestamp + c.normalizationOffset
+	endTS := f.endTimestamp + c.normalizationOffset
+
+	el, err := newEventLoader(c.options.loaders, newStringBank())
+	if err != nil {
+		return nil, err
+	}
+	el.namespaceByHost = c.options.namespaceByHost
+	es := c.TraceCollection.Header()
+	// Indices of the candidate context events, keyed by the CPU or PID they
+	// provide context for.
+	cpuContext := map[CPUID]int{}
+	pidStateContext := map[PID]int{}
+	pidCPUContext := map[PID]int{}
+	// The CPUs on which candidate context events switched threads in.
+	switchCPUs := map[int]CPUID{}
+	var sliced []*eventpb.Event
+	for eventIndex := 0; eventIndex < c.TraceCollection.EventCount(); eventIndex++ {
+		ev, err := c.TraceCollection.EventByIndex(eventIndex)
+		if err != nil {
+			return nil, err
+		}
+		if ev.Timestamp > endTS {
+			break
+		}
+		info, err := sliceEventInfoFor(el, ev)
+		if err != nil {
+			return nil, err
+		}
+		if ev.Timestamp < startTS {
+			if ev.Clipped || ev.Name == clipping.LostEventsEventName {
+				continue
+			}
+			if info.switchedIn {
+				cpuContext[CPUID(ev.CPU)] = eventIndex
+				switchCPUs[eventIndex] = CPUID(ev.CPU)
+			}
+			for pid := range info.statePIDs {
+				if pidFilteredIn(pid, requested.pids) {
+					pidStateContext[pid] = eventIndex
+				}
+			}
+			for pid := range info.cpuPIDs {
+				if pidFilteredIn(pid, requested.pids) {
+					pidCPUContext[pid] = eventIndex
+				}
+			}
+			continue
+		}
+		// Lost events are kept regardless of PIDs, since they may have affected any.
+		if ev.Name == clipping.LostEventsEventName {
+			if !info.filteredIn(requested.cpus, nil) {
+				continue
+			}
+		} else if !info.filteredIn(requested.cpus, requested.pids) {
+			continue
+		}
+		rawEv, err := c.TraceCollection.RawEvent(eventIndex)
+		if err != nil {
+			return nil, err
+		}
+		sliced = append(sliced, proto.Clone(rawEv).(*eventpb.Event))
+	}
+
+	contextIndices := map[int]struct{}{}
+	for cpu, idx := range cpuContext {
+		if cpuFilteredIn(cpu, requested.cpus) {
+			contextIndices[idx] = struct{}{}
+		}
+	}
+	for _, pidContext := range []map[PID]int{pidStateContext, pidCPUContext} {
+		for _, idx := range pidContext {
+			contextIndices[idx] = struct{}{}
+		}
+	}
+	var sortedContextIndices []int
+	for idx := range contextIndices {
+		sortedContextIndices = append(sortedContextIndices, idx)
+	}
+	sort.Ints(sortedContextIndices)
+	var context []*eventpb.Event
+	// The last context switch on each CPU.
+	lastSwitches := map[CPUID]*eventpb.Event{}
+	for i, idx := range sortedContextIndices {
+		rawEv, err := c.TraceCollection.RawEvent(idx)
+		if err != nil {
+			return nil, err
+		}
+		ev := proto.Clone(rawEv).(*eventpb.Event)
+		ev.TimestampNs = int64(startTS) - int64(len(sortedContextIndices)-i)
+		if cpu, ok := switchCPUs[idx]; ok {
+			// Context events are squeezed together, so the previous context switch
+			// on this CPU must hand the CPU to the thread that this one switches
+			// out, or they would conflict.
+			if last, ok := lastSwitches[cpu]; ok {
+				handOffSwitch(es, last, ev)
+			}
+			lastSwitches[cpu] = ev
+		}
+		context = append(context, ev)
+	}
+
+	// Merging a single EventSet compacts its string table.
+	return merge.EventSets(&eventpb.EventSet{
+		StringTable:        es.StringTable,
+		EventDescriptor:    es.EventDescriptor,
+		Event:              append(context, sliced...),
+		DefaultLoadersType: es.DefaultLoadersType,
+		TraceClock:         es.TraceClock,
+		ThreadGroup:        es.ThreadGroup,
+	})
+}
@@ -0,0 +1,180 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/analysis/schedtestcommon"
+	"github.com/google/schedviz/tracedata/scenario"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// describeIntervals renders intervals as strings, omitting dropped event IDs,
+// which differ between a collection and its slices.
+func describeIntervals(intervals []*Interval) []string {
+	var ret []string
+	for _, interval := range intervals {
+		for _, tr := range interval.ThreadResidencies {
+			ret = append(ret, fmt.Sprintf("%d+%d %s PID %d %s", interval.StartTimestamp, interval.Duration, interval.CPU, tr.Thread.PID, tr.State))
+		}
+	}
+	return ret
+}
+
+func TestSlice_PreservesThreadStates(t *testing.T) {
+	coll, err := NewCollection(schedtestcommon.TestTrace1(t))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	windows := []struct {
+		start, end trace.Timestamp
+	}{{1005, 1095}, {1050, 1100}, {1085, 1100}}
+	for _, window := range windows {
+		for _, pid := range []PID{100, 200, 300} {
+			t.Run(fmt.Sprintf("[%d, %d] PID %d", window.start, window.end, pid), func(t *testing.T) {
+				es, err := coll.Slice(TimeRange(window.start, window.end), PIDs(pid))
+				if err != nil {
+					t.Fatalf("Slice yielded unexpected error %s", err)
+				}
+				sliceColl, err := NewCollection(es)
+				if err != nil {
+					t.Fatalf("Unexpected slice collection creation error %s", err)
+				}
+				// The slice ends at its last event.
+				end := trace.Timestamp(es.Event[len(es.Event)-1].TimestampNs)
+				filters := []Filter{TimeRange(window.start, end), PIDs(pid), TruncateToTimeRange(true)}
+				want, err := coll.ThreadIntervals(filters...)
+				if err != nil {
+					t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+				}
+				got, err := sliceColl.ThreadIntervals(filters...)
+				if err != nil {
+					t.Fatalf("ThreadIntervals on slice yielded unexpected error %s", err)
+				}
+				if diff := cmp.Diff(describeIntervals(want), describeIntervals(got)); diff != "" {
+					t.Errorf("ThreadIntervals on slice: Diff -want +got:\n%s", diff)
+				}
+			})
+		}
+	}
+}
+
+// PID 10 blocks on IO, and PID 20 is then preempted on CPU 0, and moves to
+// CPU 1, where it preempts PID 40.  The states of PIDs 10 and 40 at 1035 were
+// set by switches that weren't the last on their CPUs.
+const sliceContextScenario = `
+1000 cpu0 switch prev=0:R next=10 next_comm=db
+1000 cpu1 switch prev=0:R next=40 next_comm=app
+1010 cpu0 switch prev=10:D prev_comm=db next=20 next_comm=kworker
+1020 cpu0 switch prev=20:R+ prev_comm=kworker next=30 next_comm=tool
+1022 cpu1 migrate pid=20 comm=kworker orig_cpu=0 dest_cpu=1
+1025 cpu1 switch prev=40:R+ prev_comm=app next=20 next_comm=kworker
+1030 cpu0 switch prev=30:T prev_comm=tool next=0
+1040 cpu1 wakeup pid=10 comm=db target_cpu=0
+1050 cpu0 switch prev=0:R next=10 next_comm=db
+1060 cpu1 switch prev=20:S prev_comm=kworker next=40 next_comm=app
+1070 cpu0 switch prev=10:S prev_comm=db next=0
+1080 cpu1 switch prev=40:S prev_comm=app next=0
+`
+
+func TestSlice_ContextFromEarlierSwitches(t *testing.T) {
+	es, err := scenario.Parse(strings.NewReader(sliceContextScenario))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	coll, err := NewCollection(es, NormalizeTimestamps(false))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	tests := []struct {
+		filters []Filter
+		pids    []PID
+	}{
+		{filters: nil, pids: []PID{10, 20, 30, 40}},
+		{filters: []Filter{PIDs(10)}, pids: []PID{10}},
+		{filters: []Filter{PIDs(40)}, pids: []PID{40}},
+		{filters: []Filter{CPUs(0)}, pids: []PID{10, 30}},
+	}
+	for i, test := range tests {
+		sliceES, err := coll.Slice(append(test.filters, TimeRange(1035, 1080))...)
+		if err != nil {
+			t.Fatalf("Slice yielded unexpected error %s", err)
+		}
+		sliceColl, err := NewCollection(sliceES, NormalizeTimestamps(false))
+		if err != nil {
+			t.Fatalf("Unexpected slice collection creation error %s", err)
+		}
+		// The slice ends at its last event.
+		end := trace.Timestamp(sliceES.Event[len(sliceES.Event)-1].TimestampNs)
+		for _, pid := range test.pids {
+			filters := []Filter{TimeRange(1035, end), PIDs(pid), TruncateToTimeRange(true)}
+			want, err := coll.ThreadIntervals(filters...)
+			if err != nil {
+				t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+			}
+			got, err := sliceColl.ThreadIntervals(filters...)
+			if err != nil {
+				t.Fatalf("ThreadIntervals on slice yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(describeTaskStates(want), describeTaskStates(got)); diff != "" {
+				t.Errorf("ThreadIntervals on slice %d of PID %d: Diff -want +got:\n%s", i, pid, diff)
+			}
+		}
+	}
+}
+
+func TestSlice_CPUs(t *testing.T) {
+	coll, err := NewCollection(schedtestcommon.TestTrace1(t))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	es, err := coll.Slice(TimeRange(1050, 1100), CPUs(2))
+	if err != nil {
+		t.Fatalf("Slice yielded unexpected error %s", err)
+	}
+	var got []string
+	for _, ev := range es.Event {
+		got = append(got, fmt.Sprintf("%s CPU %d at %d", es.StringTable[es.EventDescriptor[ev.EventDescriptor].Name], ev.Cpu, ev.TimestampNs))
+	}
+	want := []string{
+		// Context for PIDs 100 and 300, moved to just before the slice.
+		"sched_switch CPU 1 at 1048",
+		// Context for PID 200.
+		"sched_wakeup CPU 0 at 1049",
+		"sched_migrate_task CPU 0 at 1080",
+		"sched_switch CPU 2 at 1100",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Slice: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestSlice_InvalidTimeRange(t *testing.T) {
+	coll, err := NewCollection(schedtestcommon.TestTrace1(t))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	if _, err := coll.Slice(StartTimestamp(1100), EndTimestamp(1000)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Slice with end before start yielded %v, want InvalidArgument", err)
+	}
+}
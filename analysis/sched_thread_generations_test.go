@@ -0,0 +1,226 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/schedviz/tracedata/scenario"
+)
+
+// PID 30 runs as alpha, then, after over a second unobserved, is woken as
+// beta.  No fork of beta was traced.
+const reusedWithoutForkScenario = `
+1000 cpu0 switch prev=0:R next=30 next_comm=alpha
+2000 cpu0 switch prev=30:S prev_comm=alpha next=0
+1000003000 cpu0 wakeup pid=30 comm=beta target_cpu=0
+1000004000 cpu0 switch prev=0:R next=30 next_comm=beta
+1000005000 cpu0 switch prev=30:S prev_comm=beta next=0
+`
+
+// describeGenerationIntervals is like describeIntervals, but also describes
+// the PID generation of each residency.
+func describeGenerationIntervals(intervals []*Interval) []string {
+	var ret []string
+	for _, interval := range intervals {
+		for _, tr := range interval.ThreadResidencies {
+			ret = append(ret, fmt.Sprintf("%d+%d %s PID %d.%d %s", interval.StartTimestamp, interval.Duration, interval.CPU, tr.Thread.PID, tr.Thread.Generation, tr.State))
+		}
+	}
+	return ret
+}
+
+func TestThreadGenerations_Fork(t *testing.T) {
+	coll := newLifecycleCollection(t, NormalizeTimestamps(false), PreciseCommands(true))
+	tests := []struct {
+		description string
+		filters     []Filter
+		want        []*ThreadGeneration
+	}{{
+		description: "all generations",
+		filters:     []Filter{PIDs(20)},
+		want: []*ThreadGeneration{
+			{PID: 20, Generation: 0, Commands: []string{"shell", "true"}, StartTimestamp: 1010, EndTimestamp: 1060},
+			{PID: 20, Generation: 1, Commands: []string{"shell"}, StartTimestamp: 1080, EndTimestamp: 1090},
+		},
+	}, {
+		description: "generation filter",
+		filters:     []Filter{PIDs(20), Generations(1)},
+		want: []*ThreadGeneration{
+			{PID: 20, Generation: 1, Commands: []string{"shell"}, StartTimestamp: 1080, EndTimestamp: 1090},
+		},
+	}, {
+		description: "time filter",
+		filters:     []Filter{PIDs(20), TimeRange(1000, 1050)},
+		want: []*ThreadGeneration{
+			{PID: 20, Generation: 0, Commands: []string{"shell", "true"}, StartTimestamp: 1010, EndTimestamp: 1060},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := coll.ThreadGenerations(test.filters...)
+			if err != nil {
+				t.Fatalf("ThreadGenerations yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ThreadGenerations(): Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+	intervals, err := coll.ThreadIntervals(PIDs(20), Generations(1))
+	if err != nil {
+		t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+	}
+	wantIntervals := []string{
+		"1080+5 CPU   0 PID 20.1 Sleeping",
+		"1085+5 CPU   0 PID 20.1 Waiting",
+	}
+	if diff := cmp.Diff(wantIntervals, describeGenerationIntervals(intervals)); diff != "" {
+		t.Errorf("ThreadIntervals(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestThreadSummaries_Generations(t *testing.T) {
+	coll := newLifecycleCollection(t, NormalizeTimestamps(false), PreciseCommands(true))
+	summaries, err := coll.ThreadSummaries()
+	if err != nil {
+		t.Fatalf("ThreadSummaries yielded unexpected error %s", err)
+	}
+	type summary struct {
+		PID                                PID
+		Generation                         int
+		RunTimeNs, WaitTimeNs, SleepTimeNs Duration
+	}
+	var got []summary
+	for _, m := range summaries {
+		if len(m.Pids) != 1 {
+			t.Fatalf("ThreadSummaries() returned a summary of PIDs %v, want a single PID", m.Pids)
+		}
+		if m.Pids[0] != 20 {
+			continue
+		}
+		got = append(got, summary{m.Pids[0], m.Generation, m.RunTimeNs, m.WaitTimeNs, m.SleepTimeNs})
+	}
+	want := []summary{
+		{PID: 20, Generation: 0, RunTimeNs: 30, WaitTimeNs: 10, SleepTimeNs: 10},
+		{PID: 20, Generation: 1, RunTimeNs: 0, WaitTimeNs: 5, SleepTimeNs: 5},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ThreadSummaries(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestThreadStats_Generations(t *testing.T) {
+	coll := newLifecycleCollection(t, NormalizeTimestamps(false))
+	got, err := coll.ThreadStats(PIDs(20))
+	if err != nil {
+		t.Fatalf("ThreadStats yielded unexpected error %s", err)
+	}
+	// PID 20's second thread starts on CPU 0, where the first never ran, but
+	// that is not a migration.
+	want := &ThreadStatistics{
+		Wakeups:            2,
+		RunTime:            30,
+		WaitTime:           15,
+		SleepTime:          15,
+		PostWakeupWaitTime: 15,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ThreadStats(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestAntagonists_Generations(t *testing.T) {
+	coll := newLifecycleCollection(t, NormalizeTimestamps(false))
+	got, err := coll.Antagonists(PIDs(20), Generations(1))
+	if err != nil {
+		t.Fatalf("Antagonists yielded unexpected error %s", err)
+	}
+	want := Antagonists{
+		Victims: []*Thread{{PID: 20, Command: "shell", Priority: 0, Generation: 1}},
+		Antagonisms: []*Antagonism{{
+			RunningThread:  &Thread{PID: 10, Command: "shell", Priority: 0},
+			CPU:            0,
+			StartTimestamp: 1085,
+			EndTimestamp:   1090,
+		}},
+		StartTimestamp: 1000,
+		EndTimestamp:   1090,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Antagonists(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestThreadGenerations_CommandChangeAfterAbsence(t *testing.T) {
+	tests := []struct {
+		description string
+		options     []Option
+		want        []*ThreadGeneration
+	}{{
+		description: "default absence",
+		want: []*ThreadGeneration{
+			{PID: 30, Generation: 0, Commands: []string{"alpha"}, StartTimestamp: 1000, EndTimestamp: 1000003000},
+			{PID: 30, Generation: 1, Commands: []string{"beta"}, StartTimestamp: 1000003000, EndTimestamp: 1000005000},
+		},
+	}, {
+		description: "longer absence",
+		options:     []Option{PIDReuseAbsence(2000000000)},
+		want: []*ThreadGeneration{
+			{PID: 30, Generation: 0, Commands: []string{"alpha", "beta"}, StartTimestamp: 1000, EndTimestamp: 1000005000},
+		},
+	}, {
+		description: "heuristic disabled",
+		options:     []Option{PIDReuseAbsence(0)},
+		want: []*ThreadGeneration{
+			{PID: 30, Generation: 0, Commands: []string{"alpha", "beta"}, StartTimestamp: 1000, EndTimestamp: 1000005000},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			es, err := scenario.Parse(strings.NewReader(reusedWithoutForkScenario))
+			if err != nil {
+				t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+			}
+			options := append([]Option{NormalizeTimestamps(false), PreciseCommands(true)}, test.options...)
+			coll, err := NewCollection(es, options...)
+			if err != nil {
+				t.Fatalf("Unexpected collection creation error %s", err)
+			}
+			got, err := coll.ThreadGenerations()
+			if err != nil {
+				t.Fatalf("ThreadGenerations yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ThreadGenerations(): Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPIDReuseAbsence_Negative(t *testing.T) {
+	es, err := scenario.Parse(strings.NewReader(reusedWithoutForkScenario))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	if _, err := NewCollection(es, PIDReuseAbsence(-1)); err == nil {
+		t.Errorf("NewCollection(PIDReuseAbsence(-1)) succeeded, want an error")
+	}
+}
@@ -0,0 +1,119 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"sort"
+
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// pidGeneration identifies one generation of a PID: one of the threads to
+// have had that PID during the trace.
+type pidGeneration struct {
+	pid        PID
+	generation int
+}
+
+// forkedThread is a thread whose sched_process_fork event reported its TGID.
+// The timestamp is the fork's, unnormalized.
+type forkedThread struct {
+	pid, tgid PID
+	timestamp trace.Timestamp
+}
+
+// recordThreadGroups records the TGIDs of the collection's threads, once the
+// provided threadSpanSet has assigned the generations of their PIDs.  The
+// thread group snapshot in the provided EventSet header is taken at the end
+// of tracing, so it applies to the last generation of each PID it lists; in
+// combined multi-host traces, these PIDs are already namespaced.  If a
+// nonstandard tracer reported a TGID on a fork (see ForkData.ChildTGID), it
+// applies to the generation that the fork began, and takes precedence.
+func (c *Collection) recordThreadGroups(header *eventpb.EventSet, ts *threadSpanSet) {
+	record := func(pid, tgid PID) {
+		c.tgidByGeneration[pidGeneration{pid, ts.generationByPID[pid]}] = tgid
+	}
+	for _, group := range header.GetThreadGroup() {
+		tgid := PID(group.GetTgid())
+		record(tgid, tgid)
+		for _, pid := range group.GetPid() {
+			record(PID(pid), tgid)
+		}
+	}
+	for _, ft := range c.forkedThreads {
+		generation := ts.generationAt(ft.pid, ft.timestamp-c.normalizationOffset)
+		c.tgidByGeneration[pidGeneration{ft.pid, generation}] = ft.tgid
+	}
+	c.forkedThreads = nil
+}
+
+// TGID returns the TGID of the process, or thread group, that the provided
+// generation of the provided PID belonged to.  Thread groups are taken from
+// the trace's thread group snapshot, which describes the threads alive at the
+// end of the trace.  A thread whose thread group is not known is treated as a
+// single-threaded process, and is its own TGID.
+func (c *Collection) TGID(pid PID, generation int) PID {
+	if tgid, ok := c.tgidByGeneration[pidGeneration{pid, generation}]; ok {
+		return tgid
+	}
+	return pid
+}
+
+// lastGeneration returns the latest generation of the provided PID.
+func (c *Collection) lastGeneration(pid PID) int {
+	spans := c.spansByPID[pid]
+	if len(spans) == 0 {
+		return 0
+	}
+	return spans[len(spans)-1].generation
+}
+
+// ThreadGroups returns the PIDs observed in the collection, grouped by the
+// TGID of their process.  A reused PID is in the group of each process that
+// any of its filtered-in generations belonged to.  Each group's PIDs are
+// sorted.  PID 0, the idle task, is not in any group.
+// FILTERS:
+//   PIDs: Only the filtered-in PIDs are included.
+//   Generations: Only the filtered-in generations of each PID are grouped.
+//   Processes: Only the PIDs of the filtered-in processes are included.
+func (c *Collection) ThreadGroups(filters ...Filter) (map[PID][]PID, error) {
+	f := buildFilter(c, filters)
+	ret := map[PID][]PID{}
+	for pid := range f.pids {
+		if pid == 0 {
+			continue
+		}
+		grouped := map[PID]bool{}
+		for generation := 0; generation <= c.lastGeneration(pid); generation++ {
+			if !f.generationFilteredIn(pid, generation) {
+				continue
+			}
+			tgid := c.TGID(pid, generation)
+			if !grouped[tgid] {
+				grouped[tgid] = true
+				ret[tgid] = append(ret[tgid], pid)
+			}
+		}
+	}
+	for _, pids := range ret {
+		sort.Slice(pids, func(a, b int) bool {
+			return pids[a] < pids[b]
+		})
+	}
+	return ret, nil
+}
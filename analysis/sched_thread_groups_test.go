@@ -0,0 +1,290 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/schedviz/tracedata/scenario"
+)
+
+// Process 10, an app, has threads 10 and 11, as its thread group snapshot
+// records.  Process 20, a database, forks thread 21, and the fork reports its
+// TGID, as a nonstandard tracer's may.  Thread 30 is in no known thread
+// group.  Thread 10 is preempted by its sibling, thread 11, and then waits
+// behind thread 21.
+const processScenario = `
+event sched_process_fork parent_comm:text parent_pid:number child_comm:text child_pid:number child_tgid:number
+process 10 11
+1000 cpu0 switch prev=0:R next=10 next_comm=app
+1000 cpu1 switch prev=0:R next=20 next_comm=db
+1010 cpu1 fork parent_pid=20 parent_comm=db child_pid=21 child_comm=db child_tgid=20
+1020 cpu0 wakeup pid=11 comm=app target_cpu=0
+1030 cpu0 switch prev=10:R prev_comm=app next=11 next_comm=app
+1040 cpu0 wakeup_new pid=21 comm=db target_cpu=0
+1050 cpu0 switch prev=11:S prev_comm=app next=21 next_comm=db
+1060 cpu0 switch prev=21:S prev_comm=db next=10 next_comm=app
+1070 cpu1 switch prev=20:S prev_comm=db next=30 next_comm=tool
+1080 cpu1 switch prev=30:S prev_comm=tool next=0
+`
+
+func newProcessCollection(t *testing.T) *Collection {
+	t.Helper()
+	es, err := scenario.Parse(strings.NewReader(processScenario))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	coll, err := NewCollection(es, NormalizeTimestamps(false))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	return coll
+}
+
+func TestThreadGroups(t *testing.T) {
+	coll := newProcessCollection(t)
+	for pid, want := range map[PID]PID{10: 10, 11: 10, 20: 20, 21: 20, 30: 30} {
+		if got := coll.TGID(pid, 0); got != want {
+			t.Errorf("TGID(%d, 0) = %d, want %d", pid, got, want)
+		}
+	}
+	tests := []struct {
+		description string
+		filters     []Filter
+		want        map[PID][]PID
+	}{{
+		description: "all processes",
+		want:        map[PID][]PID{10: {10, 11}, 20: {20, 21}, 30: {30}},
+	}, {
+		description: "process filter",
+		filters:     []Filter{Processes(20, 30)},
+		want:        map[PID][]PID{20: {20, 21}, 30: {30}},
+	}, {
+		description: "process and PID filters",
+		filters:     []Filter{Processes(10), PIDs(11, 21)},
+		want:        map[PID][]PID{10: {11}},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := coll.ThreadGroups(test.filters...)
+			if err != nil {
+				t.Fatalf("ThreadGroups yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ThreadGroups(): Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+// Process 50, a server, forks thread 40, which exits and is freed.  Process
+// 60, a batch job, then forks a new thread 40, which the thread group
+// snapshot, taken at the end of the trace, places in process 60.
+const reusedPIDProcessScenario = `
+event sched_process_fork parent_comm:text parent_pid:number child_comm:text child_pid:number child_tgid:number
+process 60 40
+1000 cpu0 switch prev=0:R next=50 next_comm=server
+1000 cpu1 switch prev=0:R next=60 next_comm=batch
+1010 cpu0 fork parent_pid=50 parent_comm=server child_pid=40 child_comm=server child_tgid=50
+1020 cpu0 switch prev=50:S prev_comm=server next=40 next_comm=server
+1030 cpu0 exit pid=40 comm=server
+1040 cpu0 switch prev=40:X prev_comm=server next=0
+1050 cpu0 free pid=40 comm=server
+1060 cpu1 fork parent_pid=60 parent_comm=batch child_pid=40 child_comm=batch
+1070 cpu1 switch prev=60:S prev_comm=batch next=40 next_comm=batch
+1080 cpu1 switch prev=40:S prev_comm=batch next=0
+`
+
+func TestThreadGroups_ReusedPID(t *testing.T) {
+	es, err := scenario.Parse(strings.NewReader(reusedPIDProcessScenario))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	coll, err := NewCollection(es, NormalizeTimestamps(false))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	for pg, want := range map[pidGeneration]PID{{40, 0}: 50, {40, 1}: 60, {50, 0}: 50, {60, 0}: 60} {
+		if got := coll.TGID(pg.pid, pg.generation); got != want {
+			t.Errorf("TGID(%d, %d) = %d, want %d", pg.pid, pg.generation, got, want)
+		}
+	}
+	tests := []struct {
+		description string
+		filters     []Filter
+		want        map[PID][]PID
+	}{{
+		description: "all processes",
+		want:        map[PID][]PID{50: {40, 50}, 60: {40, 60}},
+	}, {
+		description: "process filter",
+		filters:     []Filter{Processes(50)},
+		want:        map[PID][]PID{50: {40, 50}},
+	}, {
+		description: "generation filter",
+		filters:     []Filter{PIDs(40), Generations(1)},
+		want:        map[PID][]PID{60: {40}},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := coll.ThreadGroups(test.filters...)
+			if err != nil {
+				t.Fatalf("ThreadGroups yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ThreadGroups(): Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+	threadSummaries, err := coll.ThreadSummaries(PIDs(40))
+	if err != nil {
+		t.Fatalf("ThreadSummaries yielded unexpected error %s", err)
+	}
+	wantThreads := []summary{
+		{TGID: 50, Pids: []PID{40}, RunTimeNs: 20, SleepTimeNs: 10, WakeupCount: 1},
+		{TGID: 60, Pids: []PID{40}, RunTimeNs: 10, SleepTimeNs: 10, WakeupCount: 1},
+	}
+	if diff := cmp.Diff(wantThreads, summarize(threadSummaries)); diff != "" {
+		t.Errorf("ThreadSummaries(): Diff -want +got:\n%s", diff)
+	}
+	// Each process is summarized with only its own generation of PID 40.
+	processSummaries, err := coll.ProcessSummaries(PIDs(40))
+	if err != nil {
+		t.Fatalf("ProcessSummaries yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(wantThreads, summarize(processSummaries)); diff != "" {
+		t.Errorf("ProcessSummaries(): Diff -want +got:\n%s", diff)
+	}
+}
+
+// summary holds the fields of a Metrics that process tests check.
+type summary struct {
+	TGID                               PID
+	Pids                               []PID
+	RunTimeNs, WaitTimeNs, SleepTimeNs Duration
+	WakeupCount                        int
+}
+
+func summarize(metrics []*Metrics) []summary {
+	var ret []summary
+	for _, m := range metrics {
+		ret = append(ret, summary{m.TGID, m.Pids, m.RunTimeNs, m.WaitTimeNs, m.SleepTimeNs, m.WakeupCount})
+	}
+	return ret
+}
+
+func TestProcessSummaries(t *testing.T) {
+	coll := newProcessCollection(t)
+	threadSummaries, err := coll.ThreadSummaries(Processes(10))
+	if err != nil {
+		t.Fatalf("ThreadSummaries yielded unexpected error %s", err)
+	}
+	wantThreads := []summary{
+		{TGID: 10, Pids: []PID{10}, RunTimeNs: 50, WaitTimeNs: 30},
+		{TGID: 10, Pids: []PID{11}, RunTimeNs: 20, WaitTimeNs: 10, SleepTimeNs: 50, WakeupCount: 1},
+	}
+	if diff := cmp.Diff(wantThreads, summarize(threadSummaries)); diff != "" {
+		t.Errorf("ThreadSummaries(): Diff -want +got:\n%s", diff)
+	}
+	processSummaries, err := coll.ProcessSummaries(Processes(10, 20))
+	if err != nil {
+		t.Fatalf("ProcessSummaries yielded unexpected error %s", err)
+	}
+	wantProcesses := []summary{
+		{TGID: 10, Pids: []PID{10, 11}, RunTimeNs: 70, WaitTimeNs: 40, SleepTimeNs: 50, WakeupCount: 1},
+		{TGID: 20, Pids: []PID{20, 21}, RunTimeNs: 80, WaitTimeNs: 10, SleepTimeNs: 60, WakeupCount: 1},
+	}
+	if diff := cmp.Diff(wantProcesses, summarize(processSummaries)); diff != "" {
+		t.Errorf("ProcessSummaries(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestProcessStats(t *testing.T) {
+	coll := newProcessCollection(t)
+	got, err := coll.ProcessStats(Processes(10, 30))
+	if err != nil {
+		t.Fatalf("ProcessStats yielded unexpected error %s", err)
+	}
+	app, err := coll.ThreadStats(PIDs(10, 11))
+	if err != nil {
+		t.Fatalf("ThreadStats yielded unexpected error %s", err)
+	}
+	tool, err := coll.ThreadStats(PIDs(30))
+	if err != nil {
+		t.Fatalf("ThreadStats yielded unexpected error %s", err)
+	}
+	want := map[PID]*ThreadStatistics{10: app, 30: tool}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ProcessStats(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestProcessAntagonists(t *testing.T) {
+	coll := newProcessCollection(t)
+	app10 := &Thread{PID: 10, Command: "app"}
+	app11 := &Thread{PID: 11, Command: "app"}
+	db21 := &Thread{PID: 21, Command: "db"}
+	// Thread 10's sibling, thread 11, is not its antagonist, but thread 21 is.
+	got, err := coll.ProcessAntagonists(Processes(10))
+	if err != nil {
+		t.Fatalf("ProcessAntagonists yielded unexpected error %s", err)
+	}
+	want := Antagonists{
+		Victims: []*Thread{app10, app11},
+		Antagonisms: []*Antagonism{{
+			RunningThread:  db21,
+			Victim:         app10,
+			CPU:            0,
+			StartTimestamp: 1050,
+			EndTimestamp:   1060,
+		}},
+		StartTimestamp: 1000,
+		EndTimestamp:   1080,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ProcessAntagonists(): Diff -want +got:\n%s", diff)
+	}
+	// As a single thread, thread 10 is antagonized by both.
+	got, err = coll.Antagonists(PIDs(10))
+	if err != nil {
+		t.Fatalf("Antagonists yielded unexpected error %s", err)
+	}
+	want = Antagonists{
+		Victims: []*Thread{app10},
+		Antagonisms: []*Antagonism{{
+			RunningThread:  app11,
+			CPU:            0,
+			StartTimestamp: 1030,
+			EndTimestamp:   1050,
+		}, {
+			RunningThread:  db21,
+			CPU:            0,
+			StartTimestamp: 1050,
+			EndTimestamp:   1060,
+		}},
+		StartTimestamp: 1000,
+		EndTimestamp:   1080,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Antagonists(): Diff -want +got:\n%s", diff)
+	}
+	if _, err := coll.ProcessAntagonists(Processes(10, 20)); err == nil {
+		t.Errorf("ProcessAntagonists() of two processes returned no error, want one")
+	}
+}
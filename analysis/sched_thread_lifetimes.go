@@ -0,0 +1,209 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"sort"
+
+	"github.com/google/schedviz/tracedata/multihost"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// newThreadLifetime returns a new ThreadLifetime for the provided PID, with
+// nothing yet known about it.
+func newThreadLifetime(pid PID) *ThreadLifetime {
+	return &ThreadLifetime{
+		PID:           pid,
+		ParentPID:     UnknownPID,
+		ChildPIDs:     []PID{},
+		ForkTimestamp: UnknownTimestamp,
+		ExitTimestamp: UnknownTimestamp,
+		FreeTimestamp: UnknownTimestamp,
+		Execs:         []*Exec{},
+	}
+}
+
+// startTimestamp returns the timestamp at which the receiver began, or
+// UnknownTimestamp if its fork was not traced.
+func (tl *ThreadLifetime) startTimestamp() trace.Timestamp {
+	return tl.ForkTimestamp
+}
+
+// endTimestamp returns the timestamp at which the receiver ended, or
+// UnknownTimestamp if neither its exit nor its free was traced.
+func (tl *ThreadLifetime) endTimestamp() trace.Timestamp {
+	if tl.FreeTimestamp != UnknownTimestamp {
+		return tl.FreeTimestamp
+	}
+	return tl.ExitTimestamp
+}
+
+// lifetime returns the latest ThreadLifetime of the provided PID.  If there is
+// none, or if done reports that the latest one is over, a new one is started.
+func (c *Collection) lifetime(pid PID, done func(*ThreadLifetime) bool) *ThreadLifetime {
+	lifetimes := c.lifetimesByPID[pid]
+	if len(lifetimes) > 0 && !done(lifetimes[len(lifetimes)-1]) {
+		return lifetimes[len(lifetimes)-1]
+	}
+	tl := newThreadLifetime(pid)
+	c.lifetimesByPID[pid] = append(lifetimes, tl)
+	return tl
+}
+
+func freed(tl *ThreadLifetime) bool {
+	return tl.FreeTimestamp != UnknownTimestamp
+}
+
+func exitedOrFreed(tl *ThreadLifetime) bool {
+	return tl.ExitTimestamp != UnknownTimestamp || freed(tl)
+}
+
+// recordLifecycleEvent records the provided event in the receiver's thread
+// lifetimes if it is a sched_process_fork, sched_process_exec,
+// sched_process_exit, or sched_process_free event, along with the TGID of any
+// forked child whose fork reports it.  Lifetimes are recorded regardless of
+// which event loaders the collection uses, and with unnormalized timestamps.
+// Malformed lifecycle events are ignored here; the event loaders report them.
+func (c *Collection) recordLifecycleEvent(ev *trace.Event) {
+	hostPID := func(pid PID) PID {
+		if !c.options.namespaceByHost {
+			return pid
+		}
+		host, _ := multihost.SplitCPU(ev.CPU)
+		return PID(multihost.HostPID(host, int64(pid)))
+	}
+	switch ev.Name {
+	case "sched_process_fork":
+		fd, err := LoadForkData(ev)
+		if err != nil {
+			return
+		}
+		parentPID, childPID := hostPID(fd.ParentPID), hostPID(fd.ChildPID)
+		parent := c.lifetime(parentPID, freed)
+		parent.ChildPIDs = append(parent.ChildPIDs, childPID)
+		// A fork always begins a new life.
+		child := newThreadLifetime(childPID)
+		child.ParentPID = parentPID
+		child.ForkTimestamp = ev.Timestamp
+		c.lifetimesByPID[childPID] = append(c.lifetimesByPID[childPID], child)
+		if fd.ChildTGID != UnknownPID {
+			c.forkedThreads = append(c.forkedThreads, forkedThread{
+				pid:       childPID,
+				tgid:      hostPID(fd.ChildTGID),
+				timestamp: ev.Timestamp,
+			})
+		}
+	case "sched_process_exec":
+		ed, err := LoadExecData(ev)
+		if err != nil {
+			return
+		}
+		pid, oldPID := hostPID(ed.PID), hostPID(ed.OldPID)
+		tl := c.lifetime(pid, freed)
+		if oldPID != pid {
+			// A non-leader thread exec'd, and took over its thread group leader's
+			// PID after the leader exited.  The PID lives on in the exec'ing thread,
+			// whose own PID does not.
+			tl.ExitTimestamp = UnknownTimestamp
+			old := c.lifetime(oldPID, exitedOrFreed)
+			old.ExitTimestamp = ev.Timestamp
+		}
+		tl.Execs = append(tl.Execs, &Exec{
+			Timestamp: ev.Timestamp,
+			Filename:  ed.Filename,
+			OldPID:    oldPID,
+		})
+	case "sched_process_exit":
+		pd, err := LoadProcessData(ev)
+		if err != nil {
+			return
+		}
+		c.lifetime(hostPID(pd.PID), exitedOrFreed).ExitTimestamp = ev.Timestamp
+	case "sched_process_free":
+		pd, err := LoadProcessData(ev)
+		if err != nil {
+			return
+		}
+		c.lifetime(hostPID(pd.PID), freed).FreeTimestamp = ev.Timestamp
+	}
+}
+
+// ThreadLifetimes returns the lifetimes of the threads in the collection, as
+// reported by their sched_process_fork, sched_process_exec,
+// sched_process_exit, and sched_process_free events, sorted by PID and then
+// by start.  A thread's parent and children are identified in its lifetime.
+// Threads without any such events have no lifetimes.
+// FILTERS:
+//   PIDs: Only lifetimes of the filtered-in PIDs are returned.
+//   TimeRange, StartTimestamp, EndTimestamp: Only lifetimes overlapping the
+//       filtered-in range are returned.  Lifetimes whose start or end was not
+//       traced are treated as extending to the start or end of time.
+func (c *Collection) ThreadLifetimes(filters ...Filter) []*ThreadLifetime {
+	f := &filter{
+		startTimestamp: UnknownTimestamp,
+		endTimestamp:   UnknownTimestamp,
+		pids:           map[PID]struct{}{},
+	}
+	for _, filterFunc := range filters {
+		filterFunc(f)
+	}
+	if f.startTimestamp == UnknownTimestamp {
+		f.startTimestamp = c.startTimestamp
+	}
+	if f.endTimestamp == UnknownTimestamp {
+		f.endTimestamp = c.endTimestamp
+	}
+	normalize := func(ts trace.Timestamp) trace.Timestamp {
+		if ts == UnknownTimestamp {
+			return ts
+		}
+		return ts - c.normalizationOffset
+	}
+	ret := []*ThreadLifetime{}
+	for pid, lifetimes := range c.lifetimesByPID {
+		if _, ok := f.pids[pid]; len(f.pids) > 0 && !ok {
+			continue
+		}
+		for _, tl := range lifetimes {
+			start, end := normalize(tl.startTimestamp()), normalize(tl.endTimestamp())
+			if (start != UnknownTimestamp && start > f.endTimestamp) ||
+				(end != UnknownTimestamp && end < f.startTimestamp) {
+				continue
+			}
+			normalized := *tl
+			normalized.ChildPIDs = append([]PID{}, tl.ChildPIDs...)
+			normalized.ForkTimestamp = normalize(tl.ForkTimestamp)
+			normalized.ExitTimestamp = normalize(tl.ExitTimestamp)
+			normalized.FreeTimestamp = normalize(tl.FreeTimestamp)
+			normalized.Execs = []*Exec{}
+			for _, exec := range tl.Execs {
+				normalizedExec := *exec
+				normalizedExec.Timestamp = normalize(exec.Timestamp)
+				normalized.Execs = append(normalized.Execs, &normalizedExec)
+			}
+			ret = append(ret, &normalized)
+		}
+	}
+	sort.SliceStable(ret, func(a, b int) bool {
+		if ret[a].PID != ret[b].PID {
+			return ret[a].PID < ret[b].PID
+		}
+		// Each PID's lifetimes are already in temporal order.
+		return false
+	})
+	return ret
+}
@@ -0,0 +1,202 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/schedviz/tracedata/scenario"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// A shell, PID 10, forks PID 20, which execs /bin/true and exits.  PID 20 is
+// then reused by a second fork, which is woken on a different CPU.
+const lifecycleScenario = `
+1000 cpu0 switch prev=0:R next=10 next_comm=shell
+1010 cpu0 fork parent_pid=10 parent_comm=shell child_pid=20 child_comm=shell
+1020 cpu1 wakeup_new pid=20 comm=shell target_cpu=1
+1030 cpu1 switch prev=0:R next=20 next_comm=shell
+1040 cpu1 exec pid=20 old_pid=20 filename=/bin/true
+1050 cpu1 exit pid=20 comm=true
+1060 cpu1 switch prev=20:X prev_comm=true next=0
+1070 cpu0 free pid=20 comm=true
+1080 cpu0 fork parent_pid=10 parent_comm=shell child_pid=20 child_comm=shell
+1085 cpu0 wakeup_new pid=20 comm=shell target_cpu=0
+1090 cpu0 switch prev=10:S prev_comm=shell next=0
+`
+
+func newLifecycleCollection(t *testing.T, options ...Option) *Collection {
+	t.Helper()
+	es, err := scenario.Parse(strings.NewReader(lifecycleScenario))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	coll, err := NewCollection(es, options...)
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	return coll
+}
+
+func lifetime(pid, parentPID PID, fork, exit, free trace.Timestamp, childPIDs []PID, execs ...*Exec) *ThreadLifetime {
+	if childPIDs == nil {
+		childPIDs = []PID{}
+	}
+	if execs == nil {
+		execs = []*Exec{}
+	}
+	return &ThreadLifetime{
+		PID:           pid,
+		ParentPID:     parentPID,
+		ChildPIDs:     childPIDs,
+		ForkTimestamp: fork,
+		ExitTimestamp: exit,
+		FreeTimestamp: free,
+		Execs:         execs,
+	}
+}
+
+func TestThreadLifetimes(t *testing.T) {
+	coll := newLifecycleCollection(t, NormalizeTimestamps(false))
+	shell := lifetime(10, UnknownPID, UnknownTimestamp, UnknownTimestamp, UnknownTimestamp, []PID{20, 20})
+	firstChild := lifetime(20, 10, 1010, 1050, 1070, nil, &Exec{Timestamp: 1040, Filename: "/bin/true", OldPID: 20})
+	secondChild := lifetime(20, 10, 1080, UnknownTimestamp, UnknownTimestamp, nil)
+	tests := []struct {
+		description string
+		filters     []Filter
+		want        []*ThreadLifetime
+	}{{
+		description: "all lifetimes",
+		want:        []*ThreadLifetime{shell, firstChild, secondChild},
+	}, {
+		description: "PID filter",
+		filters:     []Filter{PIDs(20)},
+		want:        []*ThreadLifetime{firstChild, secondChild},
+	}, {
+		description: "before the PID is reused",
+		filters:     []Filter{TimeRange(1000, 1075)},
+		want:        []*ThreadLifetime{shell, firstChild},
+	}, {
+		description: "after the first child is freed",
+		filters:     []Filter{StartTimestamp(1075)},
+		want:        []*ThreadLifetime{shell, secondChild},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got := coll.ThreadLifetimes(test.filters...)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ThreadLifetimes(): Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestThreadLifetimes_Normalized(t *testing.T) {
+	coll := newLifecycleCollection(t, NormalizeTimestamps(true))
+	got := coll.ThreadLifetimes(PIDs(20), EndTimestamp(50))
+	want := []*ThreadLifetime{
+		lifetime(20, 10, 10, 50, 70, nil, &Exec{Timestamp: 40, Filename: "/bin/true", OldPID: 20}),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ThreadLifetimes(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestThreadLifecycleIntervals(t *testing.T) {
+	coll := newLifecycleCollection(t, NormalizeTimestamps(false), PreciseCommands(true))
+	intervals, err := coll.ThreadIntervals(PIDs(20), ThreadStates(AnyState), TruncateToTimeRange(true))
+	if err != nil {
+		t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+	}
+	// PID 20 is dead before its first fork, and from its final switch until its
+	// second fork.  The second thread's CPU is not inherited from the first.
+	want := []string{
+		"1000+10 CPU   1 PID 20 Dead",
+		"1010+10 CPU   1 PID 20 Sleeping",
+		"1020+10 CPU   1 PID 20 Waiting",
+		"1030+10 CPU   1 PID 20 Running",
+		"1040+20 CPU   1 PID 20 Running",
+		"1060+20 CPU   1 PID 20 Dead",
+		"1080+5 CPU   0 PID 20 Sleeping",
+		"1085+5 CPU   0 PID 20 Waiting",
+	}
+	if diff := cmp.Diff(want, describeIntervals(intervals)); diff != "" {
+		t.Errorf("ThreadIntervals(): Diff -want +got:\n%s", diff)
+	}
+	// By default, dead threads are not reported.
+	intervals, err = coll.ThreadIntervals(PIDs(20), TruncateToTimeRange(true))
+	if err != nil {
+		t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+	}
+	var wantAlive []string
+	for _, interval := range want {
+		if !strings.HasSuffix(interval, "Dead") {
+			wantAlive = append(wantAlive, interval)
+		}
+	}
+	if diff := cmp.Diff(wantAlive, describeIntervals(intervals)); diff != "" {
+		t.Errorf("ThreadIntervals(): Diff -want +got:\n%s", diff)
+	}
+	pidsAndComms, err := coll.PIDsAndComms(PIDs(20))
+	if err != nil {
+		t.Fatalf("PIDsAndComms yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(map[PID][]string{20: {"shell", "true"}}, pidsAndComms); diff != "" {
+		t.Errorf("PIDsAndComms(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestThreadLifetimes_NonLeaderExec(t *testing.T) {
+	// PID 31, a thread in PID 30's thread group, execs, so PID 30 exits and PID
+	// 31 takes over its PID.
+	es, err := scenario.Parse(strings.NewReader(`
+1000 cpu0 switch prev=0:R next=31 next_comm=worker
+1010 cpu1 exit pid=30 comm=leader
+1020 cpu1 switch prev=30:X prev_comm=leader next=0
+1030 cpu0 exec pid=30 old_pid=31 filename=/usr/bin/a_very_long_binary_name
+1040 cpu0 switch prev=30:S prev_comm=a_very_long_bin next=0
+`))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	coll, err := NewCollection(es, NormalizeTimestamps(false), PreciseCommands(true))
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	want := []*ThreadLifetime{
+		lifetime(30, UnknownPID, UnknownTimestamp, UnknownTimestamp, UnknownTimestamp, nil,
+			&Exec{Timestamp: 1030, Filename: "/usr/bin/a_very_long_binary_name", OldPID: 31}),
+		lifetime(31, UnknownPID, UnknownTimestamp, 1030, UnknownTimestamp, nil),
+	}
+	if diff := cmp.Diff(want, coll.ThreadLifetimes()); diff != "" {
+		t.Errorf("ThreadLifetimes(): Diff -want +got:\n%s", diff)
+	}
+	pidsAndComms, err := coll.PIDsAndComms()
+	if err != nil {
+		t.Fatalf("PIDsAndComms yielded unexpected error %s", err)
+	}
+	// Exec'd commands are truncated as the kernel truncates them.
+	wantPIDsAndComms := map[PID][]string{
+		30: {"a_very_long_bin", "leader"},
+		31: {"worker"},
+	}
+	if diff := cmp.Diff(wantPIDsAndComms, pidsAndComms); diff != "" {
+		t.Errorf("PIDsAndComms(): Diff -want +got:\n%s", diff)
+	}
+}
@@ -31,12 +31,14 @@ import (
 // on a single CPU.
 type threadSpan struct {
 	pid            PID
+	generation     int // Incremented each time pid is reused by a new thread.
 	startTimestamp trace.Timestamp
 	endTimestamp   trace.Timestamp
 	cpu            CPUID
 	id             uint64 // A unique identifier for augmentedtree.Tree.
 	priority       Priority
 	state          ThreadState
+	taskState      TaskState // Refines state; 0 if no task state is known.
 	command        stringID
 	// The IDs of any events that were dropped due to conflicts identified during
 	// inference.
@@ -62,12 +64,14 @@ func (ts *threadSpan) less(other *threadSpan) bool {
 
 func (ts *threadSpan) equals(other *threadSpan) bool {
 	return ts.pid == other.pid &&
+		ts.generation == other.generation &&
 		ts.startTimestamp == other.startTimestamp &&
 		ts.endTimestamp == other.endTimestamp &&
 		ts.cpu == other.cpu &&
 		ts.id == other.id &&
 		ts.priority == other.priority &&
 		ts.state == other.state &&
+		ts.taskState == other.taskState &&
 		ts.command == other.command &&
 		ts.syntheticStart == other.syntheticStart &&
 		ts.syntheticEnd == other.syntheticEnd &&
@@ -92,6 +96,9 @@ func (ts *threadSpan) equals(other *threadSpan) bool {
 
 func (ts *threadSpan) String() string {
 	ret := fmt.Sprintf("%s (%s, %d, %s) on %s [%d - %d] (%d)", ts.pid, ts.state, ts.command, ts.priority, ts.cpu, ts.startTimestamp, ts.endTimestamp, ts.id)
+	if ts.taskState != 0 {
+		ret = ret + fmt.Sprintf(" (%s)", ts.taskState)
+	}
 	if ts.syntheticStart {
 		ret = ret + " (synthetic start)"
 	}
@@ -150,6 +157,7 @@ func (ts *threadSpan) ID() uint64 {
 //   changed.
 type threadSpanGenerator struct {
 	pid          PID
+	generation   int // The generation of pid whose spans are generated.
 	options      *collectionOptions
 	current      *threadSpan
 	lastCommand  stringID
@@ -261,6 +269,13 @@ func (tsg *threadSpanGenerator) addTransition(nextTT *threadTransition) (*thread
 	if !nextState.isKnown() {
 		nextState = UnknownState
 	}
+	// The next span's task state is the transition's, if it refines the next
+	// state.  Otherwise, a span split without a change of state, as on a
+	// migration, keeps the task state of the span it continues.
+	nextTaskState := nextTT.NextTaskState & nextState.taskStates()
+	if nextTaskState == 0 && tsg.current != nil && tsg.current.state == nextState {
+		nextTaskState = tsg.current.taskState
+	}
 	if tsg.current != nil {
 		switch tsg.current.state {
 		case RunningState:
@@ -281,8 +296,10 @@ func (tsg *threadSpanGenerator) addTransition(nextTT *threadTransition) (*thread
 		default:
 			// If the current span is sleeping, waiting, or a combination of possible
 			// states, it is checked against nextTT.  If nextTT's nextState is not
-			// current.state or nextTT's cpu is not current.cpu, the span is complete:
-			if nextState != tsg.current.state || nextTT.NextCPU != tsg.current.cpu {
+			// current.state, its task state is not current.taskState, or its cpu is
+			// not current.cpu, the span is complete:
+			if nextState != tsg.current.state || nextTaskState != tsg.current.taskState ||
+				nextTT.NextCPU != tsg.current.cpu {
 				split = true
 			}
 		}
@@ -302,9 +319,11 @@ func (tsg *threadSpanGenerator) addTransition(nextTT *threadTransition) (*thread
 	if tsg.current == nil {
 		tsg.current = &threadSpan{
 			pid:            tsg.pid,
+			generation:     tsg.generation,
 			startTimestamp: nextTT.Timestamp,
 			endTimestamp:   nextTT.Timestamp,
 			state:          nextState,
+			taskState:      nextTaskState,
 			command:        tsg.lastCommand,
 			priority:       tsg.lastPriority,
 			cpu:            nextTT.NextCPU,
@@ -26,6 +26,13 @@ import (
 // increasing timestamp order, and produces as output, for each observed PID, a
 // slice of threadSpans describing that PID's scheduling behavior, as far as
 // can be inferred, over the trace.
+//
+// If a PID is reused by a new thread during the trace, each thread's spans are
+// inferred separately, and attributed to a different generation of the PID.
+// A PID is taken to be reused when a transition starts a new thread with it,
+// as a fork does, or, if options.pidReuseAbsence is nonzero, when it is
+// observed with a different command after going unobserved for at least that
+// long, while not running.
 type threadSpanSet struct {
 	startTimestamp           trace.Timestamp
 	options                  *collectionOptions
@@ -34,6 +41,13 @@ type threadSpanSet struct {
 	spansByPID               map[PID][]*threadSpan
 	droppedEventCountsByID   map[int]int
 	syntheticTransitionCount int
+	// The current generation of each PID, and the timestamps at which each of
+	// its generations after the first began, for PIDs that have been reused.
+	generationByPID       map[PID]int
+	generationStartsByPID map[PID][]trace.Timestamp
+	// The timestamp and command of each PID's latest transition.
+	lastTimestampByPID map[PID]trace.Timestamp
+	lastCommandByPID   map[PID]stringID
 }
 
 // newThreadSpanSet returns a new, empty threadSpanSet.  The provided
@@ -48,23 +62,32 @@ func newThreadSpanSet(startTimestamp trace.Timestamp, options *collectionOptions
 		spanGeneratorByPID:     map[PID]*threadSpanGenerator{},
 		spansByPID:             map[PID][]*threadSpan{},
 		droppedEventCountsByID: map[int]int{},
+		generationByPID:        map[PID]int{},
+		generationStartsByPID:  map[PID][]trace.Timestamp{},
+		lastTimestampByPID:     map[PID]trace.Timestamp{},
+		lastCommandByPID:       map[PID]stringID{},
 	}
 }
 
 // inferrer returns the threadInferrer for the thread in the provided
 // thread.  If one doesn't exist yet, it is created, and an initial interval
 // with unknown prev and next CPUs and states is inserted at the
-// threadSpanSet's startTimestamp, to ensure that the first span for this
-// thread will begin at that timestamp.
+// threadSpanSet's startTimestamp, or at the start of the PID's current
+// generation, to ensure that the first span for this thread will begin at
+// that timestamp.
 func (tss *threadSpanSet) inferrer(pid PID, command stringID, priority Priority) *threadInferrer {
 	inferrer, ok := tss.inferrerByPID[pid]
 	if !ok {
 		inferrer = newThreadInferrer(pid, tss.options)
 		tss.inferrerByPID[pid] = inferrer
+		startTimestamp, ok := tss.generationStart(pid)
+		if !ok {
+			startTimestamp = tss.startTimestamp
+		}
 		// Add an initial transition, starting at the trace start timestamp,
 		inferrer.addTransition(&threadTransition{
 			EventID:                Unknown,
-			Timestamp:              tss.startTimestamp,
+			Timestamp:              startTimestamp,
 			PID:                    pid,
 			PrevCommand:            command,
 			NextCommand:            command,
@@ -81,12 +104,33 @@ func (tss *threadSpanSet) inferrer(pid PID, command stringID, priority Priority)
 	return inferrer
 }
 
+// generationStart returns the timestamp at which the current generation of the
+// specified PID began, and true, or false if the PID hasn't been reused.
+func (tss *threadSpanSet) generationStart(pid PID) (trace.Timestamp, bool) {
+	starts := tss.generationStartsByPID[pid]
+	if len(starts) == 0 {
+		return 0, false
+	}
+	return starts[len(starts)-1], true
+}
+
+// generationAt returns the generation that the specified PID was in at the
+// provided timestamp.  A transition that reuses the PID begins the new
+// generation at its own timestamp.
+func (tss *threadSpanSet) generationAt(pid PID, timestamp trace.Timestamp) int {
+	starts := tss.generationStartsByPID[pid]
+	return sort.Search(len(starts), func(i int) bool {
+		return starts[i] > timestamp
+	})
+}
+
 // spanGenerator returns the threadSpanGenerator for the specified PID.  If one
 // doesn't exist yet, it is created.
 func (tss *threadSpanSet) spanGenerator(pid PID) *threadSpanGenerator {
 	sb, ok := tss.spanGeneratorByPID[pid]
 	if !ok {
 		sb = newThreadSpanGenerator(pid, tss.options)
+		sb.generation = tss.generationByPID[pid]
 		tss.spanGeneratorByPID[pid] = sb
 	}
 	return sb
@@ -108,6 +152,13 @@ func (tss *threadSpanSet) createSpans(pid PID, infTTs []*threadTransition) error
 		if err != nil {
 			return err
 		}
+		// A reused PID's new generation begins with an empty span, ending at the
+		// transition that started the generation; it is dropped.
+		if ts != nil && ts.generation > 0 && ts.startTimestamp == ts.endTimestamp {
+			if start, _ := tss.generationStart(pid); ts.startTimestamp == start {
+				continue
+			}
+		}
 		if ts != nil {
 			tss.spansByPID[pid] = append(tss.spansByPID[pid], ts)
 		}
@@ -127,6 +178,19 @@ func (tss *threadSpanSet) addTransition(tt *threadTransition) error {
 		// is treated as a normal thread.
 		return nil
 	}
+	if tss.reused(tt) {
+		if err := tss.finishThread(pid, tt.Timestamp); err != nil {
+			return err
+		}
+		tss.generationByPID[pid]++
+		tss.generationStartsByPID[pid] = append(tss.generationStartsByPID[pid], tt.Timestamp)
+	}
+	tss.lastTimestampByPID[pid] = tt.Timestamp
+	if tt.NextCommand != UnknownCommand {
+		tss.lastCommandByPID[pid] = tt.NextCommand
+	} else if tt.PrevCommand != UnknownCommand {
+		tss.lastCommandByPID[pid] = tt.PrevCommand
+	}
 	// Add this transition to its thread's inferrer.
 	inf := tss.inferrer(tt.PID, tt.PrevCommand, tt.PrevPriority)
 	infTTs, err := inf.addTransition(tt)
@@ -136,50 +200,75 @@ func (tss *threadSpanSet) addTransition(tt *threadTransition) error {
 	return tss.createSpans(pid, infTTs)
 }
 
+// reused returns true if the provided transition shows that its PID, which
+// has already been observed, has been reused by a new thread.  Running threads
+// may rename themselves, so a command change is only taken as a sign of reuse
+// if the PID can't have been running just before the transition.
+func (tss *threadSpanSet) reused(tt *threadTransition) bool {
+	if _, ok := tss.inferrerByPID[tt.PID]; !ok {
+		return false
+	}
+	if tt.StartsThread {
+		return true
+	}
+	lastCommand, ok := tss.lastCommandByPID[tt.PID]
+	return tss.options.pidReuseAbsence > 0 && ok &&
+		tt.PrevCommand != UnknownCommand && tt.PrevCommand != lastCommand &&
+		tt.PrevState&RunningState == 0 &&
+		duration(tss.lastTimestampByPID[tt.PID], tt.Timestamp) >= tss.options.pidReuseAbsence
+}
+
+// finishThread ends the current generation of the specified PID at the
+// provided timestamp: a final all-Unknown transition is added at that
+// timestamp, then the PID's inferrer and span generator are drained into its
+// spans, and discarded.
+func (tss *threadSpanSet) finishThread(pid PID, timestamp trace.Timestamp) error {
+	inferrer := tss.inferrerByPID[pid]
+	infTTs, err := inferrer.addTransition(&threadTransition{
+		EventID:                Unknown,
+		Timestamp:              timestamp,
+		PID:                    pid,
+		PrevCommand:            UnknownCommand,
+		NextCommand:            UnknownCommand,
+		PrevPriority:           UnknownPriority,
+		NextPriority:           UnknownPriority,
+		PrevCPU:                UnknownCPU,
+		NextCPU:                UnknownCPU,
+		CPUPropagatesThrough:   true,
+		PrevState:              AnyState,
+		NextState:              AnyState,
+		StatePropagatesThrough: true,
+	})
+	if err != nil {
+		return err
+	}
+	finalInfTTs, err := inferrer.drain()
+	if err != nil {
+		return err
+	}
+	infTTs = append(infTTs, finalInfTTs...)
+	if err := tss.createSpans(pid, infTTs); err != nil {
+		return err
+	}
+	if ts := tss.spanGenerator(pid).drain(); ts != nil {
+		tss.spansByPID[pid] = append(tss.spansByPID[pid], ts)
+	}
+	delete(tss.inferrerByPID, pid)
+	delete(tss.spanGeneratorByPID, pid)
+	return nil
+}
+
 // threadSpans drains all inferrers, then returns the assembled per-PID
 // threadSpans, in sorted order of increasing startTimestamp.  It also clears
 // the receiver.
 func (tss *threadSpanSet) threadSpans(endTimestamp trace.Timestamp) (map[PID][]*threadSpan, error) {
-	// For each pid, add a final all-Unknown transition at the endTimestamp, then
-	// drain the inferrer.
-	for pid, inferrer := range tss.inferrerByPID {
-		var infTTs = []*threadTransition{}
-		infTTs, err := inferrer.addTransition(&threadTransition{
-			EventID: Unknown,
-			// End any still-open per-thread spans with a Timestamp just past the
-			// last unclipped Timestamp observed in the trace.  This indicates
-			// that the behavior in the span is ongoing past the end of the trace.
-			Timestamp:              endTimestamp + 1,
-			PID:                    pid,
-			PrevCommand:            UnknownCommand,
-			NextCommand:            UnknownCommand,
-			PrevPriority:           UnknownPriority,
-			NextPriority:           UnknownPriority,
-			PrevCPU:                UnknownCPU,
-			NextCPU:                UnknownCPU,
-			CPUPropagatesThrough:   true,
-			PrevState:              AnyState,
-			NextState:              AnyState,
-			StatePropagatesThrough: true,
-		})
-		if err != nil {
-			return nil, err
-		}
-		finalInfTTs, err := inferrer.drain()
-		if err != nil {
+	// For each pid, end any still-open per-thread spans with a Timestamp just
+	// past the last unclipped Timestamp observed in the trace.  This indicates
+	// that the behavior in the span is ongoing past the end of the trace.
+	for pid := range tss.inferrerByPID {
+		if err := tss.finishThread(pid, endTimestamp+1); err != nil {
 			return nil, err
 		}
-		infTTs = append(infTTs, finalInfTTs...)
-		if err := tss.createSpans(pid, infTTs); err != nil {
-			return nil, err
-		}
-	}
-	// Drain the per-PID thread span builders.
-	for pid, tsg := range tss.spanGeneratorByPID {
-		ts := tsg.drain()
-		if ts != nil {
-			tss.spansByPID[pid] = append(tss.spansByPID[pid], ts)
-		}
 	}
 	// Sort all spans by increasing startTimestamp, and assign a unique ID to each.
 	nextID := queryID + 1
@@ -168,10 +168,17 @@ type threadTransition struct {
 	// The state PID held after this threadTransition.  If Unknown, may be
 	// inferred from other threadTransitions.
 	NextState ThreadState
+	// The kernel task state PID held after this threadTransition, refining
+	// NextState, or 0 if none is known.
+	NextTaskState TaskState
 	// Whether states can propagate through this transition during inference.
 	// This should be true for events that do not affect a thread's state, and
 	// false for events that do.
 	StatePropagatesThrough bool
+	// Whether this threadTransition starts a new thread with PID, as when PID is
+	// forked.  Any earlier threadTransitions for PID belong to an earlier thread
+	// that held the same PID.
+	StartsThread bool
 	// Conflict resolution policies.  Some events are unreliable; for example,
 	// sched_wakeup can occur on a running or waiting thread.  Events that can be
 	// emitted as part of an interrupt are perhaps more prone to require these
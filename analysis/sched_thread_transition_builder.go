@@ -115,6 +115,12 @@ func (ttb *ThreadTransitionBuilder) WithNextState(nextState ThreadState) *Thread
 	return ttb
 }
 
+// WithNextTaskState sets the current threadTransition's NextTaskState.
+func (ttb *ThreadTransitionBuilder) WithNextTaskState(nextTaskState TaskState) *ThreadTransitionBuilder {
+	ttb.threadTransition.NextTaskState = nextTaskState
+	return ttb
+}
+
 // WithStatePropagatesThrough sets whether states can propagate through the
 // current threadTransition during state inferefence.  This should be true for
 // transitions that do not affect a thread's state (such as migrations) and
@@ -124,6 +130,14 @@ func (ttb *ThreadTransitionBuilder) WithStatePropagatesThrough(statePropagatesTh
 	return ttb
 }
 
+// WithStartsThread sets whether the current threadTransition starts a new
+// thread with its PID, as a fork does.  If so, the threadTransition, and those
+// that follow it, are attributed to a new generation of the PID.
+func (ttb *ThreadTransitionBuilder) WithStartsThread(startsThread bool) *ThreadTransitionBuilder {
+	ttb.threadTransition.StartsThread = startsThread
+	return ttb
+}
+
 // OnForwardsStateConflict sets the ConflictPolicy to be used when this
 // threadTransition's NextState conflicts with the next threadTransition's
 // PrevState.
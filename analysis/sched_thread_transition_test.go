@@ -108,6 +108,11 @@ func (tt *threadTransition) withStates(prev, next ThreadState) *threadTransition
 	return tt
 }
 
+func (tt *threadTransition) withNextTaskState(next TaskState) *threadTransition {
+	tt.NextTaskState = next
+	return tt
+}
+
 func (tt *threadTransition) withStatePropagatesThrough(statePropagatesThrough bool) *threadTransition {
 	tt.StatePropagatesThrough = statePropagatesThrough
 	return tt
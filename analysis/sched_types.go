@@ -48,7 +48,7 @@ const Unknown = -1
 type ThreadState int8
 
 // ThreadTransitions may specify any combination of RunningState, WaitingState,
-// and SleepingState.
+// SleepingState, and DeadState.
 const (
 	// UnknownState threads are of an indeterminate state, because there is not
 	// yet enough information to infer their state.  When used in ThreadTransitions,
@@ -61,12 +61,15 @@ const (
 	// SleepingState threads are in a non-RUNNABLE state (usually INTERRUPTIBLE) and
 	// are not switched in.
 	SleepingState
+	// DeadState threads are not alive: they have not yet been forked, or they
+	// have exited.
+	DeadState
 )
 
 
 // AnyState is the superposition of all possible thread states -- Running,
-// Waiting, and Sleeping.
-var AnyState ThreadState = RunningState | WaitingState | SleepingState
+// Waiting, Sleeping, and Dead.
+var AnyState ThreadState = RunningState | WaitingState | SleepingState | DeadState
 
 func (ts ThreadState) String() string {
 	var ret []string
@@ -82,6 +85,9 @@ func (ts ThreadState) String() string {
 	if ts&SleepingState != 0 {
 		ret = append(ret, "Sleeping")
 	}
+	if ts&DeadState != 0 {
+		ret = append(ret, "Dead")
+	}
 	if len(ret) == 0 {
 		ret = []string{"no known state"}
 	}
@@ -89,9 +95,84 @@ func (ts ThreadState) String() string {
 }
 
 // isKnown returns true iff the receiver is a single known state -- Running,
-// Waiting, or Sleeping.
+// Waiting, Sleeping, or Dead.
 func (ts ThreadState) isKnown() bool {
-	return ts == RunningState || ts == WaitingState || ts == SleepingState
+	return ts == RunningState || ts == WaitingState || ts == SleepingState || ts == DeadState
+}
+
+// taskStates returns the TaskStates that can refine the receiver.
+func (ts ThreadState) taskStates() TaskState {
+	switch ts {
+	case WaitingState:
+		return TaskPreempted
+	case SleepingState:
+		return TaskInterruptible | TaskUninterruptible | TaskStopped | TaskIdle
+	default:
+		return 0
+	}
+}
+
+// TaskState refines a Waiting or Sleeping ThreadState with the kernel task
+// state a thread was switched out in, as reported by sched_switch's
+// prev_state.  A thread that isn't known to have been switched out in any
+// particular task state -- for instance, a thread woken from sleep, or one
+// whose state was inferred -- has no TaskState, 0.
+type TaskState int8
+
+// Multiple TaskStates may be specified in filters by joining with bitwise OR.
+const (
+	// TaskPreempted threads were switched out while still runnable (R or R+),
+	// usually by preemption, and are Waiting.
+	TaskPreempted TaskState = 1 << iota
+	// TaskInterruptible threads are Sleeping in an interruptible sleep (S),
+	// usually waiting on an event, a lock, or a timer.
+	TaskInterruptible
+	// TaskUninterruptible threads are Sleeping in an uninterruptible sleep (D),
+	// usually waiting on IO.
+	TaskUninterruptible
+	// TaskStopped threads are Sleeping because they were stopped by a signal
+	// (T) or by a tracer (t).
+	TaskStopped
+	// TaskIdle threads are Sleeping idle kernel threads (I), which, unlike
+	// uninterruptible sleepers, aren't counted in the load average.
+	TaskIdle
+)
+
+// The task state bits of sched_switch's prev_state, as defined in sched.h in
+// the kernel.  Kernels since 4.14 report idle threads as TASK_REPORT_IDLE,
+// alone.  Older kernels report the raw task state, in which the same bit is
+// TASK_WAKEKILL, set alongside other states by killable and stopped threads,
+// and report idle threads as TASK_UNINTERRUPTIBLE | TASK_NOLOAD.
+const (
+	taskInterruptibleBits   = 0x01
+	taskUninterruptibleBits = 0x02
+	taskStoppedBits         = 0x04 | 0x08
+	taskReportedStateBits   = 0x7f
+	taskReportIdleBits      = 0x80
+	taskNoloadBits          = 0x400
+)
+
+func (ts TaskState) String() string {
+	var ret []string
+	if ts&TaskPreempted != 0 {
+		ret = append(ret, "Preempted")
+	}
+	if ts&TaskInterruptible != 0 {
+		ret = append(ret, "Interruptible")
+	}
+	if ts&TaskUninterruptible != 0 {
+		ret = append(ret, "Uninterruptible")
+	}
+	if ts&TaskStopped != 0 {
+		ret = append(ret, "Stopped")
+	}
+	if ts&TaskIdle != 0 {
+		ret = append(ret, "Idle")
+	}
+	if len(ret) == 0 {
+		ret = []string{"no task state"}
+	}
+	return strings.Join(ret, " || ")
 }
 
 // mergeState accepts two ThreadStates, and returns their intersection.
@@ -185,14 +266,21 @@ func duration(startTimestamp, endTimestamp trace.Timestamp) Duration {
 	return Duration(endTimestamp - startTimestamp)
 }
 
-// Thread describes a single thread's PID, command string, and priority.
+// Thread describes a single thread's PID, command string, priority, and PID
+// generation.
 type Thread struct {
 	PID      PID      `json:"pid"`
 	Command  string   `json:"command"`
 	Priority Priority `json:"priority"`
+	// Which of the threads to have had PID this is: 0 for the first, and
+	// incremented each time the PID was reused within the trace.
+	Generation int `json:"generation"`
 }
 
 func (t Thread) String() string {
+	if t.Generation > 0 {
+		return fmt.Sprintf("%s (%s, %s, generation %d)", t.PID, t.Command, t.Priority, t.Generation)
+	}
 	return fmt.Sprintf("%s (%s, %s)", t.PID, t.Command, t.Priority)
 }
 
@@ -201,16 +289,19 @@ type ThreadResidency struct {
 	Thread *Thread `json:"thread"`
 	// The duration of the residency in ns. If StartTimestamp is Unknown, reflects a
 	// cumulative duration.
-	Duration        Duration    `json:"duration"`
-	State           ThreadState `json:"state"`
-	DroppedEventIDs []int       `json:"droppedEventIDs"`
+	Duration Duration    `json:"duration"`
+	State    ThreadState `json:"state"`
+	// The task state refining State, or 0 if none is known.  Only thread
+	// intervals report task states.
+	TaskState       TaskState `json:"taskState"`
+	DroppedEventIDs []int     `json:"droppedEventIDs"`
 	// Set to true if this was constructed from at least one synthetic transitions
 	// i.e. a transition that was not in the raw event set.
 	IncludesSyntheticTransitions bool `json:"includesSyntheticTransitions"`
 }
 
 func (tr *ThreadResidency) merge(other *ThreadResidency) error {
-	if tr.Thread.PID != other.Thread.PID || tr.State != other.State {
+	if tr.Thread.PID != other.Thread.PID || tr.State != other.State || tr.TaskState != other.TaskState {
 		return status.Errorf(codes.Internal, "can't merge ThreadResidencies with different PIDs or states")
 	}
 	tr.Duration = tr.Duration.add(other.Duration)
@@ -234,7 +325,11 @@ func (tr *ThreadResidency) String() string {
 	for _, evID := range tr.DroppedEventIDs {
 		evIDs = append(evIDs, strconv.Itoa(evID))
 	}
-	ret := fmt.Sprintf("Thread %s, Duration %d, State %s, Dropped [%s]", tr.Thread, tr.Duration, tr.State,
+	state := tr.State.String()
+	if tr.TaskState != 0 {
+		state += fmt.Sprintf(" (%s)", tr.TaskState)
+	}
+	ret := fmt.Sprintf("Thread %s, Duration %d, State %s, Dropped [%s]", tr.Thread, tr.Duration, state,
 		strings.Join(evIDs, ","))
 	if tr.IncludesSyntheticTransitions {
 		ret += " (includes synthetic transitions)"
@@ -262,10 +357,26 @@ func (ti *Interval) String() string {
 	return ret
 }
 
+// LostEvents is a window on a single CPU during which the kernel dropped
+// events from the trace.
+type LostEvents struct {
+	CPU CPUID `json:"cpu"`
+	// The timestamp of the last event recorded on the CPU before events were
+	// lost, or UnknownTimestamp if none was.
+	StartTimestamp trace.Timestamp `json:"startTimestamp"`
+	// The timestamp at which recording resumed.
+	EndTimestamp trace.Timestamp `json:"endTimestamp"`
+	// The number of lost events, or -1 if the kernel didn't record it.
+	Count int64 `json:"count"`
+}
+
 // Antagonism is an interval during which a single thread running on a
 // single CPU antagonized the waiting victim.
 type Antagonism struct {
-	RunningThread  *Thread         `json:"runningThread"`
+	RunningThread *Thread `json:"runningThread"`
+	// The waiting thread that RunningThread antagonized.  Only set by
+	// ProcessAntagonists, whose victims are several threads.
+	Victim         *Thread         `json:"victim,omitempty"`
 	CPU            CPUID           `json:"cpu"`
 	StartTimestamp trace.Timestamp `json:"startTimestamp"`
 	EndTimestamp   trace.Timestamp `json:"endTimestamp"`
@@ -298,6 +409,14 @@ type Metrics struct {
 	RunTimeNs     Duration `json:"runTimeNs"`
 	WaitTimeNs    Duration `json:"waitTimeNs"`
 	SleepTimeNs   Duration `json:"sleepTimeNs"`
+	// The portion of WaitTimeNs spent waiting after being preempted, and the
+	// portions of SleepTimeNs spent sleeping in each task state.  Time spent in
+	// no known task state is only included in WaitTimeNs or SleepTimeNs.
+	PreemptedTimeNs            Duration `json:"preemptedTimeNs"`
+	InterruptibleSleepTimeNs   Duration `json:"interruptibleSleepTimeNs"`
+	UninterruptibleSleepTimeNs Duration `json:"uninterruptibleSleepTimeNs"`
+	StoppedTimeNs              Duration `json:"stoppedTimeNs"`
+	IdleTimeNs                 Duration `json:"idleTimeNs"`
 	// Unique PIDs, COMMs, priorities, and CPUs observed in the aggregated trace.
 	// Note that these fields are not correlated; if portions of trace containing
 	// execution from several different PIDs are aggregated together in a metric,
@@ -311,7 +430,77 @@ type Metrics struct {
 	Commands   []string   `json:"commands"`
 	Priorities []Priority `json:"priorities"`
 	Cpus       []CPUID    `json:"cpus"`
+	// The generation of the summarized PID.  Thread summaries are produced
+	// separately for each generation of a reused PID.  Process summaries
+	// aggregate all generations, and leave this 0.
+	Generation int `json:"generation"`
+	// The TGID of the process, or thread group, of the summarized threads.
+	TGID PID `json:"tgid"`
 	// The time range over which these metrics were aggregated.
 	StartTimestampNs trace.Timestamp `json:"startTimestampNs"`
 	EndTimestampNs   trace.Timestamp `json:"endTimestampNs"`
 }
+
+// ThreadLifetime describes a single thread's life, from its fork to its exit,
+// as reported by sched_process_fork, sched_process_exec, sched_process_exit,
+// and sched_process_free events.  A PID reused after it is freed has a new
+// ThreadLifetime.
+type ThreadLifetime struct {
+	PID PID `json:"pid"`
+	// The PID of the thread that forked this one, or UnknownPID if the fork
+	// was not traced.
+	ParentPID PID `json:"parentPid"`
+	// The PIDs of the threads this one forked, in the order they were forked.
+	ChildPIDs []PID `json:"childPids"`
+	// The timestamps at which the thread was forked, exited, and was freed, or
+	// UnknownTimestamp if these were not traced.
+	ForkTimestamp trace.Timestamp `json:"forkTimestamp"`
+	ExitTimestamp trace.Timestamp `json:"exitTimestamp"`
+	FreeTimestamp trace.Timestamp `json:"freeTimestamp"`
+	// The binaries the thread exec'd, in the order they were exec'd.
+	Execs []*Exec `json:"execs"`
+}
+
+// Exec is a single exec of a new binary by a thread.
+type Exec struct {
+	Timestamp trace.Timestamp `json:"timestamp"`
+	Filename  string          `json:"filename"`
+	// The PID of the thread that called exec.  This differs from the exec'ing
+	// ThreadLifetime's PID if a thread other than its thread group's leader
+	// called exec, and took over the leader's PID.
+	OldPID PID `json:"oldPid"`
+}
+
+// ThreadGeneration describes one of the threads to have had a given PID during
+// the trace.
+type ThreadGeneration struct {
+	PID        PID `json:"pid"`
+	Generation int `json:"generation"`
+	// The commands the thread was observed with, in lexical order.
+	Commands []string `json:"commands"`
+	// The span of time over which the thread was observed.
+	StartTimestamp trace.Timestamp `json:"startTimestamp"`
+	EndTimestamp   trace.Timestamp `json:"endTimestamp"`
+}
+
+// WakeupLatency describes a single wakeup of a thread, from its sched_waking,
+// when the wakeup was requested, to its next switch-in.
+type WakeupLatency struct {
+	WakingTimestamp trace.Timestamp `json:"wakingTimestamp"`
+	// When, and on which CPU, the thread next started running.
+	RunningTimestamp trace.Timestamp `json:"runningTimestamp"`
+	CPU              CPUID           `json:"cpu"`
+	Latency          Duration        `json:"latency"`
+}
+
+// ThreadWakeupLatencies describes the wakeup latencies of one of the threads to
+// have had a given PID during the trace.
+type ThreadWakeupLatencies struct {
+	PID        PID `json:"pid"`
+	Generation int `json:"generation"`
+	// The thread's wakeups, in temporal order.
+	Wakeups []*WakeupLatency `json:"wakeups"`
+	// The mean and maximum latencies of the thread's wakeups.
+	MeanLatency Duration `json:"meanLatency"`
+	MaxLatency  Duration `json:"maxLatency"`
+}
@@ -0,0 +1,140 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"sort"
+
+	"github.com/google/schedviz/tracedata/multihost"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// wakingTimestampsByPID returns the timestamps of the sched_waking events in
+// the filtered-in time range, keyed by the PID of the thread they woke.
+func (c *Collection) wakingTimestampsByPID(f *filter) (map[PID][]trace.Timestamp, error) {
+	events, err := c.GetRawEvents(TimeRange(f.startTimestamp, f.endTimestamp), EventTypes("sched_waking"))
+	if err != nil {
+		return nil, err
+	}
+	ret := map[PID][]trace.Timestamp{}
+	for _, ev := range events {
+		pid, ok := ev.NumberProperties["pid"]
+		if !ok {
+			return nil, MissingFieldError("pid", ev)
+		}
+		if c.options.namespaceByHost {
+			host, _ := multihost.SplitCPU(ev.CPU)
+			pid = multihost.HostPID(host, pid)
+		}
+		ret[PID(pid)] = append(ret[PID(pid)], ev.Timestamp)
+	}
+	return ret, nil
+}
+
+// wakeupLatency returns the latency of the wakeup, requested at the provided
+// timestamp, of the thread whose spans are provided, and the generation of
+// the woken thread.  If the thread wasn't Sleeping or Waiting when it was
+// woken, as when a thread is woken just before it sleeps, or if it didn't run
+// again within the trace, nil is returned.
+func wakeupLatency(spans []*threadSpan, wakingTimestamp trace.Timestamp) (*WakeupLatency, int) {
+	idx := sort.Search(len(spans), func(i int) bool {
+		return spans[i].endTimestamp > wakingTimestamp
+	})
+	if idx == len(spans) || spans[idx].startTimestamp > wakingTimestamp {
+		return nil, 0
+	}
+	if state := spans[idx].state; state != SleepingState && state != WaitingState {
+		return nil, 0
+	}
+	for ; idx < len(spans); idx++ {
+		span := spans[idx]
+		if span.state != RunningState {
+			continue
+		}
+		return &WakeupLatency{
+			WakingTimestamp:  wakingTimestamp,
+			RunningTimestamp: span.startTimestamp,
+			CPU:              span.cpu,
+			Latency:          duration(wakingTimestamp, span.startTimestamp),
+		}, span.generation
+	}
+	return nil, 0
+}
+
+// WakeupLatencies returns the wakeup latencies of the threads in the
+// collection, sorted by PID and then by generation.  A wakeup's latency is the
+// time from its sched_waking, when the wakeup was requested, to the woken
+// thread's next switch-in, so unlike the Waiting intervals that follow
+// sched_wakeups, it includes the time taken to select the thread's CPU and
+// enqueue it there.  Threads without any such wakeups are omitted.
+// FILTERS:
+//   PIDs, Processes: Only the wakeups of the filtered-in threads are returned.
+//   Generations: Only the wakeups of the filtered-in generations are returned.
+//   CPUs: Only wakeups after which the thread ran on a filtered-in CPU are
+//       returned.
+//   TimeRange, StartTimestamp, EndTimestamp: Only wakeups requested within the
+//       filtered-in range are returned.
+func (c *Collection) WakeupLatencies(filters ...Filter) ([]*ThreadWakeupLatencies, error) {
+	f := buildFilter(c, filters)
+	wakingTimestampsByPID, err := c.wakingTimestampsByPID(f)
+	if err != nil {
+		return nil, err
+	}
+	ret := []*ThreadWakeupLatencies{}
+	for pid, wakingTimestamps := range wakingTimestampsByPID {
+		if _, ok := f.pids[pid]; !ok {
+			continue
+		}
+		var twl *ThreadWakeupLatencies
+		var totalLatency Duration
+		for _, wakingTimestamp := range wakingTimestamps {
+			wl, generation := wakeupLatency(c.spansByPID[pid], wakingTimestamp)
+			if wl == nil || !f.generationFilteredIn(pid, generation) {
+				continue
+			}
+			if _, ok := f.cpus[wl.CPU]; !ok {
+				continue
+			}
+			if twl == nil || twl.Generation != generation {
+				if twl != nil {
+					twl.MeanLatency = totalLatency / Duration(len(twl.Wakeups))
+				}
+				twl = &ThreadWakeupLatencies{
+					PID:        pid,
+					Generation: generation,
+				}
+				totalLatency = 0
+				ret = append(ret, twl)
+			}
+			twl.Wakeups = append(twl.Wakeups, wl)
+			totalLatency += wl.Latency
+			if wl.Latency > twl.MaxLatency {
+				twl.MaxLatency = wl.Latency
+			}
+		}
+		if twl != nil {
+			twl.MeanLatency = totalLatency / Duration(len(twl.Wakeups))
+		}
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		if ret[a].PID != ret[b].PID {
+			return ret[a].PID < ret[b].PID
+		}
+		return ret[a].Generation < ret[b].Generation
+	})
+	return ret, nil
+}
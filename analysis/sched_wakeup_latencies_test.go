@@ -0,0 +1,197 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package sched
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/schedviz/tracedata/scenario"
+)
+
+// Thread 10 sleeps on CPU 0 and is woken from CPU 1, which it is migrated to
+// before it is enqueued.  It then sleeps again, and is woken in place.  Thread
+// 20 is woken just before it sleeps, so its sched_waking finds it running.
+const wakingScenario = `
+1000 cpu0 switch prev=0:R next=10 next_comm=db
+1010 cpu0 switch prev=10:S prev_comm=db next=20 next_comm=worker
+1020 cpu1 waking pid=10 comm=db target_cpu=0
+1030 cpu1 migrate pid=10 comm=db orig_cpu=0 dest_cpu=1
+1035 cpu1 wakeup pid=10 comm=db target_cpu=1
+1050 cpu1 switch prev=0:R next=10 next_comm=db
+1060 cpu1 switch prev=10:S prev_comm=db next=0
+1070 cpu0 waking pid=10 comm=db target_cpu=1
+1080 cpu0 wakeup pid=10 comm=db target_cpu=1
+1090 cpu1 switch prev=0:R next=10 next_comm=db
+1110 cpu0 waking pid=20 comm=worker target_cpu=0
+1120 cpu0 switch prev=20:S prev_comm=worker next=0
+`
+
+func newWakingCollection(t *testing.T, options ...Option) *Collection {
+	t.Helper()
+	es, err := scenario.Parse(strings.NewReader(wakingScenario))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	coll, err := NewCollection(es, append([]Option{NormalizeTimestamps(false)}, options...)...)
+	if err != nil {
+		t.Fatalf("Unexpected collection creation error %s", err)
+	}
+	return coll
+}
+
+func TestWakeupsMarkedBy(t *testing.T) {
+	tests := []struct {
+		description string
+		options     []Option
+		want        []string
+	}{{
+		description: "sched_wakeup",
+		want: []string{
+			"1000+0 CPU   0 PID 10 Unknown",
+			"1000+10 CPU   0 PID 10 Running",
+			"1010+20 CPU   0 PID 10 Sleeping",
+			"1030+5 CPU   1 PID 10 Sleeping",
+			"1035+15 CPU   1 PID 10 Waiting",
+			"1050+10 CPU   1 PID 10 Running",
+			"1060+20 CPU   1 PID 10 Sleeping",
+			"1080+10 CPU   1 PID 10 Waiting",
+			"1090+30 CPU   1 PID 10 Running",
+		},
+	}, {
+		description: "sched_waking",
+		options:     []Option{WakeupsMarkedBy(SchedWakingEvent)},
+		want: []string{
+			"1000+0 CPU   0 PID 10 Unknown",
+			"1000+10 CPU   0 PID 10 Running",
+			"1010+10 CPU   0 PID 10 Sleeping",
+			"1020+10 CPU   0 PID 10 Waiting",
+			"1030+20 CPU   1 PID 10 Waiting",
+			"1050+10 CPU   1 PID 10 Running",
+			"1060+10 CPU   1 PID 10 Sleeping",
+			"1070+20 CPU   1 PID 10 Waiting",
+			"1090+30 CPU   1 PID 10 Running",
+		},
+	}, {
+		description: "loaders without sched_wakeup",
+		options:     []Option{UsingEventLoaders(SwitchOnlyLoaders()), WakeupsMarkedBy(SchedWakingEvent)},
+		want: []string{
+			"1000+0 CPU   0 PID 10 Unknown",
+			"1000+10 CPU   0 PID 10 Running",
+			"1010+20 CPU   0 PID 10 Sleeping",
+			"1030+20 CPU   1 PID 10 Sleeping",
+			"1050+10 CPU   1 PID 10 Running",
+			"1060+30 CPU   1 PID 10 Sleeping",
+			"1090+30 CPU   1 PID 10 Running",
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			coll := newWakingCollection(t, test.options...)
+			intervals, err := coll.ThreadIntervals(PIDs(10))
+			if err != nil {
+				t.Fatalf("ThreadIntervals yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, describeIntervals(intervals)); diff != "" {
+				t.Errorf("ThreadIntervals(): Diff -want +got:\n%s", diff)
+			}
+			// Either way, thread 10 is woken twice, and thread 20's sched_waking
+			// doesn't wake it.
+			stats, err := coll.ThreadStats(PIDs(10, 20))
+			if err != nil {
+				t.Fatalf("ThreadStats yielded unexpected error %s", err)
+			}
+			if stats.Wakeups != 2 {
+				t.Errorf("ThreadStats().Wakeups = %d, want 2", stats.Wakeups)
+			}
+		})
+	}
+}
+
+func TestWakeupsMarkedBy_Invalid(t *testing.T) {
+	es, err := scenario.Parse(strings.NewReader(wakingScenario))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	if _, err := NewCollection(es, WakeupsMarkedBy(WakeupEvent(2))); err == nil {
+		t.Errorf("NewCollection with an unknown wakeup event succeeded, want an error")
+	}
+}
+
+func TestWakeupLatencies(t *testing.T) {
+	tests := []struct {
+		description string
+		options     []Option
+		filters     []Filter
+		want        []*ThreadWakeupLatencies
+	}{{
+		description: "all threads",
+		want: []*ThreadWakeupLatencies{{
+			PID: 10,
+			Wakeups: []*WakeupLatency{
+				{WakingTimestamp: 1020, RunningTimestamp: 1050, CPU: 1, Latency: 30},
+				{WakingTimestamp: 1070, RunningTimestamp: 1090, CPU: 1, Latency: 20},
+			},
+			MeanLatency: 25,
+			MaxLatency:  30,
+		}},
+	}, {
+		description: "wakeups marked by sched_waking",
+		options:     []Option{WakeupsMarkedBy(SchedWakingEvent)},
+		want: []*ThreadWakeupLatencies{{
+			PID: 10,
+			Wakeups: []*WakeupLatency{
+				{WakingTimestamp: 1020, RunningTimestamp: 1050, CPU: 1, Latency: 30},
+				{WakingTimestamp: 1070, RunningTimestamp: 1090, CPU: 1, Latency: 20},
+			},
+			MeanLatency: 25,
+			MaxLatency:  30,
+		}},
+	}, {
+		description: "time range",
+		filters:     []Filter{TimeRange(1060, 1120)},
+		want: []*ThreadWakeupLatencies{{
+			PID: 10,
+			Wakeups: []*WakeupLatency{
+				{WakingTimestamp: 1070, RunningTimestamp: 1090, CPU: 1, Latency: 20},
+			},
+			MeanLatency: 20,
+			MaxLatency:  20,
+		}},
+	}, {
+		description: "CPU filter",
+		filters:     []Filter{CPUs(0)},
+		want:        []*ThreadWakeupLatencies{},
+	}, {
+		description: "PID filter",
+		filters:     []Filter{PIDs(20)},
+		want:        []*ThreadWakeupLatencies{},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			coll := newWakingCollection(t, test.options...)
+			got, err := coll.WakeupLatencies(test.filters...)
+			if err != nil {
+				t.Fatalf("WakeupLatencies yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("WakeupLatencies(): Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
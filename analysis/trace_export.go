@@ -0,0 +1,410 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Package traceexport converts sched Collections into trace formats that can
+// be opened without SchedViz: the Chrome Trace Event JSON format, read by
+// chrome://tracing and the Perfetto UI, and Perfetto's protobuf trace format.
+package traceexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/analysis/sched"
+	perfettopb "github.com/google/schedviz/perfetto/perfetto_trace_go_proto"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// Format specifies a trace format that Collections can be exported as.
+type Format string
+
+const (
+	// ChromeJSON is the Chrome Trace Event JSON format.
+	ChromeJSON Format = "json"
+	// PerfettoProto is Perfetto's protobuf trace format. Intervals and events are
+	// stored as track events.
+	PerfettoProto Format = "perfetto"
+)
+
+// Categories of exported slices and instants.
+const (
+	runningCategory     = "running"
+	threadStateCategory = "thread_state"
+	eventCategory       = "event"
+)
+
+// slice is a span of time on a track.
+type slice struct {
+	name       string
+	category   string
+	start, end trace.Timestamp
+	args       map[string]interface{}
+}
+
+// instant is a point in time on a track.
+type instant struct {
+	name     string
+	category string
+	ts       trace.Timestamp
+	args     map[string]interface{}
+}
+
+// track is a timeline of slices and instants, belonging to either a CPU or a
+// thread.
+type track struct {
+	// The CPU or PID of the track.
+	id       int64
+	name     string
+	slices   []*slice
+	instants []*instant
+}
+
+// tracks holds the tracks of an exported Collection, sorted by ID.
+type tracks struct {
+	cpus    []*track
+	threads []*track
+}
+
+// Export writes the filtered-in portion of a Collection to w in the specified
+// format. Each CPU has a track holding slices for the threads that ran on it,
+// and instants for the raw events that it logged. Each thread has a track
+// holding slices for the states (running, waiting or sleeping) it was in.
+// FILTERS:
+//   TimeRange, StartTimestamp, EndTimestamp: Only slices and instants in the
+//       filtered-in range are exported.
+//   CPUs: Only the filtered-in CPUs are exported.
+//   PIDs: Only the filtered-in threads are exported.
+//   EventTypes: Only the filtered-in raw events are exported.
+func Export(c *sched.Collection, format Format, w io.Writer, filters ...sched.Filter) error {
+	var write func(*tracks, io.Writer) error
+	switch format {
+	case ChromeJSON:
+		write = writeChromeJSON
+	case PerfettoProto:
+		write = writePerfetto
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown export format %q", format)
+	}
+	t, err := collectTracks(c, filters...)
+	if err != nil {
+		return err
+	}
+	return write(t, w)
+}
+
+// withFilter returns a copy of filters with f appended, so that f overrides
+// any earlier filter of the same kind.
+func withFilter(filters []sched.Filter, f sched.Filter) []sched.Filter {
+	ret := make([]sched.Filter, len(filters), len(filters)+1)
+	copy(ret, filters)
+	return append(ret, f)
+}
+
+func collectTracks(c *sched.Collection, filters ...sched.Filter) (*tracks, error) {
+	cpuTracks := map[int64]*track{}
+	cpuTrack := func(cpu int64) *track {
+		t, ok := cpuTracks[cpu]
+		if !ok {
+			t = &track{id: cpu, name: fmt.Sprintf("CPU %d", cpu)}
+			cpuTracks[cpu] = t
+		}
+		return t
+	}
+
+	for cpu := range c.CPUs(filters...) {
+		t := cpuTrack(int64(cpu))
+		intervals, err := c.CPUIntervals(false /*=splitOnWaitingPIDChange*/, withFilter(filters, sched.CPUs(cpu))...)
+		if err != nil {
+			return nil, err
+		}
+		for _, interval := range intervals {
+			// Zero-length intervals, such as those at the ends of the trace, aren't
+			// visible, so they are skipped.
+			if interval == nil || interval.Duration <= 0 {
+				continue
+			}
+			for _, tr := range interval.ThreadResidencies {
+				if tr.State != sched.RunningState {
+					continue
+				}
+				t.slices = append(t.slices, &slice{
+					name:     tr.Thread.Command,
+					category: runningCategory,
+					start:    interval.StartTimestamp,
+					end:      interval.StartTimestamp + trace.Timestamp(interval.Duration),
+					args: map[string]interface{}{
+						"pid":  int64(tr.Thread.PID),
+						"prio": int64(tr.Thread.Priority),
+					},
+				})
+			}
+		}
+	}
+
+	events, err := c.GetRawEvents(filters...)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range events {
+		args := map[string]interface{}{}
+		for name, value := range ev.TextProperties {
+			args[name] = value
+		}
+		for name, value := range ev.NumberProperties {
+			args[name] = value
+		}
+		t := cpuTrack(ev.CPU)
+		t.instants = append(t.instants, &instant{
+			name:     ev.Name,
+			category: eventCategory,
+			ts:       ev.Timestamp,
+			args:     args,
+		})
+	}
+
+	pidsAndComms, err := c.PIDsAndComms(filters...)
+	if err != nil {
+		return nil, err
+	}
+	ret := &tracks{}
+	for pid, comms := range pidsAndComms {
+		t := &track{id: int64(pid), name: fmt.Sprintf("%s %d", strings.Join(comms, "/"), pid)}
+		intervals, err := c.ThreadIntervals(withFilter(filters, sched.PIDs(pid))...)
+		if err != nil {
+			return nil, err
+		}
+		for _, interval := range intervals {
+			if interval.Duration <= 0 || len(interval.ThreadResidencies) == 0 {
+				continue
+			}
+			tr := interval.ThreadResidencies[0]
+			t.slices = append(t.slices, &slice{
+				name:     tr.State.String(),
+				category: threadStateCategory,
+				start:    interval.StartTimestamp,
+				end:      interval.StartTimestamp + trace.Timestamp(interval.Duration),
+				args: map[string]interface{}{
+					"cpu":  int64(interval.CPU),
+					"prio": int64(tr.Thread.Priority),
+				},
+			})
+		}
+		ret.threads = append(ret.threads, t)
+	}
+	for _, t := range cpuTracks {
+		ret.cpus = append(ret.cpus, t)
+	}
+	for _, ts := range [][]*track{ret.cpus, ret.threads} {
+		sort.Slice(ts, func(i, j int) bool {
+			return ts[i].id < ts[j].id
+		})
+	}
+	return ret, nil
+}
+
+// The Chrome Trace Event format groups threads into processes. CPU tracks are
+// exported as threads of one process, and thread tracks as threads of another.
+const (
+	chromeCPUsPID    = 0
+	chromeThreadsPID = 1
+)
+
+type chromeEvent struct {
+	Name     string                 `json:"name"`
+	Category string                 `json:"cat,omitempty"`
+	Phase    string                 `json:"ph"`
+	Ts       float64                `json:"ts"`
+	Dur      float64                `json:"dur,omitempty"`
+	PID      int64                  `json:"pid"`
+	TID      int64                  `json:"tid"`
+	Scope    string                 `json:"s,omitempty"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+}
+
+type chromeTrace struct {
+	TraceEvents     []*chromeEvent `json:"traceEvents"`
+	DisplayTimeUnit string         `json:"displayTimeUnit"`
+}
+
+// chromeTimestamp converts a timestamp in ns to the format's µs.
+func chromeTimestamp(ts trace.Timestamp) float64 {
+	return float64(ts) / 1000
+}
+
+func writeChromeJSON(t *tracks, w io.Writer) error {
+	ct := &chromeTrace{
+		TraceEvents:     []*chromeEvent{},
+		DisplayTimeUnit: "ns",
+	}
+	for _, p := range []struct {
+		pid    int64
+		name   string
+		tracks []*track
+	}{
+		{chromeCPUsPID, "CPUs", t.cpus},
+		{chromeThreadsPID, "Threads", t.threads},
+	} {
+		ct.TraceEvents = append(ct.TraceEvents, &chromeEvent{
+			Name: "process_name", Phase: "M", PID: p.pid,
+			Args: map[string]interface{}{"name": p.name},
+		})
+		for idx, tr := range p.tracks {
+			ct.TraceEvents = append(ct.TraceEvents,
+				&chromeEvent{
+					Name: "thread_name", Phase: "M", PID: p.pid, TID: tr.id,
+					Args: map[string]interface{}{"name": tr.name},
+				},
+				&chromeEvent{
+					Name: "thread_sort_index", Phase: "M", PID: p.pid, TID: tr.id,
+					Args: map[string]interface{}{"sort_index": idx},
+				})
+			for _, s := range tr.slices {
+				ct.TraceEvents = append(ct.TraceEvents, &chromeEvent{
+					Name:     s.name,
+					Category: s.category,
+					Phase:    "X",
+					Ts:       chromeTimestamp(s.start),
+					Dur:      chromeTimestamp(s.end - s.start),
+					PID:      p.pid,
+					TID:      tr.id,
+					Args:     s.args,
+				})
+			}
+			for _, i := range tr.instants {
+				ct.TraceEvents = append(ct.TraceEvents, &chromeEvent{
+					Name:     i.name,
+					Category: i.category,
+					Phase:    "i",
+					Ts:       chromeTimestamp(i.ts),
+					PID:      p.pid,
+					TID:      tr.id,
+					Scope:    "t",
+					Args:     i.args,
+				})
+			}
+		}
+	}
+	if err := json.NewEncoder(w).Encode(ct); err != nil {
+		return status.Errorf(codes.Internal, "failed to encode JSON: %s", err)
+	}
+	return nil
+}
+
+// perfettoSequenceID is the trusted_packet_sequence_id of all exported
+// packets.
+const perfettoSequenceID = 1
+
+func debugAnnotations(args map[string]interface{}) []*perfettopb.DebugAnnotation {
+	var names []string
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ret []*perfettopb.DebugAnnotation
+	for _, name := range names {
+		da := &perfettopb.DebugAnnotation{Name: proto.String(name)}
+		switch v := args[name].(type) {
+		case int64:
+			da.Value = &perfettopb.DebugAnnotation_IntValue{IntValue: v}
+		case string:
+			da.Value = &perfettopb.DebugAnnotation_StringValue{StringValue: v}
+		default:
+			da.Value = &perfettopb.DebugAnnotation_StringValue{StringValue: fmt.Sprint(v)}
+		}
+		ret = append(ret, da)
+	}
+	return ret
+}
+
+func writePerfetto(t *tracks, w io.Writer) error {
+	pt := &perfettopb.Trace{}
+	var nextUUID uint64
+	addTrack := func(name string, parentUUID uint64) uint64 {
+		nextUUID++
+		td := &perfettopb.TrackDescriptor{
+			Uuid: proto.Uint64(nextUUID),
+			Name: proto.String(name),
+		}
+		if parentUUID != 0 {
+			td.ParentUuid = proto.Uint64(parentUUID)
+		}
+		pt.Packet = append(pt.Packet, &perfettopb.TracePacket{
+			Data:                    &perfettopb.TracePacket_TrackDescriptor{TrackDescriptor: td},
+			TrustedPacketSequenceId: proto.Uint32(perfettoSequenceID),
+		})
+		return nextUUID
+	}
+	addEvent := func(ts trace.Timestamp, te *perfettopb.TrackEvent) {
+		pt.Packet = append(pt.Packet, &perfettopb.TracePacket{
+			Timestamp:               proto.Uint64(uint64(ts)),
+			Data:                    &perfettopb.TracePacket_TrackEvent{TrackEvent: te},
+			TrustedPacketSequenceId: proto.Uint32(perfettoSequenceID),
+		})
+	}
+
+	for _, p := range []struct {
+		name   string
+		tracks []*track
+	}{
+		{"CPUs", t.cpus},
+		{"Threads", t.threads},
+	} {
+		parentUUID := addTrack(p.name, 0)
+		for _, tr := range p.tracks {
+			uuid := addTrack(tr.name, parentUUID)
+			for _, s := range tr.slices {
+				addEvent(s.start, &perfettopb.TrackEvent{
+					Type:             perfettopb.TrackEvent_TYPE_SLICE_BEGIN.Enum(),
+					TrackUuid:        proto.Uint64(uuid),
+					Name:             proto.String(s.name),
+					Categories:       []string{s.category},
+					DebugAnnotations: debugAnnotations(s.args),
+				})
+				addEvent(s.end, &perfettopb.TrackEvent{
+					Type:      perfettopb.TrackEvent_TYPE_SLICE_END.Enum(),
+					TrackUuid: proto.Uint64(uuid),
+				})
+			}
+			for _, i := range tr.instants {
+				addEvent(i.ts, &perfettopb.TrackEvent{
+					Type:             perfettopb.TrackEvent_TYPE_INSTANT.Enum(),
+					TrackUuid:        proto.Uint64(uuid),
+					Name:             proto.String(i.name),
+					Categories:       []string{i.category},
+					DebugAnnotations: debugAnnotations(i.args),
+				})
+			}
+		}
+	}
+	if len(pt.Packet) > 0 {
+		pt.Packet[0].SequenceFlags = proto.Uint32(uint32(perfettopb.TracePacket_SEQ_INCREMENTAL_STATE_CLEARED))
+	}
+
+	buf, err := proto.Marshal(pt)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to marshal Perfetto trace: %s", err)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return status.Errorf(codes.Internal, "failed to write Perfetto trace: %s", err)
+	}
+	return nil
+}
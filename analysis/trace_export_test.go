@@ -0,0 +1,180 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceexport
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/schedviz/analysis/sched"
+	"github.com/google/schedviz/analysis/schedtestcommon"
+	perfettopb "github.com/google/schedviz/perfetto/perfetto_trace_go_proto"
+)
+
+// testSlice is a slice or instant on a named track, as read back from an exported trace.
+type testSlice struct {
+	Track      string
+	Name       string
+	Start, End int64
+}
+
+var wantSlices = []testSlice{
+	{"CPU 1", "Process1", 1010, 1100},
+	{"CPU 1", "Process3", 1000, 1010},
+	{"CPU 2", "Process4", 1000, 1100},
+	{"Process1 100", "Running", 1010, 1100},
+	{"Process1 100", "Waiting", 1000, 1010},
+	{"Process2 200", "Sleeping", 1000, 1040},
+	{"Process2 200", "Waiting", 1040, 1080},
+	{"Process2 200", "Waiting", 1080, 1100},
+	{"Process3 300", "Running", 1000, 1010},
+	{"Process3 300", "Sleeping", 1010, 1090},
+	{"Process3 300", "Waiting", 1090, 1100},
+	{"Process4 400", "Running", 1000, 1100},
+}
+
+// Wakeups are logged on CPU 0, while other events are logged on the CPU they affect.
+var wantInstants = []testSlice{
+	{"CPU 0", "sched_migrate_task", 1080, 1080},
+	{"CPU 0", "sched_wakeup", 1000, 1000},
+	{"CPU 0", "sched_wakeup", 1040, 1040},
+	{"CPU 0", "sched_wakeup", 1090, 1090},
+	{"CPU 1", "sched_switch", 1000, 1000},
+	{"CPU 1", "sched_switch", 1010, 1010},
+	{"CPU 1", "sched_switch", 1100, 1100},
+	{"CPU 2", "sched_switch", 1100, 1100},
+}
+
+func sortTestSlices(slices []testSlice) {
+	sort.Slice(slices, func(i, j int) bool {
+		a, b := slices[i], slices[j]
+		if a.Track != b.Track {
+			return a.Track < b.Track
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Start < b.Start
+	})
+}
+
+func exportTestTrace(t *testing.T, format Format) []byte {
+	t.Helper()
+	c, err := sched.NewCollection(schedtestcommon.TestTrace1(t), sched.NormalizeTimestamps(false))
+	if err != nil {
+		t.Fatalf("NewCollection() yielded unexpected error %v", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := Export(c, format, buf); err != nil {
+		t.Fatalf("Export() yielded unexpected error %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportChromeJSON(t *testing.T) {
+	ct := &chromeTrace{}
+	if err := json.Unmarshal(exportTestTrace(t, ChromeJSON), ct); err != nil {
+		t.Fatalf("failed to unmarshal exported JSON: %s", err)
+	}
+	trackNames := map[[2]int64]string{}
+	for _, ev := range ct.TraceEvents {
+		if ev.Phase == "M" && ev.Name == "thread_name" {
+			trackNames[[2]int64{ev.PID, ev.TID}] = ev.Args["name"].(string)
+		}
+	}
+	var gotSlices, gotInstants []testSlice
+	for _, ev := range ct.TraceEvents {
+		track := trackNames[[2]int64{ev.PID, ev.TID}]
+		// Convert the format's µs back into ns.
+		start := int64(ev.Ts*1000 + 0.5)
+		switch ev.Phase {
+		case "X":
+			gotSlices = append(gotSlices, testSlice{track, ev.Name, start, start + int64(ev.Dur*1000+0.5)})
+		case "i":
+			gotInstants = append(gotInstants, testSlice{track, ev.Name, start, start})
+		}
+	}
+	sortTestSlices(gotSlices)
+	sortTestSlices(gotInstants)
+	if diff := cmp.Diff(wantSlices, gotSlices); diff != "" {
+		t.Errorf("exported slices: Diff -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff(wantInstants, gotInstants); diff != "" {
+		t.Errorf("exported instants: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestExportPerfetto(t *testing.T) {
+	pt := &perfettopb.Trace{}
+	if err := proto.Unmarshal(exportTestTrace(t, PerfettoProto), pt); err != nil {
+		t.Fatalf("failed to unmarshal exported trace: %s", err)
+	}
+	trackNames := map[uint64]string{}
+	openSlices := map[uint64]*testSlice{}
+	var gotSlices, gotInstants []testSlice
+	for _, packet := range pt.GetPacket() {
+		if packet.GetTrustedPacketSequenceId() != perfettoSequenceID {
+			t.Errorf("packet has sequence ID %d, want %d", packet.GetTrustedPacketSequenceId(), perfettoSequenceID)
+		}
+		if td := packet.GetTrackDescriptor(); td != nil {
+			trackNames[td.GetUuid()] = td.GetName()
+			continue
+		}
+		te := packet.GetTrackEvent()
+		ts := int64(packet.GetTimestamp())
+		track := trackNames[te.GetTrackUuid()]
+		switch te.GetType() {
+		case perfettopb.TrackEvent_TYPE_SLICE_BEGIN:
+			if _, ok := openSlices[te.GetTrackUuid()]; ok {
+				t.Fatalf("slice %q began on track %q while another slice was open", te.GetName(), track)
+			}
+			openSlices[te.GetTrackUuid()] = &testSlice{track, te.GetName(), ts, 0}
+		case perfettopb.TrackEvent_TYPE_SLICE_END:
+			s, ok := openSlices[te.GetTrackUuid()]
+			if !ok {
+				t.Fatalf("slice ended on track %q without beginning", track)
+			}
+			s.End = ts
+			gotSlices = append(gotSlices, *s)
+			delete(openSlices, te.GetTrackUuid())
+		case perfettopb.TrackEvent_TYPE_INSTANT:
+			gotInstants = append(gotInstants, testSlice{track, te.GetName(), ts, ts})
+		}
+	}
+	sortTestSlices(gotSlices)
+	sortTestSlices(gotInstants)
+	if diff := cmp.Diff(wantSlices, gotSlices); diff != "" {
+		t.Errorf("exported slices: Diff -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff(wantInstants, gotInstants); diff != "" {
+		t.Errorf("exported instants: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	c, err := sched.NewCollection(schedtestcommon.TestTrace1(t), sched.NormalizeTimestamps(false))
+	if err != nil {
+		t.Fatalf("NewCollection() yielded unexpected error %v", err)
+	}
+	if err := Export(c, Format("xml"), &bytes.Buffer{}); err == nil {
+		t.Errorf("Export() yielded no error for an unknown format")
+	}
+}
@@ -32,10 +32,117 @@ export declare interface CreateCollectionRequest {
   // The time of this collection's creation.  If left empty, it will be
   // autopopulated at the time of collection creation.
   creationTime?: number;
+  // Optional filters applied while an uploaded trace is parsed.  Events that
+  // don't pass them are dropped.  If nonzero, events recorded before
+  // startTimestamp, or after endTimestamp, are dropped.  Timestamps are in the
+  // units of the trace clock, as recorded in the raw trace.
+  startTimestamp?: number;
+  endTimestamp?: number;
+  // If nonempty, only events with these names are kept.
+  includedEvents?: string[];
+  // Events with these names are dropped.
+  excludedEvents?: string[];
+  // If nonempty, only events recorded on these CPUs are kept.
+  cpus?: number[];
+  // The event that marks thread wakeups, the transitions from sleeping to
+  // waiting, when the collection is analyzed: sched_wakeup or sched_waking.
+  // If left empty, sched_wakeup is used.
+  wakeupEvent?: string;
 }
 
 /**
- * A response for a collection parameters request.
+ * MergeCollectionsRequest is a request to create a new collection by merging
+ * existing ones
+ */
+export declare interface MergeCollectionsRequest {
+  // The unique names of the collections to merge.  At least two are required.
+  collectionNames: string[];
+  // The role tags to own the merged collection.
+  owners?: string[];
+  // The tags to initially set in the merged collection.
+  tags?: string[];
+  // The merged collection's description.  If left empty, it will list the
+  // merged collections.
+  description?: string;
+  // The time of the merged collection's creation.  If left empty, it will be
+  // autopopulated at the time of collection creation.
+  creationTime?: number;
+}
+
+/**
+ * SliceCollectionRequest is a request to create a new collection from a time
+ * range, and optionally a subset of the CPUs and PIDs, of an existing one.
+ * Timestamps of -1 refer to the start or end of the collection.
+ */
+export declare interface SliceCollectionRequest {
+  // The unique name of the collection to slice.
+  collectionName: string;
+  startTimestampNs: number;
+  endTimestampNs: number;
+  cpus?: number[];
+  pids?: number[];
+  // The role tags to own the sliced collection.
+  owners?: string[];
+  // The tags to initially set in the sliced collection.
+  tags?: string[];
+  // The sliced collection's description.  If left empty, it will name the
+  // collection it was sliced from.
+  description?: string;
+  // The time of the sliced collection's creation.  If left empty, it will be
+  // autopopulated at the time of collection creation.
+  creationTime?: number;
+}
+
+/**
+ * HostCollection is one of the hosts to combine in a multi-host collection.
+ */
+export declare interface HostCollection {
+  // The unique name of the collection holding the host's trace.
+  collectionName: string;
+  // The host's name.  If left empty, the collection's target machine, or
+  // failing that its name, is used.
+  host?: string;
+  // The duration, in ns, to add to the host's timestamps.  If left empty, it
+  // is estimated from the sync events the host shares with the first host.
+  clockOffsetNs?: number;
+}
+
+/**
+ * MultiHostCollectionRequest is a request to create a new collection holding
+ * the traces of several hosts, recorded at the same time, on a common
+ * timeline.  Each host's CPUs and PIDs are namespaced by its index.
+ */
+export declare interface MultiHostCollectionRequest {
+  // The hosts to combine.  At least two are required.
+  hosts: HostCollection[];
+  // The sync events used to estimate clock offsets.  If left empty, these are
+  // 'print' and 'buf', matching writes to each host's trace_marker.
+  syncEventName?: string;
+  syncProperty?: string;
+  // The role tags to own the new collection.
+  owners?: string[];
+  // The tags to initially set in the new collection.
+  tags?: string[];
+  // The new collection's description.  If left empty, it will list the hosts.
+  description?: string;
+  // The time of the new collection's creation.  If left empty, it will be
+  // autopopulated at the time of collection creation.
+  creationTime?: number;
+}
+
+/**
+ * The parameters of one host in a multi-host collection.
+ */
+export declare interface HostParameters {
+  host: string;
+  // The host's CPUs, by their IDs on the host.
+  cpus: number[];
+  clockOffsetNs: number;
+}
+
+/**
+ * A response for a collection parameters request.  The CPUs of multi-host
+ * collections are namespaced, and their hosts listed.
  */
 export declare interface CollectionParametersResponse {
   collectionName: string;
@@ -43,6 +150,8 @@ export declare interface CollectionParametersResponse {
   startTimestampNs: number;
   endTimestampNs: number;
   ftraceEvents: string[];
+  // Set only for multi-host collections.
+  hosts?: HostParameters[];
 }
 
 /**
@@ -37,4 +37,10 @@ export declare interface Metadata {
   // The schedviz.analysis.event_loaders.LoadersType used by default for this
   // collection.
   defaultEventLoader: number;
+  // If this collection was sliced from another one, the unique name of that
+  // collection.
+  parentCollection: string;
+  // The event that marks thread wakeups in this collection: sched_wakeup or
+  // sched_waking.  If empty, sched_wakeup.
+  wakeupEvent: string;
 }
@@ -46,6 +46,13 @@ export declare interface Metrics {
   runTimeNs: number;
   waitTimeNs: number;
   sleepTimeNs: number;
+  // Breakdowns of waitTimeNs and sleepTimeNs by task state.  Waits and sleeps
+  // with no known task state are in none of these.
+  preemptedTimeNs?: number;
+  interruptibleSleepTimeNs?: number;
+  uninterruptibleSleepTimeNs?: number;
+  stoppedTimeNs?: number;
+  idleTimeNs?: number;
   // Unique PIDs, COMMs, priorities, and CPUs observed in the aggregated trace.
   // Note that these fields are not correlated; if portions of trace containing
   // execution from several different PIDs are aggregated together in a metric,
@@ -59,6 +66,11 @@ export declare interface Metrics {
   commands: string[];
   priorities: number[];
   cpus: number[];
+  // The generation of the summarized PID.  Thread summaries are produced
+  // separately for each generation of a reused PID.
+  generation?: number;
+  // The TGID of the process the summarized PIDs belong to.
+  tgid?: number;
   // The time range over which these metrics were aggregated.
   startTimestampNs: number;
   endTimestampNs: number;
@@ -82,6 +94,8 @@ export declare interface AntagonistsRequest {
   // The collection name.
   collectionName: string;
   pids: number[];
+  // TGIDs of processes whose threads are treated together as a single victim.
+  tgids?: number[];
   startTimestampNs: number;
   endTimestampNs: number;
 }
@@ -93,6 +107,8 @@ export declare interface AntagonistsRequest {
  */
 declare interface Antagonism {
   runningThread: Thread;
+  // The waiting thread, reported only for process antagonists.
+  victim?: Thread;
   cpu: number;
   startTimestamp: number;
   endTimestamp: number;
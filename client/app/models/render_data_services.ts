@@ -19,6 +19,12 @@
  */
 export declare interface CpuIntervalsRequest {
   collectionName: string;
+  /**
+   * In a multi-host collection, the host to request intervals for.  If set,
+   * cpus, and the CPUs and PIDs in the response, are the host's own;
+   * otherwise they are namespaced.
+   */
+  host?: string;
   /**
    * The CPUs to request intervals for.  If empty, all CPUs are selected.
    */
@@ -49,6 +55,9 @@ export declare interface Thread {
   pid: number;
   command: string;
   priority: number;
+  // Which of the threads to have had this PID this is: 0 for the first, and
+  // incremented each time the PID was reused within the trace.
+  generation?: number;
 }
 
 /**
@@ -62,6 +71,10 @@ export declare interface ThreadResidency {
    */
   duration: number;
   state: ThreadState;
+  /**
+   * The task state refining state, as a TaskState, or 0 if it has none.
+   */
+  taskState?: number;
   droppedEventIDs: number[];
   /**
    * Set to true if this was constructed from at least one synthetic transitions
@@ -192,6 +205,12 @@ export declare interface PidIntervalsRequest {
    * The name of the collection to look up intervals in
    */
   collectionName: string;
+  /**
+   * In a multi-host collection, the host to request intervals for.  If set,
+   * pids, and the CPUs and PIDs in the response, are the host's own;
+   * otherwise they are namespaced.
+   */
+  host?: string;
   /**
    * The PIDs to request intervals for
    */
@@ -213,6 +232,16 @@ export declare interface PidIntervalsRequest {
    * merging is performed.
    */
   minIntervalDurationNs: number;
+  /**
+   * If nonzero, a mask of ThreadStates; only intervals in these states are
+   * returned.
+   */
+  threadStates?: number;
+  /**
+   * If nonzero, a mask of TaskStates refining threadStates; only Waiting and
+   * Sleeping intervals in these task states are returned.
+   */
+  taskStates?: number;
 }
 
 /**
@@ -235,6 +264,10 @@ export enum ThreadState {
    * Neither running nor on the run queue.
    */
   SLEEPING_STATE = 8,
+  /**
+   * Not yet forked, or exited.
+   */
+  DEAD_STATE = 16,
 }
 
 /**
@@ -250,11 +283,40 @@ export function threadStateToString(state: ThreadState) {
       return 'Waiting';
     case ThreadState.SLEEPING_STATE:
       return 'Sleeping';
+    case ThreadState.DEAD_STATE:
+      return 'Dead';
     default:
       return 'Invalid State';
   }
 }
 
+/**
+ * TaskState is an enum describing why a waiting or sleeping thread left its
+ * CPU, from the prev_state of the sched_switch that switched it out
+ */
+export enum TaskState {
+  /**
+   * Preempted while still runnable.
+   */
+  PREEMPTED = 1,
+  /**
+   * Sleeping interruptibly (S).
+   */
+  INTERRUPTIBLE = 2,
+  /**
+   * Sleeping uninterruptibly (D), usually on IO.
+   */
+  UNINTERRUPTIBLE = 4,
+  /**
+   * Stopped or traced (T or t).
+   */
+  STOPPED = 8,
+  /**
+   * Idle (I).
+   */
+  IDLE = 16,
+}
+
 /**
  * PIDIntervals is a tuple holding a PID and its intervals
  */
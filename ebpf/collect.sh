@@ -66,6 +66,12 @@ bpftrace ${LOCAL_PATH_TO_SCRIPT} |
   sort -n |
   sed 's/^[^ ]* //' > "${TMP}/ebpf_trace"
 
+# Save the thread group, or process, of each thread, as '<tgid> <pid>' lines
+for task in /proc/[0-9]*/task/[0-9]*; do
+  t="${task#/proc/}"
+  echo "${t%%/*} ${t##*/}"
+done > "${TMP}/thread_groups" 2> /dev/null
+
 echo "Creating tar file"
 rm -f "${OUT}/trace.tar.gz"
 chmod -R a+rwX "${TMP}"
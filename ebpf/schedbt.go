@@ -56,6 +56,8 @@ var unknownPrioAndComm = &prioAndComm{
 // W:<0x timestamp>:<0x cpu>:<0x target cpu>:<0x pid>
 //    Wakeup.  Timestamp offset from start timestamp.  cpu is the reporting
 //    CPU.
+// T:<0x timestamp>:<0x pid>:<0x tgid>
+//    Thread group of pid.  Timestamp offset from start timestamp.
 type Parser struct {
 	startTimestamp    int64
 	hasStartTimestamp bool
@@ -215,6 +217,27 @@ func (p *Parser) parsePIDInfo(row string, parts []string) error {
 	return nil
 }
 
+func (p *Parser) parseThreadGroup(row string, parts []string) error {
+	// T:<0x timestamp>:<0x pid>:<0x tgid>
+	var (
+		err  error
+		ts   int64
+		pid  int64
+		tgid int64
+	)
+	if len(parts) != 3 {
+		return badRow(row)
+	}
+	for idx, v := range []*int64{&ts, &pid, &tgid} {
+		*v, err = strconv.ParseInt(parts[idx], 16, 64)
+		if err != nil {
+			return badRow(row)
+		}
+	}
+	p.esb.WithThreadGroup(tgid, pid)
+	return nil
+}
+
 func (p *Parser) parseMigrate(row string, parts []string) error {
 	// M:<0x timestamp>:<0x cpu>:<0x orig cpu>:<0x dest cpu>:<0x pid>
 	var (
@@ -316,6 +339,8 @@ func (p *Parser) Parse(r *bufio.Reader) error {
 				return p.parseMigrate(row, parts)
 			case "W":
 				return p.parseWakeup(row, parts)
+			case "T":
+				return p.parseThreadGroup(row, parts)
 			default:
 				// Skip unparsable rows.
 				return nil
@@ -60,6 +60,20 @@ M:a:0:0:1:64`,
 			WithEvent("sched_migrate_task", 0, 0xbeef+10, false,
 				100, "Thread:1", 112,
 				0, 1)),
+	}, {
+		"Thread groups",
+		`Attaching 5 probes...
+ST:0:beef
+T:a:64:64
+T:a:65:64
+T:14:c8:c8
+S:14:0:c8:0:65`,
+		testeventsetbuilder.TestProtobuf(t, emptyEventSet().
+			WithEvent("sched_switch", 0, 0xbeef+20, false,
+				200, "<unknown>", 0, 0,
+				101, "<unknown>", 0).
+			WithThreadGroup(100, 101).
+			WithThreadGroup(200)),
 	}}
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
@@ -0,0 +1,320 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Package perfetto provides a type to convert a Perfetto trace containing
+// ftrace event bundles into an EventSet suitable for visualization in SchedViz.
+package perfetto
+
+import (
+	"io"
+	"io/ioutil"
+
+	log "github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	elpb "github.com/google/schedviz/analysis/event_loaders_go_proto"
+	perfettopb "github.com/google/schedviz/perfetto/perfetto_trace_go_proto"
+	"github.com/google/schedviz/tracedata/eventsetbuilder"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+const unknownComm = "<unknown>"
+
+type task struct {
+	pid  int64
+	comm string
+	prio int64
+}
+
+// Parser assembles the ftrace events in Perfetto traces into an EventSet.
+// sched_switch, sched_wakeup, sched_waking and sched_migrate_task events are
+// converted, whether they are stored as individual ftrace events or in the
+// compact sched encoding. All other events are skipped.
+// Perfetto does not record the previous task of sched_switch events in the
+// compact sched encoding; it is instead taken to be the task that was last
+// switched in on the same CPU, so the first compact sched_switch on each CPU
+// is dropped. Events with empty comms have their comms filled in from the
+// process and thread descriptors in the trace.
+type Parser struct {
+	esb *eventsetbuilder.Builder
+	// Thread names by TID, and process names by PID.
+	threadNames  map[int64]string
+	processNames map[int64]string
+	// The task that was most recently switched in on each CPU.
+	runningByCPU   map[int64]*task
+	overflowedCPUs map[int64]struct{}
+	seenCPUs       map[int64]struct{}
+}
+
+const (
+	swakeup  = "sched_wakeup"
+	swaking  = "sched_waking"
+	sswitch  = "sched_switch"
+	smigrate = "sched_migrate_task"
+)
+
+func emptyEventSet() *eventsetbuilder.Builder {
+	return eventsetbuilder.NewBuilder().
+		WithEventDescriptor(
+			swakeup,
+			eventsetbuilder.Number("pid"),
+			eventsetbuilder.Text("comm"),
+			eventsetbuilder.Number("prio"),
+			eventsetbuilder.Number("target_cpu")).
+		WithEventDescriptor(
+			swaking,
+			eventsetbuilder.Number("pid"),
+			eventsetbuilder.Text("comm"),
+			eventsetbuilder.Number("prio"),
+			eventsetbuilder.Number("target_cpu")).
+		WithEventDescriptor(
+			sswitch,
+			eventsetbuilder.Number("prev_pid"),
+			eventsetbuilder.Text("prev_comm"),
+			eventsetbuilder.Number("prev_prio"),
+			eventsetbuilder.Number("prev_state"),
+			eventsetbuilder.Number("next_pid"),
+			eventsetbuilder.Text("next_comm"),
+			eventsetbuilder.Number("next_prio")).
+		WithEventDescriptor(
+			smigrate,
+			eventsetbuilder.Number("pid"),
+			eventsetbuilder.Text("comm"),
+			eventsetbuilder.Number("prio"),
+			eventsetbuilder.Number("orig_cpu"),
+			eventsetbuilder.Number("dest_cpu")).
+		WithDefaultEventLoadersType(elpb.LoadersType_FAULT_TOLERANT)
+}
+
+// NewParser returns a new perfetto.Parser ready to parse traces.
+func NewParser() *Parser {
+	return &Parser{
+		esb:            emptyEventSet(),
+		threadNames:    map[int64]string{},
+		processNames:   map[int64]string{},
+		runningByCPU:   map[int64]*task{},
+		overflowedCPUs: map[int64]struct{}{},
+		seenCPUs:       map[int64]struct{}{},
+	}
+}
+
+// EventSet returns an EventSet proto constructed from the parsed traces.
+// CPUs on which Perfetto reported lost events are marked as overflowed, so
+// their events are clipped.
+func (p *Parser) EventSet() (*eventpb.EventSet, error) {
+	overflowedCPUs := map[int64]struct{}{}
+	for cpu := range p.overflowedCPUs {
+		if _, ok := p.seenCPUs[cpu]; ok {
+			overflowedCPUs[cpu] = struct{}{}
+		}
+	}
+	es, errs := p.esb.WithOverflowedCPUs(overflowedCPUs).EventSet()
+	if len(errs) > 0 {
+		log.Errorf("Builder encountered errors:")
+		for _, err := range errs {
+			log.Errorf("  %s", err)
+		}
+		return nil, status.Errorf(codes.Internal, "errors encountered while building EventSet, see log for details")
+	}
+	return es, nil
+}
+
+// Parse parses a serialized Perfetto Trace proto.
+func (p *Parser) Parse(r io.Reader) error {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read trace: %s", err)
+	}
+	trace := &perfettopb.Trace{}
+	if err := proto.Unmarshal(buf, trace); err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to unmarshal Perfetto trace: %s", err)
+	}
+	return p.ParseTrace(trace)
+}
+
+// ParseTrace parses a Perfetto Trace proto.
+func (p *Parser) ParseTrace(trace *perfettopb.Trace) error {
+	// Descriptors may appear anywhere in the trace, so collect them all before
+	// converting any events.
+	for _, packet := range trace.GetPacket() {
+		p.collectNames(packet)
+	}
+	for idx, packet := range trace.GetPacket() {
+		bundle := packet.GetFtraceEvents()
+		if bundle == nil {
+			continue
+		}
+		if err := p.parseBundle(bundle); err != nil {
+			s, _ := status.FromError(err)
+			return status.Errorf(s.Code(), "in packet %d, %s", idx, s.Message())
+		}
+	}
+	return nil
+}
+
+func (p *Parser) collectNames(packet *perfettopb.TracePacket) {
+	if tree := packet.GetProcessTree(); tree != nil {
+		for _, process := range tree.GetProcesses() {
+			if cmdline := process.GetCmdline(); len(cmdline) > 0 && cmdline[0] != "" {
+				p.processNames[int64(process.GetPid())] = cmdline[0]
+			}
+		}
+		for _, thread := range tree.GetThreads() {
+			if thread.GetName() != "" {
+				p.threadNames[int64(thread.GetTid())] = thread.GetName()
+			}
+		}
+	}
+	if track := packet.GetTrackDescriptor(); track != nil {
+		if process := track.GetProcess(); process != nil {
+			name := process.GetProcessName()
+			if cmdline := process.GetCmdline(); name == "" && len(cmdline) > 0 {
+				name = cmdline[0]
+			}
+			if name != "" {
+				p.processNames[int64(process.GetPid())] = name
+			}
+		}
+		if thread := track.GetThread(); thread != nil && thread.GetThreadName() != "" {
+			p.threadNames[int64(thread.GetTid())] = thread.GetThreadName()
+		}
+	}
+}
+
+// comm returns comm if it isn't empty, and otherwise the name of the thread
+// with the provided pid, as found in the trace's descriptors. The main thread
+// of a process falls back to the name of the process.
+func (p *Parser) comm(pid int64, comm string) string {
+	if comm != "" {
+		return comm
+	}
+	if name, ok := p.threadNames[pid]; ok {
+		return name
+	}
+	if name, ok := p.processNames[pid]; ok {
+		return name
+	}
+	return unknownComm
+}
+
+func (p *Parser) parseBundle(bundle *perfettopb.FtraceEventBundle) error {
+	cpu := int64(bundle.GetCpu())
+	if bundle.GetLostEvents() {
+		p.overflowedCPUs[cpu] = struct{}{}
+	}
+	for _, ev := range bundle.GetEvent() {
+		ts := int64(ev.GetTimestamp())
+		switch {
+		case ev.GetSchedSwitch() != nil:
+			e := ev.GetSchedSwitch()
+			prev := &task{pid: int64(e.GetPrevPid()), comm: p.comm(int64(e.GetPrevPid()), e.GetPrevComm()), prio: int64(e.GetPrevPrio())}
+			next := &task{pid: int64(e.GetNextPid()), comm: p.comm(int64(e.GetNextPid()), e.GetNextComm()), prio: int64(e.GetNextPrio())}
+			p.addSwitch(cpu, ts, prev, e.GetPrevState(), next)
+		case ev.GetSchedWakeup() != nil:
+			e := ev.GetSchedWakeup()
+			p.addWakeup(swakeup, cpu, ts, int64(e.GetPid()), e.GetComm(), int64(e.GetPrio()), int64(e.GetTargetCpu()))
+		case ev.GetSchedWaking() != nil:
+			e := ev.GetSchedWaking()
+			p.addWakeup(swaking, cpu, ts, int64(e.GetPid()), e.GetComm(), int64(e.GetPrio()), int64(e.GetTargetCpu()))
+		case ev.GetSchedMigrateTask() != nil:
+			e := ev.GetSchedMigrateTask()
+			pid := int64(e.GetPid())
+			p.seenCPUs[cpu] = struct{}{}
+			p.esb.WithEvent(smigrate, cpu, ts, false,
+				pid, p.comm(pid, e.GetComm()), int64(e.GetPrio()),
+				int64(e.GetOrigCpu()), int64(e.GetDestCpu()))
+		}
+	}
+	if cs := bundle.GetCompactSched(); cs != nil {
+		if err := p.parseCompactSched(cpu, cs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Parser) addSwitch(cpu, ts int64, prev *task, prevState int64, next *task) {
+	p.seenCPUs[cpu] = struct{}{}
+	p.runningByCPU[cpu] = next
+	p.esb.WithEvent(sswitch, cpu, ts, false,
+		prev.pid, prev.comm, prev.prio, prevState,
+		next.pid, next.comm, next.prio)
+}
+
+func (p *Parser) addWakeup(eventName string, cpu, ts, pid int64, comm string, prio, targetCPU int64) {
+	p.seenCPUs[cpu] = struct{}{}
+	p.esb.WithEvent(eventName, cpu, ts, false,
+		pid, p.comm(pid, comm), prio, targetCPU)
+}
+
+var badCompactSched = func(cpu int64, what string) error {
+	return status.Errorf(codes.InvalidArgument, "malformed compact sched data on CPU %d: %s", cpu, what)
+}
+
+func internedComm(cs *perfettopb.FtraceEventBundle_CompactSched, cpu int64, idx uint32) (string, error) {
+	table := cs.GetInternTable()
+	if int(idx) >= len(table) {
+		return "", badCompactSched(cpu, "comm index out of range")
+	}
+	return table[idx], nil
+}
+
+// parseCompactSched converts the sched_switch and sched_waking events stored
+// in the compact sched encoding. Each event's timestamp is stored as a delta
+// from the previous event's.
+func (p *Parser) parseCompactSched(cpu int64, cs *perfettopb.FtraceEventBundle_CompactSched) error {
+	n := len(cs.GetSwitchTimestamp())
+	if len(cs.GetSwitchPrevState()) != n || len(cs.GetSwitchNextPid()) != n ||
+		len(cs.GetSwitchNextPrio()) != n || len(cs.GetSwitchNextCommIndex()) != n {
+		return badCompactSched(cpu, "switch fields have different lengths")
+	}
+	var ts int64
+	for i := 0; i < n; i++ {
+		ts += int64(cs.GetSwitchTimestamp()[i])
+		pid := int64(cs.GetSwitchNextPid()[i])
+		comm, err := internedComm(cs, cpu, cs.GetSwitchNextCommIndex()[i])
+		if err != nil {
+			return err
+		}
+		next := &task{pid: pid, comm: p.comm(pid, comm), prio: int64(cs.GetSwitchNextPrio()[i])}
+		prev, ok := p.runningByCPU[cpu]
+		if !ok {
+			// The task that was switched out isn't known, so this switch can't be
+			// represented. Events after it on this CPU will use its next task.
+			p.runningByCPU[cpu] = next
+			continue
+		}
+		p.addSwitch(cpu, ts, prev, cs.GetSwitchPrevState()[i], next)
+	}
+
+	n = len(cs.GetWakingTimestamp())
+	if len(cs.GetWakingPid()) != n || len(cs.GetWakingTargetCpu()) != n ||
+		len(cs.GetWakingPrio()) != n || len(cs.GetWakingCommIndex()) != n {
+		return badCompactSched(cpu, "waking fields have different lengths")
+	}
+	ts = 0
+	for i := 0; i < n; i++ {
+		ts += int64(cs.GetWakingTimestamp()[i])
+		comm, err := internedComm(cs, cpu, cs.GetWakingCommIndex()[i])
+		if err != nil {
+			return err
+		}
+		p.addWakeup(swaking, cpu, ts, int64(cs.GetWakingPid()[i]), comm,
+			int64(cs.GetWakingPrio()[i]), int64(cs.GetWakingTargetCpu()[i]))
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package perfetto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/golang/protobuf/proto"
+	perfettopb "github.com/google/schedviz/perfetto/perfetto_trace_go_proto"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/testeventsetbuilder"
+)
+
+func bundlePacket(bundle *perfettopb.FtraceEventBundle) *perfettopb.TracePacket {
+	return &perfettopb.TracePacket{
+		Data: &perfettopb.TracePacket_FtraceEvents{FtraceEvents: bundle},
+	}
+}
+
+func switchEvent(ts uint64, prevComm string, prevPid, prevPrio int32, prevState int64, nextComm string, nextPid, nextPrio int32) *perfettopb.FtraceEvent {
+	return &perfettopb.FtraceEvent{
+		Timestamp: proto.Uint64(ts),
+		Event: &perfettopb.FtraceEvent_SchedSwitch{SchedSwitch: &perfettopb.SchedSwitchFtraceEvent{
+			PrevComm:  proto.String(prevComm),
+			PrevPid:   proto.Int32(prevPid),
+			PrevPrio:  proto.Int32(prevPrio),
+			PrevState: proto.Int64(prevState),
+			NextComm:  proto.String(nextComm),
+			NextPid:   proto.Int32(nextPid),
+			NextPrio:  proto.Int32(nextPrio),
+		}},
+	}
+}
+
+func TestParsing(t *testing.T) {
+	tests := []struct {
+		description string
+		input       *perfettopb.Trace
+		want        *eventpb.EventSet
+	}{{
+		"Ftrace events",
+		&perfettopb.Trace{Packet: []*perfettopb.TracePacket{
+			bundlePacket(&perfettopb.FtraceEventBundle{
+				Cpu: proto.Uint32(1),
+				Event: []*perfettopb.FtraceEvent{
+					switchEvent(100, "Thread 1", 10, 120, 1, "", 20, 110),
+					{
+						Timestamp: proto.Uint64(110),
+						Event: &perfettopb.FtraceEvent_SchedWaking{SchedWaking: &perfettopb.SchedWakingFtraceEvent{
+							Comm: proto.String("Thread 1"), Pid: proto.Int32(10), Prio: proto.Int32(120), TargetCpu: proto.Int32(2),
+						}},
+					},
+					{
+						Timestamp: proto.Uint64(120),
+						Event: &perfettopb.FtraceEvent_SchedWakeup{SchedWakeup: &perfettopb.SchedWakeupFtraceEvent{
+							Comm: proto.String("Thread 1"), Pid: proto.Int32(10), Prio: proto.Int32(120), TargetCpu: proto.Int32(2),
+						}},
+					},
+					{
+						Timestamp: proto.Uint64(130),
+						Event: &perfettopb.FtraceEvent_SchedMigrateTask{SchedMigrateTask: &perfettopb.SchedMigrateTaskFtraceEvent{
+							Pid: proto.Int32(30), Prio: proto.Int32(100), OrigCpu: proto.Int32(1), DestCpu: proto.Int32(3),
+						}},
+					},
+				},
+			}),
+			// Descriptors are used to fill in comms even if they come after the events.
+			{
+				Data: &perfettopb.TracePacket_ProcessTree{ProcessTree: &perfettopb.ProcessTree{
+					Processes: []*perfettopb.ProcessTree_Process{{Pid: proto.Int32(30), Cmdline: []string{"/bin/process", "--flag"}}},
+					Threads:   []*perfettopb.ProcessTree_Thread{{Tid: proto.Int32(20), Name: proto.String("Thread 2"), Tgid: proto.Int32(30)}},
+				}},
+			},
+		}},
+		testeventsetbuilder.TestProtobuf(t, emptyEventSet().
+			WithEvent("sched_switch", 1, 100, false,
+				10, "Thread 1", 120, 1,
+				20, "Thread 2", 110).
+			WithEvent("sched_waking", 1, 110, false,
+				10, "Thread 1", 120, 2).
+			WithEvent("sched_wakeup", 1, 120, false,
+				10, "Thread 1", 120, 2).
+			WithEvent("sched_migrate_task", 1, 130, false,
+				30, "/bin/process", 100,
+				1, 3)),
+	}, {
+		"Compact sched",
+		&perfettopb.Trace{Packet: []*perfettopb.TracePacket{
+			{
+				Data: &perfettopb.TracePacket_TrackDescriptor{TrackDescriptor: &perfettopb.TrackDescriptor{
+					Thread: &perfettopb.ThreadDescriptor{Pid: proto.Int32(40), Tid: proto.Int32(41), ThreadName: proto.String("Thread 41")},
+				}},
+			},
+			bundlePacket(&perfettopb.FtraceEventBundle{
+				Cpu: proto.Uint32(0),
+				CompactSched: &perfettopb.FtraceEventBundle_CompactSched{
+					InternTable:         []string{"Thread 1", "Thread 2", ""},
+					SwitchTimestamp:     []uint64{100, 10, 10},
+					SwitchPrevState:     []int64{0, 1, 2},
+					SwitchNextPid:       []int32{10, 20, 41},
+					SwitchNextPrio:      []int32{120, 110, 100},
+					SwitchNextCommIndex: []uint32{0, 1, 2},
+					WakingTimestamp:     []uint64{105, 20},
+					WakingPid:           []int32{20, 10},
+					WakingTargetCpu:     []int32{0, 1},
+					WakingPrio:          []int32{110, 120},
+					WakingCommIndex:     []uint32{1, 0},
+				},
+			}),
+			bundlePacket(&perfettopb.FtraceEventBundle{
+				Cpu: proto.Uint32(0),
+				CompactSched: &perfettopb.FtraceEventBundle_CompactSched{
+					InternTable:         []string{"Thread 1"},
+					SwitchTimestamp:     []uint64{200},
+					SwitchPrevState:     []int64{0},
+					SwitchNextPid:       []int32{10},
+					SwitchNextPrio:      []int32{120},
+					SwitchNextCommIndex: []uint32{0},
+				},
+			}),
+		}},
+		// The first switch is dropped, since the task it switched out isn't known.
+		testeventsetbuilder.TestProtobuf(t, emptyEventSet().
+			WithEvent("sched_switch", 0, 110, false,
+				10, "Thread 1", 120, 1,
+				20, "Thread 2", 110).
+			WithEvent("sched_switch", 0, 120, false,
+				20, "Thread 2", 110, 2,
+				41, "Thread 41", 100).
+			WithEvent("sched_waking", 0, 105, false,
+				20, "Thread 2", 110, 0).
+			WithEvent("sched_waking", 0, 125, false,
+				10, "Thread 1", 120, 1).
+			WithEvent("sched_switch", 0, 200, false,
+				41, "Thread 41", 100, 0,
+				10, "Thread 1", 120)),
+	}, {
+		"Lost events",
+		&perfettopb.Trace{Packet: []*perfettopb.TracePacket{
+			bundlePacket(&perfettopb.FtraceEventBundle{
+				Cpu:   proto.Uint32(0),
+				Event: []*perfettopb.FtraceEvent{switchEvent(100, "Thread 1", 10, 120, 1, "Thread 2", 20, 110)},
+			}),
+			bundlePacket(&perfettopb.FtraceEventBundle{
+				Cpu:        proto.Uint32(1),
+				LostEvents: proto.Bool(true),
+				Event:      []*perfettopb.FtraceEvent{switchEvent(200, "Thread 3", 30, 120, 1, "Thread 4", 40, 110)},
+			}),
+			bundlePacket(&perfettopb.FtraceEventBundle{
+				Cpu:   proto.Uint32(0),
+				Event: []*perfettopb.FtraceEvent{switchEvent(300, "Thread 2", 20, 110, 1, "Thread 1", 10, 120)},
+			}),
+		}},
+		testeventsetbuilder.TestProtobuf(t, emptyEventSet().
+			WithEvent("sched_switch", 0, 100, true,
+				10, "Thread 1", 120, 1,
+				20, "Thread 2", 110).
+			WithEvent("sched_switch", 1, 200, false,
+				30, "Thread 3", 120, 1,
+				40, "Thread 4", 110).
+			WithEvent("sched_switch", 0, 300, false,
+				20, "Thread 2", 110, 1,
+				10, "Thread 1", 120)),
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			buf, err := proto.Marshal(test.input)
+			if err != nil {
+				t.Fatalf("failed to marshal trace: %s", err)
+			}
+			p := NewParser()
+			if err := p.Parse(bytes.NewReader(buf)); err != nil {
+				t.Fatalf("Parse() yielded unexpected error %v", err)
+			}
+			got, err := p.EventSet()
+			if err != nil {
+				t.Fatalf("EventSet() yielded unexpected error %v", err)
+			}
+			if d := cmp.Diff(test.want, got, cmp.Comparer(proto.Equal)); d != "" {
+				t.Errorf("Parser produced %s, diff(want->got) %s", proto.MarshalTextString(got), d)
+			}
+		})
+	}
+}
+
+func TestParsingErrors(t *testing.T) {
+	tests := []struct {
+		description string
+		input       *perfettopb.FtraceEventBundle_CompactSched
+	}{{
+		"Mismatched switch fields",
+		&perfettopb.FtraceEventBundle_CompactSched{
+			InternTable:         []string{"Thread 1"},
+			SwitchTimestamp:     []uint64{100, 10},
+			SwitchPrevState:     []int64{0},
+			SwitchNextPid:       []int32{10},
+			SwitchNextPrio:      []int32{120},
+			SwitchNextCommIndex: []uint32{0},
+		},
+	}, {
+		"Comm index out of range",
+		&perfettopb.FtraceEventBundle_CompactSched{
+			InternTable:     []string{"Thread 1"},
+			WakingTimestamp: []uint64{100},
+			WakingPid:       []int32{10},
+			WakingTargetCpu: []int32{0},
+			WakingPrio:      []int32{120},
+			WakingCommIndex: []uint32{1},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			trace := &perfettopb.Trace{Packet: []*perfettopb.TracePacket{
+				bundlePacket(&perfettopb.FtraceEventBundle{Cpu: proto.Uint32(0), CompactSched: test.input}),
+			}}
+			if err := NewParser().ParseTrace(trace); err == nil {
+				t.Errorf("ParseTrace() yielded no error, want an error")
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ package apiservice
 import (
 	"context"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -27,8 +28,10 @@ import (
 	"github.com/golang/sync/errgroup"
 
 	"github.com/google/schedviz/analysis/sched"
+	"github.com/google/schedviz/analysis/traceexport"
 	"github.com/google/schedviz/server/models"
 	"github.com/google/schedviz/server/storageservice"
+	"github.com/google/schedviz/tracedata/multihost"
 	"github.com/google/schedviz/tracedata/trace"
 )
 
@@ -45,24 +48,67 @@ func (as *APIService) fetchCollection(ctx context.Context, collectionName string
 	return as.StorageService.GetCollection(ctx, collectionName)
 }
 
+// hostIndex returns the index of the requested host in the provided
+// collection, or -1 if no host was requested.
+func hostIndex(c *storageservice.CachedCollection, hostName string) (int, error) {
+	if hostName == "" {
+		return -1, nil
+	}
+	return c.HostIndex(hostName)
+}
+
+// localizeIntervals replaces the namespaced CPUs and PIDs in the provided
+// intervals of a multi-host collection with the IDs used on their own hosts.
+func localizeIntervals(intervals []*sched.Interval) {
+	for _, interval := range intervals {
+		_, cpu := multihost.SplitCPU(int64(interval.CPU))
+		interval.CPU = sched.CPUID(cpu)
+		for i, tr := range interval.ThreadResidencies {
+			// Threads may be shared with other queries, so are copied.
+			thread := *tr.Thread
+			_, pid := multihost.SplitPID(int64(thread.PID))
+			thread.PID = sched.PID(pid)
+			residency := *tr
+			residency.Thread = &thread
+			interval.ThreadResidencies[i] = &residency
+		}
+	}
+}
+
 // GetCPUIntervals returns CPU intervals for the specified collection.
 func (as *APIService) GetCPUIntervals(ctx context.Context, req *models.CPUIntervalsRequest) (*models.CPUIntervalsResponse, error) {
 	c, err := as.fetchCollection(ctx, req.CollectionName)
 	if err != nil {
 		return nil, err
 	}
+	host, err := hostIndex(c, req.Host)
+	if err != nil {
+		return nil, err
+	}
+	cpus := req.CPUs
+	if host >= 0 && len(cpus) == 0 {
+		for _, cpu := range c.SchedCollection().ExpandCPUs(nil) {
+			if cpuHost, hostCPU := multihost.SplitCPU(cpu); cpuHost == host {
+				cpus = append(cpus, hostCPU)
+			}
+		}
+	}
 	res := &models.CPUIntervalsResponse{
 		CollectionName: req.CollectionName,
-		Intervals:      make([]models.CPUIntervals, len(req.CPUs)),
+		Intervals:      make([]models.CPUIntervals, len(cpus)),
 	}
 
 	var g errgroup.Group
-	for i, cpu := range req.CPUs {
+	for i, cpu := range cpus {
 		i := i
+		collectionCPU := cpu
+		if host >= 0 {
+			collectionCPU = multihost.HostCPU(host, cpu)
+		}
 		filters := []sched.Filter{
 			sched.TimeRange(trace.Timestamp(req.StartTimestampNs), trace.Timestamp(req.EndTimestampNs)),
 			sched.MinIntervalDuration(sched.Duration(req.MinIntervalDurationNs)),
-			sched.CPUs(sched.CPUID(cpu)),
+			sched.CPUs(sched.CPUID(collectionCPU)),
 		}
 
 		res.Intervals[i].CPU = cpu
@@ -72,6 +118,9 @@ func (as *APIService) GetCPUIntervals(ctx context.Context, req *models.CPUInterv
 			if err != nil {
 				return err
 			}
+			if host >= 0 {
+				localizeIntervals(cpuIntervals)
+			}
 			res.Intervals[i].Running = cpuIntervals
 			return nil
 		})
@@ -84,6 +133,9 @@ func (as *APIService) GetCPUIntervals(ctx context.Context, req *models.CPUInterv
 			if err != nil {
 				return err
 			}
+			if host >= 0 {
+				localizeIntervals(waitingIntervals)
+			}
 			res.Intervals[i].Waiting = waitingIntervals
 			return nil
 		})
@@ -101,23 +153,45 @@ func (as *APIService) GetPIDIntervals(ctx context.Context, req *models.PidInterv
 	if err != nil {
 		return nil, err
 	}
+	host, err := hostIndex(c, req.Host)
+	if err != nil {
+		return nil, err
+	}
 	res := &models.PIDntervalsResponse{
 		CollectionName: req.CollectionName,
 		PIDIntervals:   make([]models.PIDIntervals, len(req.Pids)),
 	}
 
+	var stateFilters []sched.Filter
+	if req.ThreadStates != 0 || req.TaskStates != 0 {
+		threadStates := req.ThreadStates
+		if threadStates == 0 {
+			threadStates = sched.UnknownState | sched.RunningState | sched.WaitingState | sched.SleepingState
+		}
+		stateFilters = append(stateFilters, sched.ThreadStates(threadStates, req.TaskStates))
+	}
+
 	var g errgroup.Group
 	for i, pid := range req.Pids {
 		i, pid := i, pid
+		collectionPID := pid
+		if host >= 0 {
+			collectionPID = multihost.HostPID(host, pid)
+		}
 		g.Go(func() error {
-			pidIntervals, err := c.SchedCollection().ThreadIntervals(
-				sched.PIDs(sched.PID(pid)),
+			filters := append([]sched.Filter{
+				sched.PIDs(sched.PID(collectionPID)),
 				sched.TimeRange(trace.Timestamp(req.StartTimestampNs), trace.Timestamp(req.EndTimestampNs)),
 				sched.MinIntervalDuration(sched.Duration(req.MinIntervalDurationNs)),
-				sched.TruncateToTimeRange(false))
+				sched.TruncateToTimeRange(false),
+			}, stateFilters...)
+			pidIntervals, err := c.SchedCollection().ThreadIntervals(filters...)
 			if err != nil {
 				return fmt.Errorf("error occurred getting intervals for PID: %d, %v", pid, err)
 			}
+			if host >= 0 {
+				localizeIntervals(pidIntervals)
+			}
 			res.PIDIntervals[i] = models.PIDIntervals{
 				PID:       pid,
 				Intervals: pidIntervals,
@@ -131,6 +205,112 @@ func (as *APIService) GetPIDIntervals(ctx context.Context, req *models.PidInterv
 	return res, nil
 }
 
+// GetThreadLifetimes returns the lifetimes, from fork to exit, of threads in
+// the specified collection, along with their parents and children.
+func (as *APIService) GetThreadLifetimes(ctx context.Context, req *models.ThreadLifetimesRequest) (*models.ThreadLifetimesResponse, error) {
+	c, err := as.fetchCollection(ctx, req.CollectionName)
+	if err != nil {
+		return nil, err
+	}
+	host, err := hostIndex(c, req.Host)
+	if err != nil {
+		return nil, err
+	}
+	filters := []sched.Filter{
+		sched.TimeRange(trace.Timestamp(req.StartTimestampNs), trace.Timestamp(req.EndTimestampNs)),
+	}
+	if len(req.Pids) > 0 {
+		var pids []sched.PID
+		for _, pid := range req.Pids {
+			if host >= 0 {
+				pid = multihost.HostPID(host, pid)
+			}
+			pids = append(pids, sched.PID(pid))
+		}
+		filters = append(filters, sched.PIDs(pids...))
+	}
+	res := &models.ThreadLifetimesResponse{
+		CollectionName: req.CollectionName,
+		Lifetimes:      []*sched.ThreadLifetime{},
+	}
+	localPID := func(pid sched.PID) sched.PID {
+		if pid == sched.UnknownPID {
+			return pid
+		}
+		_, p := multihost.SplitPID(int64(pid))
+		return sched.PID(p)
+	}
+	for _, tl := range c.SchedCollection().ThreadLifetimes(filters...) {
+		if host >= 0 {
+			if tlHost, _ := multihost.SplitPID(int64(tl.PID)); tlHost != host {
+				continue
+			}
+			// ThreadLifetimes returns copies, which may be modified.
+			tl.PID = localPID(tl.PID)
+			tl.ParentPID = localPID(tl.ParentPID)
+			for i, child := range tl.ChildPIDs {
+				tl.ChildPIDs[i] = localPID(child)
+			}
+			for _, exec := range tl.Execs {
+				exec.OldPID = localPID(exec.OldPID)
+			}
+		}
+		res.Lifetimes = append(res.Lifetimes, tl)
+	}
+	return res, nil
+}
+
+// GetWakeupLatencies returns the latencies of the wakeups of threads in the
+// specified collection, from each wakeup's sched_waking to the woken thread's
+// next switch-in.
+func (as *APIService) GetWakeupLatencies(ctx context.Context, req *models.WakeupLatenciesRequest) (*models.WakeupLatenciesResponse, error) {
+	c, err := as.fetchCollection(ctx, req.CollectionName)
+	if err != nil {
+		return nil, err
+	}
+	host, err := hostIndex(c, req.Host)
+	if err != nil {
+		return nil, err
+	}
+	filters := []sched.Filter{
+		sched.TimeRange(trace.Timestamp(req.StartTimestampNs), trace.Timestamp(req.EndTimestampNs)),
+	}
+	if len(req.Pids) > 0 {
+		var pids []sched.PID
+		for _, pid := range req.Pids {
+			if host >= 0 {
+				pid = multihost.HostPID(host, pid)
+			}
+			pids = append(pids, sched.PID(pid))
+		}
+		filters = append(filters, sched.PIDs(pids...))
+	}
+	latencies, err := c.SchedCollection().WakeupLatencies(filters...)
+	if err != nil {
+		return nil, err
+	}
+	res := &models.WakeupLatenciesResponse{
+		CollectionName: req.CollectionName,
+		Latencies:      []*sched.ThreadWakeupLatencies{},
+	}
+	for _, twl := range latencies {
+		if host >= 0 {
+			twlHost, pid := multihost.SplitPID(int64(twl.PID))
+			if twlHost != host {
+				continue
+			}
+			// WakeupLatencies returns new latencies, which may be modified.
+			twl.PID = sched.PID(pid)
+			for _, wl := range twl.Wakeups {
+				_, cpu := multihost.SplitCPU(int64(wl.CPU))
+				wl.CPU = sched.CPUID(cpu)
+			}
+		}
+		res.Latencies = append(res.Latencies, twl)
+	}
+	return res, nil
+}
+
 // GetAntagonists returns a set of antagonist information for a specified collection, from a
 // specified set of threads and over a specified interval.
 func (as *APIService) GetAntagonists(ctx context.Context, req *models.AntagonistsRequest) (*models.AntagonistsResponse, error) {
@@ -151,6 +331,16 @@ func (as *APIService) GetAntagonists(ctx context.Context, req *models.Antagonist
 		}
 		res.Antagonists = append(res.Antagonists, &ants)
 	}
+	for _, tgid := range req.Tgids {
+		ants, err := c.SchedCollection().ProcessAntagonists(
+			sched.Processes(sched.PID(tgid)),
+			sched.StartTimestamp(trace.Timestamp(req.StartTimestampNs)),
+			sched.EndTimestamp(trace.Timestamp(req.EndTimestampNs)))
+		if err != nil {
+			return nil, fmt.Errorf("error fetching antagonists for tgid: %d. caused by: %s", tgid, err)
+		}
+		res.Antagonists = append(res.Antagonists, &ants)
+	}
 	return res, nil
 }
 
@@ -209,6 +399,30 @@ func (as *APIService) GetThreadSummaries(ctx context.Context, req *models.Thread
 	}, nil
 }
 
+// GetProcessSummaries returns a set of process summaries for a specified collection over a
+// specified interval.
+func (as *APIService) GetProcessSummaries(ctx context.Context, req *models.ProcessSummariesRequest) (*models.ProcessSummariesResponse, error) {
+	c, err := as.fetchCollection(ctx, req.CollectionName)
+	if err != nil {
+		return nil, err
+	}
+	filters := []sched.Filter{
+		sched.CPUs(req.Cpus...),
+		sched.TimeRange(trace.Timestamp(req.StartTimestampNs), trace.Timestamp(req.EndTimestampNs)),
+	}
+	if len(req.Tgids) > 0 {
+		filters = append(filters, sched.Processes(req.Tgids...))
+	}
+	processSummaries, err := c.SchedCollection().ProcessSummaries(filters...)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ProcessSummariesResponse{
+		CollectionName: req.CollectionName,
+		Metrics:        processSummaries,
+	}, nil
+}
+
 // GetUtilizationMetrics returns a set of metrics describing the utilization or over-utilization of
 // some portion of the system over some span of the trace.
 // These metrics are described in the sched.Utilization struct.
@@ -237,6 +451,31 @@ func (as *APIService) GetSystemTopology(ctx context.Context, collectionName stri
 	return c.SystemTopology(), err
 }
 
+// ExportCollection writes the specified collection to w in the requested trace format.
+func (as *APIService) ExportCollection(ctx context.Context, req *models.ExportCollectionRequest, w io.Writer) error {
+	c, err := as.fetchCollection(ctx, req.CollectionName)
+	if err != nil {
+		return err
+	}
+	filters := []sched.Filter{
+		sched.TimeRange(trace.Timestamp(req.StartTimestampNs), trace.Timestamp(req.EndTimestampNs)),
+	}
+	if len(req.Cpus) > 0 {
+		cpus := make([]sched.CPUID, 0, len(req.Cpus))
+		for _, cpu := range req.Cpus {
+			cpus = append(cpus, sched.CPUID(cpu))
+		}
+		filters = append(filters, sched.CPUs(cpus...))
+	}
+	if len(req.Pids) > 0 {
+		pids := make([]sched.PID, 0, len(req.Pids))
+		for _, pid := range req.Pids {
+			pids = append(pids, sched.PID(pid))
+		}
+		filters = append(filters, sched.PIDs(pids...))
+	}
+	return traceexport.Export(c.SchedCollection(), traceexport.Format(req.Format), w, filters...)
+}
 
 func missingFieldError(fieldName string) error {
 	return fmt.Errorf("missing required field %q", fieldName)
@@ -33,15 +33,128 @@ type CreateCollectionRequest struct {
 	// The time of this collection's creation.  If left empty, it will be
 	// autopopulated at the time of collection creation.
 	CreationTime int64 `json:"creationTime"`
+	// Optional filters applied while an uploaded trace is parsed. Events that
+	// don't pass them are dropped, and never stored in the collection.
+	// If nonzero, events recorded before StartTimestamp, or after EndTimestamp,
+	// are dropped.  Timestamps are in the units of the trace clock, as recorded
+	// in the raw trace.
+	StartTimestamp int64 `json:"startTimestamp"`
+	EndTimestamp   int64 `json:"endTimestamp"`
+	// If nonempty, only events with these names are kept.
+	IncludedEvents []string `json:"includedEvents"`
+	// Events with these names are dropped.
+	ExcludedEvents []string `json:"excludedEvents"`
+	// If nonempty, only events recorded on these CPUs are kept.
+	CPUs []int64 `json:"cpus"`
+	// The event that marks thread wakeups, the transitions from sleeping to
+	// waiting, when the collection is analyzed: sched_wakeup or sched_waking.
+	// If left empty, sched_wakeup is used.
+	WakeupEvent string `json:"wakeupEvent"`
+}
+
+// MergeCollectionsRequest is a request to create a new collection by merging
+// existing ones, such as several traces captured on the same host.
+type MergeCollectionsRequest struct {
+	// The unique names of the collections to merge.  At least two are required.
+	CollectionNames []string `json:"collectionNames"`
+	// The role tag associated with the merged collection's creation.
+	Creator string `json:"creator"`
+	// The role tags to own the merged collection.
+	Owners []string `json:"owners"`
+	// The tags to initially set in the merged collection.
+	Tags []string `json:"tags"`
+	// The merged collection's description.  If left empty, it will list the
+	// merged collections.
+	Description string `json:"description"`
+	// The time of the merged collection's creation.  If left empty, it will be
+	// autopopulated at the time of collection creation.
+	CreationTime int64 `json:"creationTime"`
+}
+
+// SliceCollectionRequest is a request to create a new collection from a time
+// range, and optionally a subset of the CPUs and PIDs, of an existing one. If
+// start_timestamp_ns is -1, the first timestamp in the collection is used
+// instead. If end_timestamp_ns is -1, the last timestamp in the collection is
+// used instead. Timestamps are normalized, as in other queries.  If the
+// provided CPU or PID sets are empty, events on all CPUs or PIDs are kept.
+type SliceCollectionRequest struct {
+	// The unique name of the collection to slice.
+	CollectionName   string  `json:"collectionName"`
+	StartTimestampNs int64   `json:"startTimestampNs"`
+	EndTimestampNs   int64   `json:"endTimestampNs"`
+	Cpus             []int64 `json:"cpus"`
+	Pids             []int64 `json:"pids"`
+	// The role tag associated with the sliced collection's creation.
+	Creator string `json:"creator"`
+	// The role tags to own the sliced collection.
+	Owners []string `json:"owners"`
+	// The tags to initially set in the sliced collection.
+	Tags []string `json:"tags"`
+	// The sliced collection's description.  If left empty, it will name the
+	// collection it was sliced from.
+	Description string `json:"description"`
+	// The time of the sliced collection's creation.  If left empty, it will be
+	// autopopulated at the time of collection creation.
+	CreationTime int64 `json:"creationTime"`
+}
+
+// HostCollection is one of the hosts to combine in a multi-host collection.
+type HostCollection struct {
+	// The unique name of the collection holding the host's trace.
+	CollectionName string `json:"collectionName"`
+	// The host's name, unique within the multi-host collection.  If left empty,
+	// the collection's target machine, or failing that its name, is used.
+	Host string `json:"host"`
+	// The duration, in ns, to add to the host's timestamps to align them with
+	// those of the other hosts.  If left empty, it is estimated from the sync
+	// events the host shares with the first host, whose offset defaults to 0.
+	ClockOffsetNs *int64 `json:"clockOffsetNs"`
+}
+
+// MultiHostCollectionRequest is a request to create a new collection holding
+// the traces of several hosts, recorded at the same time, on a common timeline.
+// Each host's CPUs and PIDs are namespaced: CPU c of the i'th host becomes
+// i*10000+c, and PID p becomes i*10000000+p.
+type MultiHostCollectionRequest struct {
+	// The hosts to combine.  At least two are required.
+	Hosts []HostCollection `json:"hosts"`
+	// The sync events used to estimate the hosts' clock offsets: events named
+	// SyncEventName whose SyncProperty has the same value on two hosts are
+	// assumed to have been recorded at the same instant.  If left empty, these
+	// are 'print' and 'buf', matching writes to each host's trace_marker.
+	SyncEventName string `json:"syncEventName"`
+	SyncProperty  string `json:"syncProperty"`
+	// The role tag associated with the new collection's creation.
+	Creator string `json:"creator"`
+	// The role tags to own the new collection.
+	Owners []string `json:"owners"`
+	// The tags to initially set in the new collection.
+	Tags []string `json:"tags"`
+	// The new collection's description.  If left empty, it will list the
+	// combined hosts.
+	Description string `json:"description"`
+	// The time of the new collection's creation.  If left empty, it will be
+	// autopopulated at the time of collection creation.
+	CreationTime int64 `json:"creationTime"`
+}
+
+// HostParameters are the parameters of one host in a multi-host collection.
+type HostParameters struct {
+	Host string `json:"host"`
+	// The host's CPUs, by their IDs on the host.
+	CPUs          []int64 `json:"cpus"`
+	ClockOffsetNs int64   `json:"clockOffsetNs"`
 }
 
 // CollectionParametersResponse is a response for a collection parameters request.
+// The CPUs of multi-host collections are namespaced, and their hosts listed.
 type CollectionParametersResponse struct {
-	CollectionName   string   `json:"collectionName"`
-	CPUs             []int64  `json:"cpus"`
-	StartTimestampNs int64    `json:"startTimestampNs"`
-	EndTimestampNs   int64    `json:"endTimestampNs"`
-	FtraceEvents     []string `json:"ftraceEvents"`
+	CollectionName   string           `json:"collectionName"`
+	CPUs             []int64          `json:"cpus"`
+	StartTimestampNs int64            `json:"startTimestampNs"`
+	EndTimestampNs   int64            `json:"endTimestampNs"`
+	FtraceEvents     []string         `json:"ftraceEvents"`
+	Hosts            []HostParameters `json:"hosts"`
 }
 
 // EditCollectionRequest is a request to edit a given collection.
@@ -55,6 +168,22 @@ type EditCollectionRequest struct {
 	AddOwners   []string `json:"addOwners"`
 }
 
+// ExportCollectionRequest is a request to export a collection in a trace
+// format that can be opened outside of SchedViz. Format is one of "json"
+// (Chrome Trace Event JSON) or "perfetto" (Perfetto protobuf). If
+// start_timestamp_ns is -1, the first timestamp in the collection is used
+// instead. If end_timestamp_ns is -1, the last timestamp in the collection is
+// used instead. If the provided CPU or PID sets are empty, all CPUs or PIDs are
+// exported.
+type ExportCollectionRequest struct {
+	CollectionName   string  `json:"collectionName"`
+	Format           string  `json:"format"`
+	StartTimestampNs int64   `json:"startTimestampNs"`
+	EndTimestampNs   int64   `json:"endTimestampNs"`
+	Cpus             []int64 `json:"cpus"`
+	Pids             []int64 `json:"pids"`
+}
+
 
 // UnknownLogicalID is a value used to represent a core, NUMA node, die, thread or socket ID that
 // has not been set
@@ -103,6 +232,20 @@ type SystemTopology struct {
 	LogicalCores []*LogicalCore `json:"logicalCores"`
 }
 
+// Host describes one of the hosts whose traces a multi-host collection
+// combines.
+type Host struct {
+	// The host's name, unique within its collection.
+	Name string `json:"name"`
+	// The duration, in ns, added to the host's timestamps to align them with
+	// those of the other hosts.
+	ClockOffsetNs int64 `json:"clockOffsetNs"`
+	// The unique name of the collection the host's trace was taken from.
+	SourceCollection string `json:"sourceCollection"`
+	// The host's own system topology, with its own CPU IDs.
+	SystemTopology *SystemTopology `json:"systemTopology"`
+}
+
 // SystemTopologyResponse is a response to a SystemTopologyRequest
 type SystemTopologyResponse struct {
 	CollectionName string          `json:"collectionName"`
@@ -38,4 +38,10 @@ type Metadata struct {
 	TargetMachine string `json:"targetMachine"`
 	// The elpb.LoadersType used by default for this collection.
 	DefaultEventLoader elpb.LoadersType `json:"defaultEventLoader"`
+	// If this collection was sliced from another one, the unique name of that
+	// collection.
+	ParentCollection string `json:"parentCollection"`
+	// The event that marks thread wakeups in this collection: sched_wakeup or
+	// sched_waking.  If empty, sched_wakeup.
+	WakeupEvent string `json:"wakeupEvent"`
 }
@@ -0,0 +1,81 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package storageservice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/server/models"
+	"github.com/google/schedviz/tracedata/merge"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+// MergeCollections creates a new collection containing the events of the
+// requested collections, which are left unchanged. See merge.EventSets for how
+// the collections' events are combined. The merged collection takes the system
+// topology of the first requested collection that has one, and the wakeup event
+// of the first requested collection. Multi-host collections cannot be merged.
+func (fs *FsStorage) MergeCollections(ctx context.Context, req *models.MergeCollectionsRequest) (string, error) {
+	if len(req.CollectionNames) < 2 {
+		return "", status.Errorf(codes.InvalidArgument, "at least two collections are required to merge, got %d", len(req.CollectionNames))
+	}
+	var eventSets []*eventpb.EventSet
+	var topology *eventpb.SystemTopology
+	var wakeupEvent string
+	for idx, collectionName := range req.CollectionNames {
+		collectionProto, err := fs.getCollectionFromDisk(collectionName)
+		if err != nil {
+			return "", fmt.Errorf("failed to read collection %s: %s", collectionName, err)
+		}
+		if len(collectionProto.Hosts) > 0 {
+			return "", status.Errorf(codes.InvalidArgument, "collection %s combines several hosts, and cannot be merged", collectionName)
+		}
+		eventSets = append(eventSets, collectionProto.EventSet)
+		if idx == 0 {
+			wakeupEvent = collectionProto.GetMetadata().GetWakeupEvent()
+		}
+		if topology == nil && len(collectionProto.GetTopology().GetLogicalCore()) > 0 {
+			topology = collectionProto.Topology
+		}
+	}
+	eventSet, err := merge.EventSets(eventSets...)
+	if err != nil {
+		return "", err
+	}
+
+	description := req.Description
+	if description == "" {
+		description = fmt.Sprintf("Merged from %s", strings.Join(req.CollectionNames, ", "))
+	}
+	metadata := makeMetadata(&models.CreateCollectionRequest{
+		Creator:      req.Creator,
+		Owners:       req.Owners,
+		Tags:         req.Tags,
+		Description:  description,
+		CreationTime: req.CreationTime,
+		WakeupEvent:  wakeupEvent,
+	})
+	systemTopology := convertTopologyProtoToStruct(topology)
+	if err := fs.saveCollection(ctx, metadata, eventSet, &systemTopology, nil /*=hosts*/); err != nil {
+		return "", err
+	}
+	return metadata.CollectionUniqueName, nil
+}
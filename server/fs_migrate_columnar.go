@@ -0,0 +1,93 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package storageservice
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+// MigrateToColumnar rewrites each collection stored as a binary proto in the
+// columnar format, and returns the names of the migrated collections.  Each
+// migrated collection is read back and checked before, unless keepOriginals is
+// true, its binary proto is removed.  Collections already in the columnar
+// format are skipped, so an interrupted migration can be resumed.  It should
+// not be run while a server is using the same storage path.
+func (fs *FsStorage) MigrateToColumnar(keepOriginals bool) ([]string, error) {
+	files, err := ioutil.ReadDir(fs.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+	var migrated []string
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), binprotoSuffix) {
+			continue
+		}
+		collectionName := strings.TrimSuffix(file.Name(), binprotoSuffix)
+		if strings.HasSuffix(fs.getCollectionPath(collectionName), columnarSuffix) {
+			continue
+		}
+		binprotoPath := path.Join(fs.StoragePath, file.Name())
+		b, err := ioutil.ReadFile(binprotoPath)
+		if err != nil {
+			return migrated, err
+		}
+		collectionProto := &eventpb.Collection{}
+		if err := proto.Unmarshal(b, collectionProto); err != nil {
+			return migrated, fmt.Errorf("failed to read collection %s: %s", collectionName, err)
+		}
+		if err := fs.writeCollectionToDisk(collectionName, collectionProto, true /*=columnarFormat*/); err != nil {
+			return migrated, fmt.Errorf("failed to write collection %s: %s", collectionName, err)
+		}
+		if err := fs.checkMigratedCollection(collectionName, collectionProto); err != nil {
+			// The original remains in use.
+			os.Remove(path.Join(fs.StoragePath, collectionName+columnarSuffix))
+			return migrated, err
+		}
+		if !keepOriginals {
+			if err := os.Remove(binprotoPath); err != nil {
+				return migrated, err
+			}
+		}
+		log.Infof("Migrated collection %s to the columnar format", collectionName)
+		migrated = append(migrated, collectionName)
+	}
+	return migrated, nil
+}
+
+// checkMigratedCollection returns an error if the named collection, once
+// migrated, doesn't hold the same events as the original.
+func (fs *FsStorage) checkMigratedCollection(collectionName string, original *eventpb.Collection) error {
+	migratedProto, err := fs.getCollectionFromDisk(collectionName)
+	if err != nil {
+		return fmt.Errorf("failed to read migrated collection %s: %s", collectionName, err)
+	}
+	if got, want := len(migratedProto.GetEventSet().GetEvent()), len(original.GetEventSet().GetEvent()); got != want {
+		return fmt.Errorf("migrated collection %s has %d events, want %d", collectionName, got, want)
+	}
+	if !proto.Equal(migratedProto.GetMetadata(), original.GetMetadata()) {
+		return fmt.Errorf("migrated collection %s has different metadata", collectionName)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package storageservice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/server/models"
+	"github.com/google/schedviz/tracedata/multihost"
+)
+
+// CreateMultiHostCollection creates a new collection combining the traces of
+// the requested collections, each recorded on a different host, which are left
+// unchanged. See multihost.Combine for how the hosts' events are combined.
+// Hosts without an explicit clock offset are aligned with the first host using
+// the requested sync events. The new collection's system topology holds every
+// host's logical cores, with namespaced CPU IDs, and each host also keeps its
+// own.  Wakeups are marked by the first host's wakeup event.
+func (fs *FsStorage) CreateMultiHostCollection(ctx context.Context, req *models.MultiHostCollectionRequest) (string, error) {
+	if len(req.Hosts) < 2 {
+		return "", status.Errorf(codes.InvalidArgument, "at least two hosts are required to create a multi-host collection, got %d", len(req.Hosts))
+	}
+	sync := multihost.SyncEvent{
+		EventName: req.SyncEventName,
+		Property:  req.SyncProperty,
+	}
+	if sync.EventName == "" {
+		sync.EventName = "print"
+	}
+	if sync.Property == "" {
+		sync.Property = "buf"
+	}
+
+	var mhHosts []*multihost.Host
+	var hosts []models.Host
+	var hostNames []string
+	hostNameSet := map[string]struct{}{}
+	combinedTopology := &models.SystemTopology{}
+	var wakeupEvent string
+	for idx, hc := range req.Hosts {
+		collectionProto, err := fs.getCollectionFromDisk(hc.CollectionName)
+		if err != nil {
+			return "", fmt.Errorf("failed to read collection %s: %s", hc.CollectionName, err)
+		}
+		if len(collectionProto.Hosts) > 0 {
+			return "", status.Errorf(codes.InvalidArgument, "collection %s already combines several hosts", hc.CollectionName)
+		}
+		name := hc.Host
+		if name == "" {
+			name = collectionProto.GetMetadata().GetTargetMachine()
+		}
+		if name == "" {
+			name = hc.CollectionName
+		}
+		if _, ok := hostNameSet[name]; ok {
+			return "", status.Errorf(codes.InvalidArgument, "host %q was requested more than once", name)
+		}
+		hostNameSet[name] = struct{}{}
+		hostNames = append(hostNames, name)
+
+		var offset int64
+		switch {
+		case hc.ClockOffsetNs != nil:
+			offset = *hc.ClockOffsetNs
+		case idx > 0:
+			estimate, err := multihost.EstimateClockOffset(mhHosts[0].EventSet, collectionProto.EventSet, sync)
+			if err != nil {
+				return "", status.Errorf(status.Code(err), "failed to estimate the clock offset of host %q: %s", name, err)
+			}
+			offset = mhHosts[0].ClockOffsetNs + estimate
+		}
+		mhHosts = append(mhHosts, &multihost.Host{
+			EventSet:      collectionProto.EventSet,
+			ClockOffsetNs: offset,
+		})
+
+		topology := convertTopologyProtoToStruct(collectionProto.Topology)
+		hosts = append(hosts, models.Host{
+			Name:             name,
+			ClockOffsetNs:    offset,
+			SourceCollection: hc.CollectionName,
+			SystemTopology:   &topology,
+		})
+		if idx == 0 {
+			wakeupEvent = collectionProto.GetMetadata().GetWakeupEvent()
+			combinedTopology.CPUIdentifier = topology.CPUIdentifier
+			combinedTopology.CPUVendor = topology.CPUVendor
+			combinedTopology.CPUFamily = topology.CPUFamily
+			combinedTopology.CPUModel = topology.CPUModel
+			combinedTopology.CPUStepping = topology.CPUStepping
+		}
+		for _, lc := range topology.LogicalCores {
+			hostCore := *lc
+			hostCore.CPUID = uint64(multihost.HostCPU(idx, int64(lc.CPUID)))
+			combinedTopology.LogicalCores = append(combinedTopology.LogicalCores, &hostCore)
+		}
+	}
+	eventSet, err := multihost.Combine(mhHosts...)
+	if err != nil {
+		return "", err
+	}
+
+	description := req.Description
+	if description == "" {
+		description = fmt.Sprintf("Hosts %s", strings.Join(hostNames, ", "))
+	}
+	metadata := makeMetadata(&models.CreateCollectionRequest{
+		Creator:      req.Creator,
+		Owners:       req.Owners,
+		Tags:         req.Tags,
+		Description:  description,
+		CreationTime: req.CreationTime,
+		WakeupEvent:  wakeupEvent,
+	})
+	if err := fs.saveCollection(ctx, metadata, eventSet, combinedTopology, hosts); err != nil {
+		return "", err
+	}
+	return metadata.CollectionUniqueName, nil
+}
@@ -0,0 +1,85 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package storageservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/schedviz/analysis/sched"
+	"github.com/google/schedviz/server/models"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// SliceCollection creates a new collection containing the requested time range,
+// CPUs and PIDs of an existing collection, which is left unchanged. See
+// sched.Collection.Slice for which events are kept. The sliced collection
+// records the collection it was sliced from, and takes its system topology,
+// hosts and wakeup event. The CPUs and PIDs of multi-host collections are namespaced.
+func (fs *FsStorage) SliceCollection(ctx context.Context, req *models.SliceCollectionRequest) (string, error) {
+	if len(req.CollectionName) == 0 {
+		return "", missingFieldError("collection_name")
+	}
+	cachedCollection, err := fs.GetCollection(ctx, req.CollectionName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read collection %s: %s", req.CollectionName, err)
+	}
+	filters := []sched.Filter{
+		sched.TimeRange(trace.Timestamp(req.StartTimestampNs), trace.Timestamp(req.EndTimestampNs)),
+	}
+	if len(req.Cpus) > 0 {
+		cpus := make([]sched.CPUID, 0, len(req.Cpus))
+		for _, cpu := range req.Cpus {
+			cpus = append(cpus, sched.CPUID(cpu))
+		}
+		filters = append(filters, sched.CPUs(cpus...))
+	}
+	if len(req.Pids) > 0 {
+		pids := make([]sched.PID, 0, len(req.Pids))
+		for _, pid := range req.Pids {
+			pids = append(pids, sched.PID(pid))
+		}
+		filters = append(filters, sched.PIDs(pids...))
+	}
+	eventSet, err := cachedCollection.SchedCollection().Slice(filters...)
+	if err != nil {
+		return "", err
+	}
+	parentMetadata, err := fs.GetCollectionMetadata(ctx, req.CollectionName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read collection %s: %s", req.CollectionName, err)
+	}
+
+	description := req.Description
+	if description == "" {
+		description = fmt.Sprintf("Sliced from %s", req.CollectionName)
+	}
+	metadata := makeMetadata(&models.CreateCollectionRequest{
+		Creator:      req.Creator,
+		Owners:       req.Owners,
+		Tags:         req.Tags,
+		Description:  description,
+		CreationTime: req.CreationTime,
+		WakeupEvent:  parentMetadata.WakeupEvent,
+	})
+	metadata.ParentCollection = req.CollectionName
+	systemTopology := cachedCollection.SystemTopology()
+	if err := fs.saveCollection(ctx, metadata, eventSet, systemTopology, cachedCollection.Hosts()); err != nil {
+		return "", err
+	}
+	return metadata.CollectionUniqueName, nil
+}
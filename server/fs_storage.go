@@ -17,6 +17,7 @@
 package storageservice
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
@@ -30,14 +31,27 @@ import (
 
 	"github.com/google/schedviz/analysis/sched"
 	"github.com/google/schedviz/server/models"
+	"github.com/google/schedviz/tracedata/columnar"
+	"github.com/google/schedviz/tracedata/multihost"
 	"github.com/google/schedviz/tracedata/trace"
 )
 
+// The suffixes of collection files stored as a single binary eventpb.Collection
+// proto, and in the columnar format.
+const (
+	binprotoSuffix = ".binproto"
+	columnarSuffix = ".columnar"
+)
+
 // FsStorage is a storage service that saves collections as protos on local disk
 // Implements StorageService
 type FsStorage struct {
 	*storageBase
 	StoragePath string
+	// If true, new collections are saved in the columnar format.
+	columnarStorage bool
+	// If true, uploaded traces are validated before being saved.
+	validateUploads bool
 }
 
 // CreateFSStorage creates a new file system storage service that stores its files at storagePath
@@ -55,37 +69,130 @@ func CreateFSStorage(storagePath string, cacheSize int) (*FsStorage, error) {
 
 // DeleteCollection deletes the collection with the given name.
 func (fs *FsStorage) DeleteCollection(_ context.Context, _ string, collectionUniqueName string) error {
-	filePath := path.Join(fs.StoragePath, collectionUniqueName+".binproto")
+	filePath := fs.getCollectionPath(collectionUniqueName)
 	fs.mu.Lock()
 	fs.lruCache.Remove(filePath)
 	defer fs.mu.Unlock()
 	if err := os.Remove(filePath); err != nil {
 		return err
 	}
+	// A migrated collection's original file may have been kept.
+	if strings.HasSuffix(filePath, columnarSuffix) {
+		if err := os.Remove(path.Join(fs.StoragePath, collectionUniqueName+binprotoSuffix)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return nil
 }
 
+// getCollectionPath returns the path of the named collection's file.  If the
+// collection is stored in both formats, the columnar file is used.
 func (fs *FsStorage) getCollectionPath(collectionName string) string {
-	return path.Join(fs.StoragePath, collectionName+".binproto")
+	columnarPath := path.Join(fs.StoragePath, collectionName+columnarSuffix)
+	if _, err := os.Stat(columnarPath); err == nil {
+		return columnarPath
+	}
+	return path.Join(fs.StoragePath, collectionName+binprotoSuffix)
 }
 
-func (fs *FsStorage) getCollectionNameFromFileName(fileName string) string {
-	return strings.TrimSuffix(fileName, ".binproto")
+// getCollectionNameFromFileName returns the name of the collection stored in
+// the named file, and false if the file doesn't hold a collection.
+func (fs *FsStorage) getCollectionNameFromFileName(fileName string) (string, bool) {
+	for _, suffix := range []string{binprotoSuffix, columnarSuffix} {
+		if strings.HasSuffix(fileName, suffix) {
+			return strings.TrimSuffix(fileName, suffix), true
+		}
+	}
+	return "", false
 }
 
+// newColumnarReader returns a Reader for the columnar collection file, which
+// reads the file's chunks as they are needed.
+func newColumnarReader(file *os.File) (*columnar.Reader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return columnar.NewReader(file, info.Size())
+}
+
+// getCollectionFromDisk reads the named collection, with all of its events,
+// from disk.
 func (fs *FsStorage) getCollectionFromDisk(collectionName string) (*eventpb.Collection, error) {
 	filePath := fs.getCollectionPath(collectionName)
-	bytes, err := ioutil.ReadFile(filePath)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if strings.HasSuffix(filePath, columnarSuffix) {
+		r, err := newColumnarReader(file)
+		if err != nil {
+			return nil, err
+		}
+		collectionProto := r.Collection()
+		if collectionProto.EventSet, err = r.EventSet(); err != nil {
+			return nil, err
+		}
+		return collectionProto, nil
+	}
+	b, err := ioutil.ReadAll(file)
 	if err != nil {
 		return nil, err
 	}
 	collectionProto := &eventpb.Collection{}
-	if err := proto.Unmarshal(bytes, collectionProto); err != nil {
+	if err := proto.Unmarshal(b, collectionProto); err != nil {
 		return nil, err
 	}
 	return collectionProto, nil
 }
 
+// getCollectionHeaderFromDisk reads the named collection from disk, without
+// necessarily reading its events.  Only the header of columnar files is read.
+func (fs *FsStorage) getCollectionHeaderFromDisk(collectionName string) (*eventpb.Collection, error) {
+	filePath := fs.getCollectionPath(collectionName)
+	if !strings.HasSuffix(filePath, columnarSuffix) {
+		return fs.getCollectionFromDisk(collectionName)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r, err := newColumnarReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return r.Collection(), nil
+}
+
+// writeCollectionToDisk writes the provided collection to disk under the
+// provided name, in the columnar format if requested, replacing any existing
+// file in that format.  The file is written in full before it replaces the
+// old one.
+func (fs *FsStorage) writeCollectionToDisk(collectionName string, collectionProto *eventpb.Collection, columnarFormat bool) error {
+	var buf bytes.Buffer
+	suffix := binprotoSuffix
+	if columnarFormat {
+		suffix = columnarSuffix
+		if err := columnar.Write(&buf, collectionProto); err != nil {
+			return err
+		}
+	} else {
+		b, err := proto.Marshal(collectionProto)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+	filePath := path.Join(fs.StoragePath, collectionName+suffix)
+	tmpPath := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
 // GetCollection returns an already-saved collection with the given name.
 // shouldCache controls whether or not the fetched collection will be saved in the cache to speed up
 // future requests for the same collection.
@@ -104,23 +211,61 @@ func (fs *FsStorage) GetCollection(ctx context.Context, collectionName string) (
 		cachedCollection.err = err
 		cachedCollection.release()
 	}()
-	collectionProto, err := fs.getCollectionFromDisk(collectionName)
+	filePath := fs.getCollectionPath(collectionName)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var collectionProto *eventpb.Collection
+	var newCollection func(options ...sched.Option) (*sched.Collection, error)
+	if strings.HasSuffix(filePath, columnarSuffix) {
+		// Columnar collections read and decode their chunks from the file as
+		// their events are needed.  The file stays open for as long as the
+		// collection is in use, which may outlast its time in the cache, so it
+		// is closed when the collection is garbage collected.
+		var r *columnar.Reader
+		if r, err = newColumnarReader(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		collectionProto = r.Collection()
+		newCollection = func(options ...sched.Option) (*sched.Collection, error) {
+			return sched.NewCollectionFromSource(r, options...)
+		}
+	} else {
+		var b []byte
+		b, err = ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		collectionProto = &eventpb.Collection{}
+		if err = proto.Unmarshal(b, collectionProto); err != nil {
+			return nil, err
+		}
+		newCollection = func(options ...sched.Option) (*sched.Collection, error) {
+			return sched.NewCollection(collectionProto.EventSet, options...)
+		}
+	}
+	wakeupOption, err := wakeupEventOption(collectionProto.GetMetadata().GetWakeupEvent())
 	if err != nil {
 		return nil, err
 	}
-	collection, err := createCollection(collectionProto.EventSet)
+	// The CPUs and PIDs of multi-host collections are namespaced by host.
+	collection, err := createCollection(newCollection, sched.NamespaceByHost(len(collectionProto.Hosts) > 0), wakeupOption)
 	if err != nil {
 		return nil, err
 	}
 	cachedCollection.collection = collection
 	cachedCollection.systemTopology = convertTopologyProtoToStruct(collectionProto.Topology)
+	cachedCollection.hosts = convertHostsProtoToStruct(collectionProto.Hosts)
 	cachedCollection.payload = map[string]interface{}{}
 	return cachedCollection, nil
 }
 
 // GetCollectionMetadata gets the metadata for the collection with the given name.
 func (fs *FsStorage) GetCollectionMetadata(ctx context.Context, collectionUniqueName string) (models.Metadata, error) {
-	collectionProto, err := fs.getCollectionFromDisk(collectionUniqueName)
+	collectionProto, err := fs.getCollectionHeaderFromDisk(collectionUniqueName)
 	if err != nil {
 		return models.Metadata{}, err
 	}
@@ -168,7 +313,7 @@ func (fs *FsStorage) EditCollection(ctx context.Context, _ string, req *models.E
 	metadata.Owners = newOwners
 	metadata.Description = req.Description
 
-	// Write updated metadata to file
+	// Write updated metadata to file, in the collection's existing format.
 	collectionProto, err := fs.getCollectionFromDisk(req.CollectionName)
 	if err != nil {
 		return err
@@ -179,15 +324,8 @@ func (fs *FsStorage) EditCollection(ctx context.Context, _ string, req *models.E
 	}
 	collectionProto.Metadata = metadataProto
 
-	outBytes, err := proto.Marshal(collectionProto)
-	if err != nil {
-		return err
-	}
-	filePath := fs.getCollectionPath(req.CollectionName)
-	if err := ioutil.WriteFile(filePath, outBytes, 0644); err != nil {
-		return err
-	}
-	return nil
+	columnarFormat := strings.HasSuffix(fs.getCollectionPath(req.CollectionName), columnarSuffix)
+	return fs.writeCollectionToDisk(req.CollectionName, collectionProto, columnarFormat)
 }
 
 // ListCollectionMetadata gets the metadata for all collections.
@@ -200,9 +338,18 @@ func (fs *FsStorage) ListCollectionMetadata(ctx context.Context, _ string, _ str
 	// Without the curly braces, this will appear as null when empty and serialized to JSON, instead
 	// of an empty array.
 	var ret = []models.Metadata{}
+	// A migrated collection's original file may have been kept.
+	seen := map[string]struct{}{}
 	for _, file := range files {
-		collectionName := fs.getCollectionNameFromFileName(file.Name())
-		collectionProto, err := fs.getCollectionFromDisk(collectionName)
+		collectionName, ok := fs.getCollectionNameFromFileName(file.Name())
+		if !ok {
+			continue
+		}
+		if _, ok := seen[collectionName]; ok {
+			continue
+		}
+		seen[collectionName] = struct{}{}
+		collectionProto, err := fs.getCollectionHeaderFromDisk(collectionName)
 		if err != nil {
 			return nil, err
 		}
@@ -227,13 +374,26 @@ func (fs *FsStorage) GetCollectionParameters(ctx context.Context, collectionName
 
 	ftraceEvents := sc.TraceCollection.EventNames()
 
+	cpus := sc.ExpandCPUs(nil)
 	ret := models.CollectionParametersResponse{
 		CollectionName:   collectionName,
-		CPUs:             sc.ExpandCPUs(nil),
+		CPUs:             cpus,
 		StartTimestampNs: int64(startTimestamp),
 		EndTimestampNs:   int64(endTimestamp),
 		FtraceEvents:     ftraceEvents,
 	}
+	for _, host := range cc.Hosts() {
+		ret.Hosts = append(ret.Hosts, models.HostParameters{
+			Host:          host.Name,
+			CPUs:          []int64{},
+			ClockOffsetNs: host.ClockOffsetNs,
+		})
+	}
+	for _, cpu := range cpus {
+		if host, hostCPU := multihost.SplitCPU(cpu); host < len(ret.Hosts) {
+			ret.Hosts[host].CPUs = append(ret.Hosts[host].CPUs, hostCPU)
+		}
+	}
 
 	return ret, nil
 }
@@ -272,6 +432,22 @@ func (fs *FsStorage) GetFtraceEvents(ctx context.Context, req *models.FtraceEven
 	}, nil
 }
 
+// SetColumnarStorage configures the format in which new collections are saved.
+// If the provided bool is true, they are saved in the columnar format, which is
+// faster to open and smaller in memory; otherwise each is saved as a single
+// binary proto.  Collections in either format can be read.
+func (fs *FsStorage) SetColumnarStorage(option bool) {
+	fs.columnarStorage = option
+}
+
+// SetValidateUploads configures whether uploaded traces are validated before
+// being saved.  If the provided bool is true, uploads whose events fail
+// validation are rejected with an InvalidArgument error, and any validation
+// warnings are logged; otherwise uploads are saved unchecked.
+func (fs *FsStorage) SetValidateUploads(option bool) {
+	fs.validateUploads = option
+}
+
 // SetFailOnUnknownEventFormat configures behavior when encountering an unknown
 // event format.  If the provided bool is true, parsing fails on unknown events;
 // otherwise unknown events are logged and ignored.
@@ -279,19 +455,20 @@ func (fs *FsStorage) SetFailOnUnknownEventFormat(option bool) {
 	fs.failOnUnknownEventFormat = option
 }
 
-// createCollection creates a collection with the default event loader, and
-// will attempt to create a collection with the fault tolerant loader if the
-// default loader failed.
-var createCollection = func(es *eventpb.EventSet) (*sched.Collection, error) {
-	coll, err := sched.NewCollection(es, sched.NormalizeTimestamps(true))
+// createCollection creates a collection with newCollection and the default
+// event loader, and will attempt to create a collection with the fault tolerant
+// loader if the default loader failed.  Any provided options are applied in
+// both attempts.
+var createCollection = func(newCollection func(options ...sched.Option) (*sched.Collection, error), options ...sched.Option) (*sched.Collection, error) {
+	coll, err := newCollection(append([]sched.Option{sched.NormalizeTimestamps(true)}, options...)...)
 	if err == nil {
 		return coll, nil
 	}
 	log.Warning("Failed to load collection with default loader. " +
 		"Retrying with fault tolerant loader.")
-	coll, err = sched.NewCollection(es,
+	coll, err = newCollection(append([]sched.Option{
 		sched.NormalizeTimestamps(true),
-		sched.UsingEventLoaders(sched.FaultTolerantEventLoaders()))
+		sched.UsingEventLoaders(sched.FaultTolerantEventLoaders())}, options...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -17,6 +17,9 @@
 package storageservice
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
@@ -24,12 +27,17 @@ import (
 	"os"
 	"path"
 	"sort"
+	"strings"
 	"testing"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/google/schedviz/analysis/sched"
 	"github.com/google/schedviz/server/models"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
 	"github.com/google/schedviz/tracedata/trace"
 )
 
@@ -175,6 +183,13 @@ func TestFsStorage_UploadFile(t *testing.T) {
 				},
 			},
 		},
+		{
+			file:               getTestTarFile(t, "scenario.tar.gz"),
+			wantNumEvents:      10,
+			wantStart:          0,
+			wantEnd:            700,
+			wantSystemTopology: &models.SystemTopology{LogicalCores: []*models.LogicalCore{}},
+		},
 	}
 
 	for _, test := range tests {
@@ -214,6 +229,198 @@ func TestFsStorage_UploadFile(t *testing.T) {
 	}
 }
 
+func TestFsStorage_MergeCollections(t *testing.T) {
+	tmpDir, err := createCollectionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(t, tmpDir)
+	fsStorage := createFSStorage(t, tmpDir, 3)
+
+	var collectionNames []string
+	for i := 0; i < 2; i++ {
+		collectionName, err := fsStorage.UploadFile(ctx, colRequest, fh(t))
+		if err != nil {
+			t.Fatalf("unexpected error thrown by FsStorage::UploadFile: %s", err)
+		}
+		collectionNames = append(collectionNames, collectionName)
+	}
+
+	if _, err := fsStorage.MergeCollections(ctx, &models.MergeCollectionsRequest{
+		CollectionNames: collectionNames[:1],
+	}); err == nil {
+		t.Errorf("expected an error merging a single collection")
+	}
+
+	mergedName, err := fsStorage.MergeCollections(ctx, &models.MergeCollectionsRequest{
+		CollectionNames: collectionNames,
+		Creator:         "bob",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::MergeCollections: %s", err)
+	}
+	cachedValue, err := fsStorage.GetCollection(ctx, mergedName)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::GetCollection: %s", err)
+	}
+	// The two collections are identical, so all of their events are merged.
+	rawEvents, err := cachedValue.SchedCollection().GetRawEvents()
+	if err != nil {
+		t.Fatalf("unexpected error thrown while checking number of raw events: %s", err)
+	}
+	if wantNumEvents := 28922; len(rawEvents) != wantNumEvents {
+		t.Errorf("wrong number of events in merged event set. got: %d, want: %d", len(rawEvents), wantNumEvents)
+	}
+	if got := len(cachedValue.SystemTopology().LogicalCores); got != 1 {
+		t.Errorf("wrong number of logical cores in merged collection. got: %d, want: 1", got)
+	}
+	metadata, err := fsStorage.GetCollectionMetadata(ctx, mergedName)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::GetCollectionMetadata: %s", err)
+	}
+	if want := "Merged from " + strings.Join(collectionNames, ", "); metadata.Description != want {
+		t.Errorf("wrong description of merged collection. got: %q, want: %q", metadata.Description, want)
+	}
+}
+
+func TestFsStorage_SliceCollection(t *testing.T) {
+	tmpDir, err := createCollectionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(t, tmpDir)
+	fsStorage := createFSStorage(t, tmpDir, 3)
+
+	parentName, err := fsStorage.UploadFile(ctx, colRequest, fh(t))
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::UploadFile: %s", err)
+	}
+	parent, err := fsStorage.GetCollection(ctx, parentName)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::GetCollection: %s", err)
+	}
+	parentEvents, err := parent.SchedCollection().GetRawEvents()
+	if err != nil {
+		t.Fatalf("unexpected error thrown while fetching raw events: %s", err)
+	}
+
+	// Slice the second half of the collection.
+	startTimestamp, endTimestamp := parent.SchedCollection().Interval()
+	slicedName, err := fsStorage.SliceCollection(ctx, &models.SliceCollectionRequest{
+		CollectionName:   parentName,
+		StartTimestampNs: int64(startTimestamp+endTimestamp) / 2,
+		EndTimestampNs:   -1,
+		Creator:          "bob",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::SliceCollection: %s", err)
+	}
+	sliced, err := fsStorage.GetCollection(ctx, slicedName)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::GetCollection: %s", err)
+	}
+	slicedEvents, err := sliced.SchedCollection().GetRawEvents()
+	if err != nil {
+		t.Fatalf("unexpected error thrown while fetching raw events: %s", err)
+	}
+	if len(slicedEvents) == 0 || len(slicedEvents) >= len(parentEvents) {
+		t.Errorf("wrong number of events in sliced collection. got: %d, want between 1 and %d", len(slicedEvents), len(parentEvents)-1)
+	}
+	if got := len(sliced.SystemTopology().LogicalCores); got != 1 {
+		t.Errorf("wrong number of logical cores in sliced collection. got: %d, want: 1", got)
+	}
+	metadata, err := fsStorage.GetCollectionMetadata(ctx, slicedName)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::GetCollectionMetadata: %s", err)
+	}
+	if metadata.ParentCollection != parentName {
+		t.Errorf("wrong parent of sliced collection. got: %q, want: %q", metadata.ParentCollection, parentName)
+	}
+	if want := "Sliced from " + parentName; metadata.Description != want {
+		t.Errorf("wrong description of sliced collection. got: %q, want: %q", metadata.Description, want)
+	}
+}
+
+func TestFsStorage_CreateMultiHostCollection(t *testing.T) {
+	tmpDir, err := createCollectionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(t, tmpDir)
+	fsStorage := createFSStorage(t, tmpDir, 3)
+
+	var hosts []models.HostCollection
+	for _, host := range []string{"a", "b"} {
+		collectionName, err := fsStorage.UploadFile(ctx, colRequest, fh(t))
+		if err != nil {
+			t.Fatalf("unexpected error thrown by FsStorage::UploadFile: %s", err)
+		}
+		hosts = append(hosts, models.HostCollection{CollectionName: collectionName, Host: host})
+	}
+
+	// The test trace has no trace_marker writes to align the hosts with.
+	if _, err := fsStorage.CreateMultiHostCollection(ctx, &models.MultiHostCollectionRequest{
+		Hosts: hosts,
+	}); err == nil {
+		t.Errorf("expected an error estimating clock offsets without sync events")
+	}
+
+	offset := int64(1000)
+	hosts[1].ClockOffsetNs = &offset
+	multiHostName, err := fsStorage.CreateMultiHostCollection(ctx, &models.MultiHostCollectionRequest{
+		Hosts:   hosts,
+		Creator: "bob",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::CreateMultiHostCollection: %s", err)
+	}
+	got, err := fsStorage.GetCollectionParameters(ctx, multiHostName)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::GetCollectionParameters: %s", err)
+	}
+	want := models.CollectionParametersResponse{
+		CollectionName:   multiHostName,
+		CPUs:             []int64{0, 10000},
+		StartTimestampNs: 0,
+		EndTimestampNs:   2009150555 + offset,
+		FtraceEvents:     []string{"sched_migrate_task", "sched_switch", "sched_wakeup", "sched_wakeup_new"},
+		Hosts: []models.HostParameters{
+			{Host: "a", CPUs: []int64{0}, ClockOffsetNs: 0},
+			{Host: "b", CPUs: []int64{0}, ClockOffsetNs: offset},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GetCollectionParameters on multi-host collection: Diff -want +got:\n%s", diff)
+	}
+
+	cachedValue, err := fsStorage.GetCollection(ctx, multiHostName)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::GetCollection: %s", err)
+	}
+	if got := len(cachedValue.SystemTopology().LogicalCores); got != 2 {
+		t.Errorf("wrong number of logical cores in multi-host collection. got: %d, want: 2", got)
+	}
+	// Both hosts recorded the same trace, so their CPUs were equally busy.
+	sc := cachedValue.SchedCollection()
+	var intervalCounts []int
+	for _, cpu := range []sched.CPUID{0, 10000} {
+		intervals, err := sc.CPUIntervals(false /*=splitOnWaitingPIDChange*/, sched.CPUs(cpu))
+		if err != nil {
+			t.Fatalf("unexpected error thrown while fetching CPU %d intervals: %s", cpu, err)
+		}
+		intervalCounts = append(intervalCounts, len(intervals))
+	}
+	if intervalCounts[0] == 0 || intervalCounts[0] != intervalCounts[1] {
+		t.Errorf("wrong number of intervals on each host's CPU. got: %v, want two equal, nonzero counts", intervalCounts)
+	}
+
+	if _, err := fsStorage.MergeCollections(ctx, &models.MergeCollectionsRequest{
+		CollectionNames: []string{multiHostName, hosts[0].CollectionName},
+	}); err == nil {
+		t.Errorf("expected an error merging a multi-host collection")
+	}
+}
+
 func TestFsStorage_DeleteCollection(t *testing.T) {
 	collectionName := "coll_to_delete"
 	tmpDir, err := createCollectionDir()
@@ -450,6 +657,298 @@ func TestFsStorage_GetCollectionParameters(t *testing.T) {
 	}
 }
 
+func TestFsStorage_ColumnarStorage(t *testing.T) {
+	tmpDir, err := createCollectionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(t, tmpDir)
+	fsStorage := createFSStorage(t, tmpDir, 1)
+	fsStorage.SetColumnarStorage(true)
+
+	collectionName, err := fsStorage.UploadFile(ctx, colRequest, fh(t))
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::UploadFile: %s", err)
+	}
+	if _, err := os.Stat(path.Join(tmpDir, collectionName+".columnar")); err != nil {
+		t.Fatalf("columnar collection file was not written: %s", err)
+	}
+	got, err := fsStorage.GetCollectionParameters(ctx, collectionName)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::GetCollectionParameters: %s", err)
+	}
+	want := models.CollectionParametersResponse{
+		CollectionName:   collectionName,
+		CPUs:             []int64{0},
+		StartTimestampNs: 0,
+		EndTimestampNs:   2009150555,
+		FtraceEvents:     []string{"sched_migrate_task", "sched_switch", "sched_wakeup", "sched_wakeup_new"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GetCollectionParameters on columnar collection: Diff -want +got:\n%s", diff)
+	}
+
+	if err := fsStorage.EditCollection(ctx, "", &models.EditCollectionRequest{
+		CollectionName: collectionName,
+		Description:    "edited",
+	}); err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::EditCollection: %s", err)
+	}
+	metadata, err := fsStorage.ListCollectionMetadata(ctx, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::ListCollectionMetadata: %s", err)
+	}
+	if len(metadata) != 1 || metadata[0].Description != "edited" {
+		t.Errorf("ListCollectionMetadata() = %v, want the edited collection", metadata)
+	}
+	if err := fsStorage.DeleteCollection(ctx, "", collectionName); err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::DeleteCollection: %s", err)
+	}
+	if files, err := ioutil.ReadDir(tmpDir); err != nil || len(files) != 0 {
+		t.Errorf("collection files remain after deletion: %v, %v", files, err)
+	}
+}
+
+type tarFile struct {
+	name, contents string
+}
+
+// scenarioTar returns a gzipped tar holding the provided scenario, as written by
+// scenario_to_trace, and any extra files.
+func scenarioTar(t *testing.T, scenario string, extraFiles ...tarFile) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	files := append([]tarFile{
+		{"metadata.textproto", "trace_type: SCENARIO\n"},
+		{"scenario", scenario},
+	}, extraFiles...)
+	for _, file := range files {
+		if err := tarWriter.WriteHeader(&tar.Header{
+			Name:     file.name,
+			Mode:     0644,
+			Size:     int64(len(file.contents)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(file.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestFsStorage_ValidateUploads(t *testing.T) {
+	tmpDir, err := createCollectionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(t, tmpDir)
+	fsStorage := createFSStorage(t, tmpDir, 1)
+	fsStorage.SetValidateUploads(true)
+
+	if _, err := fsStorage.UploadFile(ctx, colRequest, fh(t)); err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::UploadFile: %s", err)
+	}
+	invalidScenario := `
+100 cpu0 switch prev=-1:R next=10
+110 cpu0 switch prev=10:S next=0
+`
+	_, err = fsStorage.UploadFile(ctx, colRequest, scenarioTar(t, invalidScenario))
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "negative prev_pid -1") {
+		t.Errorf("FsStorage::UploadFile() returned error %v, want an InvalidArgument error naming the negative PID", err)
+	}
+	metadata, err := fsStorage.ListCollectionMetadata(ctx, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::ListCollectionMetadata: %s", err)
+	}
+	if len(metadata) != 1 {
+		t.Errorf("ListCollectionMetadata() returned %d collections, want only the valid upload", len(metadata))
+	}
+
+	// Without validation, the same upload is saved.
+	fsStorage.SetValidateUploads(false)
+	if _, err := fsStorage.UploadFile(ctx, colRequest, scenarioTar(t, invalidScenario)); err != nil {
+		t.Errorf("unexpected error thrown by FsStorage::UploadFile without validation: %s", err)
+	}
+}
+
+func TestFsStorage_WakeupEvent(t *testing.T) {
+	tmpDir, err := createCollectionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(t, tmpDir)
+	fsStorage := createFSStorage(t, tmpDir, 3)
+	// Thread 10's wakeup is requested at 110, and enqueued at 150.
+	wakingScenario := `
+100 cpu0 switch prev=10:S prev_comm=app next=0
+110 cpu0 waking pid=10 comm=app target_cpu=0
+150 cpu0 wakeup pid=10 comm=app target_cpu=0
+200 cpu0 switch prev=0:R next=10 next_comm=app
+210 cpu0 switch prev=10:S prev_comm=app next=0
+`
+	tests := []struct {
+		wakeupEvent  string
+		wantWaitTime sched.Duration
+	}{
+		{"", 50},
+		{"sched_wakeup", 50},
+		{"sched_waking", 90},
+	}
+	for _, test := range tests {
+		t.Run(test.wakeupEvent, func(t *testing.T) {
+			req := *colRequest
+			req.WakeupEvent = test.wakeupEvent
+			collectionName, err := fsStorage.UploadFile(ctx, &req, scenarioTar(t, wakingScenario))
+			if err != nil {
+				t.Fatalf("unexpected error thrown by FsStorage::UploadFile: %s", err)
+			}
+			// Slices keep the wakeup event of the collection they were sliced from.
+			slicedName, err := fsStorage.SliceCollection(ctx, &models.SliceCollectionRequest{
+				CollectionName:   collectionName,
+				StartTimestampNs: -1,
+				EndTimestampNs:   -1,
+				Creator:          "bob",
+			})
+			if err != nil {
+				t.Fatalf("unexpected error thrown by FsStorage::SliceCollection: %s", err)
+			}
+			for _, name := range []string{collectionName, slicedName} {
+				metadata, err := fsStorage.GetCollectionMetadata(ctx, name)
+				if err != nil {
+					t.Fatalf("unexpected error thrown by FsStorage::GetCollectionMetadata: %s", err)
+				}
+				if metadata.WakeupEvent != test.wakeupEvent {
+					t.Errorf("wrong wakeup event of collection %s. got: %q, want: %q", name, metadata.WakeupEvent, test.wakeupEvent)
+				}
+				coll, err := fsStorage.GetCollection(ctx, name)
+				if err != nil {
+					t.Fatalf("unexpected error thrown by FsStorage::GetCollection: %s", err)
+				}
+				stats, err := coll.SchedCollection().ThreadStats(sched.PIDs(10))
+				if err != nil {
+					t.Fatalf("unexpected error thrown by ThreadStats: %s", err)
+				}
+				if stats.WaitTime != test.wantWaitTime {
+					t.Errorf("wrong wait time in collection %s. got: %d, want: %d", name, stats.WaitTime, test.wantWaitTime)
+				}
+			}
+		})
+	}
+	req := *colRequest
+	req.WakeupEvent = "sched_wakeup_new"
+	if _, err := fsStorage.UploadFile(ctx, &req, scenarioTar(t, wakingScenario)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("FsStorage::UploadFile() with wakeup event %q returned error %v, want an InvalidArgument error", req.WakeupEvent, err)
+	}
+}
+
+func TestReadTar_ThreadGroups(t *testing.T) {
+	scenario := `
+process 10 11
+process 20 21
+100 cpu0 switch prev=10:S next=21
+`
+	// The snapshot, taken after 21 exec'd, overrides the scenario.
+	snapshot := tarFile{"thread_groups", "10 10\n10 11\n21 21\n"}
+	es, _, err := readTar(scenarioTar(t, scenario, snapshot), false, nil)
+	if err != nil {
+		t.Fatalf("readTar() returned unexpected error: %s", err)
+	}
+	want := []*eventpb.ThreadGroup{
+		{Tgid: 10, Pid: []int64{10, 11}},
+		{Tgid: 20, Pid: []int64{20}},
+		{Tgid: 21, Pid: []int64{21}},
+	}
+	if diff := cmp.Diff(want, es.ThreadGroup, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("readTar() thread groups: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestFsStorage_MigrateToColumnar(t *testing.T) {
+	tmpDir, err := createCollectionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(t, tmpDir)
+	fsStorage := createFSStorage(t, tmpDir, 1)
+
+	var collectionNames []string
+	wantParams := map[string]models.CollectionParametersResponse{}
+	for i := 0; i < 2; i++ {
+		collectionName, err := fsStorage.UploadFile(ctx, colRequest, fh(t))
+		if err != nil {
+			t.Fatalf("unexpected error thrown by FsStorage::UploadFile: %s", err)
+		}
+		collectionNames = append(collectionNames, collectionName)
+		if wantParams[collectionName], err = fsStorage.GetCollectionParameters(ctx, collectionName); err != nil {
+			t.Fatalf("unexpected error thrown by FsStorage::GetCollectionParameters: %s", err)
+		}
+	}
+	wantMetadata, err := fsStorage.ListCollectionMetadata(ctx, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::ListCollectionMetadata: %s", err)
+	}
+
+	migrator, err := CreateFSStorage(tmpDir, 1)
+	if err != nil {
+		t.Fatalf("Failed to create storage service: %s", err)
+	}
+	migrated, err := migrator.MigrateToColumnar(false /*=keepOriginals*/)
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::MigrateToColumnar: %s", err)
+	}
+	sort.Strings(collectionNames)
+	sort.Strings(migrated)
+	if diff := cmp.Diff(collectionNames, migrated); diff != "" {
+		t.Errorf("MigrateToColumnar(): Diff -want +got:\n%s", diff)
+	}
+	files, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("error reading temp directory: %s", err)
+	}
+	var gotFiles []string
+	for _, file := range files {
+		gotFiles = append(gotFiles, file.Name())
+	}
+	wantFiles := []string{collectionNames[0] + ".columnar", collectionNames[1] + ".columnar"}
+	if diff := cmp.Diff(wantFiles, gotFiles); diff != "" {
+		t.Errorf("files after migration: Diff -want +got:\n%s", diff)
+	}
+
+	// A new storage service, with an empty cache, reads the migrated collections.
+	fsStorage = createFSStorage(t, tmpDir, 1)
+	for _, collectionName := range collectionNames {
+		got, err := fsStorage.GetCollectionParameters(ctx, collectionName)
+		if err != nil {
+			t.Fatalf("unexpected error thrown by FsStorage::GetCollectionParameters: %s", err)
+		}
+		if diff := cmp.Diff(wantParams[collectionName], got); diff != "" {
+			t.Errorf("GetCollectionParameters on migrated collection: Diff -want +got:\n%s", diff)
+		}
+	}
+	gotMetadata, err := fsStorage.ListCollectionMetadata(ctx, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error thrown by FsStorage::ListCollectionMetadata: %s", err)
+	}
+	if diff := cmp.Diff(wantMetadata, gotMetadata); diff != "" {
+		t.Errorf("ListCollectionMetadata after migration: Diff -want +got:\n%s", diff)
+	}
+
+	// Migrating again has nothing to do.
+	if migrated, err := migrator.MigrateToColumnar(false /*=keepOriginals*/); err != nil || len(migrated) != 0 {
+		t.Errorf("MigrateToColumnar() again = %v, %v, want nothing migrated", migrated, err)
+	}
+}
+
 func TestFsStorage_GetFtraceEvents(t *testing.T) {
 	tmpDir, err := createCollectionDir()
 	if err != nil {
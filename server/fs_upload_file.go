@@ -39,8 +39,13 @@ import (
 	"google.golang.org/grpc/status"
 	"github.com/golang/protobuf/proto"
 	"github.com/google/uuid"
+	"github.com/google/schedviz/analysis/sched"
 	"github.com/google/schedviz/ebpf/schedbt"
+	"github.com/google/schedviz/perfetto/perfetto"
+	"github.com/google/schedviz/tracedata/scenario"
 	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/threadgroups"
+	"github.com/google/schedviz/tracedata/validation"
 
 	"github.com/google/schedviz/server/models"
 	"github.com/google/schedviz/traceparser/traceparser"
@@ -59,22 +64,76 @@ const (
 	diesPerSocket = 1
 )
 
-// UploadFile creates a new collection from the uploaded file and saves it to disk
+// UploadFile creates a new collection from the uploaded file and saves it to disk.
+// The uploaded file may be either a gzipped tar (see readTar) or a trace-cmd trace.dat file.
 func (fs *FsStorage) UploadFile(ctx context.Context, req *models.CreateCollectionRequest, file io.Reader) (string, error) {
-	eventSet, topology, err := readTar(file, fs.failOnUnknownEventFormat)
+	reader := bufio.NewReader(file)
+	var eventSet *eventpb.EventSet
+	var topology *models.SystemTopology
+	filter, err := eventFilter(req)
 	if err != nil {
 		return "", err
 	}
+	if _, err := wakeupEventOption(req.WakeupEvent); err != nil {
+		return "", err
+	}
+	if magic, peekErr := reader.Peek(traceparser.TraceDatMagicSize); peekErr == nil && traceparser.IsTraceDat(magic) {
+		eventSet, topology, err = readTraceDat(reader, fs.failOnUnknownEventFormat, filter)
+	} else {
+		eventSet, topology, err = readTar(reader, fs.failOnUnknownEventFormat, filter)
+	}
+	if err != nil {
+		return "", err
+	}
+	if fs.validateUploads {
+		report := validation.Validate(eventSet, validation.Topology(convertTopologyStructToProto(topology)))
+		if err := report.Err(); err != nil {
+			return "", err
+		}
+		for _, issue := range report.Issues {
+			log.Warningf("Uploaded trace: %s", issue)
+		}
+	}
 
 	metadata := makeMetadata(req)
 
-	if err := fs.saveCollection(ctx, metadata, eventSet, topology); err != nil {
+	if err := fs.saveCollection(ctx, metadata, eventSet, topology, nil /*=hosts*/); err != nil {
 		return "", err
 	}
 
 	return metadata.CollectionUniqueName, nil
 }
 
+// eventFilter returns the parse-time event filter requested by req.
+func eventFilter(req *models.CreateCollectionRequest) (*traceparser.EventFilter, error) {
+	if req.StartTimestamp < 0 || req.EndTimestamp < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "filter timestamps must not be negative")
+	}
+	for _, cpu := range req.CPUs {
+		if cpu < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid CPU %d in filter", cpu)
+		}
+	}
+	filter, err := traceparser.NewEventFilter(uint64(req.StartTimestamp), uint64(req.EndTimestamp), req.IncludedEvents, req.ExcludedEvents, req.CPUs)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid event filter: %s", err)
+	}
+	return filter, nil
+}
+
+// wakeupEventOption returns the sched.Option that marks wakeups with the named
+// event, sched_wakeup or sched_waking.  An empty name selects sched_wakeup.
+func wakeupEventOption(name string) (sched.Option, error) {
+	switch name {
+	case "", sched.SchedWakeupEvent.String():
+		return sched.WakeupsMarkedBy(sched.SchedWakeupEvent), nil
+	case sched.SchedWakingEvent.String():
+		return sched.WakeupsMarkedBy(sched.SchedWakingEvent), nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown wakeup event %q; want %s or %s", name, sched.SchedWakeupEvent, sched.SchedWakingEvent)
+	}
+}
+
 // generateUniqueName returns a new unique name suitable for collections.
 // It is not required that all unique names be generated via this method:
 // unique names may be any string value, but must be unique.
@@ -101,12 +160,15 @@ func makeMetadata(req *models.CreateCollectionRequest) *models.Metadata {
 		Tags:                 req.Tags,
 		Description:          req.Description,
 		CreationTime:         creationTime,
+		WakeupEvent:          req.WakeupEvent,
 	}
 
 	return metadata
 }
 
-func (fs *FsStorage) saveCollection(ctx context.Context, metadata *models.Metadata, eventSet *eventpb.EventSet, topology *models.SystemTopology) error {
+// saveCollection saves a new collection to disk and loads it into the cache.
+// hosts should be nil unless the collection combines several hosts' traces.
+func (fs *FsStorage) saveCollection(ctx context.Context, metadata *models.Metadata, eventSet *eventpb.EventSet, topology *models.SystemTopology, hosts []models.Host) error {
 	sort.Slice(eventSet.Event, func(i, j int) bool {
 		return eventSet.Event[i].TimestampNs < eventSet.Event[j].TimestampNs
 	})
@@ -126,15 +188,10 @@ func (fs *FsStorage) saveCollection(ctx context.Context, metadata *models.Metada
 		Metadata: metadataProto,
 		EventSet: eventSet,
 		Topology: convertTopologyStructToProto(topology),
+		Hosts:    convertHostsStructToProto(hosts),
 	}
 
-	protoBytes, err := proto.Marshal(outProto)
-	if err != nil {
-		return err
-	}
-
-	fullPath := fs.getCollectionPath(metadata.CollectionUniqueName)
-	if err := ioutil.WriteFile(fullPath, protoBytes, 0644); err != nil {
+	if err := fs.writeCollectionToDisk(metadata.CollectionUniqueName, outProto, fs.columnarStorage); err != nil {
 		return err
 	}
 
@@ -148,7 +205,9 @@ func (fs *FsStorage) saveCollection(ctx context.Context, metadata *models.Metada
 // Old tars created before the metadata was added will not contain the
 // metadata.textproto file; tars lacking the file will be treated as containing
 // FTrace traces.
-func readTar(inputTar io.Reader, failOnUnknownEventFormat bool) (*eventpb.EventSet, *models.SystemTopology, error) {
+// Events that don't pass filter are dropped while parsing. Only FTrace,
+// trace-cmd and textual FTrace traces can be filtered.
+func readTar(inputTar io.Reader, failOnUnknownEventFormat bool, filter *traceparser.EventFilter) (*eventpb.EventSet, *models.SystemTopology, error) {
 	tmpDir, err := ioutil.TempDir("", "temptar")
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create temp directory: %s", err)
@@ -169,14 +228,42 @@ func readTar(inputTar io.Reader, failOnUnknownEventFormat bool) (*eventpb.EventS
 		return nil, nil, err
 	}
 
+	var es *eventpb.EventSet
+	var topology *models.SystemTopology
+	if !filter.IsEmpty() && (config.TraceType == eventpb.ArchiveMetadataConfig_EBPF || config.TraceType == eventpb.ArchiveMetadataConfig_PERFETTO || config.TraceType == eventpb.ArchiveMetadataConfig_SCENARIO) {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "event filters are not supported for %s traces", config.TraceType)
+	}
 	switch config.TraceType {
 	case eventpb.ArchiveMetadataConfig_FTRACE:
-		return parseFTraceTar(tmpDir, failOnUnknownEventFormat)
+		es, topology, err = parseFTraceTar(tmpDir, failOnUnknownEventFormat, filter)
 	case eventpb.ArchiveMetadataConfig_EBPF:
-		return parseEBPFTar(tmpDir)
+		es, topology, err = parseEBPFTar(tmpDir)
+	case eventpb.ArchiveMetadataConfig_TRACE_CMD:
+		es, topology, err = parseTraceCmdTar(tmpDir, failOnUnknownEventFormat, filter)
+	case eventpb.ArchiveMetadataConfig_FTRACE_TEXT:
+		es, topology, err = parseFTraceTextTar(tmpDir, failOnUnknownEventFormat, filter)
+	case eventpb.ArchiveMetadataConfig_PERFETTO:
+		es, topology, err = parsePerfettoTar(tmpDir)
+	case eventpb.ArchiveMetadataConfig_SCENARIO:
+		es, topology, err = parseScenarioTar(tmpDir)
 	default:
 		return nil, nil, status.Errorf(codes.Internal, "unknown trace type %s", config.TraceType)
 	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// The trace clock recorded in the metadata overrides any recorded in the trace itself.
+	if config.GetTraceClock() != nil {
+		es.TraceClock = config.GetTraceClock()
+	}
+	// Any trace may be accompanied by a snapshot of its threads' thread groups, which overrides
+	// the thread groups recorded in the trace itself.
+	if threadGroups, err := readThreadGroups(path.Join(tmpDir, "thread_groups")); err != nil {
+		log.Warningf("error reading thread_groups. Threads will only be grouped by process as the trace records. error: %s", err)
+	} else if threadGroups != nil {
+		es.ThreadGroup = threadgroups.Merge(es.ThreadGroup, threadGroups)
+	}
+	return es, topology, nil
 }
 
 // untar unpacks a gzip compressed tar to the destination directory.
@@ -300,9 +387,18 @@ stats [optional]
   - cpu1
     ...
   - cpuN
-
+printk_formats [optional]
+kallsyms [optional]
+thread_groups [optional]
+
+The formats of the ftrace/bprint and ftrace/bputs events, together with
+printk_formats, are needed to render the messages recorded by trace_printk().
+kallsyms, a copy of /proc/kallsyms, is used to resolve fields holding kernel
+addresses into symbols. thread_groups, which tars of any trace type may hold, lists the
+thread group, or process, of each thread as '<tgid> <pid>' lines, so that threads can be
+aggregated by process.
 */
-func parseFTraceTar(dir string, failOnUnknownEventFormat bool) (*eventpb.EventSet, *models.SystemTopology, error) {
+func parseFTraceTar(dir string, failOnUnknownEventFormat bool, filter *traceparser.EventFilter) (*eventpb.EventSet, *models.SystemTopology, error) {
 	// Read formats
 	headerFormat, eventFormats, err := readFormats(path.Join(dir, "formats"))
 	if err != nil {
@@ -331,7 +427,22 @@ func parseFTraceTar(dir string, failOnUnknownEventFormat bool) (*eventpb.EventSe
 	}
 	traceParser.SetFailOnUnknownEventFormat(failOnUnknownEventFormat)
 
+	// Read printk formats
+	if printkFormats, err := readPrintkFormats(path.Join(dir, "printk_formats")); err != nil {
+		log.Warningf("error reading printk_formats. trace_printk() messages will not be rendered. error: %s", err)
+	} else if printkFormats != nil {
+		traceParser.SetPrintkFormats(printkFormats)
+	}
+
+	// Read kernel symbols
+	if kallsyms, err := readKallsyms(path.Join(dir, "kallsyms")); err != nil {
+		log.Warningf("error reading kallsyms. Kernel addresses will not be symbolized. error: %s", err)
+	} else if kallsyms != nil {
+		traceParser.SetKallsyms(kallsyms)
+	}
+
 	eventSetBuilder := traceparser.NewEventSetBuilder(&traceParser)
+	eventSetBuilder.SetEventFilter(filter)
 	if options != nil {
 		if overwrite, ok := options["overwrite"]; ok {
 			eventSetBuilder.SetOverwrite(overwrite)
@@ -343,14 +454,7 @@ func parseFTraceTar(dir string, failOnUnknownEventFormat bool) (*eventpb.EventSe
 		eventSetBuilder.SetOverflowedCPUs(overflowed)
 	}
 
-	addTraceEvent := func(traceEvent *traceparser.TraceEvent) (bool, error) {
-		if err := eventSetBuilder.AddTraceEvent(traceEvent); err != nil {
-			return false, fmt.Errorf("error in AddTraceEvent: %s", err)
-		}
-		return true, nil
-	}
-
-	if err := readFTraceTraces(path.Join(dir, "traces"), &traceParser, addTraceEvent); err != nil {
+	if err := readFTraceTraces(path.Join(dir, "traces"), &traceParser, eventSetBuilder); err != nil {
 		return nil, nil, fmt.Errorf("failed to read Ftrace trace files: %s", err)
 	}
 
@@ -402,6 +506,45 @@ func readFormats(formatDir string) (string, []string, error) {
 	return headerFormat, eventFormats, nil
 }
 
+// readPrintkFormats reads the printk_formats file of an FTrace tar. If the tar
+// doesn't contain one, nil is returned.
+func readPrintkFormats(printkFormatsPath string) (traceparser.PrintkFormats, error) {
+	contents, err := ioutil.ReadFile(printkFormatsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return traceparser.ParsePrintkFormats(string(contents))
+}
+
+// readKallsyms reads the kallsyms file of an FTrace tar. If the tar doesn't
+// contain one, nil is returned.
+func readKallsyms(kallsymsPath string) (*traceparser.Kallsyms, error) {
+	contents, err := ioutil.ReadFile(kallsymsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return traceparser.ParseKallsyms(string(contents))
+}
+
+// readThreadGroups reads the thread group snapshot of a tar, returning nil if there is none.
+func readThreadGroups(threadGroupsPath string) ([]*eventpb.ThreadGroup, error) {
+	f, err := os.Open(threadGroupsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return threadgroups.Parse(f)
+}
+
 // readTopology reads the topology directory of an FTrace tar and returns the
 // topology in its fully parsed format.
 func readTopology(topoDir string) (*models.SystemTopology, error) {
@@ -471,12 +614,14 @@ func readOptions(optionsDir string) (map[string]bool, error) {
 	return options, nil
 }
 
-// readFTraceTraces reads the trace files contained in an FTrace tar and
-// parses them with the provided TraceParser.
-func readFTraceTraces(traceDir string, traceParser *traceparser.TraceParser, callback traceparser.AddEventCallback) error {
-	return traceparser.WalkPerCPUDir(traceDir, true, func(reader *bufio.Reader, cpu int64) error {
-		return traceParser.ParseTrace(reader, cpu, callback)
-	})
+// readFTraceTraces reads the trace files contained in an FTrace tar, parses
+// them concurrently with the provided TraceParser, and adds their events to the
+// provided EventSetBuilder.
+func readFTraceTraces(traceDir string, traceParser *traceparser.TraceParser, eventSetBuilder *traceparser.EventSetBuilder) error {
+	walk := func(process func(reader *bufio.Reader, cpu int64) error) error {
+		return traceparser.WalkPerCPUDir(traceDir, true, process)
+	}
+	return traceParser.ParseTracesInParallel(walk, eventSetBuilder, 0 /*=parallelism*/)
 }
 
 // findOverflowedCPUs reads the per cpu stats files to find which cpus "overflowed".
@@ -501,6 +646,136 @@ func findOverflowedCPUs(traceDir string) (map[int64]struct{}, error) {
 	return overflowed, nil
 }
 
+// readTraceDat reads a bare trace-cmd trace.dat file. Since trace.dat files do not contain the
+// system topology, an empty topology is returned.
+func readTraceDat(inputFile io.Reader, failOnUnknownEventFormat bool, filter *traceparser.EventFilter) (*eventpb.EventSet, *models.SystemTopology, error) {
+	tmpFile, err := ioutil.TempFile("", "trace.dat")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp file: %s", err)
+	}
+	defer func() {
+		// Clean up temp file after parsing or on error.
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			log.Errorf("failed to clean up temp file while parsing trace.dat: %s", err)
+		}
+	}()
+	if _, err := io.Copy(tmpFile, inputFile); err != nil {
+		return nil, nil, fmt.Errorf("failed to write temp file: %s", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	eventSet, err := parseTraceDat(tmpFile.Name(), failOnUnknownEventFormat, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	return eventSet, &models.SystemTopology{
+		LogicalCores: []*models.LogicalCore{},
+	}, nil
+}
+
+/*
+parseTraceCmdTar parses a tar that has a trace-cmd trace inside of it.
+The format of the tar is:
+
+metadata.textproto
+trace.dat
+topology [optional]
+  - node0
+    - cpu0
+      - topology
+        - ... (see parseFTraceTar)
+*/
+func parseTraceCmdTar(dir string, failOnUnknownEventFormat bool, filter *traceparser.EventFilter) (*eventpb.EventSet, *models.SystemTopology, error) {
+	eventSet, err := parseTraceDat(path.Join(dir, "trace.dat"), failOnUnknownEventFormat, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Read topology
+	topology, err := readTopology(path.Join(dir, "topology"))
+	if err != nil {
+		log.Warningf("error reading topology. Using empty topology. error: %s", err)
+		topology = &models.SystemTopology{
+			LogicalCores: []*models.LogicalCore{},
+		}
+	}
+
+	return eventSet, topology, nil
+}
+
+// parseTraceDat parses the trace-cmd trace.dat file at filePath, keeping only
+// the events that pass filter.
+func parseTraceDat(filePath string, failOnUnknownEventFormat bool, filter *traceparser.EventFilter) (*eventpb.EventSet, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s for reading: %s", filePath, err)
+	}
+	defer file.Close()
+
+	traceDat, err := traceparser.ReadTraceDat(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trace.dat file: %s", err)
+	}
+	return traceDat.EventSet(failOnUnknownEventFormat, filter)
+}
+
+/*
+parseFTraceTextTar parses a tar that has a textual FTrace trace inside of it.
+The format of the tar is:
+
+metadata.textproto
+trace
+formats [optional]
+  - ... (see parseFTraceTar)
+topology [optional]
+  - ... (see parseFTraceTar)
+
+If the formats are present, they are used to type the fields of each event.
+*/
+func parseFTraceTextTar(dir string, failOnUnknownEventFormat bool, filter *traceparser.EventFilter) (*eventpb.EventSet, *models.SystemTopology, error) {
+	var traceParser *traceparser.TraceParser
+	formatDir := path.Join(dir, "formats")
+	if _, err := os.Stat(formatDir); err == nil {
+		headerFormat, eventFormats, err := readFormats(formatDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading formats: %s", err)
+		}
+		tp, err := traceparser.New(headerFormat, eventFormats)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse formats: %s", err)
+		}
+		tp.SetFailOnUnknownEventFormat(failOnUnknownEventFormat)
+		traceParser = &tp
+	}
+
+	filePath := path.Join(dir, "trace")
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening %s for reading: %s", filePath, err)
+	}
+	defer file.Close()
+
+	textParser := traceparser.NewTextTraceParser(traceParser)
+	textParser.SetEventFilter(filter)
+	eventSet, err := textParser.Parse(bufio.NewReader(file))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing textual ftrace trace: %s", err)
+	}
+
+	// Read topology
+	topology, err := readTopology(path.Join(dir, "topology"))
+	if err != nil {
+		log.Warningf("error reading topology. Using empty topology. error: %s", err)
+		topology = &models.SystemTopology{
+			LogicalCores: []*models.LogicalCore{},
+		}
+	}
+
+	return eventSet, topology, nil
+}
+
 func parseEBPFTar(dir string) (*eventpb.EventSet, *models.SystemTopology, error) {
 	traceParser := schedbt.NewParser()
 
@@ -530,6 +805,80 @@ func parseEBPFTar(dir string) (*eventpb.EventSet, *models.SystemTopology, error)
 	return eventSet, topology, nil
 }
 
+/*
+parsePerfettoTar parses a tar that has a Perfetto trace inside of it.
+The format of the tar is:
+
+metadata.textproto
+trace.perfetto-trace
+topology [optional]
+  - ... (see parseFTraceTar)
+*/
+func parsePerfettoTar(dir string) (*eventpb.EventSet, *models.SystemTopology, error) {
+	traceParser := perfetto.NewParser()
+
+	filePath := path.Join(dir, "trace.perfetto-trace")
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening %s for reading: %s", filePath, err)
+	}
+	defer file.Close()
+
+	if err := traceParser.Parse(bufio.NewReader(file)); err != nil {
+		return nil, nil, fmt.Errorf("error parsing perfetto trace: %s", err)
+	}
+	eventSet, err := traceParser.EventSet()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Read topology
+	topology, err := readTopology(path.Join(dir, "topology"))
+	if err != nil {
+		log.Warningf("error reading topology. Using empty topology. error: %s", err)
+		topology = &models.SystemTopology{
+			LogicalCores: []*models.LogicalCore{},
+		}
+	}
+
+	return eventSet, topology, nil
+}
+
+/*
+parseScenarioTar parses a tar that has a scenario, in the format described in
+package scenario, inside of it.
+The format of the tar is:
+
+metadata.textproto
+scenario
+topology [optional]
+  - ... (see parseFTraceTar)
+*/
+func parseScenarioTar(dir string) (*eventpb.EventSet, *models.SystemTopology, error) {
+	filePath := path.Join(dir, "scenario")
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening %s for reading: %s", filePath, err)
+	}
+	defer file.Close()
+
+	eventSet, err := scenario.Parse(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Read topology
+	topology, err := readTopology(path.Join(dir, "topology"))
+	if err != nil {
+		log.Warningf("error reading topology. Using empty topology. error: %s", err)
+		topology = &models.SystemTopology{
+			LogicalCores: []*models.LogicalCore{},
+		}
+	}
+
+	return eventSet, topology, nil
+}
+
 func readString(r io.Reader) (string, error) {
 	buf, err := ioutil.ReadAll(r)
 	if err != nil {
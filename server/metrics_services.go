@@ -38,14 +38,35 @@ type ThreadSummariesResponse struct {
 	Metrics        []*sched.Metrics `json:"metrics"`
 }
 
+// ProcessSummariesRequest is a request for process summary information across a specified
+// timespan for a specified collection, filtered to the requested CPU set and processes. Each
+// process's summary aggregates those of its threads. Timestamps and CPUs are interpreted as in
+// ThreadSummariesRequest. If the provided TGID set is empty, all processes are filtered in.
+type ProcessSummariesRequest struct {
+	CollectionName   string          `json:"collectionName"`
+	StartTimestampNs trace.Timestamp `json:"startTimestampNs"`
+	EndTimestampNs   trace.Timestamp `json:"endTimestampNs"`
+	Cpus             []sched.CPUID   `json:"cpus"`
+	Tgids            []sched.PID     `json:"tgids"`
+}
+
+// ProcessSummariesResponse contains the response to a ProcessSummariesRequest.
+type ProcessSummariesResponse struct {
+	CollectionName string           `json:"collectionName"`
+	Metrics        []*sched.Metrics `json:"metrics"`
+}
+
 // AntagonistsRequest is a request for antagonist information for a specified set of threads, across
 // a specified timestamp for a specified collection.  If start_timestamp_ns is -1,
 // the first timestamp in the collection is used instead.  If end_timestamp_ns
-// is -1, the last timestamp in the collection is used instead.
+// is -1, the last timestamp in the collection is used instead.  Antagonists
+// are returned for each thread in Pids, then for each process, all of whose
+// threads are victims together, in Tgids.
 type AntagonistsRequest struct {
 	// The collection name.
 	CollectionName   string          `json:"collectionName"`
 	Pids             []sched.PID     `json:"pids"`
+	Tgids            []sched.PID     `json:"tgids"`
 	StartTimestampNs trace.Timestamp `json:"startTimestampNs"`
 	EndTimestampNs   trace.Timestamp `json:"endTimestampNs"`
 }
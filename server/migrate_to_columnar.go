@@ -0,0 +1,49 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Binary migrate_to_columnar is a command line tool to rewrite the collections
+// in a SchedViz storage path in the columnar format.  It should not be run
+// while a server is using the same storage path.
+package main
+
+import (
+	"flag"
+
+	log "github.com/golang/glog"
+	"github.com/google/schedviz/server/storageservice"
+)
+
+var (
+	storagePath   = flag.String("storage_path", "", "Required. The folder where trace data is stored.")
+	keepOriginals = flag.Bool("keep_originals", false, "Optional. If true, each collection's original .binproto file is kept alongside its columnar file")
+)
+
+func main() {
+	flag.Parse()
+
+	if *storagePath == "" {
+		log.Exit("storage_path is required.")
+	}
+	fs, err := storageservice.CreateFSStorage(*storagePath, 1)
+	if err != nil {
+		log.Exitf("Failed to open storage path: %s", err)
+	}
+	migrated, err := fs.MigrateToColumnar(*keepOriginals)
+	if err != nil {
+		log.Exitf("Failed to migrate collections after migrating %d: %s", len(migrated), err)
+	}
+	log.Infof("Migrated %d collections to the columnar format", len(migrated))
+}
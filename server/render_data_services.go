@@ -24,6 +24,10 @@ import (
 // CPUIntervalsRequest is a request for CPU intervals for the specified collection.
 type CPUIntervalsRequest struct {
 	CollectionName string `json:"collectionName"`
+	// In a multi-host collection, the host to request intervals for.  If set,
+	// CPUs, and the CPUs and PIDs in the response, are the host's own;
+	// otherwise they are namespaced.
+	Host string `json:"host"`
 	// The CPUs to request intervals for.  If empty, all CPUs are selected.
 	CPUs []int64 `json:"cpus"`
 	// Designates a minimum interval duration.  Adjacent intervals smaller than
@@ -80,6 +84,10 @@ type FtraceEventsResponse struct {
 type PidIntervalsRequest struct {
 	// The name of the collection to look up intervals in
 	CollectionName string `json:"collectionName"`
+	// In a multi-host collection, the host to request intervals for.  If set,
+	// PIDs, and the CPUs and PIDs in the response, are the host's own;
+	// otherwise they are namespaced.
+	Host string `json:"host"`
 	// The PIDs to request intervals for
 	Pids []int64 `json:"pids"`
 	// The time span over which to request PID intervals, specified in
@@ -95,6 +103,12 @@ type PidIntervalsRequest struct {
 	// appear in the output, if they could not be merged with neighbors.  If 0, no
 	// merging is performed.
 	MinIntervalDurationNs int64 `json:"minIntervalDurationNs"`
+	// If nonzero, the thread states, joined with bitwise OR, to request
+	// intervals in.  If zero, all states but Dead are requested.
+	ThreadStates sched.ThreadState `json:"threadStates"`
+	// If nonzero, the task states, joined with bitwise OR, to request intervals
+	// in, among the thread states they refine.
+	TaskStates sched.TaskState `json:"taskStates"`
 }
 
 // PIDIntervals is a tuple holding a PID and its intervals
@@ -113,3 +127,62 @@ type PIDntervalsResponse struct {
 	// A list of PID intervals
 	PIDIntervals []PIDIntervals `json:"pidIntervals"`
 }
+
+// ThreadLifetimesRequest is a request for the lifetimes of threads in the
+// specified collection.
+type ThreadLifetimesRequest struct {
+	// The name of the collection to look up lifetimes in
+	CollectionName string `json:"collectionName"`
+	// In a multi-host collection, the host to request lifetimes for.  If set,
+	// PIDs, and the PIDs in the response, are the host's own; otherwise they are
+	// namespaced.
+	Host string `json:"host"`
+	// The PIDs to request lifetimes for.  If empty, the lifetimes of all threads
+	// are requested.
+	Pids []int64 `json:"pids"`
+	// The time span over which to request lifetimes, specified in nanoseconds.
+	// Only lifetimes overlapping this span are returned.  If start_timestamp_ns
+	// is -1, the time span will begin at the first valid collection timestamp.
+	// If end_timestamp_ns is -1, the time span will end at the last valid
+	// collection timestamp.
+	StartTimestampNs int64 `json:"startTimestampNs"`
+	EndTimestampNs   int64 `json:"endTimestampNs"`
+}
+
+// ThreadLifetimesResponse is a response for a thread lifetimes request.
+type ThreadLifetimesResponse struct {
+	// The name of the collection
+	CollectionName string `json:"collectionName"`
+	// The lifetimes of the requested threads, sorted by PID and then by start
+	Lifetimes []*sched.ThreadLifetime `json:"lifetimes"`
+}
+
+// WakeupLatenciesRequest is a request for the wakeup latencies of threads in
+// the specified collection.
+type WakeupLatenciesRequest struct {
+	// The name of the collection to look up wakeup latencies in
+	CollectionName string `json:"collectionName"`
+	// In a multi-host collection, the host to request wakeup latencies for.  If
+	// set, PIDs, and the PIDs and CPUs in the response, are the host's own;
+	// otherwise they are namespaced.
+	Host string `json:"host"`
+	// The PIDs to request wakeup latencies for.  If empty, the wakeup latencies
+	// of all threads are requested.
+	Pids []int64 `json:"pids"`
+	// The time span over which to request wakeup latencies, specified in
+	// nanoseconds.  Only wakeups requested within this span are returned.  If
+	// start_timestamp_ns is -1, the time span will begin at the first valid
+	// collection timestamp.  If end_timestamp_ns is -1, the time span will end
+	// at the last valid collection timestamp.
+	StartTimestampNs int64 `json:"startTimestampNs"`
+	EndTimestampNs   int64 `json:"endTimestampNs"`
+}
+
+// WakeupLatenciesResponse is a response for a wakeup latencies request.
+type WakeupLatenciesResponse struct {
+	// The name of the collection
+	CollectionName string `json:"collectionName"`
+	// The wakeup latencies of the requested threads, sorted by PID and then by
+	// generation
+	Latencies []*sched.ThreadWakeupLatencies `json:"latencies"`
+}
@@ -18,6 +18,7 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/json"
@@ -33,6 +34,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/gorilla/mux"
 
+	"github.com/google/schedviz/analysis/traceexport"
 	"github.com/google/schedviz/server/apiservice"
 	"github.com/google/schedviz/server/models"
 	"github.com/google/schedviz/server/storageservice"
@@ -48,6 +50,8 @@ var (
 	storagePath              = flag.String("storage_path", "", "The folder where trace data is/will be stored.")
 	cacheSize                = flag.Int("cache_size", 25, "The maximum number of collections to keep open at once.")
 	failOnUnknownEventFormat = flag.Bool("fail_on_unknown_event_format", true, "Whether or not to continue parsing when an unknown event is encountered")
+	columnarStorage          = flag.Bool("columnar_storage", false, "Whether to save new collections in the columnar format, which is faster to open. Collections in either format can be read")
+	validateUploads          = flag.Bool("validate_uploads", false, "Whether to check uploaded traces for malformed events, rejecting those with errors")
 )
 
 
@@ -176,6 +180,81 @@ func (s *storageServiceHTTPHandler) handleUpload(w http.ResponseWriter, req *htt
 	sendStringHTTPResponse(req, collectionName, w)
 }
 
+func (s *storageServiceHTTPHandler) handleMergeCollections(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	user, err := httpUser(w, req)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to get HTTP user: %s", err))
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to parse form: %s", err))
+		return
+	}
+	jsonreq := &models.MergeCollectionsRequest{}
+	if err := readRequestBodyIntoStruct(req, jsonreq); err != nil {
+		httpErrorBadRequest(w, req, fmt.Sprintf("Failed to parse request body: %s", err))
+		return
+	}
+	jsonreq.Creator = user
+	collectionName, err := s.MergeCollections(ctx, jsonreq)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to merge collections: %s", err))
+		return
+	}
+	sendStringHTTPResponse(req, collectionName, w)
+}
+
+func (s *storageServiceHTTPHandler) handleSliceCollection(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	user, err := httpUser(w, req)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to get HTTP user: %s", err))
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to parse form: %s", err))
+		return
+	}
+	jsonreq := &models.SliceCollectionRequest{}
+	if err := readRequestBodyIntoStruct(req, jsonreq); err != nil {
+		httpErrorBadRequest(w, req, fmt.Sprintf("Failed to parse request body: %s", err))
+		return
+	}
+	jsonreq.Creator = user
+	collectionName, err := s.SliceCollection(ctx, jsonreq)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to slice collection: %s", err))
+		return
+	}
+	sendStringHTTPResponse(req, collectionName, w)
+}
+
+func (s *storageServiceHTTPHandler) handleCreateMultiHostCollection(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	user, err := httpUser(w, req)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to get HTTP user: %s", err))
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to parse form: %s", err))
+		return
+	}
+	jsonreq := &models.MultiHostCollectionRequest{}
+	if err := readRequestBodyIntoStruct(req, jsonreq); err != nil {
+		httpErrorBadRequest(w, req, fmt.Sprintf("Failed to parse request body: %s", err))
+		return
+	}
+	jsonreq.Creator = user
+	collectionName, err := s.CreateMultiHostCollection(ctx, jsonreq)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to create multi-host collection: %s", err))
+		return
+	}
+	sendStringHTTPResponse(req, collectionName, w)
+}
+
 func (s *storageServiceHTTPHandler) handleGetCollectionMetadata(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	if err := req.ParseForm(); err != nil {
@@ -294,6 +373,9 @@ func registerStorageService(r *mux.Router, s storageservice.StorageService) {
 	sh := &storageServiceHTTPHandler{s}
 	handle(r, "/get_collection_metadata", sh.handleGetCollectionMetadata)
 	handle(r, "/upload", sh.handleUpload)
+	handle(r, "/merge_collections", sh.handleMergeCollections)
+	handle(r, "/slice_collection", sh.handleSliceCollection)
+	handle(r, "/create_multi_host_collection", sh.handleCreateMultiHostCollection)
 	handle(r, "/delete_collection", sh.handleDeleteCollection)
 	handle(r, "/edit_collection", sh.handleEditCollection)
 	handle(r, "/get_collection_parameters", sh.handleGetCollectionParameters)
@@ -341,6 +423,44 @@ func (a *apiServiceHTTPHandler) handleGetPIDIntervals(w http.ResponseWriter, req
 	sendStructHTTPResponse(req, res, w)
 }
 
+func (a *apiServiceHTTPHandler) handleGetThreadLifetimes(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	if err := req.ParseForm(); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to parse form: %s", err))
+		return
+	}
+	jsonreq := &models.ThreadLifetimesRequest{}
+	if err := readRequestBodyIntoStruct(req, jsonreq); err != nil {
+		httpErrorBadRequest(w, req, fmt.Sprintf("Failed to parse request body: %s", err))
+		return
+	}
+	res, err := a.GetThreadLifetimes(ctx, jsonreq)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to get thread lifetimes: %s", err))
+		return
+	}
+	sendStructHTTPResponse(req, res, w)
+}
+
+func (a *apiServiceHTTPHandler) handleGetWakeupLatencies(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	if err := req.ParseForm(); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to parse form: %s", err))
+		return
+	}
+	jsonreq := &models.WakeupLatenciesRequest{}
+	if err := readRequestBodyIntoStruct(req, jsonreq); err != nil {
+		httpErrorBadRequest(w, req, fmt.Sprintf("Failed to parse request body: %s", err))
+		return
+	}
+	res, err := a.GetWakeupLatencies(ctx, jsonreq)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to get wakeup latencies: %s", err))
+		return
+	}
+	sendStructHTTPResponse(req, res, w)
+}
+
 func (a *apiServiceHTTPHandler) handleGetAntagonists(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	if err := req.ParseForm(); err != nil {
@@ -398,6 +518,25 @@ func (a *apiServiceHTTPHandler) handleGetThreadSummaries(w http.ResponseWriter,
 	sendStructHTTPResponse(req, res, w)
 }
 
+func (a *apiServiceHTTPHandler) handleGetProcessSummaries(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	if err := req.ParseForm(); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to parse form: %s", err))
+		return
+	}
+	jsonreq := &models.ProcessSummariesRequest{}
+	if err := readRequestBodyIntoStruct(req, jsonreq); err != nil {
+		httpErrorBadRequest(w, req, fmt.Sprintf("Failed to parse request body: %s", err))
+		return
+	}
+	res, err := a.GetProcessSummaries(ctx, jsonreq)
+	if err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to get per process summaries: %s", err))
+		return
+	}
+	sendStructHTTPResponse(req, res, w)
+}
+
 func (a *apiServiceHTTPHandler) handleGetUtilizationMetrics(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	if err := req.ParseForm(); err != nil {
@@ -437,16 +576,49 @@ func (a *apiServiceHTTPHandler) handleSystemTopology(w http.ResponseWriter, req
 	sendStructHTTPResponse(req, jsonresp, w)
 }
 
+func (a *apiServiceHTTPHandler) handleExportCollection(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	if err := req.ParseForm(); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to parse form: %s", err))
+		return
+	}
+	jsonreq := &models.ExportCollectionRequest{}
+	if err := readRequestBodyIntoStruct(req, jsonreq); err != nil {
+		httpErrorBadRequest(w, req, fmt.Sprintf("Failed to parse request body: %s", err))
+		return
+	}
+	// Export into a buffer, so that errors can still be reported if exporting fails partway.
+	buf := &bytes.Buffer{}
+	if err := a.ExportCollection(ctx, jsonreq, buf); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to export collection: %s", err))
+		return
+	}
+	contentType, ext := "application/octet-stream", "perfetto-trace"
+	if jsonreq.Format == string(traceexport.ChromeJSON) {
+		contentType, ext = "application/json", "json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(jsonreq.CollectionName)+"."+ext))
+	writer, closer := gzipEnabledWriter(req, w)
+	defer func() { _ = closer() }()
+	if _, err := buf.WriteTo(writer); err != nil {
+		httpErrorInternal(w, req, fmt.Sprintf("Failed to write exported collection: %s", err))
+	}
+}
 
 func registerAPIService(r *mux.Router, a *apiservice.APIService) {
 	ah := &apiServiceHTTPHandler{a}
 	handle(r, "/get_cpu_intervals", ah.handleGetCPUIntervals)
 	handle(r, "/get_pid_intervals", ah.handleGetPIDIntervals)
+	handle(r, "/get_thread_lifetimes", ah.handleGetThreadLifetimes)
+	handle(r, "/get_wakeup_latencies", ah.handleGetWakeupLatencies)
 	handle(r, "/get_antagonists", ah.handleGetAntagonists)
 	handle(r, "/get_per_thread_event_series", ah.handleGetPerThreadEventSeries)
 	handle(r, "/get_thread_summaries", ah.handleGetThreadSummaries)
+	handle(r, "/get_process_summaries", ah.handleGetProcessSummaries)
 	handle(r, "/get_utilization_metrics", ah.handleGetUtilizationMetrics)
 	handle(r, "/get_system_topology", ah.handleSystemTopology)
+	handle(r, "/export_collection", ah.handleExportCollection)
 }
 
 var startServer = func(r *mux.Router) {
@@ -465,6 +637,8 @@ var setStorageService = func(ctx context.Context) error {
 		return err
 	}
 	ss.SetFailOnUnknownEventFormat(*failOnUnknownEventFormat)
+	ss.SetColumnarStorage(*columnarStorage)
+	ss.SetValidateUploads(*validateUploads)
 
 	storageService = ss
 	return nil
@@ -492,6 +492,110 @@ func TestGetPIDIntervals(t *testing.T) {
 	}
 }
 
+func TestGetPIDIntervals_TaskStates(t *testing.T) {
+	// PID 17254 sleeps from 21845, switched out with a prev_state that is not a
+	// known task state, and is preempted at 71547.  Task states only filter the
+	// thread states they refine.
+	for _, test := range []struct {
+		taskStates sched.TaskState
+		wantStarts []trace.Timestamp
+	}{
+		{sched.TaskPreempted, []trace.Timestamp{21845, 71547}},
+		{sched.TaskInterruptible, []trace.Timestamp{71547}},
+		{sched.TaskPreempted | sched.TaskInterruptible, []trace.Timestamp{71547}},
+	} {
+		requestJSON := encodeJSON(t, &models.PidIntervalsRequest{
+			CollectionName:   collectionName,
+			Pids:             []int64{17254},
+			StartTimestampNs: 0,
+			EndTimestampNs:   72000,
+			ThreadStates:     sched.WaitingState | sched.SleepingState,
+			TaskStates:       test.taskStates,
+		})
+		endpoint := fmt.Sprintf("get_pid_intervals?request=%s", requestJSON)
+		res, err := http.Post(fullURL(endpoint), "application/json", strings.NewReader(requestJSON))
+		if err != nil {
+			t.Fatalf("unexpected error fetching %s: %s", endpoint, err)
+		}
+		if err := checkStatusCode(res, http.StatusOK); err != nil {
+			t.Fatal(err)
+		}
+		got := &models.PIDntervalsResponse{}
+		if err := readResponseBodyIntoStruct(res, got); err != nil {
+			t.Fatal(err)
+		}
+		var gotStarts []trace.Timestamp
+		for _, interval := range got.PIDIntervals[0].Intervals {
+			gotStarts = append(gotStarts, interval.StartTimestamp)
+		}
+		if diff := cmp.Diff(test.wantStarts, gotStarts); diff != "" {
+			t.Errorf("GetPIDIntervals() with task states %s: Diff -want +got:\n%s", test.taskStates, diff)
+		}
+	}
+}
+
+func TestGetThreadLifetimes(t *testing.T) {
+	requestJSON := encodeJSON(t, &models.ThreadLifetimesRequest{
+		CollectionName:   collectionName,
+		Pids:             []int64{17254},
+		StartTimestampNs: -1,
+		EndTimestampNs:   -1,
+	})
+	endpoint := fmt.Sprintf("get_thread_lifetimes?request=%s", requestJSON)
+	res, err := http.Post(fullURL(endpoint), "application/json", strings.NewReader(requestJSON))
+	if err != nil {
+		t.Fatalf("unexpected error fetching %s: %s", endpoint, err)
+	}
+	if err := checkStatusCode(res, http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	got := &models.ThreadLifetimesResponse{}
+	if err := readResponseBodyIntoStruct(res, got); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test trace doesn't record thread lifecycle events.
+	want := &models.ThreadLifetimesResponse{
+		CollectionName: collectionName,
+		Lifetimes:      []*sched.ThreadLifetime{},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("TestGetThreadLifetimes: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestGetWakeupLatencies(t *testing.T) {
+	requestJSON := encodeJSON(t, &models.WakeupLatenciesRequest{
+		CollectionName:   collectionName,
+		Pids:             []int64{17254},
+		StartTimestampNs: -1,
+		EndTimestampNs:   -1,
+	})
+	endpoint := fmt.Sprintf("get_wakeup_latencies?request=%s", requestJSON)
+	res, err := http.Post(fullURL(endpoint), "application/json", strings.NewReader(requestJSON))
+	if err != nil {
+		t.Fatalf("unexpected error fetching %s: %s", endpoint, err)
+	}
+	if err := checkStatusCode(res, http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	got := &models.WakeupLatenciesResponse{}
+	if err := readResponseBodyIntoStruct(res, got); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test trace doesn't record sched_waking events.
+	want := &models.WakeupLatenciesResponse{
+		CollectionName: collectionName,
+		Latencies:      []*sched.ThreadWakeupLatencies{},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("TestGetWakeupLatencies: Diff -want +got:\n%s", diff)
+	}
+}
+
 func TestGetAntagonists(t *testing.T) {
 	requestJSON := encodeJSON(t, &models.AntagonistsRequest{
 		CollectionName:   collectionName,
@@ -668,17 +772,19 @@ func TestGetThreadSummaries(t *testing.T) {
 	want := &models.ThreadSummariesResponse{
 		CollectionName: collectionName,
 		Metrics: []*sched.Metrics{{
-			WakeupCount:      271,
-			UnknownTimeNs:    0,
-			RunTimeNs:        5680247,
-			WaitTimeNs:       2459979,
-			SleepTimeNs:      2001010329,
-			Pids:             []sched.PID{3},
-			Commands:         []string{"ksoftirqd/0"},
-			Priorities:       []sched.Priority{120},
-			Cpus:             []sched.CPUID{0},
-			StartTimestampNs: 0,
-			EndTimestampNs:   2009150555,
+			WakeupCount:              271,
+			UnknownTimeNs:            0,
+			RunTimeNs:                5680247,
+			WaitTimeNs:               2459979,
+			SleepTimeNs:              2001010329,
+			InterruptibleSleepTimeNs: 1994731363,
+			Pids:                     []sched.PID{3},
+			Commands:                 []string{"ksoftirqd/0"},
+			Priorities:               []sched.Priority{120},
+			Cpus:                     []sched.CPUID{0},
+			TGID:                     3,
+			StartTimestampNs:         0,
+			EndTimestampNs:           2009150555,
 		}},
 	}
 
@@ -687,6 +793,52 @@ func TestGetThreadSummaries(t *testing.T) {
 	}
 }
 
+func TestGetProcessSummaries(t *testing.T) {
+	requestJSON := encodeJSON(t, &models.ProcessSummariesRequest{
+		CollectionName:   collectionName,
+		Cpus:             []sched.CPUID{0},
+		StartTimestampNs: 0,
+		EndTimestampNs:   2009150555,
+		Tgids:            []sched.PID{3},
+	})
+	endpoint := fmt.Sprintf("get_process_summaries?request=%s", requestJSON)
+	res, err := http.Post(fullURL(endpoint), "application/json", strings.NewReader(requestJSON))
+	if err != nil {
+		t.Fatalf("unexpected error fetching %s: %s", endpoint, err)
+	}
+	if err := checkStatusCode(res, http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	got := &models.ProcessSummariesResponse{}
+	if err := readResponseBodyIntoStruct(res, got); err != nil {
+		t.Fatal(err)
+	}
+
+	// The trace records no thread groups, so ksoftirqd/0 is a process of its own.
+	want := &models.ProcessSummariesResponse{
+		CollectionName: collectionName,
+		Metrics: []*sched.Metrics{{
+			WakeupCount:              271,
+			UnknownTimeNs:            0,
+			RunTimeNs:                5680247,
+			WaitTimeNs:               2459979,
+			SleepTimeNs:              2001010329,
+			InterruptibleSleepTimeNs: 1994731363,
+			Pids:                     []sched.PID{3},
+			Commands:                 []string{"ksoftirqd/0"},
+			Priorities:               []sched.Priority{120},
+			Cpus:                     []sched.CPUID{0},
+			TGID:                     3,
+			StartTimestampNs:         0,
+			EndTimestampNs:           2009150555,
+		}},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("TestGetProcessSummaries: Diff -want +got:\n%s", diff)
+	}
+}
+
 func TestGetFtraceEvents(t *testing.T) {
 	requestJSON := encodeJSON(t, &models.FtraceEventsRequest{
 		CollectionName: collectionName,
@@ -814,3 +966,64 @@ func TestGetSystemTopology(t *testing.T) {
 	}
 }
 
+
+func TestExportCollection(t *testing.T) {
+	tests := []struct {
+		format          string
+		wantContentType string
+	}{
+		{"json", "application/json"},
+		{"perfetto", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		requestJSON := encodeJSON(t, &models.ExportCollectionRequest{
+			CollectionName:   collectionName,
+			Format:           test.format,
+			StartTimestampNs: -1,
+			EndTimestampNs:   -1,
+		})
+		res, err := http.Post(fullURL("export_collection"), "application/json", strings.NewReader(requestJSON))
+		if err != nil {
+			t.Fatalf("unexpected error fetching export_collection: %s", err)
+		}
+		if err := checkStatusCode(res, http.StatusOK); err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Header.Get("Content-Type"); got != test.wantContentType {
+			t.Errorf("TestExportCollection(%s): got Content-Type %q, want %q", test.format, got, test.wantContentType)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("error reading body: %s", err)
+		}
+		if err := res.Body.Close(); err != nil {
+			t.Fatalf("error closing response body: %s", err)
+		}
+		if len(body) == 0 {
+			t.Errorf("TestExportCollection(%s): got empty export", test.format)
+		}
+		if test.format == "json" {
+			var got struct {
+				TraceEvents []json.RawMessage `json:"traceEvents"`
+			}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("TestExportCollection(%s): failed to unmarshal export: %s", test.format, err)
+			}
+			if len(got.TraceEvents) == 0 {
+				t.Errorf("TestExportCollection(%s): got no trace events", test.format)
+			}
+		}
+	}
+
+	requestJSON := encodeJSON(t, &models.ExportCollectionRequest{
+		CollectionName: collectionName,
+		Format:         "xml",
+	})
+	res, err := http.Post(fullURL("export_collection"), "application/json", strings.NewReader(requestJSON))
+	if err != nil {
+		t.Fatalf("unexpected error fetching export_collection: %s", err)
+	}
+	if err := checkStatusCode(res, http.StatusOK); err == nil {
+		t.Errorf("TestExportCollection(xml): got status OK for an unknown format")
+	}
+}
@@ -40,6 +40,8 @@ func convertMetadataProtoToStruct(oldMetadata *eventpb.Metadata) models.Metadata
 		Owners:               append([]string{}, oldMetadata.Owners...),
 		Tags:                 append([]string{}, oldMetadata.Tags...),
 		Creator:              oldMetadata.Creator,
+		ParentCollection:     oldMetadata.ParentCollection,
+		WakeupEvent:          oldMetadata.WakeupEvent,
 	}
 }
 
@@ -60,6 +62,8 @@ func convertMetadataStructToProto(oldMetadata *models.Metadata) (*eventpb.Metada
 		Owners:               oldMetadata.Owners,
 		Tags:                 oldMetadata.Tags,
 		Creator:              oldMetadata.Creator,
+		ParentCollection:     oldMetadata.ParentCollection,
+		WakeupEvent:          oldMetadata.WakeupEvent,
 	}, nil
 }
 
@@ -115,3 +119,29 @@ func convertTopologyProtoToStruct(oldTopology *eventpb.SystemTopology) models.Sy
 	}
 }
 
+func convertHostsStructToProto(oldHosts []models.Host) []*eventpb.Host {
+	var hosts []*eventpb.Host
+	for _, host := range oldHosts {
+		hosts = append(hosts, &eventpb.Host{
+			Name:             host.Name,
+			ClockOffsetNs:    host.ClockOffsetNs,
+			SourceCollection: host.SourceCollection,
+			Topology:         convertTopologyStructToProto(host.SystemTopology),
+		})
+	}
+	return hosts
+}
+
+func convertHostsProtoToStruct(oldHosts []*eventpb.Host) []models.Host {
+	var hosts []models.Host
+	for _, host := range oldHosts {
+		topology := convertTopologyProtoToStruct(host.Topology)
+		hosts = append(hosts, models.Host{
+			Name:             host.Name,
+			ClockOffsetNs:    host.ClockOffsetNs,
+			SourceCollection: host.SourceCollection,
+			SystemTopology:   &topology,
+		})
+	}
+	return hosts
+}
@@ -37,6 +37,9 @@ import (
 type CachedCollection struct {
 	collection     *sched.Collection
 	systemTopology models.SystemTopology
+	// If the collection combines the traces of several hosts, the hosts, in the
+	// order used to namespace their CPUs and PIDs.
+	hosts []models.Host
 	// Payload stores arbitrary data by a string key.
 	payload map[string]interface{}
 	// ready blocks until the collection is ready to be read.
@@ -55,6 +58,24 @@ func (cc *CachedCollection) SystemTopology() *models.SystemTopology {
 	return &cc.systemTopology
 }
 
+// Hosts returns the hosts whose traces the cached collection combines, in the
+// order used to namespace their CPUs and PIDs, or nil if it holds a single
+// host's trace.
+func (cc *CachedCollection) Hosts() []models.Host {
+	return cc.hosts
+}
+
+// HostIndex returns the index, used to namespace its CPUs and PIDs, of the
+// named host in the cached collection.
+func (cc *CachedCollection) HostIndex(hostName string) (int, error) {
+	for idx, host := range cc.hosts {
+		if host.Name == hostName {
+			return idx, nil
+		}
+	}
+	return 0, status.Errorf(codes.NotFound, "host %q not found in collection", hostName)
+}
+
 // GetPayload returns the specified payload from the cached collection,
 // and a boolean indicating whether it was present.
 func (cc *CachedCollection) GetPayload(name string) (interface{}, bool) {
@@ -193,6 +214,15 @@ func (sb *storageBase) getCollectionFromCache(collectionName string, addCollecti
 // StorageService is an interface containing the APIs that storage services expose
 type StorageService interface {
 	UploadFile(ctx context.Context, req *models.CreateCollectionRequest, fileHeader io.Reader) (string, error)
+	// MergeCollections creates a new collection from the events of several
+	// existing collections, and returns its unique name.
+	MergeCollections(ctx context.Context, req *models.MergeCollectionsRequest) (string, error)
+	// SliceCollection creates a new collection from part of an existing
+	// collection, and returns its unique name.
+	SliceCollection(ctx context.Context, req *models.SliceCollectionRequest) (string, error)
+	// CreateMultiHostCollection creates a new collection from the traces of
+	// several hosts in existing collections, and returns its unique name.
+	CreateMultiHostCollection(ctx context.Context, req *models.MultiHostCollectionRequest) (string, error)
 	DeleteCollection(ctx context.Context, editor string, collectionUniqueName string) error
 	// GetCollection returns the specified collection, or any error encountered
 	// procuring it.  If the collection exists in the cache, the cached version
@@ -215,4 +245,6 @@ type StorageService interface {
 	GetFtraceEvents(ctx context.Context, req *models.FtraceEventsRequest) (models.FtraceEventsResponse, error)
 	// Helper
 	SetFailOnUnknownEventFormat(option bool)
+	SetColumnarStorage(option bool)
+	SetValidateUploads(option bool)
 }
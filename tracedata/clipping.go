@@ -19,10 +19,21 @@ package clipping
 
 import (
 	"errors"
+	"sort"
 
 	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
 )
 
+const (
+	// LostEventsEventName is the name of the synthetic events that record that the kernel dropped
+	// events from a CPU's ring buffer. A lost_events event is emitted on the affected CPU, at the
+	// timestamp of the first event recorded after the drop.
+	LostEventsEventName = "lost_events"
+	// LostEventsCountProperty is the name of the number property of lost_events events that holds
+	// the number of events that were dropped, or -1 if the kernel didn't record how many.
+	LostEventsCountProperty = "count"
+)
+
 func copyMap(overflowed map[int64]struct{}) map[int64]struct{} {
 	attestedCPUs := make(map[int64]struct{}, len(overflowed))
 	for key, value := range overflowed {
@@ -31,14 +42,68 @@ func copyMap(overflowed map[int64]struct{}) map[int64]struct{} {
 	return attestedCPUs
 }
 
+// lostEventsDescriptor returns the index of the lost_events event descriptor in es, or -1 if es
+// has none.
+func lostEventsDescriptor(es *eventpb.EventSet) int64 {
+	for idx, ed := range es.EventDescriptor {
+		name := ed.GetName()
+		if name >= 0 && name < int64(len(es.StringTable)) && es.StringTable[name] == LostEventsEventName {
+			return int64(idx)
+		}
+	}
+	return -1
+}
+
+// clipLostEventWindows marks the events that fall within a window in which the kernel reported
+// that events were lost as Clipped.
+// The window of a lost_events event on CPU c spans from the last event on c preceding it, or the
+// start of the trace if there is none, to the lost_events event. Since the lost events might have
+// affected any CPU's state, events on all CPUs within the window are clipped. lost_events events
+// themselves are never clipped. Events are expected to be sorted by timestamp.
+func clipLostEventWindows(es *eventpb.EventSet, lostEventsED int64) {
+	if lostEventsED < 0 {
+		return
+	}
+	for _, lostEv := range es.Event {
+		if lostEv.GetEventDescriptor() != lostEventsED {
+			continue
+		}
+		// Find the first event at or after the lost_events event, then search backwards for the last
+		// event on the same CPU before it.
+		end := sort.Search(len(es.Event), func(i int) bool {
+			return es.Event[i].GetTimestampNs() >= lostEv.GetTimestampNs()
+		})
+		start := 0
+		for idx := end - 1; idx >= 0; idx-- {
+			ev := es.Event[idx]
+			if ev.GetCpu() == lostEv.GetCpu() && ev.GetEventDescriptor() != lostEventsED {
+				// Events sharing the preceding event's timestamp were recorded before the loss.
+				start = sort.Search(len(es.Event), func(i int) bool {
+					return es.Event[i].GetTimestampNs() > ev.GetTimestampNs()
+				})
+				break
+			}
+		}
+		for idx := start; idx < end; idx++ {
+			if ev := es.Event[idx]; ev.GetEventDescriptor() != lostEventsED {
+				ev.Clipped = true
+			}
+		}
+	}
+}
+
 // ClipFromStartOfTrace marks clipped events. Given an input EventSet and a set of overflowedCPUs,
 // it determines the latest first timestamp among events generated by the overflowedCPUs, and marks
 // all events prior to that time on these CPUs as Clipped. Events are expected to be sorted by timestamp.
+// If the EventSet contains lost_events events, the windows in which events were lost are clipped
+// as well.
 func ClipFromStartOfTrace(es *eventpb.EventSet, overflowedCPUs map[int64]struct{}) error {
-	if len(overflowedCPUs) == 0 {
+	lostEventsED := lostEventsDescriptor(es)
+	clipLostEventWindows(es, lostEventsED)
+	attested := copyMap(overflowedCPUs)
+	if len(attested) == 0 {
 		return nil
 	}
-	attested := copyMap(overflowedCPUs)
 	for _, ev := range es.Event {
 		if _, ok := attested[ev.GetCpu()]; ok {
 			// First time we've seen an event from this CPU.  Remove it from soughtCPUs.
@@ -47,7 +112,9 @@ func ClipFromStartOfTrace(es *eventpb.EventSet, overflowedCPUs map[int64]struct{
 		if len(attested) == 0 {
 			return nil
 		}
-		ev.Clipped = true
+		if ev.GetEventDescriptor() != lostEventsED {
+			ev.Clipped = true
+		}
 	}
 	return errors.New("Not all overflowed CPUs emitted events")
 }
@@ -55,11 +122,15 @@ func ClipFromStartOfTrace(es *eventpb.EventSet, overflowedCPUs map[int64]struct{
 // ClipFromEndOfTrace marks clipped events. Given an input EventSet and a set of overflowedCPUs,
 // it determines the earliest last timestamp among events generated by the overflowedCPUs, and marks
 // all events prior to that time on these CPUs as Clipped. Events are expected to be sorted by timestamp.
+// If the EventSet contains lost_events events, the windows in which events were lost are clipped
+// as well.
 func ClipFromEndOfTrace(es *eventpb.EventSet, overflowedCPUs map[int64]struct{}) error {
-	if len(overflowedCPUs) == 0 {
+	lostEventsED := lostEventsDescriptor(es)
+	clipLostEventWindows(es, lostEventsED)
+	attested := copyMap(overflowedCPUs)
+	if len(attested) == 0 {
 		return nil
 	}
-	attested := copyMap(overflowedCPUs)
 	for idx := len(es.Event) - 1; idx >= 0; idx-- {
 		ev := es.Event[idx]
 		if _, ok := attested[ev.GetCpu()]; ok {
@@ -69,7 +140,9 @@ func ClipFromEndOfTrace(es *eventpb.EventSet, overflowedCPUs map[int64]struct{})
 		if len(attested) == 0 {
 			return nil
 		}
-		ev.Clipped = true
+		if ev.GetEventDescriptor() != lostEventsED {
+			ev.Clipped = true
+		}
 	}
 	return errors.New("Not all overflowed CPUs emitted events")
 }
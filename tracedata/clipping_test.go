@@ -137,3 +137,92 @@ func TestNoEventsFromClippedCPU(t *testing.T) {
 		t.Error("Expected error when overflowed CPU has no events.")
 	}
 }
+
+// Creates sample events containing lost_events events to test clipping.
+// CPU 4 lost events before any of its events were recorded, so everything before its lost_events
+// event should be clipped. CPU 2 lost events between its events at 2000 and 3000, so the events
+// strictly between those times should be clipped, on every CPU. CPUs that lost events are still
+// clipped from the start or end of the trace if they overflowed.
+func sampleLostEventsClippingData() *eventpb.EventSet {
+	lost := func(cpu int64, timestamp int64) *eventpb.Event {
+		ev := createEvent(cpu, timestamp)
+		ev.EventDescriptor = 1
+		ev.Property = []int64{10}
+		return ev
+	}
+	events := []*eventpb.Event{
+		createEvent(3, 1000),
+		lost(4, 1200),
+		createEvent(1, 1500),
+		createEvent(2, 2000),
+		createEvent(1, 2500),
+		createEvent(3, 2800),
+		lost(2, 3000),
+		createEvent(2, 3000),
+		createEvent(1, 3500),
+	}
+	return &eventpb.EventSet{
+		StringTable: []string{"", "sched_switch", LostEventsEventName, LostEventsCountProperty},
+		EventDescriptor: []*eventpb.EventDescriptor{
+			{Name: 1},
+			{
+				Name: 2,
+				PropertyDescriptor: []*eventpb.EventDescriptor_PropertyDescriptor{
+					{Name: 3, Type: eventpb.EventDescriptor_PropertyDescriptor_NUMBER},
+				},
+			},
+		},
+		Event: events,
+	}
+}
+
+func TestLostEventsClipping(t *testing.T) {
+	tests := []struct {
+		description    string
+		clip           func(*eventpb.EventSet, map[int64]struct{}) error
+		overflowedCPUs map[int64]struct{}
+		wantClipped    map[int]bool
+	}{
+		{
+			description: "start, no overflowed CPUs",
+			clip:        ClipFromStartOfTrace,
+			wantClipped: map[int]bool{0: true, 4: true, 5: true},
+		},
+		{
+			description: "end, no overflowed CPUs",
+			clip:        ClipFromEndOfTrace,
+			wantClipped: map[int]bool{0: true, 4: true, 5: true},
+		},
+		{
+			description:    "start, overflowed CPUs with and without lost events",
+			clip:           ClipFromStartOfTrace,
+			overflowedCPUs: map[int64]struct{}{1: {}, 2: {}},
+			wantClipped:    map[int]bool{0: true, 2: true, 4: true, 5: true},
+		},
+		{
+			description:    "end, overflowed CPU with lost events",
+			clip:           ClipFromEndOfTrace,
+			overflowedCPUs: map[int64]struct{}{2: {}},
+			wantClipped:    map[int]bool{0: true, 4: true, 5: true, 8: true},
+		},
+		{
+			description:    "end, overflowed CPU without lost events",
+			clip:           ClipFromEndOfTrace,
+			overflowedCPUs: map[int64]struct{}{3: {}},
+			wantClipped:    map[int]bool{0: true, 4: true, 5: true, 7: true, 8: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			es := sampleLostEventsClippingData()
+			if err := test.clip(es, test.overflowedCPUs); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for ix, ev := range es.Event {
+				if ev.Clipped != test.wantClipped[ix] {
+					t.Errorf("event %d (CPU %d at %d): got clipped %t, want %t", ix, ev.Cpu, ev.TimestampNs, ev.Clipped, test.wantClipped[ix])
+				}
+			}
+		})
+	}
+}
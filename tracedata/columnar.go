@@ -0,0 +1,556 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Package columnar reads and writes collections in a columnar, chunked and
+// compressed file format, which can be opened without reading all of its
+// events.
+//
+// A columnar file starts with an 8-byte magic string, which is followed by its
+// chunks, then its header, then an 8-byte little-endian offset of the header
+// and the magic string again.  Events are divided into time ranges of
+// consecutive events, and each time range's events are split by CPU.  Each
+// chunk is a gzip-compressed ColumnarChunk holding the events of one CPU within
+// one time range, with each field, and each property of each event
+// descriptor, in its own column.  The gzip-compressed ColumnarHeader holds the
+// collection's metadata, its system topology, the string table and event
+// descriptors shared by all chunks, and an index of the chunks recording the
+// time range and CPU of their events.
+package columnar
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// magic starts and ends every columnar file.
+const magic = "SVCOL02\n"
+
+// trailerSize is the size of the header offset and magic string ending every
+// columnar file.
+const trailerSize = 8 + len(magic)
+
+// DefaultEventsPerTimeRange is the number of events in each time range, unless
+// otherwise requested.
+const DefaultEventsPerTimeRange = 1 << 14
+
+// timeRangeCacheSize is the number of decoded time ranges each Reader keeps in
+// memory.
+const timeRangeCacheSize = 4
+
+type options struct {
+	eventsPerTimeRange int
+}
+
+// Option specifies an option for writing a columnar file.
+type Option func(o *options) error
+
+// EventsPerTimeRange specifies the number of events in each time range, whose
+// events are split by CPU into chunks.  Smaller time ranges allow narrower
+// reads, but compress less well.
+func EventsPerTimeRange(n int) Option {
+	return func(o *options) error {
+		if n <= 0 {
+			return status.Errorf(codes.InvalidArgument, "events per time range must be positive, got %d", n)
+		}
+		o.eventsPerTimeRange = n
+		return nil
+	}
+}
+
+// IsColumnar returns true if the provided file prefix begins a columnar file.
+func IsColumnar(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(magic))
+}
+
+func compress(msg proto.Message) ([]byte, error) {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(b []byte, msg proto.Message) error {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(raw, msg)
+}
+
+// Write writes the provided collection to w in the columnar format.  The
+// collection is not modified.  Its events are stored in increasing timestamp
+// order and, if its trace clock allows, converted to ns, as they would be when
+// loaded.
+func Write(w io.Writer, coll *eventpb.Collection, opts ...Option) error {
+	o := &options{
+		eventsPerTimeRange: DefaultEventsPerTimeRange,
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return err
+		}
+	}
+	es := proto.Clone(coll.GetEventSet()).(*eventpb.EventSet)
+	sort.SliceStable(es.Event, func(a, b int) bool {
+		return es.Event[a].TimestampNs < es.Event[b].TimestampNs
+	})
+	// Timestamps that cannot be converted are stored as recorded; loading them
+	// fails as it would have anyway.
+	if err := trace.ConvertTimestampsToNs(es); err != nil {
+		es = proto.Clone(coll.GetEventSet()).(*eventpb.EventSet)
+		sort.SliceStable(es.Event, func(a, b int) bool {
+			return es.Event[a].TimestampNs < es.Event[b].TimestampNs
+		})
+	}
+	events := es.Event
+	es.Event = nil
+	header := &eventpb.ColumnarHeader{
+		Metadata: coll.GetMetadata(),
+		Topology: coll.GetTopology(),
+		Hosts:    coll.GetHosts(),
+		EventSet: es,
+	}
+
+	offset := int64(len(magic))
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	for start := 0; start < len(events); start += o.eventsPerTimeRange {
+		end := start + o.eventsPerTimeRange
+		if end > len(events) {
+			end = len(events)
+		}
+		timeRange := int64(start / o.eventsPerTimeRange)
+		rangeEvents := events[start:end]
+		// The positions, within the time range, of each CPU's events.
+		positionsByCPU := map[int64][]int{}
+		var cpus []int64
+		for pos, ev := range rangeEvents {
+			if _, ok := positionsByCPU[ev.Cpu]; !ok {
+				cpus = append(cpus, ev.Cpu)
+			}
+			positionsByCPU[ev.Cpu] = append(positionsByCPU[ev.Cpu], pos)
+		}
+		sort.Slice(cpus, func(a, b int) bool {
+			return cpus[a] < cpus[b]
+		})
+		for _, cpu := range cpus {
+			chunk, idx, err := encodeChunk(rangeEvents, positionsByCPU[cpu], es)
+			if err != nil {
+				return err
+			}
+			b, err := compress(chunk)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			idx.Offset = offset
+			idx.Length = int64(len(b))
+			idx.Cpu = cpu
+			idx.TimeRange = timeRange
+			offset += idx.Length
+			header.Chunk = append(header.Chunk, idx)
+		}
+	}
+	b, err := compress(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	trailer := make([]byte, 8, trailerSize)
+	binary.LittleEndian.PutUint64(trailer, uint64(offset))
+	trailer = append(trailer, magic...)
+	_, err = w.Write(trailer)
+	return err
+}
+
+// propertyColumnKey identifies the property column holding a property of the
+// events with an event descriptor.
+type propertyColumnKey struct {
+	eventDescriptor int64
+	property        int
+}
+
+// encodeChunk returns a chunk holding the events at the provided positions in
+// rangeEvents, which is a time range's events in increasing timestamp order,
+// and its index, lacking its offset, length, CPU and time range.
+func encodeChunk(rangeEvents []*eventpb.Event, positions []int, es *eventpb.EventSet) (*eventpb.ColumnarChunk, *eventpb.ColumnarChunkIndex, error) {
+	chunk := &eventpb.ColumnarChunk{}
+	idx := &eventpb.ColumnarChunkIndex{
+		EventCount:       int64(len(positions)),
+		FirstTimestampNs: rangeEvents[positions[0]].TimestampNs,
+		LastTimestampNs:  rangeEvents[positions[len(positions)-1]].TimestampNs,
+	}
+	columns := map[propertyColumnKey]*eventpb.ColumnarChunk_PropertyColumn{}
+	lastTimestamp := idx.FirstTimestampNs
+	lastPosition := 0
+	for i, pos := range positions {
+		ev := rangeEvents[pos]
+		if ev.EventDescriptor < 0 || ev.EventDescriptor >= int64(len(es.EventDescriptor)) {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "event at %d has invalid event descriptor %d", ev.TimestampNs, ev.EventDescriptor)
+		}
+		if want := len(es.EventDescriptor[ev.EventDescriptor].PropertyDescriptor); len(ev.Property) != want {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "event at %d has %d properties, but its descriptor has %d", ev.TimestampNs, len(ev.Property), want)
+		}
+		chunk.TimestampDelta = append(chunk.TimestampDelta, ev.TimestampNs-lastTimestamp)
+		lastTimestamp = ev.TimestampNs
+		chunk.PositionDelta = append(chunk.PositionDelta, int64(pos-lastPosition))
+		lastPosition = pos
+		chunk.EventDescriptor = append(chunk.EventDescriptor, ev.EventDescriptor)
+		if ev.Clipped {
+			chunk.ClippedEvent = append(chunk.ClippedEvent, int64(i))
+		}
+		for propIdx, prop := range ev.Property {
+			key := propertyColumnKey{ev.EventDescriptor, propIdx}
+			col, ok := columns[key]
+			if !ok {
+				col = &eventpb.ColumnarChunk_PropertyColumn{
+					EventDescriptor: ev.EventDescriptor,
+					Property:        int64(propIdx),
+				}
+				columns[key] = col
+				chunk.PropertyColumn = append(chunk.PropertyColumn, col)
+			}
+			col.Value = append(col.Value, prop)
+		}
+	}
+	return chunk, idx, nil
+}
+
+// Reader reads a collection stored in the columnar format.  Only its header is
+// read when it is opened; chunks are read and decoded as their events are
+// requested, and the few most recently used time ranges are kept.  Reader
+// implements trace.EventSource, and is safe for concurrent use.
+type Reader struct {
+	r      io.ReaderAt
+	header *eventpb.ColumnarHeader
+	// The index of the first event in each time range.
+	rangeStarts []int
+	// The indices of the chunks of each time range.
+	rangeChunks [][]int
+	eventCount  int
+
+	mu sync.Mutex
+	// Decoded time ranges, keyed by time range index, and their indices in
+	// increasing order of last use.
+	ranges     map[int][]*eventpb.Event
+	rangeOrder []int
+}
+
+// NewReader returns a Reader for the columnar file of the provided size read
+// from r.  Its header is read immediately.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < int64(len(magic)+trailerSize) {
+		return nil, status.Errorf(codes.InvalidArgument, "file is too small (%d bytes) to be in the columnar format", size)
+	}
+	prefix := make([]byte, len(magic))
+	if _, err := r.ReadAt(prefix, 0); err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := r.ReadAt(trailer, size-int64(trailerSize)); err != nil {
+		return nil, err
+	}
+	if !IsColumnar(prefix) || string(trailer[8:]) != magic {
+		return nil, status.Errorf(codes.InvalidArgument, "file is not in the columnar format")
+	}
+	headerOffset := int64(binary.LittleEndian.Uint64(trailer))
+	headerEnd := size - int64(trailerSize)
+	if headerOffset < int64(len(magic)) || headerOffset > headerEnd {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid header offset %d in columnar file of %d bytes", headerOffset, size)
+	}
+	b := make([]byte, headerEnd-headerOffset)
+	if _, err := r.ReadAt(b, headerOffset); err != nil {
+		return nil, err
+	}
+	header := &eventpb.ColumnarHeader{}
+	if err := decompress(b, header); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to read columnar header: %s", err)
+	}
+	if header.EventSet == nil {
+		header.EventSet = &eventpb.EventSet{}
+	}
+	ret := &Reader{
+		r:      r,
+		header: header,
+		ranges: map[int][]*eventpb.Event{},
+	}
+	for chunkIdx, idx := range header.Chunk {
+		if idx.Offset < int64(len(magic)) || idx.Length < 0 || idx.Offset+idx.Length > headerOffset {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid chunk location %d+%d in columnar file", idx.Offset, idx.Length)
+		}
+		if idx.EventCount <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "chunk %d has invalid event count %d", chunkIdx, idx.EventCount)
+		}
+		switch idx.TimeRange {
+		case int64(len(ret.rangeChunks)):
+			ret.rangeStarts = append(ret.rangeStarts, ret.eventCount)
+			ret.rangeChunks = append(ret.rangeChunks, nil)
+		case int64(len(ret.rangeChunks) - 1):
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "chunk %d has out-of-order time range %d", chunkIdx, idx.TimeRange)
+		}
+		ret.rangeChunks[idx.TimeRange] = append(ret.rangeChunks[idx.TimeRange], chunkIdx)
+		ret.eventCount += int(idx.EventCount)
+	}
+	return ret, nil
+}
+
+// Collection returns the stored collection without its events.  Its EventSet
+// holds the string table, event descriptors, default loaders type and trace
+// clock of the stored events.
+func (r *Reader) Collection() *eventpb.Collection {
+	return &eventpb.Collection{
+		Metadata: r.header.Metadata,
+		EventSet: r.header.EventSet,
+		Topology: r.header.Topology,
+		Hosts:    r.header.Hosts,
+	}
+}
+
+// Header returns the string table, event descriptors, default loaders type and
+// trace clock of the stored events.
+func (r *Reader) Header() *eventpb.EventSet {
+	return r.header.EventSet
+}
+
+// EventCount returns the number of stored events.
+func (r *Reader) EventCount() int {
+	return r.eventCount
+}
+
+// Event returns the stored event at the provided index.  Events are in
+// increasing timestamp order.  The returned Event must not be modified.
+func (r *Reader) Event(index int) (*eventpb.Event, error) {
+	if index < 0 || index >= r.eventCount {
+		return nil, status.Errorf(codes.NotFound, "event %d not found", index)
+	}
+	rangeIdx := sort.Search(len(r.rangeStarts), func(i int) bool {
+		return r.rangeStarts[i] > index
+	}) - 1
+	events, err := r.timeRange(rangeIdx)
+	if err != nil {
+		return nil, err
+	}
+	return events[index-r.rangeStarts[rangeIdx]], nil
+}
+
+// EventSet returns the stored events in a new EventSet, reading all chunks.
+func (r *Reader) EventSet() (*eventpb.EventSet, error) {
+	return r.EventsInRange(trace.UnknownTimestamp, trace.UnknownTimestamp)
+}
+
+// EventsInRange returns, in a new EventSet, the stored events recorded between
+// the provided timestamps, inclusive, on any of the provided CPUs.  An unknown
+// start or end timestamp leaves that end of the range open, and if no CPUs are
+// provided, events on all CPUs are returned.  Only the chunks that may hold
+// such events are read.
+func (r *Reader) EventsInRange(startTimestamp, endTimestamp trace.Timestamp, cpus ...int64) (*eventpb.EventSet, error) {
+	es := proto.Clone(r.header.EventSet).(*eventpb.EventSet)
+	cpuSet := map[int64]struct{}{}
+	for _, cpu := range cpus {
+		cpuSet[cpu] = struct{}{}
+	}
+	inRange := func(ts int64) bool {
+		return (startTimestamp == trace.UnknownTimestamp || ts >= int64(startTimestamp)) &&
+			(endTimestamp == trace.UnknownTimestamp || ts <= int64(endTimestamp))
+	}
+	for rangeIdx, chunkIdxs := range r.rangeChunks {
+		// Only read the chunks that may hold requested events.
+		var selected []int
+		for _, chunkIdx := range chunkIdxs {
+			idx := r.header.Chunk[chunkIdx]
+			if startTimestamp != trace.UnknownTimestamp && idx.LastTimestampNs < int64(startTimestamp) {
+				continue
+			}
+			if endTimestamp != trace.UnknownTimestamp && idx.FirstTimestampNs > int64(endTimestamp) {
+				continue
+			}
+			if _, ok := cpuSet[idx.Cpu]; !ok && len(cpuSet) > 0 {
+				continue
+			}
+			selected = append(selected, chunkIdx)
+		}
+		if len(selected) == 0 {
+			continue
+		}
+		var events []*eventpb.Event
+		var err error
+		if len(selected) == len(chunkIdxs) {
+			events, err = r.timeRange(rangeIdx)
+		} else {
+			events, err = r.decodeChunks(rangeIdx, selected)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range events {
+			// Events of unselected chunks are nil.
+			if ev == nil {
+				continue
+			}
+			if _, ok := cpuSet[ev.Cpu]; (ok || len(cpuSet) == 0) && inRange(ev.TimestampNs) {
+				es.Event = append(es.Event, proto.Clone(ev).(*eventpb.Event))
+			}
+		}
+	}
+	return es, nil
+}
+
+// timeRange returns the decoded events of the time range at the provided
+// index, in order, reading its chunks if it isn't cached.
+func (r *Reader) timeRange(rangeIdx int) ([]*eventpb.Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if events, ok := r.ranges[rangeIdx]; ok {
+		for i, idx := range r.rangeOrder {
+			if idx == rangeIdx {
+				r.rangeOrder = append(append(r.rangeOrder[:i:i], r.rangeOrder[i+1:]...), rangeIdx)
+				break
+			}
+		}
+		return events, nil
+	}
+	events, err := r.decodeChunks(rangeIdx, r.rangeChunks[rangeIdx])
+	if err != nil {
+		return nil, err
+	}
+	if len(r.rangeOrder) == timeRangeCacheSize {
+		delete(r.ranges, r.rangeOrder[0])
+		r.rangeOrder = r.rangeOrder[1:]
+	}
+	r.ranges[rangeIdx] = events
+	r.rangeOrder = append(r.rangeOrder, rangeIdx)
+	return events, nil
+}
+
+// decodeChunks reads and decodes the provided chunks of the time range at the
+// provided index, and returns the time range's events in order.  The events of
+// chunks that weren't provided are nil.
+func (r *Reader) decodeChunks(rangeIdx int, chunkIdxs []int) ([]*eventpb.Event, error) {
+	count := r.eventCount - r.rangeStarts[rangeIdx]
+	if rangeIdx+1 < len(r.rangeStarts) {
+		count = r.rangeStarts[rangeIdx+1] - r.rangeStarts[rangeIdx]
+	}
+	events := make([]*eventpb.Event, count)
+	for _, chunkIdx := range chunkIdxs {
+		chunkEvents, positions, err := r.readChunk(chunkIdx)
+		if err != nil {
+			return nil, err
+		}
+		for i, pos := range positions {
+			if pos >= count || events[pos] != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "chunk %d has invalid event position %d", chunkIdx, pos)
+			}
+			events[pos] = chunkEvents[i]
+		}
+	}
+	return events, nil
+}
+
+// readChunk reads and decodes the chunk at the provided index, returning its
+// events and their positions within their time range.
+func (r *Reader) readChunk(chunkIdx int) ([]*eventpb.Event, []int, error) {
+	idx := r.header.Chunk[chunkIdx]
+	b := make([]byte, idx.Length)
+	if _, err := r.r.ReadAt(b, idx.Offset); err != nil {
+		return nil, nil, err
+	}
+	chunk := &eventpb.ColumnarChunk{}
+	if err := decompress(b, chunk); err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "failed to read chunk %d: %s", chunkIdx, err)
+	}
+	count := int(idx.EventCount)
+	if len(chunk.TimestampDelta) != count || len(chunk.PositionDelta) != count || len(chunk.EventDescriptor) != count {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "chunk %d has mismatched columns for %d events", chunkIdx, count)
+	}
+	eds := r.header.EventSet.EventDescriptor
+	columns := map[propertyColumnKey]*eventpb.ColumnarChunk_PropertyColumn{}
+	for _, col := range chunk.PropertyColumn {
+		columns[propertyColumnKey{col.EventDescriptor, int(col.Property)}] = col
+	}
+	// The position of the next value in each property column.
+	columnPositions := map[*eventpb.ColumnarChunk_PropertyColumn]int{}
+	events := make([]*eventpb.Event, count)
+	positions := make([]int, count)
+	timestamp := idx.FirstTimestampNs
+	position := 0
+	for i := range events {
+		timestamp += chunk.TimestampDelta[i]
+		position += int(chunk.PositionDelta[i])
+		if position < 0 || (i > 0 && chunk.PositionDelta[i] <= 0) {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "chunk %d has invalid event positions", chunkIdx)
+		}
+		positions[i] = position
+		ev := &eventpb.Event{
+			EventDescriptor: chunk.EventDescriptor[i],
+			Cpu:             idx.Cpu,
+			TimestampNs:     timestamp,
+		}
+		if ev.EventDescriptor < 0 || ev.EventDescriptor >= int64(len(eds)) {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "chunk %d has invalid event descriptor %d", chunkIdx, ev.EventDescriptor)
+		}
+		propCount := len(eds[ev.EventDescriptor].PropertyDescriptor)
+		if propCount > 0 {
+			ev.Property = make([]int64, propCount)
+		}
+		for propIdx := 0; propIdx < propCount; propIdx++ {
+			col, ok := columns[propertyColumnKey{ev.EventDescriptor, propIdx}]
+			if !ok || columnPositions[col] >= len(col.Value) {
+				return nil, nil, status.Errorf(codes.InvalidArgument, "chunk %d is missing properties", chunkIdx)
+			}
+			ev.Property[propIdx] = col.Value[columnPositions[col]]
+			columnPositions[col]++
+		}
+		events[i] = ev
+	}
+	for _, clipped := range chunk.ClippedEvent {
+		if clipped < 0 || clipped >= int64(count) {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "chunk %d has invalid clipped event %d", chunkIdx, clipped)
+		}
+		events[clipped].Clipped = true
+	}
+	return events, positions, nil
+}
@@ -0,0 +1,252 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package columnar
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/eventsetbuilder"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+func testCollection(t *testing.T) *eventpb.Collection {
+	t.Helper()
+	es, errs := eventsetbuilder.NewBuilder().
+		WithEventDescriptor("sched_switch",
+			eventsetbuilder.Number("prev_pid"),
+			eventsetbuilder.Text("prev_comm"),
+			eventsetbuilder.Number("next_pid"),
+			eventsetbuilder.Text("next_comm")).
+		WithEventDescriptor("print", eventsetbuilder.Text("buf")).
+		WithEventDescriptor("no_properties").
+		WithEvent("sched_switch", 0, 1000, false, 0, "idle", 100, "thread 1").
+		WithEvent("print", 1, 1010, false, "hello").
+		WithEvent("sched_switch", 1, 1020, true, 0, "idle", -200, "thread 2").
+		WithEvent("no_properties", 2, 1030, false).
+		WithEvent("sched_switch", 0, 1040, false, 100, "thread 1", 0, "idle").
+		WithEvent("print", 0, 1050, false, "goodbye").
+		EventSet()
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors building EventSet: %v", errs)
+	}
+	return &eventpb.Collection{
+		Metadata: &eventpb.Metadata{CollectionUniqueName: "test"},
+		EventSet: es,
+		Topology: &eventpb.SystemTopology{
+			LogicalCore: []*eventpb.SystemTopology_LogicalCore{{CpuId: 0}, {CpuId: 1}, {CpuId: 2}},
+		},
+	}
+}
+
+func writeAndRead(t *testing.T, coll *eventpb.Collection, opts ...Option) *Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, coll, opts...); err != nil {
+		t.Fatalf("Write() returned unexpected error: %s", err)
+	}
+	if !IsColumnar(buf.Bytes()) {
+		t.Fatalf("IsColumnar() = false for a written columnar file")
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("NewReader() returned unexpected error: %s", err)
+	}
+	return r
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, eventsPerTimeRange := range []int{1, 2, 4, DefaultEventsPerTimeRange} {
+		t.Run(fmt.Sprintf("%d events per time range", eventsPerTimeRange), func(t *testing.T) {
+			coll := testCollection(t)
+			r := writeAndRead(t, coll, EventsPerTimeRange(eventsPerTimeRange))
+			got, err := r.EventSet()
+			if err != nil {
+				t.Fatalf("EventSet() returned unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(coll.EventSet, got, cmp.Comparer(proto.Equal)); diff != "" {
+				t.Errorf("EventSet(): Diff -want +got:\n%s", diff)
+			}
+			header := r.Collection()
+			if !proto.Equal(header.Metadata, coll.Metadata) || !proto.Equal(header.Topology, coll.Topology) {
+				t.Errorf("Collection() = %v, want the written metadata and topology", header)
+			}
+			if r.EventCount() != len(coll.EventSet.Event) {
+				t.Errorf("EventCount() = %d, want %d", r.EventCount(), len(coll.EventSet.Event))
+			}
+			// Read events out of order, to cycle through the time range cache.
+			for _, idx := range []int{5, 0, 3, 1, 4, 2, 5} {
+				ev, err := r.Event(idx)
+				if err != nil {
+					t.Fatalf("Event(%d) returned unexpected error: %s", idx, err)
+				}
+				if !proto.Equal(ev, coll.EventSet.Event[idx]) {
+					t.Errorf("Event(%d) = %v, want %v", idx, ev, coll.EventSet.Event[idx])
+				}
+			}
+			if _, err := r.Event(6); status.Code(err) != codes.NotFound {
+				t.Errorf("Event(6) = %v, want NotFound", err)
+			}
+		})
+	}
+}
+
+func TestEventsInRange(t *testing.T) {
+	coll := testCollection(t)
+	r := writeAndRead(t, coll, EventsPerTimeRange(2))
+	tests := []struct {
+		description string
+		start, end  trace.Timestamp
+		cpus        []int64
+		want        []int64
+	}{{
+		description: "all events",
+		start:       trace.UnknownTimestamp,
+		end:         trace.UnknownTimestamp,
+		want:        []int64{1000, 1010, 1020, 1030, 1040, 1050},
+	}, {
+		description: "time range",
+		start:       1015,
+		end:         1040,
+		want:        []int64{1020, 1030, 1040},
+	}, {
+		description: "open start",
+		start:       trace.UnknownTimestamp,
+		end:         1010,
+		want:        []int64{1000, 1010},
+	}, {
+		description: "CPUs",
+		start:       trace.UnknownTimestamp,
+		end:         trace.UnknownTimestamp,
+		cpus:        []int64{1, 2},
+		want:        []int64{1010, 1020, 1030},
+	}, {
+		description: "time range and CPU",
+		start:       1000,
+		end:         1045,
+		cpus:        []int64{0},
+		want:        []int64{1000, 1040},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			es, err := r.EventsInRange(test.start, test.end, test.cpus...)
+			if err != nil {
+				t.Fatalf("EventsInRange() returned unexpected error: %s", err)
+			}
+			var got []int64
+			for _, ev := range es.Event {
+				got = append(got, ev.TimestampNs)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("EventsInRange(): Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWrite_ChunksByCPU(t *testing.T) {
+	coll := testCollection(t)
+	r := writeAndRead(t, coll, EventsPerTimeRange(4))
+	type chunkSummary struct {
+		TimeRange, CPU, EventCount int64
+	}
+	var got []chunkSummary
+	for _, idx := range r.header.Chunk {
+		got = append(got, chunkSummary{idx.TimeRange, idx.Cpu, idx.EventCount})
+	}
+	want := []chunkSummary{{0, 0, 1}, {0, 1, 2}, {0, 2, 1}, {1, 0, 2}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("chunks: Diff -want +got:\n%s", diff)
+	}
+
+	// CPU 1's chunk holds a print and a sched_switch event, whose first
+	// properties are stored in different columns.
+	idx := r.header.Chunk[1]
+	b := make([]byte, idx.Length)
+	if _, err := r.r.ReadAt(b, idx.Offset); err != nil {
+		t.Fatalf("failed to read chunk: %s", err)
+	}
+	chunk := &eventpb.ColumnarChunk{}
+	if err := decompress(b, chunk); err != nil {
+		t.Fatalf("failed to decompress chunk: %s", err)
+	}
+	type column struct {
+		EventDescriptor, Property int64
+		Values                    int
+	}
+	var gotColumns []column
+	for _, col := range chunk.PropertyColumn {
+		gotColumns = append(gotColumns, column{col.EventDescriptor, col.Property, len(col.Value)})
+	}
+	// Event descriptor 0 is sched_switch, and 1 is print.
+	wantColumns := []column{{1, 0, 1}, {0, 0, 1}, {0, 1, 1}, {0, 2, 1}, {0, 3, 1}}
+	if diff := cmp.Diff(wantColumns, gotColumns); diff != "" {
+		t.Errorf("property columns: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestWrite_ConvertsTimestamps(t *testing.T) {
+	coll := testCollection(t)
+	coll.EventSet.TraceClock = &eventpb.TraceClock{
+		Name:         "x86-tsc",
+		Units:        eventpb.TraceClock_CYCLES,
+		FrequencyKhz: 2000000,
+	}
+	r := writeAndRead(t, coll)
+	ev, err := r.Event(0)
+	if err != nil {
+		t.Fatalf("Event(0) returned unexpected error: %s", err)
+	}
+	if ev.TimestampNs != 500 {
+		t.Errorf("Event(0) timestamp = %d, want 500", ev.TimestampNs)
+	}
+	if got := r.Header().GetTraceClock().GetUnits(); got != eventpb.TraceClock_NANOSECONDS {
+		t.Errorf("Header() trace clock units = %s, want NANOSECONDS", got)
+	}
+	if coll.EventSet.Event[0].TimestampNs != 1000 {
+		t.Errorf("Write() modified the written collection")
+	}
+}
+
+func TestNewReader_Invalid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testCollection(t)); err != nil {
+		t.Fatalf("Write() returned unexpected error: %s", err)
+	}
+	valid := buf.Bytes()
+	tests := []struct {
+		description string
+		file        []byte
+	}{
+		{"empty", nil},
+		{"binary proto", []byte("\x0a\x04test and then some more bytes")},
+		{"truncated", valid[:len(valid)-1]},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(test.file), int64(len(test.file))); err == nil {
+				t.Errorf("NewReader() succeeded, want error")
+			}
+		})
+	}
+}
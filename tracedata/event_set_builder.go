@@ -25,6 +25,7 @@ import (
 
 	elpb "github.com/google/schedviz/analysis/event_loaders_go_proto"
 	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/threadgroups"
 	tp "github.com/google/schedviz/traceparser/traceparser"
 )
 
@@ -59,6 +60,8 @@ type Builder struct {
 	esb                *tp.EventSetBuilder
 	eventFormatsByName map[string]*tp.EventFormat
 	errs               []error
+	// The TGID of each PID placed in a thread group by WithThreadGroup.
+	tgidsByPID map[int64]int64
 }
 
 // NewBuilder constructs and returns a new, empty Builder.
@@ -68,6 +71,7 @@ func NewBuilder() *Builder {
 		esb:                esb,
 		eventFormatsByName: make(map[string]*tp.EventFormat),
 		errs:               []error{},
+		tgidsByPID:         map[int64]int64{},
 	}
 }
 
@@ -80,6 +84,10 @@ func (b *Builder) Clone() (*Builder, error) {
 	newB := Builder{
 		esb:                newEsb,
 		eventFormatsByName: make(map[string]*tp.EventFormat),
+		tgidsByPID:         make(map[int64]int64),
+	}
+	for k, v := range b.tgidsByPID {
+		newB.tgidsByPID[k] = v
 	}
 	for k, v := range b.eventFormatsByName {
 		newB.eventFormatsByName[k] = v
@@ -106,7 +114,8 @@ func (b Builder) TestClone(t *testing.T) *Builder {
 func (b *Builder) WithEventDescriptor(name string, propertyDescriptors ...PropertyDescriptor) *Builder {
 	eventFormat := &tp.EventFormat{
 		Name: name,
-		ID:   uint16(len(b.eventFormatsByName)),
+		// ID 0 is reserved for lost events.
+		ID: uint16(len(b.eventFormatsByName) + 1),
 		Format: tp.Format{
 			Fields: make([]*tp.FormatField, len(propertyDescriptors)),
 		},
@@ -183,11 +192,32 @@ func (b *Builder) WithDefaultEventLoadersType(elt elpb.LoadersType) *Builder {
 	return b
 }
 
+// WithOverflowedCPUs specifies the CPUs whose trace buffers overflowed, so
+// that their events are clipped when the EventSet is built.
+func (b *Builder) WithOverflowedCPUs(cpus map[int64]struct{}) *Builder {
+	b.esb.SetOverflowedCPUs(cpus)
+	return b
+}
+
+// WithThreadGroup places the provided PIDs, and the leader whose PID is the
+// provided TGID, in that TGID's thread group, returning the receiver to
+// facilitate chaining.  A PID already placed in another thread group is moved.
+func (b *Builder) WithThreadGroup(tgid int64, pids ...int64) *Builder {
+	b.tgidsByPID[tgid] = tgid
+	for _, pid := range pids {
+		b.tgidsByPID[pid] = tgid
+	}
+	return b
+}
+
 // EventSet returns the constructed EventSet protobuf, along with any errors
 // encountered in building.  If the errors slice is nonempty, the returned
 // EventSet is invalid.
 func (b *Builder) EventSet() (*eventpb.EventSet, []error) {
 	es, err := b.esb.Finalize()
+	if es != nil && len(b.tgidsByPID) > 0 {
+		es.ThreadGroup = threadgroups.FromTGIDs(b.tgidsByPID)
+	}
 	errors := b.errs
 	if err != nil {
 		errors = append(errors, err)
@@ -0,0 +1,332 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Package merge provides utilities for combining several eventpb.EventSets into one.
+package merge
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/trace"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/threadgroups"
+)
+
+// mergedProperty is a property of a merged event descriptor.
+type mergedProperty struct {
+	name string
+	typ  eventpb.EventDescriptor_PropertyDescriptor_FieldType
+}
+
+// mergedDescriptor is an event descriptor of the merged EventSet, combining the properties of
+// every input event descriptor with the same name.
+type mergedDescriptor struct {
+	idx         int64
+	name        string
+	props       []mergedProperty
+	propsByName map[string]int
+}
+
+// inputDescriptor maps an event descriptor of an input EventSet to its merged event descriptor.
+type inputDescriptor struct {
+	merged *mergedDescriptor
+	// The index, within merged.props, of each of the input descriptor's properties.
+	propIdxs []int
+	// The type of each of the input descriptor's properties.
+	propTypes []eventpb.EventDescriptor_PropertyDescriptor_FieldType
+}
+
+// merger holds the state of a merge.
+type merger struct {
+	strTable    []string
+	strIdxs     map[string]int64
+	descriptors []*mergedDescriptor
+	descByName  map[string]*mergedDescriptor
+}
+
+func (m *merger) addString(str string) int64 {
+	if idx, ok := m.strIdxs[str]; ok {
+		return idx
+	}
+	idx := int64(len(m.strTable))
+	m.strTable = append(m.strTable, str)
+	m.strIdxs[str] = idx
+	return idx
+}
+
+// lookupString returns the string at idx in es's string table.
+func lookupString(es *eventpb.EventSet, esIdx int, idx int64) (string, error) {
+	if idx < 0 || idx >= int64(len(es.StringTable)) {
+		return "", status.Errorf(codes.InvalidArgument, "string index %d out of range in EventSet %d", idx, esIdx)
+	}
+	return es.StringTable[idx], nil
+}
+
+// addDescriptors reconciles the event descriptors of es with those already merged, and returns
+// the mapping from es's event descriptors to merged event descriptors.
+// Descriptors with the same name are merged into one, whose properties are the union of theirs.
+// If a property is a NUMBER in one descriptor and TEXT in another, the merged property is TEXT.
+func (m *merger) addDescriptors(es *eventpb.EventSet, esIdx int) ([]*inputDescriptor, error) {
+	var ret []*inputDescriptor
+	for _, ed := range es.EventDescriptor {
+		name, err := lookupString(es, esIdx, ed.Name)
+		if err != nil {
+			return nil, err
+		}
+		md, ok := m.descByName[name]
+		if !ok {
+			md = &mergedDescriptor{
+				idx:         int64(len(m.descriptors)),
+				name:        name,
+				propsByName: make(map[string]int),
+			}
+			m.descriptors = append(m.descriptors, md)
+			m.descByName[name] = md
+		}
+		id := &inputDescriptor{merged: md}
+		for _, pd := range ed.PropertyDescriptor {
+			propName, err := lookupString(es, esIdx, pd.Name)
+			if err != nil {
+				return nil, err
+			}
+			propIdx, ok := md.propsByName[propName]
+			if !ok {
+				propIdx = len(md.props)
+				md.props = append(md.props, mergedProperty{name: propName, typ: pd.Type})
+				md.propsByName[propName] = propIdx
+			} else if md.props[propIdx].typ != pd.Type {
+				md.props[propIdx].typ = eventpb.EventDescriptor_PropertyDescriptor_TEXT
+			}
+			id.propIdxs = append(id.propIdxs, propIdx)
+			id.propTypes = append(id.propTypes, pd.Type)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+// convertEvent converts ev, an event of es, to an event of the merged EventSet.
+func (m *merger) convertEvent(es *eventpb.EventSet, esIdx int, ids []*inputDescriptor, ev *eventpb.Event) (*eventpb.Event, error) {
+	if ev.EventDescriptor < 0 || ev.EventDescriptor >= int64(len(ids)) {
+		return nil, status.Errorf(codes.InvalidArgument, "event descriptor index %d out of range in EventSet %d", ev.EventDescriptor, esIdx)
+	}
+	id := ids[ev.EventDescriptor]
+	if len(ev.Property) != len(id.propIdxs) {
+		return nil, status.Errorf(codes.InvalidArgument, "event in EventSet %d has %d properties, but its event descriptor %q has %d", esIdx, len(ev.Property), id.merged.name, len(id.propIdxs))
+	}
+	// Properties missing from the input event are left 0, which is also the index of "".
+	props := make([]int64, len(id.merged.props))
+	for i, val := range ev.Property {
+		propIdx := id.propIdxs[i]
+		switch {
+		case id.propTypes[i] == eventpb.EventDescriptor_PropertyDescriptor_TEXT:
+			str, err := lookupString(es, esIdx, val)
+			if err != nil {
+				return nil, err
+			}
+			props[propIdx] = m.addString(str)
+		case id.merged.props[propIdx].typ == eventpb.EventDescriptor_PropertyDescriptor_TEXT:
+			// NUMBER properties that were merged with TEXT ones are stored as text.
+			props[propIdx] = m.addString(strconv.FormatInt(val, 10))
+		default:
+			props[propIdx] = val
+		}
+	}
+	return &eventpb.Event{
+		EventDescriptor: id.merged.idx,
+		Cpu:             ev.Cpu,
+		TimestampNs:     ev.TimestampNs,
+		Clipped:         ev.Clipped,
+		Property:        props,
+	}, nil
+}
+
+// eventKey returns a key identifying ev by everything but its clipping.
+func eventKey(ev *eventpb.Event) string {
+	var sb strings.Builder
+	for _, val := range append([]int64{ev.EventDescriptor, ev.Cpu, ev.TimestampNs}, ev.Property...) {
+		sb.WriteString(strconv.FormatInt(val, 10))
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+// timeRange is the span of timestamps of an EventSet's events.
+type timeRange struct {
+	start, end int64
+	empty      bool
+}
+
+func eventSetTimeRange(es *eventpb.EventSet) timeRange {
+	if len(es.Event) == 0 {
+		return timeRange{empty: true}
+	}
+	tr := timeRange{start: es.Event[0].TimestampNs, end: es.Event[0].TimestampNs}
+	for _, ev := range es.Event {
+		if ev.TimestampNs < tr.start {
+			tr.start = ev.TimestampNs
+		}
+		if ev.TimestampNs > tr.end {
+			tr.end = ev.TimestampNs
+		}
+	}
+	return tr
+}
+
+// mergedTraceClock returns the trace clock of the merged EventSet. The inputs' timestamps must be
+// comparable: either all of their clocks are identical, or all of them are the same clock, by
+// name, and produce ns. Different ns clocks, such as local and mono, have different epochs, so
+// their timestamps can't be compared.
+func mergedTraceClock(eventSets []*eventpb.EventSet) (*eventpb.TraceClock, error) {
+	first := eventSets[0].GetTraceClock()
+	identical := true
+	allNs := true
+	for _, es := range eventSets {
+		if !proto.Equal(es.GetTraceClock(), first) {
+			identical = false
+		}
+		if es.GetTraceClock().GetName() != first.GetName() || trace.ClockUnits(es.GetTraceClock()) != eventpb.TraceClock_NANOSECONDS {
+			allNs = false
+		}
+	}
+	switch {
+	case identical:
+		if first == nil {
+			return nil, nil
+		}
+		return proto.Clone(first).(*eventpb.TraceClock), nil
+	case allNs:
+		return &eventpb.TraceClock{
+			Name:  first.GetName(),
+			Units: eventpb.TraceClock_NANOSECONDS,
+		}, nil
+	default:
+		return nil, status.Errorf(codes.FailedPrecondition, "EventSets recorded with different trace clocks cannot be merged")
+	}
+}
+
+// EventSets merges the provided EventSets, such as those of several captures recorded
+// back-to-back or concurrently on the same host, into a new EventSet. The inputs are not modified.
+//  * The inputs' string tables are unified.
+//  * Event descriptors with the same name are merged into one, whose properties are the union of
+//    theirs. Events lacking some of the merged properties get 0, or "", for them. If a property is
+//    a NUMBER in one input and TEXT in another, it becomes TEXT, and its numeric values are
+//    converted to text.
+//  * Identical events from different inputs, which occur when the inputs' time ranges overlap, are
+//    only kept once. The kept event is clipped only if all of its copies were; an event clipped
+//    in one capture because that capture's buffer overflowed is valid if another capture recorded
+//    it without overflowing.
+//  * Otherwise, each event keeps its clipping.
+//  * The inputs' thread groups are unified. A PID that different inputs place in different thread
+//    groups is placed in the group of the last of them.
+// The merged events are sorted by timestamp. The inputs must use the same trace clock, and the
+// same default event loaders. Since only the strings that are referred
+// to are kept, merging a single EventSet compacts its string table.
+func EventSets(eventSets ...*eventpb.EventSet) (*eventpb.EventSet, error) {
+	if len(eventSets) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "at least one EventSet is required")
+	}
+	for idx, es := range eventSets {
+		if es.GetDefaultLoadersType() != eventSets[0].GetDefaultLoadersType() {
+			return nil, status.Errorf(codes.FailedPrecondition, "EventSet %d uses default event loaders %s, but EventSet 0 uses %s", idx, es.GetDefaultLoadersType(), eventSets[0].GetDefaultLoadersType())
+		}
+	}
+	clock, err := mergedTraceClock(eventSets)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &merger{
+		strIdxs:    make(map[string]int64),
+		descByName: make(map[string]*mergedDescriptor),
+	}
+	// Keep "" at index 0, so that omitted string indices refer to it.
+	m.addString("")
+	var inputDescriptors [][]*inputDescriptor
+	for idx, es := range eventSets {
+		ids, err := m.addDescriptors(es, idx)
+		if err != nil {
+			return nil, err
+		}
+		inputDescriptors = append(inputDescriptors, ids)
+	}
+	ret := &eventpb.EventSet{
+		DefaultLoadersType: eventSets[0].GetDefaultLoadersType(),
+		TraceClock:         clock,
+	}
+	var threadGroups [][]*eventpb.ThreadGroup
+	for _, es := range eventSets {
+		threadGroups = append(threadGroups, es.GetThreadGroup())
+	}
+	ret.ThreadGroup = threadgroups.Merge(threadGroups...)
+	for _, md := range m.descriptors {
+		ed := &eventpb.EventDescriptor{Name: m.addString(md.name)}
+		for _, prop := range md.props {
+			ed.PropertyDescriptor = append(ed.PropertyDescriptor, &eventpb.EventDescriptor_PropertyDescriptor{
+				Name: m.addString(prop.name),
+				Type: prop.typ,
+			})
+		}
+		ret.EventDescriptor = append(ret.EventDescriptor, ed)
+	}
+
+	var timeRanges []timeRange
+	for _, es := range eventSets {
+		timeRanges = append(timeRanges, eventSetTimeRange(es))
+	}
+	// overlapsOther returns true if ts falls within the time range of an input other than esIdx.
+	overlapsOther := func(esIdx int, ts int64) bool {
+		for idx, tr := range timeRanges {
+			if idx != esIdx && !tr.empty && tr.start <= ts && ts <= tr.end {
+				return true
+			}
+		}
+		return false
+	}
+	type seenEvent struct {
+		event *eventpb.Event
+		esIdx int
+	}
+	seen := make(map[string]seenEvent)
+	for esIdx, es := range eventSets {
+		for _, inputEv := range es.Event {
+			ev, err := m.convertEvent(es, esIdx, inputDescriptors[esIdx], inputEv)
+			if err != nil {
+				return nil, err
+			}
+			if overlapsOther(esIdx, ev.TimestampNs) {
+				key := eventKey(ev)
+				if prev, ok := seen[key]; ok && prev.esIdx != esIdx {
+					prev.event.Clipped = prev.event.Clipped && ev.Clipped
+					continue
+				} else if !ok {
+					seen[key] = seenEvent{ev, esIdx}
+				}
+			}
+			ret.Event = append(ret.Event, ev)
+		}
+	}
+	sort.SliceStable(ret.Event, func(i, j int) bool {
+		return ret.Event[i].TimestampNs < ret.Event[j].TimestampNs
+	})
+	ret.StringTable = m.strTable
+	return ret, nil
+}
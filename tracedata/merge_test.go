@@ -0,0 +1,197 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package merge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/eventsetbuilder"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+func buildEventSet(t *testing.T, b *eventsetbuilder.Builder) *eventpb.EventSet {
+	t.Helper()
+	es, errs := b.EventSet()
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors building EventSet: %v", errs)
+	}
+	return es
+}
+
+// describeEvents renders the events of es as strings, so that EventSets with differently ordered
+// string tables and event descriptors can be compared.
+func describeEvents(es *eventpb.EventSet) []string {
+	var ret []string
+	for _, ev := range es.Event {
+		ed := es.EventDescriptor[ev.EventDescriptor]
+		parts := []string{es.StringTable[ed.Name], fmt.Sprintf("cpu=%d ts=%d clipped=%t", ev.Cpu, ev.TimestampNs, ev.Clipped)}
+		for i, pd := range ed.PropertyDescriptor {
+			val := fmt.Sprintf("%d", ev.Property[i])
+			if pd.Type == eventpb.EventDescriptor_PropertyDescriptor_TEXT {
+				val = fmt.Sprintf("%q", es.StringTable[ev.Property[i]])
+			}
+			parts = append(parts, es.StringTable[pd.Name]+"="+val)
+		}
+		ret = append(ret, strings.Join(parts, " "))
+	}
+	return ret
+}
+
+func TestEventSets(t *testing.T) {
+	sched := buildEventSet(t, eventsetbuilder.NewBuilder().
+		WithEventDescriptor("sched_switch",
+			eventsetbuilder.Text("prev_comm"),
+			eventsetbuilder.Number("prev_pid"),
+			eventsetbuilder.Text("next_comm"),
+			eventsetbuilder.Number("next_pid")).
+		WithEventDescriptor("sched_wakeup",
+			eventsetbuilder.Text("comm"),
+			eventsetbuilder.Number("pid")).
+		WithEvent("sched_wakeup", 0, 100, false, "foo", 10).
+		WithEvent("sched_switch", 0, 200, true, "bar", 20, "foo", 10).
+		WithEvent("sched_switch", 1, 300, false, "foo", 10, "baz", 30))
+	// An irq capture, which overlaps the sched capture and recorded some of the same events.
+	irq := buildEventSet(t, eventsetbuilder.NewBuilder().
+		WithEventDescriptor("irq_handler_entry",
+			eventsetbuilder.Number("irq"),
+			eventsetbuilder.Text("name")).
+		// This capture's sched_wakeup lacks comm, and has an extra target_cpu, recorded as text.
+		WithEventDescriptor("sched_wakeup",
+			eventsetbuilder.Number("pid"),
+			eventsetbuilder.Text("target_cpu")).
+		WithEventDescriptor("sched_switch",
+			eventsetbuilder.Text("prev_comm"),
+			eventsetbuilder.Number("prev_pid"),
+			eventsetbuilder.Text("next_comm"),
+			eventsetbuilder.Number("next_pid")).
+		WithEvent("irq_handler_entry", 1, 150, false, 24, "eth0").
+		WithEvent("sched_switch", 0, 200, false, "bar", 20, "foo", 10).
+		WithEvent("sched_wakeup", 1, 250, false, 30, "1").
+		WithEvent("irq_handler_entry", 1, 400, false, 24, "eth0"))
+	// A restarted irq capture, in which irq was recorded as text.
+	irq2 := buildEventSet(t, eventsetbuilder.NewBuilder().
+		WithEventDescriptor("irq_handler_entry",
+			eventsetbuilder.Text("irq"),
+			eventsetbuilder.Text("name")).
+		WithEvent("irq_handler_entry", 1, 500, false, "25", "nvme0"))
+
+	got, err := EventSets(sched, irq, irq2)
+	if err != nil {
+		t.Fatalf("EventSets() returned unexpected error: %s", err)
+	}
+	want := []string{
+		`sched_wakeup cpu=0 ts=100 clipped=false comm="foo" pid=10 target_cpu=""`,
+		`irq_handler_entry cpu=1 ts=150 clipped=false irq="24" name="eth0"`,
+		// The sched capture's copy was clipped, but the irq capture's wasn't.
+		`sched_switch cpu=0 ts=200 clipped=false prev_comm="bar" prev_pid=20 next_comm="foo" next_pid=10`,
+		`sched_wakeup cpu=1 ts=250 clipped=false comm="" pid=30 target_cpu="1"`,
+		`sched_switch cpu=1 ts=300 clipped=false prev_comm="foo" prev_pid=10 next_comm="baz" next_pid=30`,
+		`irq_handler_entry cpu=1 ts=400 clipped=false irq="24" name="eth0"`,
+		`irq_handler_entry cpu=1 ts=500 clipped=false irq="25" name="nvme0"`,
+	}
+	if diff := cmp.Diff(want, describeEvents(got)); diff != "" {
+		t.Errorf("EventSets(): Diff -want +got:\n%s", diff)
+	}
+	if len(got.EventDescriptor) != 3 {
+		t.Errorf("EventSets() produced %d event descriptors, want 3", len(got.EventDescriptor))
+	}
+	if got.StringTable[0] != "" {
+		t.Errorf("EventSets() string table starts with %q, want \"\"", got.StringTable[0])
+	}
+	// The inputs must be left unmodified.
+	if !sched.Event[1].Clipped {
+		t.Errorf("EventSets() modified its inputs")
+	}
+}
+
+func TestEventSets_KeepsDuplicatesWithinOneInput(t *testing.T) {
+	b := eventsetbuilder.NewBuilder().
+		WithEventDescriptor("tick", eventsetbuilder.Number("n")).
+		WithEvent("tick", 0, 100, false, 1).
+		WithEvent("tick", 0, 100, false, 1)
+	got, err := EventSets(buildEventSet(t, b), buildEventSet(t, b))
+	if err != nil {
+		t.Fatalf("EventSets() returned unexpected error: %s", err)
+	}
+	if len(got.Event) != 2 {
+		t.Errorf("EventSets() produced %d events, want 2", len(got.Event))
+	}
+}
+
+func TestEventSets_TraceClocks(t *testing.T) {
+	tests := []struct {
+		description string
+		clocks      []*eventpb.TraceClock
+		wantClock   *eventpb.TraceClock
+		wantCode    codes.Code
+	}{{
+		description: "no clocks",
+		clocks:      []*eventpb.TraceClock{nil, nil},
+	}, {
+		description: "identical cycle clocks",
+		clocks: []*eventpb.TraceClock{
+			{Name: "x86-tsc", FrequencyKhz: 1000},
+			{Name: "x86-tsc", FrequencyKhz: 1000},
+		},
+		wantClock: &eventpb.TraceClock{Name: "x86-tsc", FrequencyKhz: 1000},
+	}, {
+		description: "same ns clock",
+		clocks:      []*eventpb.TraceClock{{Name: "local"}, {Name: "local", Units: eventpb.TraceClock_NANOSECONDS}},
+		wantClock:   &eventpb.TraceClock{Name: "local", Units: eventpb.TraceClock_NANOSECONDS},
+	}, {
+		description: "ns clocks",
+		clocks:      []*eventpb.TraceClock{{Name: "local"}, nil},
+		wantCode:    codes.FailedPrecondition,
+	}, {
+		description: "different named ns clocks",
+		clocks:      []*eventpb.TraceClock{{Name: "mono"}, {Name: "boot"}},
+		wantCode:    codes.FailedPrecondition,
+	}, {
+		description: "different cycle clocks",
+		clocks: []*eventpb.TraceClock{
+			{Name: "x86-tsc", FrequencyKhz: 1000},
+			{Name: "x86-tsc", FrequencyKhz: 2000},
+		},
+		wantCode: codes.FailedPrecondition,
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var eventSets []*eventpb.EventSet
+			for _, clock := range test.clocks {
+				es := buildEventSet(t, eventsetbuilder.NewBuilder())
+				es.TraceClock = clock
+				eventSets = append(eventSets, es)
+			}
+			got, err := EventSets(eventSets...)
+			if status.Code(err) != test.wantCode {
+				t.Fatalf("EventSets() = %v, want code %s", err, test.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(test.wantClock, got.TraceClock, cmp.Comparer(proto.Equal)); diff != "" {
+				t.Errorf("EventSets() trace clock: Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Package multihost provides utilities for combining the traces of several
+// hosts, recorded at the same time, into one EventSet on a common timeline.
+//
+// In a combined EventSet, each host's CPUs and PIDs are namespaced by the
+// host's index: CPU c of host h becomes h*HostCPUStride+c, and PID p of host h
+// becomes h*HostPIDStride+p.  The first host's CPUs and PIDs are thus
+// unchanged, and PID 0, the idle thread, is never namespaced.
+package multihost
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/merge"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+const (
+	// HostCPUStride is the distance between the namespaced CPUs of consecutive
+	// hosts.  Hosts may have at most this many CPUs.
+	HostCPUStride int64 = 10000
+	// HostPIDStride is the distance between the namespaced PIDs of consecutive
+	// hosts.  It exceeds the largest PID Linux allows, 2^22.
+	HostPIDStride int64 = 10000000
+)
+
+// HostCPU returns the namespaced CPU of the provided host's cpu.
+func HostCPU(host int, cpu int64) int64 {
+	return int64(host)*HostCPUStride + cpu
+}
+
+// SplitCPU returns the host of a namespaced CPU, and its CPU on that host.
+func SplitCPU(cpu int64) (host int, hostCPU int64) {
+	return int(cpu / HostCPUStride), cpu % HostCPUStride
+}
+
+// HostPID returns the namespaced PID of the provided host's pid.
+func HostPID(host int, pid int64) int64 {
+	if pid == 0 {
+		return 0
+	}
+	return int64(host)*HostPIDStride + pid
+}
+
+// SplitPID returns the host of a namespaced PID, and its PID on that host.
+// PID 0 is reported on host 0.
+func SplitPID(pid int64) (host int, hostPID int64) {
+	return int(pid / HostPIDStride), pid % HostPIDStride
+}
+
+// Host is the trace of one of the hosts to combine.
+type Host struct {
+	EventSet *eventpb.EventSet
+	// The duration, in ns, to add to the host's timestamps to place them on the
+	// common timeline.
+	ClockOffsetNs int64
+}
+
+// Combine combines the traces of the provided hosts into a new EventSet, on a
+// common timeline, with each host's CPUs, and the PIDs of its thread groups,
+// namespaced.  The inputs are not modified.  Timestamps are converted to ns,
+// then shifted by their host's clock offset.  All hosts must use the same
+// default event loaders.
+func Combine(hosts ...*Host) (*eventpb.EventSet, error) {
+	if len(hosts) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "at least one host is required")
+	}
+	var eventSets []*eventpb.EventSet
+	for idx, host := range hosts {
+		es := proto.Clone(host.EventSet).(*eventpb.EventSet)
+		if err := trace.ConvertTimestampsToNs(es); err != nil {
+			return nil, status.Errorf(status.Code(err), "failed to convert timestamps of host %d: %s", idx, err)
+		}
+		// Only ns matter from now on; the clocks' names needn't match.
+		es.TraceClock = &eventpb.TraceClock{Units: eventpb.TraceClock_NANOSECONDS}
+		for _, ev := range es.Event {
+			if ev.Cpu < 0 || ev.Cpu >= HostCPUStride {
+				return nil, status.Errorf(codes.OutOfRange, "CPU %d of host %d cannot be namespaced; hosts may have at most %d CPUs", ev.Cpu, idx, HostCPUStride)
+			}
+			ev.Cpu = HostCPU(idx, ev.Cpu)
+			ev.TimestampNs += host.ClockOffsetNs
+		}
+		hostPID := func(pid int64) (int64, error) {
+			if pid < 0 || pid >= HostPIDStride {
+				return 0, status.Errorf(codes.OutOfRange, "PID %d of host %d cannot be namespaced", pid, idx)
+			}
+			return HostPID(idx, pid), nil
+		}
+		for _, group := range es.ThreadGroup {
+			var err error
+			if group.Tgid, err = hostPID(group.Tgid); err != nil {
+				return nil, err
+			}
+			for i, pid := range group.Pid {
+				if group.Pid[i], err = hostPID(pid); err != nil {
+					return nil, err
+				}
+			}
+		}
+		eventSets = append(eventSets, es)
+	}
+	return merge.EventSets(eventSets...)
+}
+
+// SyncEvent identifies events recorded at the same instant on several hosts,
+// such as matching writes to each host's trace_marker, which are recorded as
+// 'print' events with the written text in their 'buf' property.  Events with
+// EventName whose Property has the same value on two hosts are matched.
+type SyncEvent struct {
+	EventName string
+	Property  string
+}
+
+// syncTimestamps returns the ns timestamps of the sync events of es, keyed by
+// their property values.  Values that occur more than once are ambiguous, and
+// are omitted.
+func syncTimestamps(es *eventpb.EventSet, sync SyncEvent) (map[string]int64, error) {
+	es = proto.Clone(es).(*eventpb.EventSet)
+	if err := trace.ConvertTimestampsToNs(es); err != nil {
+		return nil, err
+	}
+	// The index of sync.Property in each matching event descriptor.
+	propIdxs := map[int64]int{}
+	for edIdx, ed := range es.EventDescriptor {
+		if es.StringTable[ed.Name] != sync.EventName {
+			continue
+		}
+		for propIdx, pd := range ed.PropertyDescriptor {
+			if es.StringTable[pd.Name] == sync.Property {
+				propIdxs[int64(edIdx)] = propIdx
+			}
+		}
+	}
+	ret := map[string]int64{}
+	ambiguous := map[string]struct{}{}
+	for _, ev := range es.Event {
+		propIdx, ok := propIdxs[ev.EventDescriptor]
+		if !ok || ev.Clipped {
+			continue
+		}
+		val := ev.Property[propIdx]
+		key := strconv.FormatInt(val, 10)
+		if es.EventDescriptor[ev.EventDescriptor].PropertyDescriptor[propIdx].Type == eventpb.EventDescriptor_PropertyDescriptor_TEXT {
+			key = es.StringTable[val]
+		}
+		if _, ok := ret[key]; ok {
+			ambiguous[key] = struct{}{}
+		}
+		ret[key] = ev.TimestampNs
+	}
+	for key := range ambiguous {
+		delete(ret, key)
+	}
+	return ret, nil
+}
+
+// EstimateClockOffset estimates the duration, in ns, to add to the timestamps
+// of es to align them with those of ref, from the sync events that both
+// recorded.  The median of the matched events' offsets is used, so that a few
+// sync events delayed on one host don't skew the estimate.
+func EstimateClockOffset(ref, es *eventpb.EventSet, sync SyncEvent) (int64, error) {
+	refTimestamps, err := syncTimestamps(ref, sync)
+	if err != nil {
+		return 0, err
+	}
+	timestamps, err := syncTimestamps(es, sync)
+	if err != nil {
+		return 0, err
+	}
+	var offsets []int64
+	for key, ts := range timestamps {
+		if refTs, ok := refTimestamps[key]; ok {
+			offsets = append(offsets, refTs-ts)
+		}
+	}
+	if len(offsets) == 0 {
+		return 0, status.Errorf(codes.FailedPrecondition, "no %s events with matching %s values were found to align the hosts' clocks", sync.EventName, sync.Property)
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+	return offsets[len(offsets)/2], nil
+}
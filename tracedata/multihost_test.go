@@ -0,0 +1,119 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package multihost
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/eventsetbuilder"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+func hostEventSet(t *testing.T, markers map[string]int64, switchTimestamps ...int64) *eventpb.EventSet {
+	t.Helper()
+	b := eventsetbuilder.NewBuilder().
+		WithEventDescriptor("print", eventsetbuilder.Text("buf")).
+		WithEventDescriptor("sched_switch",
+			eventsetbuilder.Number("prev_pid"),
+			eventsetbuilder.Number("next_pid"))
+	for buf, ts := range markers {
+		b = b.WithEvent("print", 1, ts, false, buf)
+	}
+	for _, ts := range switchTimestamps {
+		b = b.WithEvent("sched_switch", 1, ts, false, 0, 100)
+	}
+	es, errs := b.EventSet()
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors building EventSet: %v", errs)
+	}
+	return es
+}
+
+func TestNamespacing(t *testing.T) {
+	if got := HostCPU(2, 3); got != 20003 {
+		t.Errorf("HostCPU(2, 3) = %d, want 20003", got)
+	}
+	if host, cpu := SplitCPU(20003); host != 2 || cpu != 3 {
+		t.Errorf("SplitCPU(20003) = %d, %d, want 2, 3", host, cpu)
+	}
+	if got := HostPID(2, 0); got != 0 {
+		t.Errorf("HostPID(2, 0) = %d, want 0", got)
+	}
+	if host, pid := SplitPID(HostPID(2, 1234)); host != 2 || pid != 1234 {
+		t.Errorf("SplitPID(HostPID(2, 1234)) = %d, %d, want 2, 1234", host, pid)
+	}
+}
+
+func TestEstimateClockOffset(t *testing.T) {
+	ref := hostEventSet(t, map[string]int64{"sync 1": 1000, "sync 2": 2000, "sync 3": 3000, "ref only": 4000})
+	// The second host's clock is 500ns behind, but its second sync event was
+	// delayed.
+	other := hostEventSet(t, map[string]int64{"sync 1": 500, "sync 2": 1900, "sync 3": 2500, "other only": 10})
+	got, err := EstimateClockOffset(ref, other, SyncEvent{EventName: "print", Property: "buf"})
+	if err != nil {
+		t.Fatalf("EstimateClockOffset() returned unexpected error: %s", err)
+	}
+	if got != 500 {
+		t.Errorf("EstimateClockOffset() = %d, want 500", got)
+	}
+
+	if _, err := EstimateClockOffset(ref, other, SyncEvent{EventName: "print", Property: "missing"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("EstimateClockOffset() without sync events = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	first := hostEventSet(t, nil, 1000, 3000)
+	first.ThreadGroup = []*eventpb.ThreadGroup{{Tgid: 100, Pid: []int64{100, 101}}}
+	second := hostEventSet(t, nil, 1500)
+	second.ThreadGroup = []*eventpb.ThreadGroup{{Tgid: 100, Pid: []int64{100}}}
+	got, err := Combine(
+		&Host{EventSet: first},
+		&Host{EventSet: second, ClockOffsetNs: 1000})
+	if err != nil {
+		t.Fatalf("Combine() returned unexpected error: %s", err)
+	}
+	var gotEvents []string
+	for _, ev := range got.Event {
+		gotEvents = append(gotEvents, fmt.Sprintf("CPU %d at %d", ev.Cpu, ev.TimestampNs))
+	}
+	want := []string{"CPU 1 at 1000", "CPU 10001 at 2500", "CPU 1 at 3000"}
+	if diff := cmp.Diff(want, gotEvents); diff != "" {
+		t.Errorf("Combine(): Diff -want +got:\n%s", diff)
+	}
+	wantThreadGroups := []*eventpb.ThreadGroup{
+		{Tgid: 100, Pid: []int64{100, 101}},
+		{Tgid: 10000100, Pid: []int64{10000100}},
+	}
+	if diff := cmp.Diff(wantThreadGroups, got.ThreadGroup, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Combine() thread groups: Diff -want +got:\n%s", diff)
+	}
+	if first.ThreadGroup[0].Pid[1] != 101 || second.ThreadGroup[0].Tgid != 100 {
+		t.Errorf("Combine() modified its inputs' thread groups")
+	}
+
+	tooManyCPUs := hostEventSet(t, nil, 1000)
+	tooManyCPUs.Event[0].Cpu = HostCPUStride
+	if _, err := Combine(&Host{EventSet: tooManyCPUs}); status.Code(err) != codes.OutOfRange {
+		t.Errorf("Combine() with too many CPUs = %v, want OutOfRange", err)
+	}
+}
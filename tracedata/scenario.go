@@ -0,0 +1,433 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Package scenario parses scenarios, a compact textual format for writing
+// small traces by hand, into EventSets.
+//
+// A scenario has one statement per line.  A '#' outside a quoted string starts
+// a comment running to the end of the line, and blank lines are ignored.
+//
+// An event descriptor declaration names an event and its properties, each of
+// which is either a number or text:
+//
+//   event my_event count:number label:text
+//
+// The sched events sched_switch, sched_wakeup, sched_wakeup_new, sched_waking,
+// sched_migrate_task, sched_wait_task, sched_process_wait,
+// sched_stat_runtime, sched_process_fork, sched_process_exec,
+// sched_process_exit and sched_process_free, and the print event, are declared
+// as FTrace records them, and need not be declared unless they are to be declared differently.
+// An event must be declared before its first use, and may only be declared
+// once.
+//
+// An event gives its timestamp in ns, the CPU that recorded it, its name, and
+// any of its properties, by name:
+//
+//   100 cpu0 my_event count=3 label="two words"
+//
+// Numbers are decimal, or hexadecimal with a 0x prefix.  Text containing
+// spaces or '#' must be double-quoted, and may then use Go escape sequences.
+// Properties that aren't given are zero, or empty.  The word 'clipped' among
+// the properties marks the event as clipped.
+//
+// The sched events may be abbreviated by dropping their 'sched_' prefix,
+// sched_migrate_task to 'migrate', and the sched_process_ events to 'fork',
+// 'exec', 'exit' and 'free'.  sched_switch's prev_state may be given as
+// the task state letters FTrace prints, such as R, S, D or R+, and
+// sched_switch also accepts the shorthand properties prev=<pid>[:<state>] and
+// next=<pid>:
+//
+//   100 cpu0 switch prev=10:S next=20
+//
+// A process declaration places threads in a process, or thread group, giving
+// its TGID and the PIDs of its threads other than the leader, whose PID is the
+// TGID:
+//
+//   process 10 11 12
+package scenario
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/eventsetbuilder"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/traceparser/traceparser"
+)
+
+// builtinDeclarations holds the declarations of the events which scenarios
+// may use without declaring them.
+var builtinDeclarations = map[string]string{
+	"sched_switch":       "prev_comm:text prev_pid:number prev_prio:number prev_state:number next_comm:text next_pid:number next_prio:number",
+	"sched_wakeup":       "comm:text pid:number prio:number target_cpu:number",
+	"sched_wakeup_new":   "comm:text pid:number prio:number target_cpu:number",
+	"sched_waking":       "comm:text pid:number prio:number target_cpu:number",
+	"sched_migrate_task": "comm:text pid:number prio:number orig_cpu:number dest_cpu:number",
+	"sched_wait_task":    "comm:text pid:number prio:number",
+	"sched_process_wait": "comm:text pid:number prio:number",
+	"sched_stat_runtime": "comm:text pid:number runtime:number vruntime:number",
+	"sched_process_fork": "parent_comm:text parent_pid:number child_comm:text child_pid:number",
+	"sched_process_exec": "filename:text pid:number old_pid:number",
+	"sched_process_exit": "comm:text pid:number prio:number",
+	"sched_process_free": "comm:text pid:number prio:number",
+	"print":              "ip:number buf:text",
+}
+
+// eventAliases maps the abbreviated names of builtin events to their names.
+var eventAliases = map[string]string{
+	"switch":       "sched_switch",
+	"wakeup":       "sched_wakeup",
+	"wakeup_new":   "sched_wakeup_new",
+	"waking":       "sched_waking",
+	"migrate":      "sched_migrate_task",
+	"migrate_task": "sched_migrate_task",
+	"wait_task":    "sched_wait_task",
+	"process_wait": "sched_process_wait",
+	"stat_runtime": "sched_stat_runtime",
+	"fork":         "sched_process_fork",
+	"process_fork": "sched_process_fork",
+	"exec":         "sched_process_exec",
+	"process_exec": "sched_process_exec",
+	"exit":         "sched_process_exit",
+	"process_exit": "sched_process_exit",
+	"free":         "sched_process_free",
+	"process_free": "sched_process_free",
+}
+
+// shorthandProperties maps, for each event with shorthand properties, each
+// shorthand to the properties that the ':'-separated parts of its value set.
+var shorthandProperties = map[string]map[string][]string{
+	"sched_switch": {
+		"prev": {"prev_pid", "prev_state"},
+		"next": {"next_pid"},
+	},
+}
+
+var (
+	eventNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	cpuRe       = regexp.MustCompile(`^cpu(\d+)$`)
+)
+
+type property struct {
+	name   string
+	number bool
+}
+
+type eventDescriptor struct {
+	name       string
+	properties []property
+	// The index of each property in properties, by name.
+	indices map[string]int
+	// The line on which the event was declared, or 0 if it is builtin.
+	line int
+}
+
+type parser struct {
+	builder     *eventsetbuilder.Builder
+	descriptors map[string]*eventDescriptor
+	errs        []string
+}
+
+// Parse parses the scenario read from r, returning its events as an EventSet.
+// If the scenario is invalid, the returned error describes each invalid line.
+func Parse(r io.Reader) (*eventpb.EventSet, error) {
+	p := &parser{
+		builder:     eventsetbuilder.NewBuilder(),
+		descriptors: map[string]*eventDescriptor{},
+	}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if err := p.parseLine(lineNum, scanner.Text()); err != nil {
+			p.errs = append(p.errs, fmt.Sprintf("line %d: %s", lineNum, err))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %s", err)
+	}
+	if len(p.errs) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid scenario:\n%s", strings.Join(p.errs, "\n"))
+	}
+	es, errs := p.builder.EventSet()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to build EventSet from scenario: %v", errs)
+	}
+	return es, nil
+}
+
+// parseLine parses a single line of a scenario.
+func (p *parser) parseLine(lineNum int, line string) error {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	if tokens[0] == "event" {
+		if len(tokens) < 2 {
+			return fmt.Errorf("expected an event name after 'event'")
+		}
+		if prev, ok := p.descriptors[tokens[1]]; ok {
+			if prev.line == 0 {
+				return fmt.Errorf("event %s is declared after its first use", tokens[1])
+			}
+			return fmt.Errorf("event %s is already declared on line %d", tokens[1], prev.line)
+		}
+		return p.declare(lineNum, tokens[1], tokens[2:])
+	}
+	if tokens[0] == "process" {
+		return p.parseProcess(tokens[1:])
+	}
+	return p.parseEvent(tokens)
+}
+
+// parseProcess parses the TGID and PIDs of a process declaration, and adds
+// its thread group to the EventSet.
+func (p *parser) parseProcess(tokens []string) error {
+	if len(tokens) == 0 {
+		return fmt.Errorf("expected 'process <tgid> [<pid>]...'")
+	}
+	var ids []int64
+	for _, token := range tokens {
+		id, err := strconv.ParseInt(token, 10, 64)
+		if err != nil || id < 0 {
+			return fmt.Errorf("invalid PID %q; want a non-negative number", token)
+		}
+		ids = append(ids, id)
+	}
+	p.builder.WithThreadGroup(ids[0], ids[1:]...)
+	return nil
+}
+
+// declare declares the named event with the provided property declarations.
+func (p *parser) declare(lineNum int, name string, propertyDecls []string) error {
+	if !eventNameRe.MatchString(name) {
+		return fmt.Errorf("invalid event name %q", name)
+	}
+	ed := &eventDescriptor{
+		name:    name,
+		indices: map[string]int{},
+		line:    lineNum,
+	}
+	var pds []eventsetbuilder.PropertyDescriptor
+	for _, decl := range propertyDecls {
+		parts := strings.Split(decl, ":")
+		if len(parts) != 2 || !eventNameRe.MatchString(parts[0]) {
+			return fmt.Errorf("invalid property declaration %q; want <name>:number or <name>:text", decl)
+		}
+		if _, ok := ed.indices[parts[0]]; ok {
+			return fmt.Errorf("property %s is declared more than once", parts[0])
+		}
+		switch parts[1] {
+		case "number":
+			pds = append(pds, eventsetbuilder.Number(parts[0]))
+		case "text":
+			pds = append(pds, eventsetbuilder.Text(parts[0]))
+		default:
+			return fmt.Errorf("invalid type %q for property %s; want number or text", parts[1], parts[0])
+		}
+		ed.indices[parts[0]] = len(ed.properties)
+		ed.properties = append(ed.properties, property{
+			name:   parts[0],
+			number: parts[1] == "number",
+		})
+	}
+	p.descriptors[name] = ed
+	p.builder.WithEventDescriptor(name, pds...)
+	return nil
+}
+
+// descriptor returns the descriptor of the named event, declaring it if it is
+// builtin and not yet declared.
+func (p *parser) descriptor(name string) (*eventDescriptor, error) {
+	if alias, ok := eventAliases[name]; ok {
+		if _, declared := p.descriptors[name]; !declared {
+			name = alias
+		}
+	}
+	if ed, ok := p.descriptors[name]; ok {
+		return ed, nil
+	}
+	decl, ok := builtinDeclarations[name]
+	if !ok {
+		return nil, fmt.Errorf("event %s is not declared", name)
+	}
+	if err := p.declare(0 /*=lineNum*/, name, strings.Fields(decl)); err != nil {
+		return nil, err
+	}
+	return p.descriptors[name], nil
+}
+
+// parseEvent parses an event line, and adds its event to the EventSet.
+func (p *parser) parseEvent(tokens []string) error {
+	if len(tokens) < 3 {
+		return fmt.Errorf("expected '<timestamp> cpu<N> <event> [<property>=<value>]...' or 'event <name> [<property>:<type>]...'")
+	}
+	timestamp, err := strconv.ParseInt(tokens[0], 10, 64)
+	if err != nil || timestamp < 0 {
+		return fmt.Errorf("invalid timestamp %q; want a non-negative number of ns", tokens[0])
+	}
+	match := cpuRe.FindStringSubmatch(tokens[1])
+	if match == nil {
+		return fmt.Errorf("invalid CPU %q; want cpu<N>", tokens[1])
+	}
+	cpu, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid CPU %q: %s", tokens[1], err)
+	}
+	ed, err := p.descriptor(tokens[2])
+	if err != nil {
+		return err
+	}
+	props := make([]interface{}, len(ed.properties))
+	for i, prop := range ed.properties {
+		if prop.number {
+			props[i] = int64(0)
+		} else {
+			props[i] = ""
+		}
+	}
+	set := make([]bool, len(ed.properties))
+	setProperty := func(name, value string) error {
+		idx, ok := ed.indices[name]
+		if !ok {
+			return fmt.Errorf("event %s has no property %s", ed.name, name)
+		}
+		if set[idx] {
+			return fmt.Errorf("property %s is given more than once", name)
+		}
+		set[idx] = true
+		if !ed.properties[idx].number {
+			props[idx] = value
+			return nil
+		}
+		num, err := parseNumber(ed.name, name, value)
+		if err != nil {
+			return err
+		}
+		props[idx] = num
+		return nil
+	}
+	clipped := false
+	for _, token := range tokens[3:] {
+		if token == "clipped" {
+			clipped = true
+			continue
+		}
+		eq := strings.Index(token, "=")
+		if eq <= 0 {
+			return fmt.Errorf("invalid property %q; want <property>=<value>", token)
+		}
+		name, value := token[:eq], token[eq+1:]
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return fmt.Errorf("invalid quoted value for property %s: %s", name, err)
+			}
+		}
+		if targets, ok := shorthandProperties[ed.name][name]; ok && ed.line == 0 {
+			parts := strings.Split(value, ":")
+			if len(parts) > len(targets) {
+				return fmt.Errorf("too many parts in %s=%s; want at most %d", name, value, len(targets))
+			}
+			for i, part := range parts {
+				if err := setProperty(targets[i], part); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := setProperty(name, value); err != nil {
+			return err
+		}
+	}
+	p.builder.WithEvent(ed.name, cpu, timestamp, clipped, props...)
+	return nil
+}
+
+// parseNumber parses the value of a number property.
+func parseNumber(eventName, propertyName, value string) (int64, error) {
+	if eventName == "sched_switch" && propertyName == "prev_state" {
+		if state, ok := traceparser.ParseTextTaskState(value); ok {
+			return state, nil
+		}
+	}
+	base := 10
+	digits := value
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		base = 16
+		digits = digits[2:]
+	} else if strings.HasPrefix(digits, "-0x") || strings.HasPrefix(digits, "-0X") {
+		base = 16
+		digits = "-" + digits[3:]
+	}
+	num, err := strconv.ParseInt(digits, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for number property %s", value, propertyName)
+	}
+	return num, nil
+}
+
+// tokenize splits a scenario line into whitespace-separated tokens, dropping
+// any comment.  Whitespace and '#' within double quotes don't end a token or
+// start a comment.
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	inToken, inQuotes, escaped := false, false, false
+	for _, c := range line {
+		switch {
+		case inQuotes:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inQuotes = false
+			}
+		case c == '#':
+			if inToken {
+				tokens = append(tokens, token.String())
+			}
+			return tokens, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+			continue
+		case c == '"':
+			inQuotes = true
+		}
+		token.WriteRune(c)
+		inToken = true
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quoted string")
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
@@ -0,0 +1,171 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package scenario
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/eventsetbuilder"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+func TestParse(t *testing.T) {
+	scenario := `
+# PID 10 sleeps, and PID 20 runs, on CPU 0.
+100 cpu0 switch prev=10:S next=20 prev_comm=Process1 next_comm="Process 2"
+110 cpu1 sched_wakeup pid=10 comm=Process1 target_cpu=0   # Wake PID 10.
+
+event my_event count:number label:text
+120 cpu1 my_event count=0x10 label="a # in a label"
+130 cpu0 sched_switch prev_pid=20 prev_state=R+ next_pid=10 clipped
+140 cpu2 migrate pid=10 orig_cpu=0 dest_cpu=2
+`
+	got, err := Parse(strings.NewReader(scenario))
+	if err != nil {
+		t.Fatalf("Parse() returned unexpected error: %s", err)
+	}
+	want, errs := eventsetbuilder.NewBuilder().
+		WithEventDescriptor("sched_switch",
+			eventsetbuilder.Text("prev_comm"),
+			eventsetbuilder.Number("prev_pid"),
+			eventsetbuilder.Number("prev_prio"),
+			eventsetbuilder.Number("prev_state"),
+			eventsetbuilder.Text("next_comm"),
+			eventsetbuilder.Number("next_pid"),
+			eventsetbuilder.Number("next_prio")).
+		WithEvent("sched_switch", 0, 100, false, "Process1", 10, 0, 1, "Process 2", 20, 0).
+		WithEventDescriptor("sched_wakeup",
+			eventsetbuilder.Text("comm"),
+			eventsetbuilder.Number("pid"),
+			eventsetbuilder.Number("prio"),
+			eventsetbuilder.Number("target_cpu")).
+		WithEvent("sched_wakeup", 1, 110, false, "Process1", 10, 0, 0).
+		WithEventDescriptor("my_event",
+			eventsetbuilder.Number("count"),
+			eventsetbuilder.Text("label")).
+		WithEvent("my_event", 1, 120, false, 16, "a # in a label").
+		WithEvent("sched_switch", 0, 130, true, "", 20, 0, 0x100, "", 10, 0).
+		WithEventDescriptor("sched_migrate_task",
+			eventsetbuilder.Text("comm"),
+			eventsetbuilder.Number("pid"),
+			eventsetbuilder.Number("prio"),
+			eventsetbuilder.Number("orig_cpu"),
+			eventsetbuilder.Number("dest_cpu")).
+		WithEvent("sched_migrate_task", 2, 140, false, "", 10, 0, 0, 2).
+		EventSet()
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors building EventSet: %v", errs)
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Parse(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestParse_Processes(t *testing.T) {
+	got, err := Parse(strings.NewReader(`
+process 20 22 21
+process 10
+100 cpu0 switch prev=10:S next=21
+process 30 22   # PID 22 has left process 20.
+`))
+	if err != nil {
+		t.Fatalf("Parse() returned unexpected error: %s", err)
+	}
+	want := []*eventpb.ThreadGroup{
+		{Tgid: 10, Pid: []int64{10}},
+		{Tgid: 20, Pid: []int64{20, 21}},
+		{Tgid: 30, Pid: []int64{22, 30}},
+	}
+	if diff := cmp.Diff(want, got.ThreadGroup, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Parse() thread groups: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		description string
+		scenario    string
+		wantErrs    []string
+	}{{
+		description: "undeclared event",
+		scenario:    "100 cpu0 my_event",
+		wantErrs:    []string{"line 1: event my_event is not declared"},
+	}, {
+		description: "property type",
+		scenario: `event my_event count:number
+100 cpu0 my_event count=three`,
+		wantErrs: []string{`line 2: invalid value "three" for number property count`},
+	}, {
+		description: "several errors",
+		scenario: `100 cpu0 switch prev=10:Q
+# A comment.
+100 cpu0 wakeup target=1
+100 cpu0 wakeup pid=1 pid=2
+cpu0 100 wakeup`,
+		wantErrs: []string{
+			`line 1: invalid value "Q" for number property prev_state`,
+			"line 3: event sched_wakeup has no property target",
+			"line 4: property pid is given more than once",
+			`line 5: invalid timestamp "cpu0"; want a non-negative number of ns`,
+		},
+	}, {
+		description: "invalid declarations",
+		scenario: `event my_event count:float
+event my_event count:number
+event my_event count:number
+100 cpu0 print buf=hello
+event print buf:text`,
+		wantErrs: []string{
+			`line 1: invalid type "float" for property count; want number or text`,
+			"line 3: event my_event is already declared on line 2",
+			"line 5: event print is declared after its first use",
+		},
+	}, {
+		description: "malformed lines",
+		scenario: `100 cpu0
+100 core0 switch
+100 cpu0 switch prev
+100 cpu0 print buf="unterminated
+process
+process 10 eleven`,
+		wantErrs: []string{
+			"line 1: expected '<timestamp> cpu<N> <event> [<property>=<value>]...' or 'event <name> [<property>:<type>]...'",
+			`line 2: invalid CPU "core0"; want cpu<N>`,
+			`line 3: invalid property "prev"; want <property>=<value>`,
+			"line 4: unterminated quoted string",
+			"line 5: expected 'process <tgid> [<pid>]...'",
+			`line 6: invalid PID "eleven"; want a non-negative number`,
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.scenario))
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("Parse() returned error %v, want InvalidArgument", err)
+			}
+			gotErrs := strings.Split(status.Convert(err).Message(), "\n")[1:]
+			if diff := cmp.Diff(test.wantErrs, gotErrs); diff != "" {
+				t.Errorf("Parse() errors: Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
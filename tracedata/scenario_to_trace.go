@@ -0,0 +1,135 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Binary scenario_to_trace is a command line tool to convert scenarios, written
+// in the format described in package scenario, into traces that can be
+// uploaded to SchedViz.  Each scenario file named on the command line is
+// checked, then written to the output directory with the same base name.
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/schedviz/tracedata/scenario"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+var (
+	outputDir    = flag.String("output_dir", ".", "Optional. The folder where the converted scenarios are written. Defaults to the current folder")
+	outputFormat = flag.String("output_format", "tar", "Optional. Format to write the output in. Can be \"tar\", for a .tar.gz that can be uploaded to SchedViz, or \"proto\" or \"textproto\", for an EventSet. Will use \"tar\" if not specified")
+)
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Exit("Usage: scenario_to_trace [-output_dir=<dir>] [-output_format=tar|proto|textproto] <scenario file>...")
+	}
+	for _, scenarioPath := range flag.Args() {
+		outputPath, err := convert(scenarioPath)
+		if err != nil {
+			log.Exitf("Failed to convert %s: %s", scenarioPath, err)
+		}
+		log.Infof("Wrote %s", outputPath)
+	}
+}
+
+// convert converts the scenario at scenarioPath in the requested output
+// format, and returns the path it was written to.
+func convert(scenarioPath string) (string, error) {
+	contents, err := ioutil.ReadFile(scenarioPath)
+	if err != nil {
+		return "", err
+	}
+	// Check the scenario even if it is written as is.
+	es, err := scenario.Parse(bytes.NewReader(contents))
+	if err != nil {
+		return "", err
+	}
+	baseName := strings.TrimSuffix(path.Base(scenarioPath), path.Ext(scenarioPath))
+	var output []byte
+	var ext string
+	switch *outputFormat {
+	case "tar":
+		output, err = scenarioTar(contents)
+		ext = ".tar.gz"
+	case "proto":
+		output, err = proto.Marshal(es)
+		ext = ".binproto"
+	case "textproto":
+		output = []byte(proto.MarshalTextString(es))
+		ext = ".textproto"
+	default:
+		return "", fmt.Errorf("unknown output format %q", *outputFormat)
+	}
+	if err != nil {
+		return "", err
+	}
+	outputPath := path.Join(*outputDir, baseName+ext)
+	if err := ioutil.WriteFile(outputPath, output, 0644); err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
+
+// scenarioTar returns a gzipped tar holding the provided scenario, in the
+// layout the SchedViz server expects of uploaded SCENARIO traces.
+func scenarioTar(contents []byte) ([]byte, error) {
+	metadata := proto.MarshalTextString(&eventpb.ArchiveMetadataConfig{
+		TraceType: eventpb.ArchiveMetadataConfig_SCENARIO,
+		Recorder:  "scenario_to_trace",
+	})
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	files := []struct {
+		name     string
+		contents []byte
+	}{
+		{"metadata.textproto", []byte(metadata)},
+		{"scenario", contents},
+	}
+	for _, file := range files {
+		header := &tar.Header{
+			Name:     file.name,
+			Mode:     0644,
+			Size:     int64(len(file.contents)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tarWriter.Write(file.contents); err != nil {
+			return nil, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -37,7 +37,7 @@ func String(ev *trace.Event) string {
 			ev.NumberProperties["prev_pid"], ev.TextProperties["prev_comm"], ev.NumberProperties["prev_prio"], ev.NumberProperties["prev_state"],
 			ev.NumberProperties["next_pid"], ev.TextProperties["next_comm"], ev.NumberProperties["next_prio"],
 			ev.CPU)
-	case "sched_wakeup", "sched_wakeup_new":
+	case "sched_wakeup", "sched_wakeup_new", "sched_waking":
 		return fmt.Sprintf("%s PID %d ('%s', prio %d) on CPU %3d",
 			prefix,
 			ev.NumberProperties["pid"], ev.TextProperties["comm"], ev.NumberProperties["prio"],
@@ -47,7 +47,18 @@ func String(ev *trace.Event) string {
 			prefix,
 			ev.NumberProperties["pid"], ev.TextProperties["comm"], ev.NumberProperties["prio"],
 			ev.NumberProperties["orig_cpu"], ev.NumberProperties["dest_cpu"])
-	case "sched_wait_task", "sched_process_wait":
+	case "sched_process_fork":
+		return fmt.Sprintf("%s PID %d ('%s') forked PID %d ('%s') on CPU %3d",
+			prefix,
+			ev.NumberProperties["parent_pid"], ev.TextProperties["parent_comm"],
+			ev.NumberProperties["child_pid"], ev.TextProperties["child_comm"],
+			ev.CPU)
+	case "sched_process_exec":
+		return fmt.Sprintf("%s PID %d (formerly PID %d) exec'd '%s' on CPU %3d",
+			prefix,
+			ev.NumberProperties["pid"], ev.NumberProperties["old_pid"], ev.TextProperties["filename"],
+			ev.CPU)
+	case "sched_wait_task", "sched_process_wait", "sched_process_exit", "sched_process_free":
 		return fmt.Sprintf("%s PID %d ('%s', prio %d) on CPU %3d",
 			prefix,
 			ev.NumberProperties["pid"], ev.TextProperties["comm"], ev.NumberProperties["prio"],
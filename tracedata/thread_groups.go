@@ -0,0 +1,113 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Package threadgroups provides utilities for building and reading the thread
+// groups, or processes, recorded in eventpb.EventSets.
+package threadgroups
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+// Parse reads a thread group snapshot from the provided reader.  Each nonblank
+// line of the snapshot holds a TGID and the PID of one thread in that thread
+// group, separated by whitespace, as in
+//   1234 1234
+//   1234 1240
+// If a PID appears on more than one line, the last line wins.
+func Parse(r io.Reader) ([]*eventpb.ThreadGroup, error) {
+	tgidsByPID := map[int64]int64{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a TGID and a PID, got %q", lineNum, scanner.Text())
+		}
+		tgid, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || tgid < 0 {
+			return nil, fmt.Errorf("line %d: invalid TGID %q", lineNum, fields[0])
+		}
+		pid, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || pid < 0 {
+			return nil, fmt.Errorf("line %d: invalid PID %q", lineNum, fields[1])
+		}
+		tgidsByPID[pid] = tgid
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return FromTGIDs(tgidsByPID), nil
+}
+
+// FromTGIDs returns the thread groups described by the provided map from PID
+// to TGID, in increasing TGID order.  Each thread group leader is included in
+// its own group.
+func FromTGIDs(tgidsByPID map[int64]int64) []*eventpb.ThreadGroup {
+	pidsByTGID := map[int64][]int64{}
+	for pid, tgid := range tgidsByPID {
+		pidsByTGID[tgid] = append(pidsByTGID[tgid], pid)
+	}
+	ret := make([]*eventpb.ThreadGroup, 0, len(pidsByTGID))
+	for tgid, pids := range pidsByTGID {
+		if _, ok := tgidsByPID[tgid]; !ok {
+			pids = append(pids, tgid)
+		}
+		sort.Slice(pids, func(a, b int) bool { return pids[a] < pids[b] })
+		ret = append(ret, &eventpb.ThreadGroup{Tgid: tgid, Pid: pids})
+	}
+	sort.Slice(ret, func(a, b int) bool { return ret[a].Tgid < ret[b].Tgid })
+	return ret
+}
+
+// TGIDs returns a map from each PID in the provided thread groups to its TGID.
+func TGIDs(groups []*eventpb.ThreadGroup) map[int64]int64 {
+	ret := map[int64]int64{}
+	for _, group := range groups {
+		ret[group.GetTgid()] = group.GetTgid()
+		for _, pid := range group.GetPid() {
+			ret[pid] = group.GetTgid()
+		}
+	}
+	return ret
+}
+
+// Merge returns the union of the provided lists of thread groups.  If a PID
+// is placed in different thread groups by different lists, the last list
+// wins.
+func Merge(groupLists ...[]*eventpb.ThreadGroup) []*eventpb.ThreadGroup {
+	tgidsByPID := map[int64]int64{}
+	for _, groups := range groupLists {
+		for pid, tgid := range TGIDs(groups) {
+			tgidsByPID[pid] = tgid
+		}
+	}
+	if len(tgidsByPID) == 0 {
+		return nil
+	}
+	return FromTGIDs(tgidsByPID)
+}
@@ -0,0 +1,80 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package threadgroups
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+func TestParse(t *testing.T) {
+	got, err := Parse(strings.NewReader(`
+20 21
+10 10
+20 20
+
+10 12
+20 12
+`))
+	if err != nil {
+		t.Fatalf("Parse() returned unexpected error: %s", err)
+	}
+	want := []*eventpb.ThreadGroup{
+		{Tgid: 10, Pid: []int64{10}},
+		{Tgid: 20, Pid: []int64{12, 20, 21}},
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Parse(): Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, snapshot := range []string{
+		"10",
+		"10 11 12",
+		"ten 11",
+		"10 -11",
+	} {
+		if _, err := Parse(strings.NewReader(snapshot)); err == nil {
+			t.Errorf("Parse(%q) returned no error, want one", snapshot)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	got := Merge(
+		[]*eventpb.ThreadGroup{{Tgid: 10, Pid: []int64{10, 11}}},
+		nil,
+		// PID 11 has since exec'd, leaving its old thread group.
+		[]*eventpb.ThreadGroup{{Tgid: 11}, {Tgid: 30, Pid: []int64{31}}},
+	)
+	want := []*eventpb.ThreadGroup{
+		{Tgid: 10, Pid: []int64{10}},
+		{Tgid: 11, Pid: []int64{11}},
+		{Tgid: 30, Pid: []int64{30, 31}},
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("Merge(): Diff -want +got:\n%s", diff)
+	}
+	if got := Merge(nil, nil); got != nil {
+		t.Errorf("Merge(nil, nil) = %v, want nil", got)
+	}
+}
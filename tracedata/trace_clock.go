@@ -0,0 +1,120 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package trace
+
+import (
+	"math"
+	"math/bits"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+// clockUnitsByName maps the names of FTrace's trace clocks to the units of
+// their timestamps.
+var clockUnitsByName = map[string]eventpb.TraceClock_Units{
+	"":         eventpb.TraceClock_NANOSECONDS,
+	"local":    eventpb.TraceClock_NANOSECONDS,
+	"global":   eventpb.TraceClock_NANOSECONDS,
+	"perf":     eventpb.TraceClock_NANOSECONDS,
+	"mono":     eventpb.TraceClock_NANOSECONDS,
+	"mono_raw": eventpb.TraceClock_NANOSECONDS,
+	"boot":     eventpb.TraceClock_NANOSECONDS,
+	"tai":      eventpb.TraceClock_NANOSECONDS,
+	"x86-tsc":  eventpb.TraceClock_CYCLES,
+	"ppc-tb":   eventpb.TraceClock_CYCLES,
+	"counter":  eventpb.TraceClock_NOT_CONVERTIBLE,
+	"uptime":   eventpb.TraceClock_NOT_CONVERTIBLE,
+}
+
+// ClockUnits returns the units of the timestamps produced by the provided
+// trace clock.  If the clock's units are unspecified, they are inferred from
+// its name; clocks with unknown names are assumed not to be convertible to ns.
+// A nil clock produces ns.
+func ClockUnits(clock *eventpb.TraceClock) eventpb.TraceClock_Units {
+	if units := clock.GetUnits(); units != eventpb.TraceClock_UNSPECIFIED {
+		return units
+	}
+	if units, ok := clockUnitsByName[clock.GetName()]; ok {
+		return units
+	}
+	return eventpb.TraceClock_NOT_CONVERTIBLE
+}
+
+// cyclesToNs converts a timestamp in clock cycles to ns using the conversion
+// parameters of clock.  False is returned if the result doesn't fit in an
+// int64.
+func cyclesToNs(clock *eventpb.TraceClock, cycles uint64) (int64, bool) {
+	var ns uint64
+	if mult := clock.GetMult(); mult != 0 {
+		hi, lo := bits.Mul64(cycles, uint64(mult))
+		shift := clock.GetShift()
+		if shift >= 64 || (shift == 0 && hi != 0) || (shift > 0 && hi>>shift != 0) {
+			return 0, false
+		}
+		ns = lo >> shift
+		if shift > 0 {
+			ns |= hi << (64 - shift)
+		}
+	} else {
+		khz := clock.GetFrequencyKhz()
+		hi, lo := bits.Mul64(cycles, 1e6)
+		if hi >= khz {
+			return 0, false
+		}
+		ns, _ = bits.Div64(hi, lo, khz)
+	}
+	if ns > math.MaxInt64 {
+		return 0, false
+	}
+	return int64(ns), true
+}
+
+// ConvertTimestampsToNs converts the timestamps of the events in es to ns,
+// according to es's trace clock, and records that es's timestamps are in ns.
+// An error is returned, and es is left unmodified, if the trace clock's
+// timestamps cannot be converted to ns.
+func ConvertTimestampsToNs(es *eventpb.EventSet) error {
+	clock := es.GetTraceClock()
+	switch ClockUnits(clock) {
+	case eventpb.TraceClock_NANOSECONDS:
+		return nil
+	case eventpb.TraceClock_CYCLES:
+		if clock.GetMult() == 0 && clock.GetFrequencyKhz() == 0 {
+			return status.Errorf(codes.FailedPrecondition, "trace clock %q counts clock cycles, but the trace lacks the clock's frequency or mult and shift, so its timestamps cannot be converted to ns", clock.GetName())
+		}
+	default:
+		return status.Errorf(codes.FailedPrecondition, "trace clock %q produces timestamps that cannot be converted to ns; record the trace with a ns-based clock such as 'local' or 'global'", clock.GetName())
+	}
+	converted := make([]int64, len(es.Event))
+	for i, ev := range es.Event {
+		ns, ok := cyclesToNs(clock, uint64(ev.TimestampNs))
+		if !ok {
+			return status.Errorf(codes.OutOfRange, "timestamp %d of trace clock %q overflows when converted to ns", ev.TimestampNs, clock.GetName())
+		}
+		converted[i] = ns
+	}
+	for i, ev := range es.Event {
+		ev.TimestampNs = converted[i]
+	}
+	es.TraceClock = &eventpb.TraceClock{
+		Name:  clock.GetName(),
+		Units: eventpb.TraceClock_NANOSECONDS,
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package trace
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+func TestConvertTimestampsToNs(t *testing.T) {
+	tests := []struct {
+		description    string
+		clock          *eventpb.TraceClock
+		timestamps     []int64
+		wantTimestamps []int64
+		wantCode       codes.Code
+	}{{
+		description:    "no clock",
+		timestamps:     []int64{1000, 2000},
+		wantTimestamps: []int64{1000, 2000},
+	}, {
+		description:    "ns clock",
+		clock:          &eventpb.TraceClock{Name: "global"},
+		timestamps:     []int64{1000, 2000},
+		wantTimestamps: []int64{1000, 2000},
+	}, {
+		description: "tsc with frequency",
+		clock:       &eventpb.TraceClock{Name: "x86-tsc", FrequencyKhz: 2000000},
+		// A 2GHz clock ticks twice per ns.
+		timestamps:     []int64{1000, 2001, 1 << 62},
+		wantTimestamps: []int64{500, 1000, 1 << 61},
+	}, {
+		description: "tsc with mult and shift",
+		// (cycles * 3) >> 1, i.e. a clock that ticks 2/3rds of a time per ns.
+		clock:          &eventpb.TraceClock{Name: "x86-tsc", Mult: 3, Shift: 1},
+		timestamps:     []int64{1000, 1 << 62},
+		wantTimestamps: []int64{1500, 3 << 61},
+	}, {
+		description: "explicit units override the name",
+		clock:       &eventpb.TraceClock{Name: "my-clock", Units: eventpb.TraceClock_CYCLES, FrequencyKhz: 1000},
+		// A 1MHz clock ticks once per us.
+		timestamps:     []int64{3},
+		wantTimestamps: []int64{3000},
+	}, {
+		description: "overflow",
+		clock:       &eventpb.TraceClock{Name: "x86-tsc", FrequencyKhz: 1000},
+		timestamps:  []int64{1 << 62},
+		wantCode:    codes.OutOfRange,
+	}, {
+		description: "tsc without conversion parameters",
+		clock:       &eventpb.TraceClock{Name: "x86-tsc"},
+		timestamps:  []int64{1000},
+		wantCode:    codes.FailedPrecondition,
+	}, {
+		description: "counter",
+		clock:       &eventpb.TraceClock{Name: "counter"},
+		timestamps:  []int64{1000},
+		wantCode:    codes.FailedPrecondition,
+	}, {
+		description: "unknown clock",
+		clock:       &eventpb.TraceClock{Name: "sundial"},
+		timestamps:  []int64{1000},
+		wantCode:    codes.FailedPrecondition,
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			es := &eventpb.EventSet{TraceClock: test.clock}
+			for _, ts := range test.timestamps {
+				es.Event = append(es.Event, &eventpb.Event{TimestampNs: ts})
+			}
+			err := ConvertTimestampsToNs(es)
+			if status.Code(err) != test.wantCode {
+				t.Fatalf("ConvertTimestampsToNs() = %v, want code %s", err, test.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			var gotTimestamps []int64
+			for _, ev := range es.Event {
+				gotTimestamps = append(gotTimestamps, ev.TimestampNs)
+			}
+			if !reflect.DeepEqual(gotTimestamps, test.wantTimestamps) {
+				t.Errorf("ConvertTimestampsToNs() timestamps = %v, want %v", gotTimestamps, test.wantTimestamps)
+			}
+			if units := ClockUnits(es.GetTraceClock()); units != eventpb.TraceClock_NANOSECONDS {
+				t.Errorf("after ConvertTimestampsToNs(), clock units = %s, want NANOSECONDS", units)
+			}
+		})
+	}
+}
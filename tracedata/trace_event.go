@@ -35,9 +35,10 @@ import (
 // Timestamp describes a trace event timestamp.  Its units of this timestamp
 // depend on the trace clock specified during collection.  'local', the default
 // trace clock, and 'global' both generate ns-based timestamps, but some
-// clocks, such as 'x86_tsc', generate timestamps that are not in, but can be
+// clocks, such as 'x86-tsc', generate timestamps that are not in, but can be
 // converted to, ns, and some, such as 'counter', are in units that cannot be
-// converted to ns at all.
+// converted to ns at all.  ConvertTimestampsToNs uses an EventSet's
+// trace_clock to convert its timestamps to ns.
 type Timestamp int64
 
 // UnknownTimestamp represents an unspecified event timestamp.
@@ -101,6 +102,10 @@ func (ev Event) String() string {
 
 type options struct {
 	normalizationOffset Timestamp
+	// If set, used to look up the CPUs of events named in eventCPUsNames in the
+	// event index.
+	eventCPUs      EventCPUsFunc
+	eventCPUsNames []string
 }
 
 // NormalizationOffset specifies the timestamp offset to which all event
@@ -111,12 +116,59 @@ func NormalizationOffset(normalizationOffset Timestamp) func(o *options) {
 	}
 }
 
+// EventSource provides the events of a Collection.  Unlike an EventSet, an
+// EventSource need not hold all of its events in memory; it may read them on
+// demand, for instance from a columnar collection file.
+type EventSource interface {
+	// Header returns the string table, event descriptors, default loaders type
+	// and trace clock of the source's events.  Any events in the returned
+	// EventSet should be ignored.
+	Header() *eventpb.EventSet
+	// EventCount returns the number of events in the source.
+	EventCount() int
+	// Event returns the event at the provided index.  Events are in increasing
+	// timestamp order.  The returned Event must not be modified.
+	Event(index int) (*eventpb.Event, error)
+}
+
+// eventSetSource is an EventSource holding its events in an EventSet.
+type eventSetSource struct {
+	es *eventpb.EventSet
+}
+
+func (src eventSetSource) Header() *eventpb.EventSet {
+	return src.es
+}
+
+func (src eventSetSource) EventCount() int {
+	return len(src.es.Event)
+}
+
+func (src eventSetSource) Event(index int) (*eventpb.Event, error) {
+	if index < 0 || index >= len(src.es.Event) {
+		return nil, status.Errorf(codes.NotFound, "event %d not found", index)
+	}
+	return src.es.Event[index], nil
+}
+
 // NewCollection builds and returns a new trace.Collection based on the
 // tracepoint event set in es, or nil and an error if one could not be created.
 func NewCollection(es *eventpb.EventSet, opts ...func(o *options)) (*Collection, error) {
 	sort.Slice(es.Event, func(a, b int) bool {
 		return es.Event[a].TimestampNs < es.Event[b].TimestampNs
 	})
+	c, err := NewCollectionFromSource(eventSetSource{es}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.eventSet = es
+	return c, nil
+}
+
+// NewCollectionFromSource builds and returns a new trace.Collection reading
+// its events from src as they are needed, or nil and an error if one could not
+// be created.
+func NewCollectionFromSource(src EventSource, opts ...func(o *options)) (*Collection, error) {
 	o := &options{
 		normalizationOffset: 0,
 	}
@@ -124,8 +176,10 @@ func NewCollection(es *eventpb.EventSet, opts ...func(o *options)) (*Collection,
 		opt(o)
 	}
 	c := &Collection{
-		eventSet: es,
-		o:        o,
+		source: src,
+		header: src.Header(),
+		index:  &eventIndex{},
+		o:      o,
 	}
 	if err := c.init(); err != nil {
 		return nil, err
@@ -133,39 +187,67 @@ func NewCollection(es *eventpb.EventSet, opts ...func(o *options)) (*Collection,
 	return c, nil
 }
 
-// Collection provides convenience accessors for event traces stored in eventpb.EventSets.
+// Collection provides convenience accessors for event traces stored in
+// eventpb.EventSets, or read from other EventSources.
 type Collection struct {
-	o              *options
+	o      *options
+	source EventSource
+	header *eventpb.EventSet
+	// Secondary indices of the collection's events, built on first use.
+	index *eventIndex
+	// The EventSet holding the collection's events, if it was built from one.
 	eventSet       *eventpb.EventSet
 	startTimestamp Timestamp
 	endTimestamp   Timestamp
 }
 
-// RawEventSet returns the EventSet proto contained in this collection
+// RawEventSet returns the EventSet proto contained in this collection, or nil
+// if this collection reads its events from another EventSource.  Use Header
+// and RawEvent to access any collection's events in their stored form.
 func (tc Collection) RawEventSet() *eventpb.EventSet {
 	return tc.eventSet
 }
 
+// Header returns the string table, event descriptors, default loaders type and
+// trace clock of this collection's events.  Any events in the returned EventSet
+// should be ignored.
+func (tc Collection) Header() *eventpb.EventSet {
+	return tc.header
+}
+
+// RawEvent returns the event with the provided ID in the collection, in its
+// stored form.  Its timestamp is not normalized.  The returned Event must not
+// be modified.
+func (tc Collection) RawEvent(id int) (*eventpb.Event, error) {
+	if !tc.Valid() {
+		return nil, errors.New("invalid collection")
+	}
+	return tc.source.Event(id)
+}
+
 // eventDescriptorByID returns the EventDescriptor associated with the provided
 // ID, or nil if there is no such EventDescriptor.
 func (tc Collection) eventDescriptorByID(id int64) *eventpb.EventDescriptor {
-	if tc.eventSet == nil || id < 0 || id >= int64(len(tc.eventSet.EventDescriptor)) {
+	if tc.header == nil || id < 0 || id >= int64(len(tc.header.EventDescriptor)) {
 		return nil
 	}
-	return tc.eventSet.EventDescriptor[id]
+	return tc.header.EventDescriptor[id]
 }
 
 // stringByID returns the string table entry at the provided ID, or "<INVALID>"
 // if there is no such string.
 func (tc Collection) stringByID(id int64) string {
-	if tc.eventSet == nil || id < 0 || id >= int64(len(tc.eventSet.StringTable)) {
+	if tc.header == nil || id < 0 || id >= int64(len(tc.header.StringTable)) {
 		return "<INVALID>"
 	}
-	return tc.eventSet.StringTable[id]
+	return tc.header.StringTable[id]
 }
 
 // clear clears the Collection's state.
 func (tc *Collection) clear() {
+	tc.source = nil
+	tc.header = nil
+	tc.index = nil
 	tc.eventSet = nil
 	tc.startTimestamp = 0
 	tc.endTimestamp = 0
@@ -211,12 +293,15 @@ func (tc *Collection) init() error {
 
 // EventCount returns the number of events in the managed EventSet, or 0 if none is managed.
 func (tc Collection) EventCount() int {
-	return len(tc.eventSet.Event)
+	if tc.source == nil {
+		return 0
+	}
+	return tc.source.EventCount()
 }
 
 // Valid returns whether tc is a valid initialized Collection.
 func (tc Collection) Valid() bool {
-	return tc.eventSet != nil && tc.EventCount() > 0
+	return tc.source != nil && tc.EventCount() > 0
 }
 
 // Interval returns the first and last timestamps of the events present in
@@ -234,7 +319,10 @@ func (tc Collection) EventByIndex(id int) (*Event, error) {
 	if id < 0 || id >= tc.EventCount() {
 		return nil, status.Errorf(codes.NotFound, "event %d not found", id)
 	}
-	ev := tc.eventSet.Event[id]
+	ev, err := tc.source.Event(id)
+	if err != nil {
+		return nil, err
+	}
 	ed := tc.eventDescriptorByID(ev.EventDescriptor)
 	if ed == nil || len(ed.PropertyDescriptor) != len(ev.Property) {
 		pc := 0
@@ -270,7 +358,7 @@ func (tc Collection) EventNames() sort.StringSlice {
 		return nil
 	}
 	var ens sort.StringSlice
-	for _, ed := range tc.eventSet.EventDescriptor {
+	for _, ed := range tc.header.EventDescriptor {
 		ens = append(ens, tc.stringByID(ed.Name))
 	}
 	sort.Sort(ens)
@@ -0,0 +1,215 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package trace
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// EventCPUsFunc returns the CPUs with which the provided event is associated in
+// a Collection's event index.  Returning no CPUs leaves the event out of the
+// index.
+type EventCPUsFunc func(ev *Event) ([]int64, error)
+
+// IndexEventCPUs specifies that events with the provided names are associated,
+// in the collection's event index, with the CPUs returned by eventCPUs, rather
+// than with the CPU that reported them.  This determines which events
+// EventIndices returns when filtering by CPU.
+func IndexEventCPUs(eventCPUs EventCPUsFunc, eventNames ...string) func(o *options) {
+	return func(o *options) {
+		o.eventCPUs = eventCPUs
+		o.eventCPUsNames = eventNames
+	}
+}
+
+// eventIndex holds secondary indices of a Collection's unclipped events by CPU
+// and event type.  It is built on first use.
+type eventIndex struct {
+	once sync.Once
+	err  error
+	// The (normalized) timestamp of each event in the collection, by index.
+	timestamps []Timestamp
+	// For each CPU, and each event descriptor ID, the indices of the unclipped
+	// events of that type associated with that CPU, in increasing order.  As a
+	// collection's events are in increasing timestamp order, so are these.
+	indicesByCPUAndDescriptor map[int64]map[int64][]int
+}
+
+// buildIndex populates the receiver's event index.
+func (tc Collection) buildIndex() error {
+	idx := tc.index
+	idx.timestamps = make([]Timestamp, tc.EventCount())
+	idx.indicesByCPUAndDescriptor = make(map[int64]map[int64][]int)
+	lookupDescriptors := map[int64]struct{}{}
+	for _, name := range tc.o.eventCPUsNames {
+		for id, ed := range tc.header.EventDescriptor {
+			if tc.stringByID(ed.Name) == name {
+				lookupDescriptors[int64(id)] = struct{}{}
+			}
+		}
+	}
+	for index := range idx.timestamps {
+		ev, err := tc.source.Event(index)
+		if err != nil {
+			return err
+		}
+		idx.timestamps[index] = Timestamp(ev.TimestampNs) - tc.o.normalizationOffset
+		if ev.Clipped {
+			continue
+		}
+		cpus := []int64{ev.Cpu}
+		if _, ok := lookupDescriptors[ev.EventDescriptor]; ok && tc.o.eventCPUs != nil {
+			tev, err := tc.EventByIndex(index)
+			if err != nil {
+				return err
+			}
+			if cpus, err = tc.o.eventCPUs(tev); err != nil {
+				return err
+			}
+		}
+		for _, cpu := range cpus {
+			indicesByDescriptor, ok := idx.indicesByCPUAndDescriptor[cpu]
+			if !ok {
+				indicesByDescriptor = make(map[int64][]int)
+				idx.indicesByCPUAndDescriptor[cpu] = indicesByDescriptor
+			}
+			indices := indicesByDescriptor[ev.EventDescriptor]
+			// An event may be associated with the same CPU more than once.
+			if len(indices) > 0 && indices[len(indices)-1] == index {
+				continue
+			}
+			indicesByDescriptor[ev.EventDescriptor] = append(indices, index)
+		}
+	}
+	return nil
+}
+
+// EventIndices returns, in increasing order, the indices of the unclipped
+// events in the collection with timestamps between startTimestamp and
+// endTimestamp inclusive, which are associated with any of the provided CPUs
+// and have any of the provided event names.  An UnknownTimestamp start or end
+// leaves the time range open at that end, and empty cpus or eventNames match
+// all CPUs or event names.  Unknown CPUs and event names match no events.
+// The first call indexes the collection's events; after that, EventIndices
+// takes time proportional to the number of returned indices.
+func (tc Collection) EventIndices(startTimestamp, endTimestamp Timestamp, cpus []int64, eventNames []string) ([]int, error) {
+	if !tc.Valid() || tc.index == nil {
+		return nil, errors.New("invalid collection")
+	}
+	idx := tc.index
+	idx.once.Do(func() {
+		idx.err = tc.buildIndex()
+	})
+	if idx.err != nil {
+		return nil, idx.err
+	}
+	if len(cpus) == 0 {
+		for cpu := range idx.indicesByCPUAndDescriptor {
+			cpus = append(cpus, cpu)
+		}
+	}
+	var descriptors []int64
+	for _, name := range eventNames {
+		for id, ed := range tc.header.EventDescriptor {
+			if tc.stringByID(ed.Name) == name {
+				descriptors = append(descriptors, int64(id))
+			}
+		}
+	}
+	if len(eventNames) > 0 && len(descriptors) == 0 {
+		return []int{}, nil
+	}
+	ih := &indicesHeap{}
+	addIndices := func(indices []int) {
+		// Find the first index at or after startTimestamp, and the first after
+		// endTimestamp.
+		start, end := 0, len(indices)
+		if startTimestamp != UnknownTimestamp {
+			start = sort.Search(len(indices), func(i int) bool {
+				return idx.timestamps[indices[i]] >= startTimestamp
+			})
+		}
+		if endTimestamp != UnknownTimestamp {
+			end = sort.Search(len(indices), func(i int) bool {
+				return idx.timestamps[indices[i]] > endTimestamp
+			})
+		}
+		if start < end {
+			*ih = append(*ih, indices[start:end])
+		}
+	}
+	for _, cpu := range cpus {
+		indicesByDescriptor := idx.indicesByCPUAndDescriptor[cpu]
+		if len(eventNames) == 0 {
+			for _, indices := range indicesByDescriptor {
+				addIndices(indices)
+			}
+			continue
+		}
+		for _, descriptor := range descriptors {
+			addIndices(indicesByDescriptor[descriptor])
+		}
+	}
+	// Merge the selected index lists, removing any duplicates from events
+	// associated with several of the requested CPUs.
+	heap.Init(ih)
+	ret := []int{}
+	for ih.Len() > 0 {
+		indices := (*ih)[0]
+		if len(ret) == 0 || ret[len(ret)-1] != indices[0] {
+			ret = append(ret, indices[0])
+		}
+		if len(indices) > 1 {
+			(*ih)[0] = indices[1:]
+			heap.Fix(ih, 0)
+		} else {
+			heap.Pop(ih)
+		}
+	}
+	return ret, nil
+}
+
+// indicesHeap is a min-heap of nonempty, increasing, lists of event indices,
+// ordered by their first index.
+type indicesHeap [][]int
+
+func (ih indicesHeap) Len() int {
+	return len(ih)
+}
+
+func (ih indicesHeap) Less(a, b int) bool {
+	return ih[a][0] < ih[b][0]
+}
+
+func (ih indicesHeap) Swap(a, b int) {
+	ih[a], ih[b] = ih[b], ih[a]
+}
+
+func (ih *indicesHeap) Push(x interface{}) {
+	*ih = append(*ih, x.([]int))
+}
+
+func (ih *indicesHeap) Pop() interface{} {
+	old := *ih
+	n := len(old)
+	x := old[n-1]
+	*ih = old[:n-1]
+	return x
+}
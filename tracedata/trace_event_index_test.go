@@ -0,0 +1,126 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package trace
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/schedviz/tracedata/testeventsetbuilder"
+)
+
+func TestEventIndices(t *testing.T) {
+	es := testeventsetbuilder.TestProtobuf(t, b.TestClone(t).
+		WithEvent("event1", 0, 1000, false, 100, 200).
+		WithEvent("event2", 1, 1500, false, "thing1", "thing2").
+		WithEvent("event1", 1, 2000, true, 100, 400).
+		WithEvent("event3", 0, 2500, false, 1, "thing1", 150, "thing2").
+		WithEvent("event1", 2, 3000, false, 100, 600).
+		WithEvent("event2", 0, 3500, false, "thing3", "thing4").
+		WithEvent("event3", 2, 4000, false, 0, "thing1", 150, "thing2"))
+	// event3 is associated with the CPU in its numprop1 as well as its
+	// reporting CPU.
+	coll, err := NewCollection(es, IndexEventCPUs(func(ev *Event) ([]int64, error) {
+		return []int64{ev.CPU, ev.NumberProperties["numprop1"]}, nil
+	}, "event3"))
+	if err != nil {
+		t.Fatalf("NewCollection() returned unexpected error: %s", err)
+	}
+	tests := []struct {
+		description    string
+		startTimestamp Timestamp
+		endTimestamp   Timestamp
+		cpus           []int64
+		eventNames     []string
+		want           []int
+	}{{
+		description:    "all unclipped events",
+		startTimestamp: UnknownTimestamp,
+		endTimestamp:   UnknownTimestamp,
+		want:           []int{0, 1, 3, 4, 5, 6},
+	}, {
+		description:    "time range",
+		startTimestamp: 1500,
+		endTimestamp:   3000,
+		want:           []int{1, 3, 4},
+	}, {
+		description:    "open end",
+		startTimestamp: 3001,
+		endTimestamp:   UnknownTimestamp,
+		want:           []int{5, 6},
+	}, {
+		description:    "CPU",
+		startTimestamp: UnknownTimestamp,
+		endTimestamp:   UnknownTimestamp,
+		cpus:           []int64{1},
+		want:           []int{1, 3},
+	}, {
+		description:    "event associated twice with a CPU",
+		startTimestamp: UnknownTimestamp,
+		endTimestamp:   UnknownTimestamp,
+		cpus:           []int64{0, 2},
+		eventNames:     []string{"event3"},
+		want:           []int{3, 6},
+	}, {
+		description:    "CPUs and event names",
+		startTimestamp: UnknownTimestamp,
+		endTimestamp:   3000,
+		cpus:           []int64{0, 2},
+		eventNames:     []string{"event1", "event2"},
+		want:           []int{0, 4},
+	}, {
+		description:    "unknown event name",
+		startTimestamp: UnknownTimestamp,
+		endTimestamp:   UnknownTimestamp,
+		eventNames:     []string{"event4"},
+		want:           []int{},
+	}, {
+		description:    "unknown CPU",
+		startTimestamp: UnknownTimestamp,
+		endTimestamp:   UnknownTimestamp,
+		cpus:           []int64{3},
+		want:           []int{},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := coll.EventIndices(test.startTimestamp, test.endTimestamp, test.cpus, test.eventNames)
+			if err != nil {
+				t.Fatalf("EventIndices() returned unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("EventIndices() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEventIndices_LookupError(t *testing.T) {
+	lookupErr := errors.New("lookup failed")
+	coll, err := NewCollection(testeventsetbuilder.TestProtobuf(t, populatedBuilder(t)),
+		IndexEventCPUs(func(ev *Event) ([]int64, error) {
+			return nil, lookupErr
+		}, "event2"))
+	if err != nil {
+		t.Fatalf("NewCollection() returned unexpected error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := coll.EventIndices(UnknownTimestamp, UnknownTimestamp, nil, nil); err != lookupErr {
+			t.Errorf("EventIndices() returned error %v, want %v", err, lookupErr)
+		}
+	}
+}
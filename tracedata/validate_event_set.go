@@ -0,0 +1,113 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Binary validate_event_set is a command line tool to check an EventSet, or a
+// stored SchedViz collection, for problems that would keep it from loading or
+// make it load wrongly.  It prints a report of the problems found, and exits
+// with a nonzero status if any are errors.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/schedviz/tracedata/columnar"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/validation"
+)
+
+var (
+	inputPath         = flag.String("input_path", "", "Required. The file to check")
+	inputFormat       = flag.String("input_format", "proto", "Optional. Format of the input file. Can be \"proto\" or \"textproto\", for an EventSet, or \"collection\", for a collection file from a SchedViz storage path. Will use \"proto\" if not specified")
+	outputFormat      = flag.String("output_format", "text", "Optional. Format to print the report in. Can be \"text\" or \"json\". Will use \"text\" if not specified")
+	maxIssuesPerCheck = flag.Int("max_issues_per_check", validation.DefaultMaxIssuesPerCheck, "Optional. The maximum number of issues to report for each check; further issues are only counted")
+)
+
+func main() {
+	flag.Parse()
+
+	if *inputPath == "" {
+		log.Exit("input_path is required.")
+	}
+	es, topology, err := readEventSet(*inputPath)
+	if err != nil {
+		log.Exitf("Failed to read %s: %s", *inputPath, err)
+	}
+	report := validation.Validate(es, validation.Topology(topology), validation.MaxIssuesPerCheck(*maxIssuesPerCheck))
+	switch *outputFormat {
+	case "text":
+		fmt.Println(report)
+	case "json":
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Exitf("Failed to marshal report: %s", err)
+		}
+		fmt.Println(string(b))
+	default:
+		log.Exitf("Unknown output format %q", *outputFormat)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+// readEventSet reads the EventSet at the provided path, and, for collections,
+// the system topology it was recorded on.
+func readEventSet(path string) (*eventpb.EventSet, *eventpb.SystemTopology, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch *inputFormat {
+	case "proto":
+		es := &eventpb.EventSet{}
+		if err := proto.Unmarshal(b, es); err != nil {
+			return nil, nil, err
+		}
+		return es, nil, nil
+	case "textproto":
+		es := &eventpb.EventSet{}
+		if err := proto.UnmarshalText(string(b), es); err != nil {
+			return nil, nil, err
+		}
+		return es, nil, nil
+	case "collection":
+		if columnar.IsColumnar(b) {
+			r, err := columnar.NewReader(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				return nil, nil, err
+			}
+			es, err := r.EventSet()
+			if err != nil {
+				return nil, nil, err
+			}
+			return es, r.Collection().Topology, nil
+		}
+		collection := &eventpb.Collection{}
+		if err := proto.Unmarshal(b, collection); err != nil {
+			return nil, nil, err
+		}
+		return collection.EventSet, collection.Topology, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown input format %q", *inputFormat)
+	}
+}
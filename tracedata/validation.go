@@ -0,0 +1,467 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// Package validation checks eventpb.EventSets for problems that would keep
+// them from loading, or make them load wrongly, and reports what it finds.
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/clipping"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/tracedata/trace"
+)
+
+// Severity describes how serious an Issue is.
+type Severity int
+
+const (
+	// Warning issues may make an EventSet load wrongly, or are unusual.
+	Warning Severity = iota
+	// Error issues keep an EventSet from loading, or make it load wrongly.
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// MarshalText marshals a Severity as its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Check names the check that found an Issue.
+type Check string
+
+// The checks that Validate performs.
+const (
+	// StringTable checks that string table indices are within the string table.
+	StringTable Check = "string_table"
+	// EventDescriptors checks that events' descriptors exist, and that event
+	// and property names are unique.
+	EventDescriptors Check = "event_descriptors"
+	// PropertyCounts checks that events have as many properties as their
+	// descriptors.
+	PropertyCounts Check = "property_counts"
+	// Timestamps checks that timestamps are nonnegative, and increase on each
+	// CPU.
+	Timestamps Check = "timestamps"
+	// Clipping checks that clipped events are only at the start or end of the
+	// trace, or before a lost_events event, where clipping places them.
+	Clipping Check = "clipping"
+	// CPUs checks that CPUs, both those recording events and those in sched
+	// events' properties, are nonnegative and present in the system topology.
+	CPUs Check = "cpus"
+	// SchedFields checks that sched events have the properties analysis needs,
+	// and that their PIDs are valid.
+	SchedFields Check = "sched_fields"
+	// TraceClock checks that timestamps can be converted to ns.
+	TraceClock Check = "trace_clock"
+)
+
+// Issue describes a single problem found in an EventSet.
+type Issue struct {
+	Severity Severity `json:"severity"`
+	Check    Check    `json:"check"`
+	// The index of the event with the problem, or -1 if the problem isn't with a
+	// single event.
+	EventIndex int    `json:"eventIndex"`
+	Message    string `json:"message"`
+}
+
+func (i *Issue) String() string {
+	if i.EventIndex < 0 {
+		return fmt.Sprintf("%s [%s]: %s", i.Severity, i.Check, i.Message)
+	}
+	return fmt.Sprintf("%s [%s] event %d: %s", i.Severity, i.Check, i.EventIndex, i.Message)
+}
+
+// Report describes the problems found in an EventSet.
+type Report struct {
+	EventCount   int `json:"eventCount"`
+	ErrorCount   int `json:"errorCount"`
+	WarningCount int `json:"warningCount"`
+	// The number of issues each check found, including any omitted from Issues.
+	IssueCounts map[Check]int `json:"issueCounts"`
+	// The issues found, in the order they were found, up to the maximum number
+	// per check.
+	Issues []*Issue `json:"issues"`
+}
+
+// OK returns true if no errors were found.
+func (r *Report) OK() bool {
+	return r.ErrorCount == 0
+}
+
+// Err returns an InvalidArgument error describing the errors found, or nil if
+// none were.
+func (r *Report) Err() error {
+	if r.OK() {
+		return nil
+	}
+	var errs []string
+	for _, issue := range r.Issues {
+		if issue.Severity == Error {
+			errs = append(errs, issue.String())
+		}
+	}
+	if omitted := r.ErrorCount - len(errs); omitted > 0 {
+		errs = append(errs, fmt.Sprintf("and %d more errors", omitted))
+	}
+	return status.Errorf(codes.InvalidArgument, "invalid event set: %s", strings.Join(errs, "; "))
+}
+
+func (r *Report) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("%d events: %d errors, %d warnings", r.EventCount, r.ErrorCount, r.WarningCount))
+	for _, issue := range r.Issues {
+		lines = append(lines, issue.String())
+	}
+	var checks []string
+	for check := range r.IssueCounts {
+		checks = append(checks, string(check))
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		lines = append(lines, fmt.Sprintf("%s: %d issues", check, r.IssueCounts[Check(check)]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DefaultMaxIssuesPerCheck is the default maximum number of issues each check
+// adds to a Report.
+const DefaultMaxIssuesPerCheck = 20
+
+type options struct {
+	topology          *eventpb.SystemTopology
+	maxIssuesPerCheck int
+}
+
+// Option specifies an optional parameter to Validate.
+type Option func(o *options)
+
+// Topology specifies the system topology of the machine the EventSet was
+// recorded on, against which CPUs are checked.  If no topology, or an empty
+// one, is provided, CPUs are only checked to be nonnegative.
+func Topology(topology *eventpb.SystemTopology) Option {
+	return func(o *options) {
+		o.topology = topology
+	}
+}
+
+// MaxIssuesPerCheck specifies the maximum number of issues each check adds to
+// the Report.  Issues beyond these are only counted.
+func MaxIssuesPerCheck(n int) Option {
+	return func(o *options) {
+		o.maxIssuesPerCheck = n
+	}
+}
+
+// validator accumulates a Report.
+type validator struct {
+	es     *eventpb.EventSet
+	o      *options
+	report *Report
+	// The CPUs in the system topology, or nil if none was provided.
+	topologyCPUs map[int64]struct{}
+	// The index of each event descriptor's properties, by name, for those
+	// descriptors whose names are valid.
+	propertyIndices []map[string]int
+}
+
+func (v *validator) add(severity Severity, check Check, eventIndex int, format string, args ...interface{}) {
+	v.report.IssueCounts[check]++
+	if severity == Error {
+		v.report.ErrorCount++
+	} else {
+		v.report.WarningCount++
+	}
+	if v.report.IssueCounts[check] > v.o.maxIssuesPerCheck {
+		return
+	}
+	v.report.Issues = append(v.report.Issues, &Issue{
+		Severity:   severity,
+		Check:      check,
+		EventIndex: eventIndex,
+		Message:    fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks the provided EventSet, which is not modified, and returns a
+// Report describing any problems found.  Events need not be sorted.
+func Validate(es *eventpb.EventSet, opts ...Option) *Report {
+	o := &options{
+		maxIssuesPerCheck: DefaultMaxIssuesPerCheck,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	v := &validator{
+		es: es,
+		o:  o,
+		report: &Report{
+			EventCount:  len(es.GetEvent()),
+			IssueCounts: map[Check]int{},
+			Issues:      []*Issue{},
+		},
+	}
+	if cores := o.topology.GetLogicalCore(); len(cores) > 0 {
+		v.topologyCPUs = map[int64]struct{}{}
+		for _, lc := range cores {
+			v.topologyCPUs[int64(lc.GetCpuId())] = struct{}{}
+		}
+	}
+	v.checkTraceClock()
+	v.checkDescriptors()
+	v.checkEvents()
+	v.checkClipping()
+	return v.report
+}
+
+// stringByID returns the string table entry at the provided index, and whether
+// the index is valid.
+func (v *validator) stringByID(id int64) (string, bool) {
+	if id < 0 || id >= int64(len(v.es.GetStringTable())) {
+		return "", false
+	}
+	return v.es.StringTable[id], true
+}
+
+func (v *validator) checkTraceClock() {
+	clock := v.es.GetTraceClock()
+	switch trace.ClockUnits(clock) {
+	case eventpb.TraceClock_NANOSECONDS:
+	case eventpb.TraceClock_CYCLES:
+		if clock.GetMult() == 0 && clock.GetFrequencyKhz() == 0 {
+			v.add(Error, TraceClock, -1, "trace clock %q counts cycles, but has neither a frequency nor a mult and shift", clock.GetName())
+		}
+	default:
+		v.add(Error, TraceClock, -1, "trace clock %q produces timestamps that cannot be converted to ns", clock.GetName())
+	}
+}
+
+func (v *validator) checkDescriptors() {
+	v.propertyIndices = make([]map[string]int, len(v.es.GetEventDescriptor()))
+	descriptorsByName := map[string]int{}
+	for idx, ed := range v.es.GetEventDescriptor() {
+		name, ok := v.stringByID(ed.GetName())
+		if !ok {
+			v.add(Error, StringTable, -1, "event descriptor %d's name %d is not in the string table", idx, ed.GetName())
+			continue
+		}
+		if prev, ok := descriptorsByName[name]; ok && name != "" {
+			v.add(Error, EventDescriptors, -1, "event descriptors %d and %d are both named %q", prev, idx, name)
+		}
+		descriptorsByName[name] = idx
+		propertyIndices := map[string]int{}
+		for propIdx, pd := range ed.GetPropertyDescriptor() {
+			propName, ok := v.stringByID(pd.GetName())
+			if !ok {
+				v.add(Error, StringTable, -1, "property %d of event %q has name %d, which is not in the string table", propIdx, name, pd.GetName())
+				continue
+			}
+			if _, ok := propertyIndices[propName]; ok {
+				v.add(Warning, EventDescriptors, -1, "event %q has more than one property named %q", name, propName)
+			}
+			propertyIndices[propName] = propIdx
+		}
+		v.propertyIndices[idx] = propertyIndices
+	}
+}
+
+// eventName returns the name of the provided event's descriptor, and whether
+// the descriptor and its name are valid.
+func (v *validator) eventName(ev *eventpb.Event) (string, bool) {
+	ed := ev.GetEventDescriptor()
+	if ed < 0 || ed >= int64(len(v.es.GetEventDescriptor())) {
+		return "", false
+	}
+	return v.stringByID(v.es.EventDescriptor[ed].GetName())
+}
+
+func (v *validator) checkEvents() {
+	lastTimestampByCPU := map[int64]int64{}
+	for idx, ev := range v.es.GetEvent() {
+		if ev.GetTimestampNs() < 0 {
+			v.add(Error, Timestamps, idx, "negative timestamp %d", ev.GetTimestampNs())
+		}
+		if last, ok := lastTimestampByCPU[ev.GetCpu()]; ok && ev.GetTimestampNs() < last {
+			v.add(Warning, Timestamps, idx, "timestamp %d precedes that of the previous event on CPU %d, %d", ev.GetTimestampNs(), ev.GetCpu(), last)
+		}
+		lastTimestampByCPU[ev.GetCpu()] = ev.GetTimestampNs()
+		v.checkCPU(idx, "recording CPU", ev.GetCpu())
+
+		edIdx := ev.GetEventDescriptor()
+		if edIdx < 0 || edIdx >= int64(len(v.es.GetEventDescriptor())) {
+			v.add(Error, EventDescriptors, idx, "event descriptor %d does not exist", edIdx)
+			continue
+		}
+		ed := v.es.EventDescriptor[edIdx]
+		if len(ev.GetProperty()) != len(ed.GetPropertyDescriptor()) {
+			v.add(Error, PropertyCounts, idx, "has %d properties, but its descriptor has %d", len(ev.GetProperty()), len(ed.GetPropertyDescriptor()))
+			continue
+		}
+		for propIdx, pd := range ed.GetPropertyDescriptor() {
+			if pd.GetType() != eventpb.EventDescriptor_PropertyDescriptor_TEXT {
+				continue
+			}
+			if _, ok := v.stringByID(ev.Property[propIdx]); !ok {
+				v.add(Error, StringTable, idx, "text property %d has value %d, which is not in the string table", propIdx, ev.Property[propIdx])
+			}
+		}
+		if name, ok := v.eventName(ev); ok {
+			v.checkSchedFields(idx, name, ev)
+		}
+	}
+}
+
+// checkCPU checks that the provided CPU is nonnegative, and in the topology.
+func (v *validator) checkCPU(idx int, description string, cpu int64) {
+	if cpu < 0 {
+		v.add(Error, CPUs, idx, "%s %d is negative", description, cpu)
+		return
+	}
+	if v.topologyCPUs == nil {
+		return
+	}
+	if _, ok := v.topologyCPUs[cpu]; !ok {
+		v.add(Warning, CPUs, idx, "%s %d is not in the system topology", description, cpu)
+	}
+}
+
+// numberProperty returns the value of the named number property of ev, and
+// whether it has one.
+func (v *validator) numberProperty(ev *eventpb.Event, name string) (int64, bool) {
+	ed := v.es.EventDescriptor[ev.EventDescriptor]
+	propIdx, ok := v.propertyIndices[ev.EventDescriptor][name]
+	if !ok || ed.PropertyDescriptor[propIdx].GetType() != eventpb.EventDescriptor_PropertyDescriptor_NUMBER {
+		return 0, false
+	}
+	return ev.Property[propIdx], true
+}
+
+// checkSchedFields checks the properties of sched events that analysis uses.
+func (v *validator) checkSchedFields(idx int, name string, ev *eventpb.Event) {
+	// requireNumber returns the value of the named number property, adding an
+	// error if it is missing.
+	requireNumber := func(property string) (int64, bool) {
+		val, ok := v.numberProperty(ev, property)
+		if !ok {
+			v.add(Error, SchedFields, idx, "%s lacks number property %s", name, property)
+		}
+		return val, ok
+	}
+	checkPID := func(property string, allowIdle bool) (int64, bool) {
+		pid, ok := requireNumber(property)
+		if !ok {
+			return 0, false
+		}
+		if pid < 0 {
+			v.add(Error, SchedFields, idx, "%s has negative %s %d", name, property, pid)
+			return 0, false
+		}
+		if pid == 0 && !allowIdle {
+			v.add(Warning, SchedFields, idx, "%s has %s 0, the idle task", name, property)
+		}
+		return pid, true
+	}
+	checkCPU := func(property string) {
+		if cpu, ok := requireNumber(property); ok {
+			v.checkCPU(idx, name+" "+property, cpu)
+		}
+	}
+	switch name {
+	case "sched_switch":
+		prevPID, prevOK := checkPID("prev_pid", true /*=allowIdle*/)
+		nextPID, nextOK := checkPID("next_pid", true /*=allowIdle*/)
+		if prevOK && nextOK && prevPID == nextPID && prevPID != 0 {
+			v.add(Warning, SchedFields, idx, "sched_switch switches PID %d to itself", prevPID)
+		}
+		requireNumber("prev_state")
+	case "sched_wakeup", "sched_wakeup_new", "sched_waking":
+		checkPID("pid", false /*=allowIdle*/)
+		checkCPU("target_cpu")
+	case "sched_migrate_task":
+		checkPID("pid", false /*=allowIdle*/)
+		checkCPU("orig_cpu")
+		checkCPU("dest_cpu")
+	}
+}
+
+// checkClipping checks that clipped events are where clipping places them: at
+// the start or end of the trace, or in a window ending at a lost_events event.
+func (v *validator) checkClipping() {
+	events := v.es.GetEvent()
+	isLostEvents := func(ev *eventpb.Event) bool {
+		name, ok := v.eventName(ev)
+		return ok && name == clipping.LostEventsEventName
+	}
+	order := make([]int, len(events))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return events[order[a]].GetTimestampNs() < events[order[b]].GetTimestampNs()
+	})
+	// Find the first and last unclipped events.
+	first, last := -1, -1
+	for pos, idx := range order {
+		ev := events[idx]
+		if isLostEvents(ev) {
+			if ev.GetClipped() {
+				v.add(Warning, Clipping, idx, "lost_events events should not be clipped")
+			}
+			continue
+		}
+		if !ev.GetClipped() {
+			if first < 0 {
+				first = pos
+			}
+			last = pos
+		}
+	}
+	// Walk backwards, tracking whether the next unclipped event is a
+	// lost_events event.
+	nextIsLostEvents := false
+	for pos := len(order) - 1; pos >= 0; pos-- {
+		ev := events[order[pos]]
+		if isLostEvents(ev) {
+			nextIsLostEvents = true
+			continue
+		}
+		if !ev.GetClipped() {
+			nextIsLostEvents = false
+			continue
+		}
+		if pos < first || pos > last || nextIsLostEvents {
+			continue
+		}
+		v.add(Warning, Clipping, order[pos], "clipped event at %d is amid unclipped events, and not before a lost_events event", ev.GetTimestampNs())
+	}
+}
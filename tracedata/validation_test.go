@@ -0,0 +1,288 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/google/schedviz/tracedata/scenario"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+const validScenario = `
+1000 cpu0 switch prev=0:R next=100
+1010 cpu1 wakeup pid=200 target_cpu=1
+1020 cpu1 switch prev=0:R next=200
+1030 cpu0 migrate pid=200 orig_cpu=1 dest_cpu=0
+1040 cpu0 switch prev=100:S next=200
+`
+
+func parse(t *testing.T, s string) *eventpb.EventSet {
+	t.Helper()
+	es, err := scenario.Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("scenario.Parse() returned unexpected error: %s", err)
+	}
+	return es
+}
+
+// descriptorIndex returns the index of the named event descriptor in es.
+func descriptorIndex(t *testing.T, es *eventpb.EventSet, name string) int64 {
+	t.Helper()
+	for idx, ed := range es.EventDescriptor {
+		if es.StringTable[ed.Name] == name {
+			return int64(idx)
+		}
+	}
+	t.Fatalf("no event descriptor named %s", name)
+	return -1
+}
+
+func topology(cpus ...uint64) *eventpb.SystemTopology {
+	ret := &eventpb.SystemTopology{}
+	for _, cpu := range cpus {
+		ret.LogicalCore = append(ret.LogicalCore, &eventpb.SystemTopology_LogicalCore{CpuId: cpu})
+	}
+	return ret
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		description string
+		scenario    string
+		mutate      func(t *testing.T, es *eventpb.EventSet)
+		opts        []Option
+		want        []*Issue
+	}{{
+		description: "valid",
+		scenario:    validScenario,
+		opts:        []Option{Topology(topology(0, 1))},
+	}, {
+		description: "index bounds",
+		scenario:    validScenario,
+		mutate: func(t *testing.T, es *eventpb.EventSet) {
+			es.Event[0].EventDescriptor = 10
+			es.Event[1].Property = es.Event[1].Property[1:]
+			// Point sched_switch's prev_comm at a missing string.
+			es.Event[2].Property[0] = 1000
+			es.EventDescriptor[descriptorIndex(t, es, "sched_migrate_task")].Name = -1
+		},
+		want: []*Issue{{
+			Severity:   Error,
+			Check:      StringTable,
+			EventIndex: -1,
+			Message:    "event descriptor 2's name -1 is not in the string table",
+		}, {
+			Severity:   Error,
+			Check:      EventDescriptors,
+			EventIndex: 0,
+			Message:    "event descriptor 10 does not exist",
+		}, {
+			Severity:   Error,
+			Check:      PropertyCounts,
+			EventIndex: 1,
+			Message:    "has 3 properties, but its descriptor has 4",
+		}, {
+			Severity:   Error,
+			Check:      StringTable,
+			EventIndex: 2,
+			Message:    "text property 0 has value 1000, which is not in the string table",
+		}},
+	}, {
+		description: "duplicate event names",
+		scenario:    validScenario,
+		mutate: func(t *testing.T, es *eventpb.EventSet) {
+			es.EventDescriptor[descriptorIndex(t, es, "sched_wakeup")].Name = es.EventDescriptor[0].Name
+		},
+		want: []*Issue{{
+			Severity:   Error,
+			Check:      EventDescriptors,
+			EventIndex: -1,
+			Message:    `event descriptors 0 and 1 are both named "sched_switch"`,
+		}, {
+			Severity:   Error,
+			Check:      SchedFields,
+			EventIndex: 1,
+			Message:    "sched_switch lacks number property prev_pid",
+		}, {
+			Severity:   Error,
+			Check:      SchedFields,
+			EventIndex: 1,
+			Message:    "sched_switch lacks number property next_pid",
+		}, {
+			Severity:   Error,
+			Check:      SchedFields,
+			EventIndex: 1,
+			Message:    "sched_switch lacks number property prev_state",
+		}},
+	}, {
+		description: "timestamps",
+		scenario: validScenario + `
+1035 cpu0 print buf=late
+1050 cpu1 print buf=negative`,
+		mutate: func(t *testing.T, es *eventpb.EventSet) {
+			// Scenario events are sorted by timestamp, so reorder them here.
+			es.Event[4].TimestampNs = 1045
+			es.Event[6].TimestampNs = -5
+		},
+		want: []*Issue{{
+			Severity:   Warning,
+			Check:      Timestamps,
+			EventIndex: 5,
+			Message:    "timestamp 1040 precedes that of the previous event on CPU 0, 1045",
+		}, {
+			Severity:   Error,
+			Check:      Timestamps,
+			EventIndex: 6,
+			Message:    "negative timestamp -5",
+		}, {
+			Severity:   Warning,
+			Check:      Timestamps,
+			EventIndex: 6,
+			Message:    "timestamp -5 precedes that of the previous event on CPU 1, 1020",
+		}},
+	}, {
+		description: "sched fields and CPUs",
+		scenario: `
+1000 cpu0 switch prev=-1:R next=100
+1010 cpu1 wakeup pid=0 target_cpu=2
+1020 cpu2 switch prev=100:R next=100
+1030 cpu0 migrate pid=200 orig_cpu=-1 dest_cpu=0
+`,
+		opts: []Option{Topology(topology(0, 1))},
+		want: []*Issue{{
+			Severity:   Error,
+			Check:      SchedFields,
+			EventIndex: 0,
+			Message:    "sched_switch has negative prev_pid -1",
+		}, {
+			Severity:   Warning,
+			Check:      SchedFields,
+			EventIndex: 1,
+			Message:    "sched_wakeup has pid 0, the idle task",
+		}, {
+			Severity:   Warning,
+			Check:      CPUs,
+			EventIndex: 1,
+			Message:    "sched_wakeup target_cpu 2 is not in the system topology",
+		}, {
+			Severity:   Warning,
+			Check:      CPUs,
+			EventIndex: 2,
+			Message:    "recording CPU 2 is not in the system topology",
+		}, {
+			Severity:   Warning,
+			Check:      SchedFields,
+			EventIndex: 2,
+			Message:    "sched_switch switches PID 100 to itself",
+		}, {
+			Severity:   Error,
+			Check:      CPUs,
+			EventIndex: 3,
+			Message:    "sched_migrate_task orig_cpu -1 is negative",
+		}},
+	}, {
+		description: "clipping",
+		scenario: `
+event lost_events count:number
+900 cpu1 print clipped
+1000 cpu0 print
+1010 cpu0 print clipped
+1020 cpu0 print
+1030 cpu1 print clipped
+1040 cpu1 lost_events count=10
+1050 cpu0 print
+1060 cpu1 lost_events clipped
+1070 cpu0 print clipped
+`,
+		want: []*Issue{{
+			Severity:   Warning,
+			Check:      Clipping,
+			EventIndex: 7,
+			Message:    "lost_events events should not be clipped",
+		}, {
+			Severity:   Warning,
+			Check:      Clipping,
+			EventIndex: 2,
+			Message:    "clipped event at 1010 is amid unclipped events, and not before a lost_events event",
+		}},
+	}, {
+		description: "trace clock",
+		scenario:    validScenario,
+		mutate: func(t *testing.T, es *eventpb.EventSet) {
+			es.TraceClock = &eventpb.TraceClock{Name: "counter"}
+		},
+		want: []*Issue{{
+			Severity:   Error,
+			Check:      TraceClock,
+			EventIndex: -1,
+			Message:    `trace clock "counter" produces timestamps that cannot be converted to ns`,
+		}},
+	}}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			es := parse(t, test.scenario)
+			if test.mutate != nil {
+				test.mutate(t, es)
+			}
+			report := Validate(es, test.opts...)
+			want := test.want
+			if want == nil {
+				want = []*Issue{}
+			}
+			if diff := cmp.Diff(want, report.Issues); diff != "" {
+				t.Errorf("Validate() issues: Diff -want +got:\n%s", diff)
+			}
+			hasErrors := false
+			for _, issue := range want {
+				hasErrors = hasErrors || issue.Severity == Error
+			}
+			if report.OK() == hasErrors {
+				t.Errorf("Validate().OK() = %t, want %t", report.OK(), !hasErrors)
+			}
+			if err := report.Err(); hasErrors != (status.Code(err) == codes.InvalidArgument) {
+				t.Errorf("Validate().Err() = %v, want an InvalidArgument error: %t", err, hasErrors)
+			}
+		})
+	}
+}
+
+func TestValidate_MaxIssuesPerCheck(t *testing.T) {
+	es := parse(t, validScenario)
+	for _, ev := range es.Event {
+		ev.TimestampNs = -ev.TimestampNs
+	}
+	report := Validate(es, MaxIssuesPerCheck(2))
+	if len(report.Issues) != 2 {
+		t.Errorf("Validate() returned %d issues, want 2: %v", len(report.Issues), report.Issues)
+	}
+	// Each event's timestamp is negative, and, on each CPU, each but the first
+	// precedes the last.
+	wantCounts := map[Check]int{
+		Timestamps: 8,
+	}
+	if diff := cmp.Diff(wantCounts, report.IssueCounts); diff != "" {
+		t.Errorf("Validate() issue counts: Diff -want +got:\n%s", diff)
+	}
+	if report.ErrorCount != 5 || report.WarningCount != 3 {
+		t.Errorf("Validate() = %d errors and %d warnings, want 5 and 3", report.ErrorCount, report.WarningCount)
+	}
+}
@@ -47,6 +47,23 @@ type EventSetBuilder struct {
 	strTable             map[string]int64
 	overwrite            bool
 	overflowedCPUs       map[int64]struct{}
+	// Events recording that the kernel lost events. Their event descriptor is only added to the
+	// EventSet by Finalize, and only if there are any.
+	lostEvents []*pb.Event
+	// Events that don't pass the filter are dropped by AddTraceEvent. Nil keeps every event.
+	filter *EventFilter
+	// The lengths of the dynamic numeric arrays of each format, by format ID and field name.
+	dynamicArrays map[uint16]map[string]dynamicArrayLens
+}
+
+// dynamicArrayLens tracks the lengths of the arrays in a dynamic numeric array field. Each event
+// stores the length of its array, followed by its elements padded to the number of element
+// properties in the event descriptor. Finalize adds element properties for longer arrays.
+type dynamicArrayLens struct {
+	// described is the number of element properties in the event descriptor.
+	described int
+	// max is the length of the longest array added so far.
+	max int
 }
 
 // NewEventSetBuilder constructs a new builder for making EventSet proto
@@ -76,6 +93,7 @@ func NewEventSetBuilder(tp *TraceParser) *EventSetBuilder {
 		overwrite: true,
 		// The set of cpus that overflowed. This is used along with the overwrite field to decide which events need to be marked as clipped.
 		overflowedCPUs: make(map[int64]struct{}),
+		dynamicArrays:  make(map[uint16]map[string]dynamicArrayLens),
 	}
 	// Add an empty string to the start of the string table so that an omitted string
 	// (default value "") is in parity with an omitted string index (default value 0)
@@ -117,6 +135,12 @@ func (esb *EventSetBuilder) SetOverflowedCPUs(cpus map[int64]struct{}) {
 	esb.overflowedCPUs = cpus
 }
 
+// SetEventFilter configures the EventSetBuilder to drop the events that don't pass filter. This must
+// be called before any events are added.
+func (esb *EventSetBuilder) SetEventFilter(filter *EventFilter) {
+	esb.filter = filter
+}
+
 // SetDefaultEventLoadersType specifies the default event loaders that should
 // be used to interpret traces.
 func (esb *EventSetBuilder) SetDefaultEventLoadersType(elt elpb.LoadersType) {
@@ -127,7 +151,8 @@ func (esb *EventSetBuilder) SetDefaultEventLoadersType(elt elpb.LoadersType) {
 // by the EventSetBuilder.
 func (esb *EventSetBuilder) AddFormat(eFormat *EventFormat) {
 	esb.formats[eFormat.ID] = eFormat
-	fields := append(eFormat.Format.CommonFields, eFormat.Format.Fields...)
+	delete(esb.dynamicArrays, eFormat.ID)
+	fields := eFormat.Format.eventFields()
 
 	eventDescriptor := &pb.EventDescriptor{
 		Name: esb.addString(eFormat.Name),
@@ -135,20 +160,36 @@ func (esb *EventSetBuilder) AddFormat(eFormat *EventFormat) {
 
 	// For each field in the format, create a property in the event descriptor
 	for _, field := range fields {
+		if field.IsNumericArray() {
+			// Each element of a numeric array gets its own property.
+			for i := uint64(0); i < field.NumElements; i++ {
+				elemDescriptor := &pb.EventDescriptor_PropertyDescriptor{
+					Name: esb.addString(field.ElementName(i)),
+					Type: pb.EventDescriptor_PropertyDescriptor_NUMBER,
+				}
+				eventDescriptor.PropertyDescriptor = append(eventDescriptor.PropertyDescriptor, elemDescriptor)
+			}
+			continue
+		}
+		if field.IsDynamicNumericArray() {
+			// The length of a dynamic numeric array is stored under its name. Properties for its
+			// elements are added by Finalize, once the length of the longest array is known.
+			if esb.dynamicArrays[eFormat.ID] == nil {
+				esb.dynamicArrays[eFormat.ID] = make(map[string]dynamicArrayLens)
+			}
+			esb.dynamicArrays[eFormat.ID][field.Name] = dynamicArrayLens{}
+			eventDescriptor.PropertyDescriptor = append(eventDescriptor.PropertyDescriptor, &pb.EventDescriptor_PropertyDescriptor{
+				Name: esb.addString(field.Name),
+				Type: pb.EventDescriptor_PropertyDescriptor_NUMBER,
+			})
+			continue
+		}
 		propertyDescriptor := &pb.EventDescriptor_PropertyDescriptor{
 			Name: esb.addString(field.Name),
 			Type: convertProtoTypeToFieldType(field),
 		}
 
 		eventDescriptor.PropertyDescriptor = append(eventDescriptor.PropertyDescriptor, propertyDescriptor)
-
-		if field.IsDynamicArray {
-			dynArrDescriptor := &pb.EventDescriptor_PropertyDescriptor{
-				Name: esb.addString("__data_loc_" + field.Name),
-				Type: pb.EventDescriptor_PropertyDescriptor_TEXT,
-			}
-			eventDescriptor.PropertyDescriptor = append(eventDescriptor.PropertyDescriptor, dynArrDescriptor)
-		}
 	}
 
 	// Store the event descriptor in the bookkeeping data structures.
@@ -157,7 +198,19 @@ func (esb *EventSetBuilder) AddFormat(eFormat *EventFormat) {
 }
 
 // AddTraceEvent adds a new trace event to the EventSet being built by the EventSetBuilder.
+// Events that don't pass the builder's filter are dropped.
 func (esb *EventSetBuilder) AddTraceEvent(traceEvent *TraceEvent) error {
+	if !esb.filter.keepTraceEvent(traceEvent, esb.formats[traceEvent.FormatID]) {
+		return nil
+	}
+	if traceEvent.FormatID == LostEventsFormatID {
+		esb.lostEvents = append(esb.lostEvents, &pb.Event{
+			Cpu:         traceEvent.CPU,
+			TimestampNs: int64(traceEvent.Timestamp),
+			Property:    []int64{traceEvent.NumberProperties[clipping.LostEventsCountProperty]},
+		})
+		return nil
+	}
 	// Get the event descriptor
 	ed, ok := esb.eventDescriptorMap[traceEvent.FormatID]
 	if !ok {
@@ -170,7 +223,7 @@ func (esb *EventSetBuilder) AddTraceEvent(traceEvent *TraceEvent) error {
 	if !ok {
 		return fmt.Errorf("missing format definition for format %d", traceEvent.FormatID)
 	}
-	fields := append(eFormat.Format.CommonFields, eFormat.Format.Fields...)
+	fields := eFormat.Format.eventFields()
 
 	// Fetch properties out of the events and store them in the properties field of the proto.
 	// Each item in the properties array has a corresponding property descriptor in the event
@@ -178,14 +231,28 @@ func (esb *EventSetBuilder) AddTraceEvent(traceEvent *TraceEvent) error {
 	// descriptors, they won't be able to be correctly interpreted.
 	var properties []int64
 	for _, field := range fields {
-		if field.ProtoType == "string" {
+		if field.IsDynamicNumericArray() {
+			// If the field is a dynamic numeric array, store its length followed by its elements,
+			// padded to the number of element properties in the event descriptor.
+			vals := traceEvent.NumberListProperties[field.Name]
+			lens := esb.dynamicArrays[traceEvent.FormatID][field.Name]
+			properties = append(properties, int64(len(vals)))
+			properties = append(properties, vals...)
+			for i := len(vals); i < lens.described; i++ {
+				properties = append(properties, 0)
+			}
+			if len(vals) > lens.max {
+				lens.max = len(vals)
+				esb.dynamicArrays[traceEvent.FormatID][field.Name] = lens
+			}
+		} else if field.ProtoType == "string" {
 			// If the field is a string field, insert the value into the string table
 			// and store its index in the properties table
-			prop := traceEvent.TextProperties[field.Name]
-			properties = append(properties, esb.addString(prop))
-			if field.IsDynamicArray {
-				dynProp := traceEvent.TextProperties["__data_loc_"+field.Name]
-				properties = append(properties, esb.addString(dynProp))
+			properties = append(properties, esb.addString(traceEvent.TextProperties[field.Name]))
+		} else if field.IsNumericArray() {
+			// If the field is a numeric array, store each element in order.
+			for i := uint64(0); i < field.NumElements; i++ {
+				properties = append(properties, traceEvent.NumberProperties[field.ElementName(i)])
 			}
 		} else if field.ProtoType == "int64" {
 			// If the field is a number field, directly store the number in the properties table.
@@ -221,6 +288,8 @@ func (esb *EventSetBuilder) Clone() (*EventSetBuilder, error) {
 		eventDescriptorMap:   make(map[uint16]*pb.EventDescriptor),
 		eventDescriptorTable: make(map[*pb.EventDescriptor]int64),
 		strTable:             make(map[string]int64),
+		filter:               esb.filter,
+		dynamicArrays:        make(map[uint16]map[string]dynamicArrayLens),
 	}
 
 	for k, v := range esb.formats {
@@ -229,19 +298,27 @@ func (esb *EventSetBuilder) Clone() (*EventSetBuilder, error) {
 		newEsb.formats[k] = &format
 	}
 	for k, eventDescriptor := range esb.eventDescriptorMap {
-		// Make a copy of the event descriptor
-		clonedEventDescriptor, ok := proto.Clone(eventDescriptor).(*pb.EventDescriptor)
-		if !ok {
-			return nil, errors.New("failed to clone EventDescriptor")
-		}
+		// Use the copy of the event descriptor in the cloned EventSet, so that descriptors which
+		// grow, such as those with dynamic numeric arrays, stay in sync with it.
+		idx := esb.eventDescriptorTable[eventDescriptor]
+		clonedEventDescriptor := clonedEventSet.EventDescriptor[idx]
 
 		newEsb.eventDescriptorMap[k] = clonedEventDescriptor
 		// Set the corresponding eventDescriptorTable entry to the same number as before
-		newEsb.eventDescriptorTable[clonedEventDescriptor] = esb.eventDescriptorTable[eventDescriptor]
+		newEsb.eventDescriptorTable[clonedEventDescriptor] = idx
 	}
 	for k, v := range esb.strTable {
 		newEsb.strTable[k] = v
 	}
+	for _, event := range esb.lostEvents {
+		newEsb.lostEvents = append(newEsb.lostEvents, proto.Clone(event).(*pb.Event))
+	}
+	for k, arrays := range esb.dynamicArrays {
+		newEsb.dynamicArrays[k] = make(map[string]dynamicArrayLens)
+		for name, lens := range arrays {
+			newEsb.dynamicArrays[k][name] = lens
+		}
+	}
 
 	return &newEsb, nil
 }
@@ -251,15 +328,28 @@ func (esb *EventSetBuilder) Clone() (*EventSetBuilder, error) {
 // which events must be clipped until after all events are parsed, so this must
 // be called after parsing events.
 func (esb *EventSetBuilder) clip() error {
+	overflowedCPUs := esb.overflowedCPUs
+	if !esb.filter.IsEmpty() {
+		// The filter may have dropped every event of an overflowed CPU, in which case it can't
+		// contribute to clipping.
+		overflowedCPUs = make(map[int64]struct{})
+		for _, event := range esb.eventSet.Event {
+			if _, ok := esb.overflowedCPUs[event.Cpu]; ok {
+				overflowedCPUs[event.Cpu] = struct{}{}
+			}
+		}
+	}
 	if esb.overwrite {
-		return clipping.ClipFromStartOfTrace(esb.eventSet, esb.overflowedCPUs)
+		return clipping.ClipFromStartOfTrace(esb.eventSet, overflowedCPUs)
 	}
-	return clipping.ClipFromEndOfTrace(esb.eventSet, esb.overflowedCPUs)
+	return clipping.ClipFromEndOfTrace(esb.eventSet, overflowedCPUs)
 }
 
 // Finalize creates and returns the final event set. This method should only be called once after
 // all events and data have been processed.
 func (esb *EventSetBuilder) Finalize() (*pb.EventSet, error) {
+	esb.addDynamicArrayElements()
+	esb.addLostEvents()
 	events := esb.eventSet.Event
 	sort.Slice(events, func(i int, j int) bool {
 		return events[i].TimestampNs < events[j].TimestampNs
@@ -270,6 +360,115 @@ func (esb *EventSetBuilder) Finalize() (*pb.EventSet, error) {
 	return esb.eventSet, nil
 }
 
+// addDynamicArrayElements adds a property to the event descriptors for each element of the longest
+// dynamic numeric arrays added, and pads the elements of the shorter arrays to match.
+func (esb *EventSetBuilder) addDynamicArrayElements() {
+	// Perform a deterministic iteration, since new property names are added to the string table.
+	var ids []uint16
+	for id, arrays := range esb.dynamicArrays {
+		for _, lens := range arrays {
+			if lens.max > lens.described {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	grown := make(map[int64]uint16)
+	for _, id := range ids {
+		arrays := esb.dynamicArrays[id]
+		ed := esb.eventDescriptorMap[id]
+		var propertyDescriptors []*pb.EventDescriptor_PropertyDescriptor
+		pdIdx := 0
+		for _, field := range esb.formats[id].Format.eventFields() {
+			count := 1
+			if field.IsNumericArray() {
+				count = int(field.NumElements)
+			}
+			propertyDescriptors = append(propertyDescriptors, ed.PropertyDescriptor[pdIdx:pdIdx+count]...)
+			pdIdx += count
+			if !field.IsDynamicNumericArray() {
+				continue
+			}
+			lens := arrays[field.Name]
+			propertyDescriptors = append(propertyDescriptors, ed.PropertyDescriptor[pdIdx:pdIdx+lens.described]...)
+			pdIdx += lens.described
+			for i := lens.described; i < lens.max; i++ {
+				propertyDescriptors = append(propertyDescriptors, &pb.EventDescriptor_PropertyDescriptor{
+					Name: esb.addString(field.ElementName(uint64(i))),
+					Type: pb.EventDescriptor_PropertyDescriptor_NUMBER,
+				})
+			}
+		}
+		ed.PropertyDescriptor = propertyDescriptors
+		grown[esb.eventDescriptorTable[ed]] = id
+	}
+	for _, event := range esb.eventSet.Event {
+		id, ok := grown[event.EventDescriptor]
+		if !ok {
+			continue
+		}
+		arrays := esb.dynamicArrays[id]
+		var properties []int64
+		propIdx := 0
+		for _, field := range esb.formats[id].Format.eventFields() {
+			count := 1
+			if field.IsNumericArray() {
+				count = int(field.NumElements)
+			}
+			if field.IsDynamicNumericArray() {
+				// Arrays no longer than the described elements were padded by AddTraceEvent.
+				lens := arrays[field.Name]
+				numElements := int(event.Property[propIdx])
+				if numElements < lens.described {
+					numElements = lens.described
+				}
+				properties = append(properties, event.Property[propIdx:propIdx+1+numElements]...)
+				propIdx += 1 + numElements
+				for i := numElements; i < lens.max; i++ {
+					properties = append(properties, 0)
+				}
+				continue
+			}
+			properties = append(properties, event.Property[propIdx:propIdx+count]...)
+			propIdx += count
+		}
+		event.Property = properties
+	}
+	for _, id := range ids {
+		for name, lens := range esb.dynamicArrays[id] {
+			lens.described = lens.max
+			esb.dynamicArrays[id][name] = lens
+		}
+	}
+}
+
+// addLostEvents adds the lost_events event descriptor, and the events recording lost events, to
+// the EventSet. Nothing is added if no events were lost, so that the EventSets of traces without
+// lost events are unaffected.
+func (esb *EventSetBuilder) addLostEvents() {
+	if len(esb.lostEvents) == 0 {
+		return
+	}
+	eventDescriptor := &pb.EventDescriptor{
+		Name: esb.addString(clipping.LostEventsEventName),
+		PropertyDescriptor: []*pb.EventDescriptor_PropertyDescriptor{
+			{
+				Name: esb.addString(clipping.LostEventsCountProperty),
+				Type: pb.EventDescriptor_PropertyDescriptor_NUMBER,
+			},
+		},
+	}
+	edIndex := esb.addEventDescriptor(eventDescriptor)
+	for _, event := range esb.lostEvents {
+		event.EventDescriptor = edIndex
+		esb.eventSet.Event = append(esb.eventSet.Event, event)
+	}
+	esb.lostEvents = nil
+}
+
 // convertProtoTypeToFieldType converts TraceEvent's ProtoType into the proto's FieldType enum
 func convertProtoTypeToFieldType(field *FormatField) pb.EventDescriptor_PropertyDescriptor_FieldType {
 	switch field.ProtoType {
@@ -61,7 +61,7 @@ func testEventSetBuilder() *EventSetBuilder {
 						{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true, IsDynamicArray: false},
 					},
 					Fields: []*FormatField{
-						{FieldType: "__data_loc uint8[] event", Name: "event", ProtoType: "string", Offset: 8, Size: 4, NumElements: 1, ElementSize: 4, Signed: false, IsDynamicArray: true},
+						{FieldType: "__data_loc uint8[] event", Name: "event", ProtoType: "string", Offset: 8, Size: 4, NumElements: 1, ElementSize: 1, Signed: false, IsDynamicArray: true},
 					},
 				},
 			},
@@ -76,8 +76,9 @@ var traceEvents = []*TraceEvent{
 		TextProperties: map[string]string{
 			"common_flags":         "\x01",
 			"common_preempt_count": "",
-			"__data_loc_event":     "def",
-			"event":                "abc",
+		},
+		NumberListProperties: map[string][]int64{
+			"event": {1, 2, 3},
 		},
 		NumberProperties: map[string]int64{
 			"common_pid": 0,
@@ -148,7 +149,7 @@ var traceEvents = []*TraceEvent{
 }
 
 var eventSet = &pb.EventSet{
-	StringTable: []string{"", "sched_switch", "common_type", "common_flags", "common_preempt_count", "common_pid", "prev_comm", "prev_pid", "prev_prio", "prev_state", "next_comm", "next_pid", "next_prio", "special_event", "event", "__data_loc_event", "\x01", "abc", "def", "swapper/0", "cat e.sh minal-"},
+	StringTable: []string{"", "sched_switch", "common_type", "common_flags", "common_preempt_count", "common_pid", "prev_comm", "prev_pid", "prev_prio", "prev_state", "next_comm", "next_pid", "next_prio", "special_event", "event", "\x01", "swapper/0", "cat e.sh minal-", "event[0]", "event[1]", "event[2]"},
 	EventDescriptor: []*pb.EventDescriptor{
 		{
 			Name: 1,
@@ -173,8 +174,10 @@ var eventSet = &pb.EventSet{
 				{Name: 3, Type: pb.EventDescriptor_PropertyDescriptor_TEXT},
 				{Name: 4, Type: pb.EventDescriptor_PropertyDescriptor_TEXT},
 				{Name: 5, Type: pb.EventDescriptor_PropertyDescriptor_NUMBER},
-				{Name: 14, Type: pb.EventDescriptor_PropertyDescriptor_TEXT},
-				{Name: 15, Type: pb.EventDescriptor_PropertyDescriptor_TEXT},
+				{Name: 14, Type: pb.EventDescriptor_PropertyDescriptor_NUMBER},
+				{Name: 18, Type: pb.EventDescriptor_PropertyDescriptor_NUMBER},
+				{Name: 19, Type: pb.EventDescriptor_PropertyDescriptor_NUMBER},
+				{Name: 20, Type: pb.EventDescriptor_PropertyDescriptor_NUMBER},
 			},
 		},
 	},
@@ -184,28 +187,28 @@ var eventSet = &pb.EventSet{
 			Cpu:             1,
 			TimestampNs:     1040483711613818,
 			Clipped:         false,
-			Property:        []int64{0, 16, 0, 0, 17, 18},
+			Property:        []int64{0, 15, 0, 0, 3, 1, 2, 3},
 		},
 		{
 			EventDescriptor: 0,
 			Cpu:             0,
 			TimestampNs:     1040483711613819,
 			Clipped:         false,
-			Property:        []int64{0, 16, 0, 0, 19, 0, 120, 0, 20, 166549, 120},
+			Property:        []int64{0, 15, 0, 0, 16, 0, 120, 0, 17, 166549, 120},
 		},
 		{
 			EventDescriptor: 0,
 			Cpu:             0,
 			TimestampNs:     1040483711630169,
 			Clipped:         false,
-			Property:        []int64{0, 16, 0, 166549, 20, 166549, 120, 1, 19, 0, 120},
+			Property:        []int64{0, 15, 0, 166549, 17, 166549, 120, 1, 16, 0, 120},
 		},
 		{
 			EventDescriptor: 0,
 			Cpu:             1,
 			TimestampNs:     1040483711647349,
 			Clipped:         false,
-			Property:        []int64{0, 16, 0, 0, 19, 0, 120, 0, 20, 166549, 120},
+			Property:        []int64{0, 15, 0, 0, 16, 0, 120, 0, 17, 166549, 120},
 		},
 	},
 }
@@ -321,3 +324,118 @@ func TestEventSetBuilder_Clone(t *testing.T) {
 		t.Fatalf("TestEventSetBuilder_Clone: Diff -want +got:\n%s", diff)
 	}
 }
+
+func TestEventSetBuilder_NumericArray(t *testing.T) {
+	esb := NewEventSetBuilder(&TraceParser{
+		Formats: map[uint16]*EventFormat{
+			7: {
+				Name: "array_event",
+				ID:   7,
+				Format: Format{
+					CommonFields: []*FormatField{
+						{FieldType: "unsigned short common_type", Name: "common_type", ProtoType: "int64", Size: 2, NumElements: 1, ElementSize: 2},
+					},
+					Fields: []*FormatField{
+						{FieldType: "int foo[3]", Name: "foo", ProtoType: "int64", Offset: 4, Size: 12, NumElements: 3, ElementSize: 4, Signed: true},
+					},
+				},
+			},
+		},
+	})
+	if err := esb.AddTraceEvent(&TraceEvent{
+		Timestamp:        100,
+		NumberProperties: map[string]int64{"common_type": 7, "foo[0]": -1, "foo[1]": 0, "foo[2]": 42},
+		FormatID:         7,
+	}); err != nil {
+		t.Fatalf("error in AddTraceEvent: %s", err)
+	}
+	got, err := esb.Finalize()
+	if err != nil {
+		t.Fatalf("unexpected error finalizing events: %s", err)
+	}
+
+	var gotNames []string
+	for _, pd := range got.EventDescriptor[0].PropertyDescriptor {
+		gotNames = append(gotNames, got.StringTable[pd.Name])
+	}
+	if diff := cmp.Diff([]string{"common_type", "foo[0]", "foo[1]", "foo[2]"}, gotNames); diff != "" {
+		t.Fatalf("property names: Diff -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff([]int64{7, -1, 0, 42}, got.Event[0].Property); diff != "" {
+		t.Fatalf("properties: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestEventSetBuilder_DynamicNumericArray(t *testing.T) {
+	esb := NewEventSetBuilder(&TraceParser{
+		Formats: map[uint16]*EventFormat{
+			7: {
+				Name: "dyn_event",
+				ID:   7,
+				Format: Format{
+					CommonFields: []*FormatField{
+						{FieldType: "unsigned short common_type", Name: "common_type", ProtoType: "int64", Size: 2, NumElements: 1, ElementSize: 2},
+					},
+					Fields: []*FormatField{
+						{FieldType: "__data_loc u16[] ids", Name: "ids", ProtoType: "string", Offset: 4, Size: 4, NumElements: 1, ElementSize: 2, IsDynamicArray: true},
+						{FieldType: "int foo", Name: "foo", ProtoType: "int64", Offset: 8, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+					},
+				},
+			},
+		},
+	})
+	addEvent := func(esb *EventSetBuilder, ts uint64, ids []int64) {
+		if err := esb.AddTraceEvent(&TraceEvent{
+			Timestamp:            ts,
+			NumberProperties:     map[string]int64{"common_type": 7, "foo": 42},
+			NumberListProperties: map[string][]int64{"ids": ids},
+			FormatID:             7,
+		}); err != nil {
+			t.Fatalf("error in AddTraceEvent: %s", err)
+		}
+	}
+	check := func(es *pb.EventSet, wantNames []string, wantProperties [][]int64) {
+		var gotNames []string
+		for _, pd := range es.EventDescriptor[0].PropertyDescriptor {
+			if pd.Type != pb.EventDescriptor_PropertyDescriptor_NUMBER {
+				t.Errorf("property %q is %s, want NUMBER", es.StringTable[pd.Name], pd.Type)
+			}
+			gotNames = append(gotNames, es.StringTable[pd.Name])
+		}
+		if diff := cmp.Diff(wantNames, gotNames); diff != "" {
+			t.Fatalf("property names: Diff -want +got:\n%s", diff)
+		}
+		var gotProperties [][]int64
+		for _, ev := range es.Event {
+			gotProperties = append(gotProperties, ev.Property)
+		}
+		if diff := cmp.Diff(wantProperties, gotProperties); diff != "" {
+			t.Fatalf("properties: Diff -want +got:\n%s", diff)
+		}
+	}
+
+	addEvent(esb, 100, []int64{1, 2})
+	addEvent(esb, 200, nil)
+	original, err := esb.Finalize()
+	if err != nil {
+		t.Fatalf("unexpected error finalizing events: %s", err)
+	}
+	check(original,
+		[]string{"common_type", "ids", "ids[0]", "ids[1]", "foo"},
+		[][]int64{{7, 2, 1, 2, 42}, {7, 0, 0, 0, 42}})
+
+	// Arrays added after the EventSet was finalized are padded to, or grow, the existing elements.
+	clonedEsb, err := esb.Clone()
+	if err != nil {
+		t.Fatalf("error cloning event set builder: %s", err)
+	}
+	addEvent(clonedEsb, 300, []int64{3})
+	addEvent(clonedEsb, 400, []int64{4, 5, 6})
+	cloned, err := clonedEsb.Finalize()
+	if err != nil {
+		t.Fatalf("unexpected error finalizing events: %s", err)
+	}
+	check(cloned,
+		[]string{"common_type", "ids", "ids[0]", "ids[1]", "ids[2]", "foo"},
+		[][]int64{{7, 2, 1, 2, 0, 42}, {7, 0, 0, 0, 0, 42}, {7, 1, 3, 0, 0, 42}, {7, 3, 4, 5, 6, 42}})
+}
@@ -0,0 +1,159 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/schedviz/tracedata/clipping"
+)
+
+// EventFilter selects which events are kept while a trace is parsed. Events that are not kept are
+// dropped before they are added to an EventSet, so none of their strings reach its string table.
+// The zero EventFilter keeps every event.
+type EventFilter struct {
+	// If nonzero, events recorded before StartTimestamp are dropped.
+	// Timestamps are in the units of the trace's clock, as recorded in the raw trace.
+	StartTimestamp uint64
+	// If nonzero, events recorded after EndTimestamp are dropped.
+	EndTimestamp uint64
+	// If nonempty, only events with these names are kept.
+	// Events recording that the kernel lost events are never dropped by name, since without them
+	// the kept events can't be clipped around the loss.
+	IncludedEvents map[string]struct{}
+	// Events with these names are dropped.
+	ExcludedEvents map[string]struct{}
+	// If nonempty, only events recorded on these CPUs are kept.
+	CPUs map[int64]struct{}
+}
+
+// NewEventFilter creates an EventFilter from lists of event names and CPUs. Nil or empty lists
+// don't filter anything.
+func NewEventFilter(startTimestamp, endTimestamp uint64, includedEvents, excludedEvents []string, cpus []int64) (*EventFilter, error) {
+	if endTimestamp != 0 && endTimestamp < startTimestamp {
+		return nil, fmt.Errorf("end timestamp %d is before start timestamp %d", endTimestamp, startTimestamp)
+	}
+	f := &EventFilter{
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+	}
+	if len(includedEvents) > 0 {
+		f.IncludedEvents = stringSet(includedEvents)
+	}
+	if len(excludedEvents) > 0 {
+		f.ExcludedEvents = stringSet(excludedEvents)
+	}
+	if len(cpus) > 0 {
+		f.CPUs = make(map[int64]struct{})
+		for _, cpu := range cpus {
+			f.CPUs[cpu] = struct{}{}
+		}
+	}
+	return f, nil
+}
+
+// ParseEventNameList splits a comma-separated list of event names, i.e. "sched_switch,sched_wakeup".
+func ParseEventNameList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ParseCPUList parses a comma-separated list of CPUs and CPU ranges, i.e. "0-3,8".
+func ParseCPUList(list string) ([]int64, error) {
+	var cpus []int64
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		bounds := strings.SplitN(item, "-", 2)
+		first, err := strconv.ParseInt(bounds[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad CPU %q in CPU list. caused by: %s", item, err)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.ParseInt(bounds[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("bad CPU range %q in CPU list. caused by: %s", item, err)
+			}
+		}
+		if first < 0 || last < first {
+			return nil, fmt.Errorf("bad CPU range %q in CPU list", item)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+func stringSet(strs []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(strs))
+	for _, str := range strs {
+		set[str] = struct{}{}
+	}
+	return set
+}
+
+// IsEmpty returns true if f keeps every event. A nil EventFilter is empty.
+func (f *EventFilter) IsEmpty() bool {
+	return f == nil || (f.StartTimestamp == 0 && f.EndTimestamp == 0 && len(f.IncludedEvents) == 0 &&
+		len(f.ExcludedEvents) == 0 && len(f.CPUs) == 0)
+}
+
+// Keep returns true if an event with the provided name, recorded on the provided CPU at the
+// provided timestamp, passes the filter. A nil EventFilter keeps every event.
+func (f *EventFilter) Keep(name string, cpu int64, timestamp uint64) bool {
+	if f == nil {
+		return true
+	}
+	if timestamp < f.StartTimestamp || (f.EndTimestamp != 0 && timestamp > f.EndTimestamp) {
+		return false
+	}
+	if len(f.CPUs) > 0 {
+		if _, ok := f.CPUs[cpu]; !ok {
+			return false
+		}
+	}
+	if name == clipping.LostEventsEventName {
+		return true
+	}
+	if len(f.IncludedEvents) > 0 {
+		if _, ok := f.IncludedEvents[name]; !ok {
+			return false
+		}
+	}
+	_, excluded := f.ExcludedEvents[name]
+	return !excluded
+}
+
+// keepTraceEvent returns true if traceEvent, whose format is eFormat, passes the filter. Events
+// recording that the kernel lost events are named lost_events.
+func (f *EventFilter) keepTraceEvent(traceEvent *TraceEvent, eFormat *EventFormat) bool {
+	name := clipping.LostEventsEventName
+	if traceEvent.FormatID != LostEventsFormatID && eFormat != nil {
+		name = eFormat.Name
+	}
+	return f.Keep(name, traceEvent.CPU, traceEvent.Timestamp)
+}
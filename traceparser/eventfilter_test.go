@@ -0,0 +1,177 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func mustNewEventFilter(t *testing.T, start, end uint64, included, excluded []string, cpus []int64) *EventFilter {
+	t.Helper()
+	filter, err := NewEventFilter(start, end, included, excluded, cpus)
+	if err != nil {
+		t.Fatalf("unexpected error creating event filter: %s", err)
+	}
+	return filter
+}
+
+func TestEventFilter_Keep(t *testing.T) {
+	tests := []struct {
+		description string
+		filter      *EventFilter
+		name        string
+		cpu         int64
+		timestamp   uint64
+		want        bool
+	}{
+		{description: "nil filter", name: "sched_switch", timestamp: 10, want: true},
+		{description: "empty filter", filter: mustNewEventFilter(t, 0, 0, nil, nil, nil), name: "sched_switch", timestamp: 10, want: true},
+		{description: "before start", filter: mustNewEventFilter(t, 10, 20, nil, nil, nil), name: "sched_switch", timestamp: 9, want: false},
+		{description: "at start", filter: mustNewEventFilter(t, 10, 20, nil, nil, nil), name: "sched_switch", timestamp: 10, want: true},
+		{description: "at end", filter: mustNewEventFilter(t, 10, 20, nil, nil, nil), name: "sched_switch", timestamp: 20, want: true},
+		{description: "after end", filter: mustNewEventFilter(t, 10, 20, nil, nil, nil), name: "sched_switch", timestamp: 21, want: false},
+		{description: "no end", filter: mustNewEventFilter(t, 10, 0, nil, nil, nil), name: "sched_switch", timestamp: 1 << 62, want: true},
+		{description: "included", filter: mustNewEventFilter(t, 0, 0, []string{"sched_switch"}, nil, nil), name: "sched_switch", want: true},
+		{description: "not included", filter: mustNewEventFilter(t, 0, 0, []string{"sched_switch"}, nil, nil), name: "sched_wakeup", want: false},
+		{description: "excluded", filter: mustNewEventFilter(t, 0, 0, nil, []string{"sched_wakeup"}, nil), name: "sched_wakeup", want: false},
+		{description: "included and excluded", filter: mustNewEventFilter(t, 0, 0, []string{"sched_wakeup"}, []string{"sched_wakeup"}, nil), name: "sched_wakeup", want: false},
+		{description: "lost events not included", filter: mustNewEventFilter(t, 0, 0, []string{"sched_switch"}, nil, nil), name: "lost_events", want: true},
+		{description: "lost events excluded", filter: mustNewEventFilter(t, 0, 0, nil, []string{"lost_events"}, nil), name: "lost_events", want: true},
+		{description: "lost events after end", filter: mustNewEventFilter(t, 10, 20, []string{"sched_switch"}, nil, nil), name: "lost_events", timestamp: 21, want: false},
+		{description: "lost events on other CPU", filter: mustNewEventFilter(t, 0, 0, nil, nil, []int64{1, 3}), name: "lost_events", cpu: 2, want: false},
+		{description: "CPU in set", filter: mustNewEventFilter(t, 0, 0, nil, nil, []int64{1, 3}), name: "sched_switch", cpu: 3, want: true},
+		{description: "CPU not in set", filter: mustNewEventFilter(t, 0, 0, nil, nil, []int64{1, 3}), name: "sched_switch", cpu: 2, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := test.filter.Keep(test.name, test.cpu, test.timestamp); got != test.want {
+				t.Errorf("Keep(%q, %d, %d) = %t, want %t", test.name, test.cpu, test.timestamp, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewEventFilter_EndBeforeStart(t *testing.T) {
+	if _, err := NewEventFilter(20, 10, nil, nil, nil); err == nil {
+		t.Fatal("expected an error creating an event filter that ends before it starts")
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []int64
+		wantErr bool
+	}{
+		{list: "", want: nil},
+		{list: "3", want: []int64{3}},
+		{list: "0-3, 8", want: []int64{0, 1, 2, 3, 8}},
+		{list: "3-1", wantErr: true},
+		{list: "a", wantErr: true},
+		{list: "-1", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseCPUList(test.list)
+		if (err != nil) != test.wantErr {
+			t.Fatalf("ParseCPUList(%q) returned error %v, want error: %t", test.list, err, test.wantErr)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("ParseCPUList(%q): Diff -want +got:\n%s", test.list, diff)
+		}
+	}
+}
+
+func TestEventSetBuilder_EventFilter(t *testing.T) {
+	tests := []struct {
+		description    string
+		filter         *EventFilter
+		wantTimestamps []int64
+	}{
+		{
+			description:    "time window",
+			filter:         mustNewEventFilter(t, 1040483711613819, 1040483711630169, nil, nil, nil),
+			wantTimestamps: []int64{1040483711613819, 1040483711630169},
+		},
+		{
+			description:    "included events",
+			filter:         mustNewEventFilter(t, 0, 0, []string{"sched_switch"}, nil, nil),
+			wantTimestamps: []int64{1040483711613819, 1040483711630169, 1040483711647349},
+		},
+		{
+			description:    "excluded events",
+			filter:         mustNewEventFilter(t, 0, 0, nil, []string{"sched_switch"}, nil),
+			wantTimestamps: []int64{1040483711613818},
+		},
+		{
+			description:    "CPUs",
+			filter:         mustNewEventFilter(t, 0, 0, nil, nil, []int64{0}),
+			wantTimestamps: []int64{1040483711613819, 1040483711630169},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			esb := testEventSetBuilder()
+			esb.SetEventFilter(test.filter)
+			for _, traceEvent := range traceEvents {
+				if err := esb.AddTraceEvent(traceEvent); err != nil {
+					t.Fatalf("error in AddTraceEvent: %s", err)
+				}
+			}
+			got, err := esb.Finalize()
+			if err != nil {
+				t.Fatalf("unexpected error finalizing events: %s", err)
+			}
+			var gotTimestamps []int64
+			for _, event := range got.Event {
+				gotTimestamps = append(gotTimestamps, event.TimestampNs)
+			}
+			if diff := cmp.Diff(test.wantTimestamps, gotTimestamps); diff != "" {
+				t.Fatalf("event timestamps: Diff -want +got:\n%s", diff)
+			}
+			// The strings of dropped events must not be added to the string table.
+			hasSpecialEvent := test.wantTimestamps[0] == 1040483711613818
+			for _, str := range got.StringTable {
+				if str == "1,2,3" && !hasSpecialEvent {
+					t.Errorf("string table contains %q, a property of a dropped event", str)
+				}
+			}
+		})
+	}
+}
+
+func TestTextTraceParser_EventFilter(t *testing.T) {
+	ttp := NewTextTraceParser(nil)
+	ttp.SetEventFilter(mustNewEventFilter(t, 0, 0, []string{"sched_switch"}, nil, nil))
+	got, err := ttp.Parse(strings.NewReader(testTextTrace))
+	if err != nil {
+		t.Fatalf("unexpected error parsing text trace: %s", err)
+	}
+	if len(got.Event) != 2 {
+		t.Fatalf("expected 2 events, but got %d", len(got.Event))
+	}
+	if len(got.EventDescriptor) != 1 || got.StringTable[got.EventDescriptor[0].Name] != "sched_switch" {
+		t.Fatalf("expected only a sched_switch event descriptor, but got %v", got.EventDescriptor)
+	}
+	for _, str := range got.StringTable {
+		if str == "kworker/1:1-events" || str == "sched_wakeup" {
+			t.Errorf("string table contains %q, which only belongs to dropped events", str)
+		}
+	}
+}
@@ -16,6 +16,8 @@
 //
 package traceparser
 
+import "fmt"
+
 // EventFormat represents a TraceFS event's format
 type EventFormat struct {
 	Name   string
@@ -29,6 +31,18 @@ type Format struct {
 	CommonFields []*FormatField
 	// Fields are fields that are unique to each Event type
 	Fields []*FormatField
+	// DerivedFields are fields whose values are computed by the TraceParser rather than read from
+	// the event, such as the rendered message of a bprint event.
+	DerivedFields []*FormatField
+}
+
+// eventFields returns the fields whose values are stored for each event: the common fields, the
+// event specific fields, and the derived fields, in that order.
+func (f *Format) eventFields() []*FormatField {
+	fields := make([]*FormatField, 0, len(f.CommonFields)+len(f.Fields)+len(f.DerivedFields))
+	fields = append(fields, f.CommonFields...)
+	fields = append(fields, f.Fields...)
+	return append(fields, f.DerivedFields...)
 }
 
 // FormatField describes a single field within a format
@@ -47,8 +61,11 @@ type FormatField struct {
 	// NumElements is the number of elements in this type. Only relevant for array types.
 	NumElements uint64
 	// ElementSize is the size of each element in bytes. Only relevant for array types.
+	// For dynamic arrays, this is the size of the elements of the array that the field points to, or
+	// 0 if the element type is unknown.
 	ElementSize uint64
 	// Signed states if this type is signed or unsigned. Only relevant for numeric types.
+	// For dynamic arrays, this describes the elements of the array that the field points to.
 	Signed bool
 	// IsDynamicArray is true if this field contains a struct describing a dynamic array
 	// The struct is of the form:
@@ -58,3 +75,27 @@ type FormatField struct {
 	// }
 	IsDynamicArray bool
 }
+
+// IsNumericArray returns true if this field is a fixed-size array of numbers, such as int foo[4].
+// The elements of numeric arrays are stored as separate number properties; see ElementName.
+func (f *FormatField) IsNumericArray() bool {
+	return f.ProtoType == "int64" && !f.IsDynamicArray && f.NumElements > 1 && f.ElementSize > 0
+}
+
+// ElementName returns the name of the property that holds the element at index i of a numeric
+// array field, i.e. foo[i].
+func (f *FormatField) ElementName(i uint64) string {
+	return fmt.Sprintf("%s[%d]", f.Name, i)
+}
+
+// IsDynamicNumericArray returns true if this field is a dynamic array of numbers of a known type,
+// such as __data_loc u16[] foo. The length of such an array is stored as a number property named
+// after the field, and its elements as separate number properties; see ElementName.
+func (f *FormatField) IsDynamicNumericArray() bool {
+	return f.IsDynamicArray && f.ElementSize > 0 && !f.IsDynamicString()
+}
+
+// IsDynamicString returns true if this field is a dynamic array of chars, i.e. __data_loc char[].
+func (f *FormatField) IsDynamicString() bool {
+	return f.IsDynamicArray && f.ElementSize == 1 && charRe.MatchString(f.FieldType)
+}
@@ -19,6 +19,7 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
@@ -28,21 +29,47 @@ import (
 	
 	log "github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
+	eventpb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
 	"github.com/google/schedviz/traceparser/traceparser"
 )
 
 var (
-	formatFilePaths          = flag.String("format_files", "", "Required. Comma separated list of paths to format files. Must include path to header_page file as well.")
-	traceFilesPath           = flag.String("trace_files", "", "Required. Path to the recorded trace files. Should be a folder containing cpu0, cpu1, ... files")
+	formatFilePaths          = flag.String("format_files", "", "Required unless trace_dat is provided. Comma separated list of paths to format files. Must include path to header_page file as well.")
+	traceFilesPath           = flag.String("trace_files", "", "Required unless trace_dat is provided. Path to the recorded trace files. Should be a folder containing cpu0, cpu1, ... files")
 	outputPath               = flag.String("output_path", "", "Required. Path to the file where the output should be written.")
 	statsFilesPath           = flag.String("stats_files", "", "Optional. Path to the recorded cpu stats. Should be a folder containing cpu0, cpu1, ... files")
 	outputFormat             = flag.String("output_format", "proto", "Optional. Format to write the output in. Can be either \"proto\" or \"textproto\". Will use \"proto\" if not specified")
+	traceDatPath             = flag.String("trace_dat", "", "Optional. Path to a trace.dat file recorded by trace-cmd. If provided, format_files, trace_files, and stats_files are ignored.")
 	failOnUnknownEventFormat = flag.Bool("fail_on_unknown_event_format", true, "Whether or not to continue parsing when an unknown event is encountered")
+	parallelism              = flag.Int("parallelism", 0, "Optional. Maximum number of per-CPU trace files to parse concurrently. Will use one per available CPU if not specified")
+	startTimestamp           = flag.Uint64("start_timestamp", 0, "Optional. If provided, events recorded before this timestamp, in the units of the trace clock, are dropped")
+	endTimestamp             = flag.Uint64("end_timestamp", 0, "Optional. If provided, events recorded after this timestamp, in the units of the trace clock, are dropped")
+	includeEvents            = flag.String("include_events", "", "Optional. Comma separated list of event names. If provided, only these events are converted")
+	excludeEvents            = flag.String("exclude_events", "", "Optional. Comma separated list of event names that are not converted")
+	cpus                     = flag.String("cpus", "", "Optional. Comma separated list of CPUs and CPU ranges, i.e. 0-3,8. If provided, only events recorded on these CPUs are converted")
 )
 
 func main() {
 		flag.Parse()
 
+	if *outputPath == "" {
+		log.Exit("output_path is required.")
+	}
+
+	filter, err := eventFilterFromFlags()
+	if err != nil {
+		log.Exitf("Invalid event filter: %s", err)
+	}
+
+	if *traceDatPath != "" {
+		protos, err := convertTraceDat(*traceDatPath, filter)
+		if err != nil {
+			log.Exitf("Failed to convert trace.dat file: %s", err)
+		}
+		writeOutput(protos)
+		return
+	}
+
 	// Filter out empty strings
 	formatFilePathsSlice := strings.Split(*formatFilePaths, ",")
 	for i, ffp := range formatFilePathsSlice {
@@ -55,9 +82,6 @@ func main() {
 	if len(filteredFormatFilePaths) < 2 {
 		log.Exit("format_files is required. Must pass the path to at least one format file and the header_page format file.")
 	}
-	if *outputPath == "" {
-		log.Exit("output_path is required.")
-	}
 
 	var formatFiles = make([]string, len(filteredFormatFilePaths)-1)
 	var headerContent string
@@ -101,6 +125,7 @@ func main() {
 	}
 
 	eventSetBuilder := traceparser.NewEventSetBuilder(&traceParser)
+	eventSetBuilder.SetEventFilter(filter)
 
 	overflowedCPUs := map[int64]struct{}{}
 	for i, statsFilePath := range statsFiles {
@@ -115,20 +140,20 @@ func main() {
 	}
 	eventSetBuilder.SetOverflowedCPUs(overflowedCPUs)
 
-	for i, traceFilePath := range traceFiles {
-		traceFile, err := os.Open(path.Join(*traceFilesPath, traceFilePath.Name()))
-		if err != nil {
-			log.Exitf("Failed to open trace file: %s", err)
-		}
-		reader := bufio.NewReader(traceFile)
-		if err := traceParser.ParseTrace(reader, int64(i), func(traceEvent *traceparser.TraceEvent) (bool, error) {
-			if err := eventSetBuilder.AddTraceEvent(traceEvent); err != nil {
-				log.Exitf("Error in AddTraceEvent: %s", err)
+	walkTraceFiles := func(process func(reader *bufio.Reader, cpu int64) error) error {
+		for i, traceFilePath := range traceFiles {
+			traceFile, err := os.Open(path.Join(*traceFilesPath, traceFilePath.Name()))
+			if err != nil {
+				return fmt.Errorf("failed to open trace file: %s", err)
+			}
+			if err := process(bufio.NewReader(traceFile), int64(i)); err != nil {
+				return err
 			}
-			return true, nil
-		}); err != nil {
-			log.Exitf("Failed to parse trace: %s", err)
 		}
+		return nil
+	}
+	if err := traceParser.ParseTracesInParallel(walkTraceFiles, eventSetBuilder, *parallelism); err != nil {
+		log.Exitf("Failed to parse trace: %s", err)
 	}
 
 	protos, err := eventSetBuilder.Finalize()
@@ -137,6 +162,39 @@ func main() {
 		log.Exitf("Failed to finalize events: %s", err)
 	}
 
+	writeOutput(protos)
+}
+
+// eventFilterFromFlags creates the EventFilter described by the start_timestamp, end_timestamp,
+// include_events, exclude_events, and cpus flags.
+func eventFilterFromFlags() (*traceparser.EventFilter, error) {
+	cpuList, err := traceparser.ParseCPUList(*cpus)
+	if err != nil {
+		return nil, err
+	}
+	return traceparser.NewEventFilter(*startTimestamp, *endTimestamp,
+		traceparser.ParseEventNameList(*includeEvents), traceparser.ParseEventNameList(*excludeEvents), cpuList)
+}
+
+// convertTraceDat parses the trace.dat file at filePath into an EventSet, keeping only the events
+// that pass filter.
+func convertTraceDat(filePath string, filter *traceparser.EventFilter) (*eventpb.EventSet, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	traceDat, err := traceparser.ReadTraceDat(file)
+	if err != nil {
+		return nil, err
+	}
+	return traceDat.EventSet(*failOnUnknownEventFormat, filter)
+}
+
+// writeOutput writes the EventSet to output_path in the requested output_format.
+func writeOutput(protos *eventpb.EventSet) {
+	var err error
 	var output []byte
 	switch *outputFormat {
 	case "proto":
@@ -20,6 +20,7 @@ package traceparser
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -32,9 +33,58 @@ var (
 	fieldRe  = regexp.MustCompile(`field:[ \t]*([^;]+);[ \t]*offset:[ \t]*(\d+);[ \t]*size:[ \t]*(\d+);[ \t]*(?:signed:[ \t]*(\d+);)?`)
 	typeRe   = regexp.MustCompile(`^((?:\w+\s+)?\w+(?:\s+\**\s*)?(?:\[])?)\s+(\w+)\s*(?:\[\s*(\S+)\s*])?$`)
 	charRe   = regexp.MustCompile(`\bchar\b`)
-	dynArrRe = regexp.MustCompile(`^__data_loc\b`)
+	dynArrRe = regexp.MustCompile(`^__data_loc\s+(\w+)`)
 )
 
+// dynArrElemType describes the C type of the elements of a dynamic array.
+type dynArrElemType struct {
+	size   uint64
+	signed bool
+}
+
+// dynArrElemTypes maps the element types of dynamic arrays that can be decoded to their size and
+// signedness. Element types that are not in this map, or whose size depends on the architecture
+// (such as long), are stored as binary blobs.
+var dynArrElemTypes = map[string]dynArrElemType{
+	"char":     {1, false},
+	"bool":     {1, false},
+	"u8":       {1, false},
+	"__u8":     {1, false},
+	"uint8":    {1, false},
+	"uint8_t":  {1, false},
+	"s8":       {1, true},
+	"__s8":     {1, true},
+	"int8":     {1, true},
+	"int8_t":   {1, true},
+	"u16":      {2, false},
+	"__u16":    {2, false},
+	"uint16":   {2, false},
+	"uint16_t": {2, false},
+	"s16":      {2, true},
+	"__s16":    {2, true},
+	"int16":    {2, true},
+	"int16_t":  {2, true},
+	"short":    {2, true},
+	"u32":      {4, false},
+	"__u32":    {4, false},
+	"uint32":   {4, false},
+	"uint32_t": {4, false},
+	"s32":      {4, true},
+	"__s32":    {4, true},
+	"int32":    {4, true},
+	"int32_t":  {4, true},
+	"int":      {4, true},
+	"pid_t":    {4, true},
+	"u64":      {8, false},
+	"__u64":    {8, false},
+	"uint64":   {8, false},
+	"uint64_t": {8, false},
+	"s64":      {8, true},
+	"__s64":    {8, true},
+	"int64":    {8, true},
+	"int64_t":  {8, true},
+}
+
 type parseState int
 
 const (
@@ -211,7 +261,9 @@ func parseField(line string) (*FormatField, error) {
 	field.Offset = offset
 
 	// Some kernels don't have signed in their field formats.
-	if matches[4] != nil {
+	// The signedness of a dynamic array describes the __data_loc word, not the array's elements, so
+	// it is ignored in favor of the signedness of the element type.
+	if matches[4] != nil && !field.IsDynamicArray {
 		signed, err := strconv.ParseUint(string(matches[4]), 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing signed for field %s: %s", field.Name, err)
@@ -238,19 +290,27 @@ func constructFormatField(fieldType string, size uint64) (FormatField, error) {
 	field.Name = string(matches[2])
 
 	cType := matches[1]
+	if dynArrMatches := dynArrRe.FindSubmatch(cType); dynArrMatches != nil {
+		// If this field's type includes "__data_loc", then it describes a dynamic array.
+		// Dynamic arrays are stored as text: char arrays as strings, and arrays of numbers as lists.
+		field.ProtoType = "string"
+		field.IsDynamicArray = true
+		field.NumElements = 1
+		if elemType, ok := dynArrElemTypes[string(dynArrMatches[1])]; ok {
+			field.ElementSize = elemType.size
+			field.Signed = elemType.signed
+		}
+		return field, nil
+	}
 	// Treat fields of char type that are more than one byte long as strings;
 	// char types that are one byte long will be treated as integers.
 	// This is needed because many char fields in some events are used as
 	// bitfields and can therefore contain non-UTF8 code point values, which can
 	// not be stored in a proto string.
-	if charRe.Match(cType) && size > 1 {
+	// Pointers, such as the const char * fmt field of bprint events, are
+	// addresses, so they are treated as integers too.
+	if charRe.Match(cType) && size > 1 && !bytes.Contains(cType, []byte("*")) {
 		field.ProtoType = "string"
-	} else if dynArrRe.Match(cType) {
-		// If this field's type includes "__data_loc", then it describes a dynamic array.
-		// If the type is "__data_loc char []" then it is a dynamic string, which we currently don't
-		// handle, so in this case, don't treat the field as a dynamic array.
-		field.ProtoType = "string"
-		field.IsDynamicArray = true
 	} else {
 		field.ProtoType = "int64"
 	}
@@ -95,7 +95,7 @@ print fmt: "%s", print_special_evt(p, (__get_dynamic_array(event)))
 						{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true, IsDynamicArray: false},
 					},
 					Fields: []*FormatField{
-						{FieldType: "__data_loc uint8[] event", Name: "event", ProtoType: "string", Offset: 8, Size: 4, NumElements: 1, ElementSize: 4, Signed: false, IsDynamicArray: true},
+						{FieldType: "__data_loc uint8[] event", Name: "event", ProtoType: "string", Offset: 8, Size: 4, NumElements: 1, ElementSize: 1, Signed: false, IsDynamicArray: true},
 					},
 				},
 			},
@@ -132,10 +132,16 @@ func TestFieldParsing(t *testing.T) {
 			out: FormatField{FieldType: "char comm[TASK_COMM_LEN]", Name: "comm", ProtoType: "string", Offset: 0, Size: 16, NumElements: 16, ElementSize: 1, Signed: false, IsDynamicArray: false},
 		},
 		{
-			in: "	field: __data_loc char[] dev;	offset:0;	size:16;	signed:0;",
-			// Note: don't currently compute number of elements by size/type size.
-			// Instead, we look at the number in the brackets.
-			out: FormatField{FieldType: "__data_loc char[] dev", Name: "dev", ProtoType: "string", Offset: 0, Size: 16, NumElements: 1, ElementSize: 16, Signed: false, IsDynamicArray: false},
+			in: "	field: __data_loc char[] dev;	offset:0;	size:4;	signed:1;",
+			out: FormatField{FieldType: "__data_loc char[] dev", Name: "dev", ProtoType: "string", Offset: 0, Size: 4, NumElements: 1, ElementSize: 1, Signed: false, IsDynamicArray: true},
+		},
+		{
+			in:  "	field: __data_loc s32[] vals;	offset:0;	size:4;	signed:0;",
+			out: FormatField{FieldType: "__data_loc s32[] vals", Name: "vals", ProtoType: "string", Offset: 0, Size: 4, NumElements: 1, ElementSize: 4, Signed: true, IsDynamicArray: true},
+		},
+		{
+			in:  "	field: __data_loc unknown_t[] blob;	offset:0;	size:4;	signed:0;",
+			out: FormatField{FieldType: "__data_loc unknown_t[] blob", Name: "blob", ProtoType: "string", Offset: 0, Size: 4, NumElements: 1, ElementSize: 0, Signed: false, IsDynamicArray: true},
 		},
 		{
 			in: "	field: s64 now;	offset:0;	size:8;	signed:0;",
@@ -0,0 +1,192 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+// kallsyms resolves kernel addresses stored in event fields into symbols
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SymbolFieldSuffix is appended to the name of a field holding a kernel address to name the text
+// property holding its resolved symbol, i.e. function_sym.
+const SymbolFieldSuffix = "_sym"
+
+// symbolizedFieldNames are the names of the fields that hold kernel text addresses, such as the
+// function field of workqueue_execute_start and timer_expire_entry events.
+var symbolizedFieldNames = map[string]bool{
+	"function":  true,
+	"func":      true,
+	"handler":   true,
+	"ip":        true,
+	"call_site": true,
+	"caller":    true,
+}
+
+// isSymbolizedField returns true if field holds a kernel text address that should be resolved.
+func isSymbolizedField(field *FormatField) bool {
+	return symbolizedFieldNames[field.Name] && field.ProtoType == "int64" && !field.IsNumericArray() && field.Size >= 4
+}
+
+// textEndSymbolNames are the names of the text symbols that mark the end of a range of kernel
+// text, rather than the start of a function.
+var textEndSymbolNames = map[string]bool{
+	"_etext":     true,
+	"_einittext": true,
+}
+
+// maxSymbolSize is the largest offset into a symbol that an address may have and still be
+// resolved to that symbol. It bounds the last symbol in a range of text, such as the last symbol
+// in a module, which is otherwise unbounded.
+const maxSymbolSize = 1 << 20
+
+// kernelSymbol is a single symbol in /proc/kallsyms.
+type kernelSymbol struct {
+	addr   uint64
+	name   string
+	module string
+	// True if the symbol is a text symbol that addresses may resolve to, false if it only marks
+	// the end of the preceding text symbol.
+	isText bool
+}
+
+// Kallsyms resolves kernel addresses into symbols, using the contents of /proc/kallsyms.
+type Kallsyms struct {
+	// Text symbols, and the symbols ending them, sorted by address.
+	symbols []kernelSymbol
+}
+
+// ParseKallsyms parses the contents of /proc/kallsyms.
+// kallsyms files look like this:
+/**
+ffffffff81000000 T _stext
+ffffffff810a1b20 t process_one_work
+ffffffffc0a01000 t e1000_intr	[e1000]
+*/
+// Addresses resolve only to text symbols; other symbols, and markers like _etext, just end the
+// text symbol preceding them. Symbols whose addresses are hidden, which happens when kallsyms is
+// read without sufficient privileges, are skipped.
+func ParseKallsyms(contents string) (*Kallsyms, error) {
+	k := &Kallsyms{}
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		pieces := strings.Fields(line)
+		if len(pieces) < 3 {
+			return nil, fmt.Errorf("bad kallsyms line: %s", line)
+		}
+		addr, err := strconv.ParseUint(pieces[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing address in kallsyms line %q: %s", line, err)
+		}
+		if addr == 0 {
+			continue
+		}
+		sym := kernelSymbol{addr: addr, name: pieces[2]}
+		switch pieces[1] {
+		case "t", "T", "w", "W":
+			sym.isText = !textEndSymbolNames[sym.name]
+		}
+		if len(pieces) > 3 {
+			sym.module = strings.Trim(pieces[3], "[]")
+		}
+		k.symbols = append(k.symbols, sym)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read kallsyms. caused by: %v", err)
+	}
+	sort.SliceStable(k.symbols, func(i, j int) bool {
+		return k.symbols[i].addr < k.symbols[j].addr
+	})
+	return k, nil
+}
+
+// Symbolize returns the symbol containing addr, formatted as symbol+offset, i.e.
+// process_one_work+0x1a0, followed by the module in brackets for module symbols.
+// False is returned if addr doesn't fall within a known text symbol, including when it lies past
+// the end of the kernel's or a module's text.
+func (k *Kallsyms) Symbolize(addr uint64) (string, bool) {
+	// Find the last symbol at or below addr.
+	i := sort.Search(len(k.symbols), func(i int) bool {
+		return k.symbols[i].addr > addr
+	}) - 1
+	if i < 0 {
+		return "", false
+	}
+	sym := k.symbols[i]
+	if !sym.isText || addr-sym.addr >= maxSymbolSize {
+		return "", false
+	}
+	ret := fmt.Sprintf("%s+%#x", sym.name, addr-sym.addr)
+	if sym.module != "" {
+		ret += fmt.Sprintf(" [%s]", sym.module)
+	}
+	return ret, true
+}
+
+// SetKallsyms provides the TraceParser with the kernel's symbols, so that fields holding kernel
+// text addresses, such as the function field of workqueue_execute_start events, are resolved into
+// a <field>_sym text property.
+// This must be called before the TraceParser's formats are added to an EventSetBuilder.
+func (tp *TraceParser) SetKallsyms(kallsyms *Kallsyms) {
+	tp.kallsyms = kallsyms
+	for _, evtFmt := range tp.Formats {
+		existing := make(map[string]bool)
+		for _, field := range evtFmt.Format.DerivedFields {
+			existing[field.Name] = true
+		}
+		for _, field := range evtFmt.Format.Fields {
+			symName := field.Name + SymbolFieldSuffix
+			if !isSymbolizedField(field) || existing[symName] {
+				continue
+			}
+			evtFmt.Format.DerivedFields = append(evtFmt.Format.DerivedFields, &FormatField{
+				FieldType: fmt.Sprintf("char %s[]", symName),
+				Name:      symName,
+				ProtoType: "string",
+			})
+		}
+	}
+}
+
+// saveSymbols resolves the kernel addresses held by the fields of traceEvent. Addresses that don't
+// fall within a known symbol are left unresolved.
+func (tp *TraceParser) saveSymbols(format Format, traceEvent *TraceEvent) {
+	for _, field := range format.Fields {
+		if !isSymbolizedField(field) {
+			continue
+		}
+		addr, ok := traceEvent.NumberProperties[field.Name]
+		if !ok {
+			continue
+		}
+		if field.Size < 8 {
+			// Addresses are unsigned.
+			addr &= 1<<(8*field.Size) - 1
+		}
+		if sym, ok := tp.kallsyms.Symbolize(uint64(addr)); ok {
+			traceEvent.TextProperties[field.Name+SymbolFieldSuffix] = sym
+		}
+	}
+}
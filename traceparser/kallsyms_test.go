@@ -0,0 +1,140 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testKallsyms = `0000000000000000 A fixed_percpu_data
+ffffffff81000000 T _stext
+ffffffff810a1b20 t process_one_work
+ffffffff810a2000 T worker_thread
+ffffffff81e02000 T _etext
+ffffffff82000000 D jiffies
+ffffffff82100000 T init_kernel
+ffffffff82100400 T _einittext
+ffffffffc0a01000 t e1000_intr	[e1000]
+`
+
+const testWorkqueueFormat = `name: workqueue_execute_start
+ID: 400
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:void * work;	offset:8;	size:8;	signed:0;
+	field:void * function;	offset:16;	size:8;	signed:0;
+
+print fmt: "work struct %p: function %ps", REC->work, REC->function
+`
+
+func TestSymbolize(t *testing.T) {
+	k, err := ParseKallsyms(testKallsyms)
+	if err != nil {
+		t.Fatalf("ParseKallsyms() yielded unexpected error %s", err)
+	}
+	tests := []struct {
+		addr   uint64
+		want   string
+		wantOk bool
+	}{
+		{0xffffffff810a1b20, "process_one_work+0x0", true},
+		{0xffffffff810a1cc0, "process_one_work+0x1a0", true},
+		{0xffffffff810a2010, "worker_thread+0x10", true},
+		{0xffffffff82100010, "init_kernel+0x10", true},
+		{0xffffffffc0a01004, "e1000_intr+0x4 [e1000]", true},
+		{0x1000, "", false},
+		// Past the end of the kernel's text.
+		{0xffffffff81e02008, "", false},
+		{0xffffffff82100408, "", false},
+		// Within a data symbol.
+		{0xffffffff82000008, "", false},
+		// Too far past the last symbol in a module.
+		{0xffffffffc0c01000, "", false},
+	}
+	for _, test := range tests {
+		got, ok := k.Symbolize(test.addr)
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("Symbolize(%#x) = %q, %t; want %q, %t", test.addr, got, ok, test.want, test.wantOk)
+		}
+	}
+
+	if _, err := ParseKallsyms("ffffffff81000000"); err == nil {
+		t.Errorf("ParseKallsyms() yielded no error for a malformed line")
+	}
+}
+
+func TestParseTrace_Kallsyms(t *testing.T) {
+	k, err := ParseKallsyms(testKallsyms)
+	if err != nil {
+		t.Fatalf("ParseKallsyms() yielded unexpected error %s", err)
+	}
+	for _, endianness := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(endianness.String(), func(t *testing.T) {
+			formats, err := parseRegularFormats([]string{testWorkqueueFormat})
+			if err != nil {
+				t.Fatalf("parseRegularFormats() yielded unexpected error %s", err)
+			}
+			symTp := &TraceParser{
+				HeaderFormat:             tp.HeaderFormat,
+				Formats:                  formats,
+				Endianness:               endianness,
+				failOnUnknownEventFormat: true,
+			}
+			symTp.SetKallsyms(k)
+
+			var events [][]byte
+			for _, function := range []uint64{0xffffffff810a1cc0, 0x1000} {
+				event := make([]byte, 24)
+				endianness.PutUint16(event[0:], 400)
+				endianness.PutUint32(event[4:], 42)
+				endianness.PutUint64(event[8:], 0xffff888100000000)
+				endianness.PutUint64(event[16:], function)
+				events = append(events, event)
+			}
+			var got []map[string]string
+			if err := symTp.ParseTrace(bufio.NewReader(bytes.NewReader(makeTestPage(endianness, events))), 0 /*=cpu*/, func(event *TraceEvent) (bool, error) {
+				got = append(got, event.TextProperties)
+				return true, nil
+			}); err != nil {
+				t.Fatalf("error during ParseTrace(): %s", err)
+			}
+			want := []map[string]string{
+				{"function_sym": "process_one_work+0x1a0"},
+				// The address doesn't fall within a known symbol, so it isn't resolved.
+				{},
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("TestParseTrace_Kallsyms: Diff -want +got:\n%s", diff)
+			}
+
+			// Only the function field is symbolized.
+			derived := formats[400].Format.DerivedFields
+			if len(derived) != 1 || derived[0].Name != "function_sym" {
+				t.Errorf("got derived fields %v, want only function_sym", derived)
+			}
+		})
+	}
+}
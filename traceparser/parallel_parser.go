@@ -0,0 +1,143 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"bufio"
+	"fmt"
+	"runtime"
+	"sync"
+
+	pb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+// PerCPUWalker calls process once for the ring buffer data of each CPU in a trace, in a fixed
+// order. WalkPerCPUDir and TraceDat.WalkPerCPUBuffers can both be adapted into PerCPUWalkers.
+type PerCPUWalker func(process func(reader *bufio.Reader, cpu int64) error) error
+
+// ParseTracesInParallel parses the ring buffer data of every CPU provided by walk concurrently,
+// using up to parallelism goroutines (or one per available CPU if parallelism is not positive), and
+// adds the parsed events to esb.
+// Each CPU is parsed into its own partial EventSet, and the partial EventSets are merged into esb
+// in walk order once all CPUs are parsed. This makes the finalized EventSet identical to the one
+// that would be produced by calling ParseTrace on each CPU in walk order with a callback that adds
+// each event to esb.
+// If parsing fails on more than one CPU, the error from the CPU that comes first in walk order is
+// returned.
+func (tp *TraceParser) ParseTracesInParallel(walk PerCPUWalker, esb *EventSetBuilder, parallelism int) error {
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	// ParseTrace sets the endianness if it is missing, so set it now to avoid a data race.
+	if tp.Endianness == nil {
+		if err := tp.SetNativeEndian(); err != nil {
+			return err
+		}
+	}
+
+	type cpuResult struct {
+		cpu     int64
+		partial *EventSetBuilder
+		err     error
+	}
+	var results []*cpuResult
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	walkErr := walk(func(reader *bufio.Reader, cpu int64) error {
+		result := &cpuResult{cpu: cpu, partial: esb.newPartial()}
+		results = append(results, result)
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result.err = tp.ParseTrace(reader, cpu, func(traceEvent *TraceEvent) (bool, error) {
+				if err := result.partial.AddTraceEvent(traceEvent); err != nil {
+					return false, fmt.Errorf("error in AddTraceEvent: %s", err)
+				}
+				return true, nil
+			})
+		}()
+		return nil
+	})
+	wg.Wait()
+	if walkErr != nil {
+		return walkErr
+	}
+
+	for _, result := range results {
+		if result.err != nil {
+			return result.err
+		}
+		if err := esb.mergePartial(result.partial); err != nil {
+			return fmt.Errorf("failed to merge events from CPU %d: %s", result.cpu, err)
+		}
+	}
+	return nil
+}
+
+// newPartial creates an EventSetBuilder that shares esb's formats and event descriptors, and
+// starts with a copy of esb's string table, but has no events.
+// Events can be added to the partial builder concurrently with other partial builders, as long as
+// no formats are added to esb. The partial builder's events are added to esb by mergePartial.
+func (esb *EventSetBuilder) newPartial() *EventSetBuilder {
+	partial := &EventSetBuilder{
+		eventSet: &pb.EventSet{
+			Event:       []*pb.Event{},
+			StringTable: append([]string{}, esb.eventSet.StringTable...),
+		},
+		formats:              esb.formats,
+		eventDescriptorMap:   esb.eventDescriptorMap,
+		eventDescriptorTable: esb.eventDescriptorTable,
+		strTable:             make(map[string]int64, len(esb.strTable)),
+		filter:               esb.filter,
+	}
+	for k, v := range esb.strTable {
+		partial.strTable[k] = v
+	}
+	return partial
+}
+
+// mergePartial appends the events of a partial EventSetBuilder created by newPartial to esb.
+// Strings that the partial builder added to its string table are added to esb's string table in the
+// same order, so merging partial builders in order gives the same result as adding their events to
+// esb directly.
+func (esb *EventSetBuilder) mergePartial(partial *EventSetBuilder) error {
+	strMap := make([]int64, len(partial.eventSet.StringTable))
+	for i, str := range partial.eventSet.StringTable {
+		strMap[i] = esb.addString(str)
+	}
+	for _, event := range partial.eventSet.Event {
+		if event.EventDescriptor < 0 || event.EventDescriptor >= int64(len(esb.eventSet.EventDescriptor)) {
+			return fmt.Errorf("event has unknown event descriptor %d", event.EventDescriptor)
+		}
+		ed := esb.eventSet.EventDescriptor[event.EventDescriptor]
+		if len(ed.PropertyDescriptor) != len(event.Property) {
+			return fmt.Errorf("event has %d properties, but its event descriptor has %d", len(event.Property), len(ed.PropertyDescriptor))
+		}
+		for i, pd := range ed.PropertyDescriptor {
+			if pd.Type == pb.EventDescriptor_PropertyDescriptor_TEXT {
+				event.Property[i] = strMap[event.Property[i]]
+			}
+		}
+		esb.eventSet.Event = append(esb.eventSet.Event, event)
+	}
+	esb.lostEvents = append(esb.lostEvents, partial.lostEvents...)
+	return nil
+}
@@ -0,0 +1,175 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/schedviz/testhelpers/testhelpers"
+	pb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+// readTestPages reads the pages of the testdata/input/cpu0 trace.
+func readTestPages(tb testing.TB) [][]byte {
+	tb.Helper()
+	data, err := ioutil.ReadFile(path.Join(testhelpers.GetRunFilesPath(), "traceparser", "testdata", "input", "cpu0"))
+	if err != nil {
+		tb.Fatalf("error reading test trace file. caused by: %s", err)
+	}
+	var pages [][]byte
+	for len(data) >= testPageSize {
+		pages = append(pages, data[:testPageSize])
+		data = data[testPageSize:]
+	}
+	return pages
+}
+
+// makeTestCPUs deals the pages round robin to numCPUs CPUs, repeating them copies times, so that
+// each CPU has different events and discovers strings in a different order.
+func makeTestCPUs(pages [][]byte, numCPUs, copies int) [][]byte {
+	cpus := make([][]byte, numCPUs)
+	for c := 0; c < copies; c++ {
+		for i, page := range pages {
+			cpu := (i + c) % numCPUs
+			cpus[cpu] = append(cpus[cpu], page...)
+		}
+	}
+	return cpus
+}
+
+// walkTestCPUs returns a PerCPUWalker over the provided per-CPU data, in reverse CPU order.
+func walkTestCPUs(cpus [][]byte) PerCPUWalker {
+	return func(process func(reader *bufio.Reader, cpu int64) error) error {
+		for cpu := len(cpus) - 1; cpu >= 0; cpu-- {
+			if err := process(bufio.NewReader(bytes.NewReader(cpus[cpu])), int64(cpu)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func parseSerially(tb testing.TB, cpus [][]byte) *pb.EventSet {
+	tb.Helper()
+	_ = tp.SetLittleEndian()
+	esb := NewEventSetBuilder(tp)
+	if err := walkTestCPUs(cpus)(func(reader *bufio.Reader, cpu int64) error {
+		return tp.ParseTrace(reader, cpu, func(traceEvent *TraceEvent) (bool, error) {
+			if err := esb.AddTraceEvent(traceEvent); err != nil {
+				return false, err
+			}
+			return true, nil
+		})
+	}); err != nil {
+		tb.Fatalf("error parsing serially: %s", err)
+	}
+	es, err := esb.Finalize()
+	if err != nil {
+		tb.Fatalf("error finalizing serially parsed events: %s", err)
+	}
+	return es
+}
+
+func parseInParallel(tb testing.TB, cpus [][]byte, parallelism int) *pb.EventSet {
+	tb.Helper()
+	_ = tp.SetLittleEndian()
+	esb := NewEventSetBuilder(tp)
+	if err := tp.ParseTracesInParallel(walkTestCPUs(cpus), esb, parallelism); err != nil {
+		tb.Fatalf("error parsing in parallel: %s", err)
+	}
+	es, err := esb.Finalize()
+	if err != nil {
+		tb.Fatalf("error finalizing events parsed in parallel: %s", err)
+	}
+	return es
+}
+
+func TestParseTracesInParallel(t *testing.T) {
+	pages := readTestPages(t)
+	for _, numCPUs := range []int{1, 3, 8} {
+		for _, parallelism := range []int{1, 2, 0} {
+			t.Run(fmt.Sprintf("%d CPUs, parallelism %d", numCPUs, parallelism), func(t *testing.T) {
+				cpus := makeTestCPUs(pages, numCPUs, 1 /*=copies*/)
+				want, err := proto.Marshal(parseSerially(t, cpus))
+				if err != nil {
+					t.Fatalf("error marshalling serially parsed events: %s", err)
+				}
+				got, err := proto.Marshal(parseInParallel(t, cpus, parallelism))
+				if err != nil {
+					t.Fatalf("error marshalling events parsed in parallel: %s", err)
+				}
+				if !bytes.Equal(want, got) {
+					t.Fatalf("EventSet parsed in parallel differs from the EventSet parsed serially")
+				}
+			})
+		}
+	}
+}
+
+func TestParseTracesInParallel_Errors(t *testing.T) {
+	pages := readTestPages(t)
+	cpus := makeTestCPUs(pages, 4 /*=numCPUs*/, 1 /*=copies*/)
+	// Truncate CPUs 1 and 2 mid-page. Since CPUs are walked in reverse order, CPU 2's error should be
+	// reported.
+	cpus[1] = cpus[1][:testPageSize+100]
+	cpus[2] = cpus[2][:100]
+	_ = tp.SetLittleEndian()
+	serialErr := walkTestCPUs(cpus)(func(reader *bufio.Reader, cpu int64) error {
+		return tp.ParseTrace(reader, cpu, func(*TraceEvent) (bool, error) { return true, nil })
+	})
+	if serialErr == nil {
+		t.Fatalf("expected an error when parsing truncated traces")
+	}
+	err := tp.ParseTracesInParallel(walkTestCPUs(cpus), NewEventSetBuilder(tp), 4 /*=parallelism*/)
+	if err == nil || err.Error() != serialErr.Error() {
+		t.Fatalf("ParseTracesInParallel() returned error %v, want %v", err, serialErr)
+	}
+
+	walkErr := errors.New("walk error")
+	err = tp.ParseTracesInParallel(func(process func(reader *bufio.Reader, cpu int64) error) error {
+		return walkErr
+	}, NewEventSetBuilder(tp), 4 /*=parallelism*/)
+	if err != walkErr {
+		t.Fatalf("ParseTracesInParallel() returned error %v, want %v", err, walkErr)
+	}
+}
+
+// benchmarkParse measures parsing a synthetic 32 CPU trace with 8 copies of each test page.
+func benchmarkParse(b *testing.B, parse func(tb testing.TB, cpus [][]byte) *pb.EventSet) {
+	cpus := makeTestCPUs(readTestPages(b), 32 /*=numCPUs*/, 8 /*=copies*/)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parse(b, cpus)
+	}
+}
+
+func BenchmarkParseTraceSerial(b *testing.B) {
+	benchmarkParse(b, parseSerially)
+}
+
+func BenchmarkParseTracesInParallel(b *testing.B) {
+	benchmarkParse(b, func(tb testing.TB, cpus [][]byte) *pb.EventSet {
+		return parseInParallel(tb, cpus, 0 /*=parallelism*/)
+	})
+}
@@ -0,0 +1,384 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+// printk renders the messages of the events recorded by trace_printk()
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/schedviz/util/util"
+)
+
+// The names of the events recorded by trace_printk(). bprint events hold the address of a format
+// string, followed by the arguments packed by the kernel's vbin_printf(). bputs events hold the
+// address of a constant string.
+const (
+	bprintEventName = "bprint"
+	bputsEventName  = "bputs"
+)
+
+// PrintkMessageField is the name of the text property holding the rendered message of bprint and
+// bputs events.
+const PrintkMessageField = "msg"
+
+// PrintkFormats maps the addresses of trace_printk() format strings to the format strings.
+type PrintkFormats map[uint64]string
+
+// ParsePrintkFormats parses the contents of TraceFS's printk_formats file.
+// printk_formats files look like this:
+/**
+0xffffffff82a0d1f8 : "sched: cpu %d picked %s\n"
+0xffffffff82a0d220 : "rq clock %llu\n"
+*/
+// The kernel only escapes newlines, tabs and double quotes in the format strings.
+func ParsePrintkFormats(contents string) (PrintkFormats, error) {
+	ret := make(PrintkFormats)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		pieces := strings.SplitN(line, " : ", 2)
+		if len(pieces) != 2 {
+			return nil, fmt.Errorf("bad printk_formats line: %s", line)
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(pieces[0], "0x"), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing address in printk_formats line %q: %s", line, err)
+		}
+		format := pieces[1]
+		if len(format) >= 2 && strings.HasPrefix(format, `"`) && strings.HasSuffix(format, `"`) {
+			format = format[1 : len(format)-1]
+		}
+		ret[addr] = unescapePrintkFormat(format)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read printk_formats. caused by: %v", err)
+	}
+	return ret, nil
+}
+
+// unescapePrintkFormat reverses the escaping applied to format strings in printk_formats. Other
+// backslashes are printed by the kernel as-is, so they are kept.
+func unescapePrintkFormat(format string) string {
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] == '\\' && i+1 < len(format) {
+			switch format[i+1] {
+			case 'n':
+				sb.WriteByte('\n')
+				i++
+				continue
+			case 't':
+				sb.WriteByte('\t')
+				i++
+				continue
+			case '"':
+				sb.WriteByte('"')
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(format[i])
+	}
+	return sb.String()
+}
+
+// SetPrintkFormats provides the TraceParser with the trace_printk() format strings, so that the
+// messages of bprint and bputs events can be rendered into their msg text property.
+// This must be called before the TraceParser's formats are added to an EventSetBuilder.
+func (tp *TraceParser) SetPrintkFormats(printkFormats PrintkFormats) {
+	tp.printkFormats = printkFormats
+	for _, evtFmt := range tp.Formats {
+		if evtFmt.Name != bprintEventName && evtFmt.Name != bputsEventName {
+			continue
+		}
+		hasMsg := false
+		for _, field := range evtFmt.Format.DerivedFields {
+			hasMsg = hasMsg || field.Name == PrintkMessageField
+		}
+		if !hasMsg {
+			evtFmt.Format.DerivedFields = append(evtFmt.Format.DerivedFields, &FormatField{
+				FieldType: "char msg[]",
+				Name:      PrintkMessageField,
+				ProtoType: "string",
+			})
+		}
+	}
+}
+
+// savePrintkMessage renders the message of a bprint or bputs event into its msg text property.
+// Messages that can't be rendered, for instance because their format string is missing from
+// printk_formats, are logged and left empty.
+func (tp *TraceParser) savePrintkMessage(evtFmt *EventFormat, eventData []byte, traceEvent *TraceEvent) {
+	var msg string
+	var err error
+	switch evtFmt.Name {
+	case bprintEventName:
+		msg, err = tp.renderBprint(evtFmt, eventData, traceEvent)
+	case bputsEventName:
+		msg, err = tp.lookupPrintkFormat(traceEvent, "str")
+	default:
+		return
+	}
+	if err != nil {
+		util.LogWarnEveryNTime(100*time.Microsecond, fmt.Sprintf("unable to render %s message: %s", evtFmt.Name, err))
+		return
+	}
+	// Nearly all trace_printk() messages end with a newline, which is not part of the message.
+	traceEvent.TextProperties[PrintkMessageField] = strings.TrimSuffix(msg, "\n")
+}
+
+// lookupPrintkFormat returns the string whose address is stored in the named field of traceEvent.
+func (tp *TraceParser) lookupPrintkFormat(traceEvent *TraceEvent, fieldName string) (string, error) {
+	addr, ok := traceEvent.NumberProperties[fieldName]
+	if !ok {
+		return "", fmt.Errorf("event is missing the %q field", fieldName)
+	}
+	format, ok := tp.printkFormats[uint64(addr)]
+	if !ok {
+		return "", fmt.Errorf("no string found in printk_formats at address %#x", uint64(addr))
+	}
+	return format, nil
+}
+
+// renderBprint renders a bprint event's format string with the arguments that follow its fmt field.
+func (tp *TraceParser) renderBprint(evtFmt *EventFormat, eventData []byte, traceEvent *TraceEvent) (string, error) {
+	format, err := tp.lookupPrintkFormat(traceEvent, "fmt")
+	if err != nil {
+		return "", err
+	}
+	// The size of a long on the traced machine is the size of the ip field.
+	longSize := uint64(8)
+	argsOffset := uint64(0)
+	for _, field := range evtFmt.Format.Fields {
+		switch field.Name {
+		case "ip":
+			longSize = field.Size
+		case "fmt":
+			// The arguments, declared as u32 buf[], immediately follow the fmt field.
+			argsOffset = field.Offset + field.Size
+		}
+	}
+	if argsOffset == 0 || argsOffset > uint64(len(eventData)) {
+		return "", errors.New("unable to locate the arguments of the bprint event")
+	}
+	return formatBinaryPrintf(format, eventData[argsOffset:], longSize, tp.Endianness)
+}
+
+// binaryArgs reads the arguments packed by the kernel's vbin_printf().
+type binaryArgs struct {
+	buf        []byte
+	pos        int
+	endianness binary.ByteOrder
+}
+
+// number reads an integer of the provided size. Arguments are aligned to their size, except for
+// 8 byte arguments, which are stored as two u32s and so are only aligned to 4 bytes.
+func (a *binaryArgs) number(size int, signed bool) (int64, error) {
+	align := size
+	if align > 4 {
+		align = 4
+	}
+	a.pos = (a.pos + align - 1) / align * align
+	if a.pos+size > len(a.buf) {
+		return 0, fmt.Errorf("not enough arguments: need %d bytes at offset %d, but only have %d bytes", size, a.pos, len(a.buf))
+	}
+	val, err := readNumber(a.buf[a.pos:a.pos+size], signed, a.endianness)
+	if err != nil {
+		return 0, err
+	}
+	a.pos += size
+	return val, nil
+}
+
+// str reads a NUL-terminated string, which is stored without any alignment.
+func (a *binaryArgs) str() (string, error) {
+	if a.pos > len(a.buf) {
+		return "", errors.New("not enough arguments for string")
+	}
+	end := strings.IndexByte(string(a.buf[a.pos:]), 0)
+	if end < 0 {
+		return "", errors.New("string argument is not NUL-terminated")
+	}
+	s := string(a.buf[a.pos : a.pos+end])
+	a.pos += end + 1
+	return s, nil
+}
+
+// isAlnum returns true if c is an ASCII letter or digit.
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// formatBinaryPrintf renders a printf-style format string using arguments that were packed by the
+// kernel's vbin_printf(), as the kernel's bstr_printf() does.
+// longSize is the size of a long on the machine that packed the arguments.
+// Pointers are rendered as zero-padded hexadecimal, as %px does; the kernel would render them
+// hashed, or as symbols.
+func formatBinaryPrintf(format string, args []byte, longSize uint64, endianness binary.ByteOrder) (string, error) {
+	a := &binaryArgs{buf: args, endianness: endianness}
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+		// Flags
+		flags := ""
+		for ; i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0; i++ {
+			flags += string(format[i])
+		}
+		// Field width
+		width := ""
+		if i < len(format) && format[i] == '*' {
+			w, err := a.number(4, true /*=signed*/)
+			if err != nil {
+				return "", err
+			}
+			if w < 0 {
+				flags += "-"
+				w = -w
+			}
+			width = strconv.FormatInt(w, 10)
+			i++
+		} else {
+			for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+				width += string(format[i])
+			}
+		}
+		// Precision
+		precision := ""
+		if i < len(format) && format[i] == '.' {
+			i++
+			if i < len(format) && format[i] == '*' {
+				p, err := a.number(4, true /*=signed*/)
+				if err != nil {
+					return "", err
+				}
+				// A negative precision is treated as if it were omitted.
+				if p >= 0 {
+					precision = "." + strconv.FormatInt(p, 10)
+				}
+				i++
+			} else {
+				digits := ""
+				for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+					digits += string(format[i])
+				}
+				precision = "." + digits
+			}
+		}
+		// Length qualifier
+		qualifier := ""
+		for ; i < len(format) && strings.IndexByte("hlLqzZt", format[i]) >= 0; i++ {
+			qualifier += string(format[i])
+		}
+		size := 4
+		switch qualifier {
+		case "hh":
+			size = 1
+		case "h":
+			size = 2
+		case "l", "z", "Z", "t":
+			size = int(longSize)
+		case "ll", "L", "q":
+			size = 8
+		case "":
+		default:
+			return "", fmt.Errorf("unsupported length qualifier %q in format %q", qualifier, format)
+		}
+		if i >= len(format) {
+			return "", fmt.Errorf("format %q ends in an incomplete conversion", format)
+		}
+		spec := "%" + flags + width + precision
+		switch conv := format[i]; conv {
+		case 'd', 'i':
+			val, err := a.number(size, true /*=signed*/)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(fmt.Sprintf(spec+"d", val))
+		case 'u', 'x', 'X', 'o':
+			val, err := a.number(size, false /*=signed*/)
+			if err != nil {
+				return "", err
+			}
+			verb := string(conv)
+			if conv == 'u' {
+				verb = "d"
+			}
+			sb.WriteString(fmt.Sprintf(spec+verb, uint64(val)))
+		case 'c':
+			val, err := a.number(1, false /*=signed*/)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(fmt.Sprintf(spec+"c", rune(val)))
+		case 's':
+			s, err := a.str()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(fmt.Sprintf(spec+"s", s))
+		case 'p':
+			// Most pointer extensions dereference the pointer, so vbin_printf() renders them into a
+			// string up front. Only the address is saved for plain %p and the S, s, x, K and e
+			// extensions.
+			var ext byte
+			if i+1 < len(format) && isAlnum(format[i+1]) {
+				ext = format[i+1]
+			}
+			if ext == 0 || strings.IndexByte("SsxKe", ext) >= 0 {
+				val, err := a.number(int(longSize), false /*=signed*/)
+				if err != nil {
+					return "", err
+				}
+				if width == "" {
+					sb.WriteString(fmt.Sprintf("%0*x", 2*longSize, uint64(val)))
+				} else {
+					sb.WriteString(fmt.Sprintf(spec+"x", uint64(val)))
+				}
+			} else {
+				s, err := a.str()
+				if err != nil {
+					return "", err
+				}
+				sb.WriteString(s)
+			}
+			for i+1 < len(format) && isAlnum(format[i+1]) {
+				i++
+			}
+		default:
+			return "", fmt.Errorf("unsupported conversion %%%c in format %q", conv, format)
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,308 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+const testBprintFormat = `name: bprint
+ID: 6
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long ip;	offset:8;	size:8;	signed:0;
+	field:const char * fmt;	offset:16;	size:8;	signed:0;
+	field:u32 buf[];	offset:24;	size:0;	signed:0;
+
+print fmt: "%ps: %s", (void *)REC->ip, REC->fmt
+`
+
+const testBputsFormat = `name: bputs
+ID: 10
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long ip;	offset:8;	size:8;	signed:0;
+	field:const char * str;	offset:16;	size:8;	signed:0;
+
+print fmt: "%ps: %s", (void *)REC->ip, REC->str
+`
+
+const testPrintkFormats = `0xffffffff81e6a2a8 : "cpu %d picked %s prio %lld%c\n"
+0xffffffff81e6a2f0 : "quoted \"str\"\ttab \d\n"
+0xffffffff81e6a320 : "rq locked\n"
+`
+
+func TestParsePrintkFormats(t *testing.T) {
+	got, err := ParsePrintkFormats(testPrintkFormats)
+	if err != nil {
+		t.Fatalf("ParsePrintkFormats() yielded unexpected error %s", err)
+	}
+	want := PrintkFormats{
+		0xffffffff81e6a2a8: "cpu %d picked %s prio %lld%c\n",
+		0xffffffff81e6a2f0: "quoted \"str\"\ttab \\d\n",
+		0xffffffff81e6a320: "rq locked\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParsePrintkFormats(): Diff -want +got:\n%s", diff)
+	}
+
+	if _, err := ParsePrintkFormats("not a printk format"); err == nil {
+		t.Errorf("ParsePrintkFormats() yielded no error for a malformed line")
+	}
+}
+
+// binaryArgsWriter packs printf arguments the way the kernel's vbin_printf() does.
+type binaryArgsWriter struct {
+	buf        []byte
+	endianness binary.ByteOrder
+}
+
+func (w *binaryArgsWriter) align(n int) *binaryArgsWriter {
+	for len(w.buf)%n != 0 {
+		w.buf = append(w.buf, 0)
+	}
+	return w
+}
+
+func (w *binaryArgsWriter) u8(v uint8) *binaryArgsWriter {
+	w.buf = append(w.buf, v)
+	return w
+}
+
+func (w *binaryArgsWriter) u16(v uint16) *binaryArgsWriter {
+	w.align(2)
+	b := make([]byte, 2)
+	w.endianness.PutUint16(b, v)
+	w.buf = append(w.buf, b...)
+	return w
+}
+
+func (w *binaryArgsWriter) u32(v uint32) *binaryArgsWriter {
+	w.align(4)
+	b := make([]byte, 4)
+	w.endianness.PutUint32(b, v)
+	w.buf = append(w.buf, b...)
+	return w
+}
+
+func (w *binaryArgsWriter) u64(v uint64) *binaryArgsWriter {
+	// 8 byte arguments are only aligned to 4 bytes.
+	w.align(4)
+	b := make([]byte, 8)
+	w.endianness.PutUint64(b, v)
+	w.buf = append(w.buf, b...)
+	return w
+}
+
+func (w *binaryArgsWriter) str(s string) *binaryArgsWriter {
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+	return w
+}
+
+func TestFormatBinaryPrintf(t *testing.T) {
+	for _, endianness := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		args := func() *binaryArgsWriter {
+			return &binaryArgsWriter{endianness: endianness}
+		}
+		tests := []struct {
+			description string
+			format      string
+			args        *binaryArgsWriter
+			longSize    uint64
+			want        string
+		}{
+			{
+				description: "no arguments",
+				format:      "100%% done",
+				args:        args(),
+				longSize:    8,
+				want:        "100% done",
+			},
+			{
+				description: "signed and unsigned ints",
+				format:      "%d %i %u %x %X %o",
+				args:        args().u32(0xffffffff).u32(7).u32(0xffffffff).u32(255).u32(255).u32(8),
+				longSize:    8,
+				want:        "-1 7 4294967295 ff FF 10",
+			},
+			{
+				description: "length qualifiers",
+				format:      "%hhd %hd %ld %lld %zu",
+				args:        args().u8(0xff).u16(0xfffe).u64(0xfffffffffffffffd).u64(1 << 40).u64(42),
+				longSize:    8,
+				want:        "-1 -2 -3 1099511627776 42",
+			},
+			{
+				description: "long on a 32 bit machine",
+				format:      "%ld %llu",
+				args:        args().u32(0xfffffffd).u64(1 << 40),
+				longSize:    4,
+				want:        "-3 1099511627776",
+			},
+			{
+				description: "strings and chars are unaligned",
+				format:      "%s%c %d",
+				args:        args().str("ab").u8('!').u32(5),
+				longSize:    8,
+				want:        "ab! 5",
+			},
+			{
+				description: "flags, width and precision",
+				format:      "[%-4d] [%05d] [%+d] [%#x] [%.2s] [%6.3d]",
+				args:        args().u32(1).u32(42).u32(3).u32(255).str("abc").u32(7),
+				longSize:    8,
+				want:        "[1   ] [00042] [+3] [0xff] [ab] [   007]",
+			},
+			{
+				description: "star width and precision",
+				format:      "[%*d] [%-*d] [%.*s]",
+				args:        args().u32(4).u32(1).u32(3).u32(2).u32(1).str("xyz"),
+				longSize:    8,
+				want:        "[   1] [2  ] [x]",
+			},
+			{
+				description: "pointers",
+				format:      "%p %pS %pI4",
+				args:        args().u64(0xffffffff81000000).u64(0x1234).str("10.0.0.1"),
+				longSize:    8,
+				want:        "ffffffff81000000 0000000000001234 10.0.0.1",
+			},
+		}
+		for _, test := range tests {
+			got, err := formatBinaryPrintf(test.format, test.args.buf, test.longSize, endianness)
+			if err != nil {
+				t.Errorf("formatBinaryPrintf(%s, %s) yielded unexpected error %s", endianness, test.description, err)
+				continue
+			}
+			if got != test.want {
+				t.Errorf("formatBinaryPrintf(%s, %s) = %q, want %q", endianness, test.description, got, test.want)
+			}
+		}
+	}
+
+	errorTests := []struct {
+		description string
+		format      string
+		args        []byte
+	}{
+		{"missing argument", "%d %d", []byte{1, 0, 0, 0}},
+		{"unterminated string", "%s", []byte("abc")},
+		{"incomplete conversion", "%l", nil},
+		{"unsupported conversion", "%f", []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, test := range errorTests {
+		if _, err := formatBinaryPrintf(test.format, test.args, 8, binary.LittleEndian); err == nil {
+			t.Errorf("formatBinaryPrintf(%s) yielded no error", test.description)
+		}
+	}
+}
+
+// makePrintkPage returns a single ring buffer page containing a bprint event, a bputs event and a
+// bprint event whose format string is unknown.
+func makePrintkPage(endianness binary.ByteOrder) []byte {
+	bprint := func(fmtAddr uint64, args []byte) []byte {
+		event := make([]byte, 24, 24+len(args)+3)
+		endianness.PutUint16(event[0:], 6)
+		endianness.PutUint32(event[4:], 42)
+		endianness.PutUint64(event[8:], 0xffffffff810a0000)
+		endianness.PutUint64(event[16:], fmtAddr)
+		event = append(event, args...)
+		for len(event)%4 != 0 {
+			event = append(event, 0)
+		}
+		return event
+	}
+	bputs := make([]byte, 24)
+	endianness.PutUint16(bputs[0:], 10)
+	endianness.PutUint32(bputs[4:], 42)
+	endianness.PutUint64(bputs[8:], 0xffffffff810a0000)
+	endianness.PutUint64(bputs[16:], 0xffffffff81e6a320)
+
+	args := &binaryArgsWriter{endianness: endianness}
+	args.u32(3).str("foo").u64(0xffffffffffffffec).u8('!')
+	events := [][]byte{
+		bprint(0xffffffff81e6a2a8, args.buf),
+		bputs,
+		bprint(0xdead, nil),
+	}
+	return makeTestPage(endianness, events)
+}
+
+func TestParseTrace_Printk(t *testing.T) {
+	printkFormats, err := ParsePrintkFormats(testPrintkFormats)
+	if err != nil {
+		t.Fatalf("ParsePrintkFormats() yielded unexpected error %s", err)
+	}
+	for _, endianness := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(endianness.String(), func(t *testing.T) {
+			formats, err := parseRegularFormats([]string{testBprintFormat, testBputsFormat})
+			if err != nil {
+				t.Fatalf("parseRegularFormats() yielded unexpected error %s", err)
+			}
+			printkTp := &TraceParser{
+				HeaderFormat:             tp.HeaderFormat,
+				Formats:                  formats,
+				Endianness:               endianness,
+				failOnUnknownEventFormat: true,
+			}
+			printkTp.SetPrintkFormats(printkFormats)
+			var got []string
+			if err := printkTp.ParseTrace(bufio.NewReader(bytes.NewReader(makePrintkPage(endianness))), 0 /*=cpu*/, func(event *TraceEvent) (bool, error) {
+				got = append(got, event.TextProperties[PrintkMessageField])
+				return true, nil
+			}); err != nil {
+				t.Fatalf("error during ParseTrace(): %s", err)
+			}
+			want := []string{
+				"cpu 3 picked foo prio -20!",
+				"rq locked",
+				// The format string of the last event is unknown, so it has no message.
+				"",
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("TestParseTrace_Printk: Diff -want +got:\n%s", diff)
+			}
+
+			// The msg property is included in the event descriptors.
+			es, err := NewEventSetBuilder(printkTp).Finalize()
+			if err != nil {
+				t.Fatalf("Finalize() yielded unexpected error %s", err)
+			}
+			for _, ed := range es.GetEventDescriptor() {
+				last := ed.GetPropertyDescriptor()[len(ed.GetPropertyDescriptor())-1]
+				if name := es.GetStringTable()[last.GetName()]; name != PrintkMessageField || last.GetType() != pb.EventDescriptor_PropertyDescriptor_TEXT {
+					t.Errorf("event %s: last property is %s (%s), want %s (TEXT)", es.GetStringTable()[ed.GetName()], name, last.GetType(), PrintkMessageField)
+				}
+			}
+		})
+	}
+}
@@ -94,6 +94,9 @@ type ringBufferEvent struct {
 }
 
 // TypeLen returns the type_len field of a ringBufferEvent
+// The ringBufferEvent header is a C bitfield, so the position of type_len within it depends on the
+// endianness of the machine that recorded the trace: it occupies the lowest 5 bits on little endian
+// machines, and the highest 5 bits on big endian machines.
 // type_len is a field that describes both the type of the event and the length of its data array.
 // If 0 <= type_len <= 28, then the event is a data event and type_len << 2 is the length of the
 // data array. If type_len == 0 or type_len > 28, then type_len is just a type, and the length is
@@ -105,7 +108,8 @@ func (r *ringBufferEvent) TypeLen() (uint8, error) {
 		// Get the lower 5 bits.
 		return uint8(r.Bitfield & ((1 << typeLenSize) - 1)), nil
 	case binary.BigEndian:
-		return 0, errors.New("big endian is not supported")
+		// Get the upper 5 bits.
+		return uint8(r.Bitfield >> timeDeltaSize), nil
 	default:
 		return 0, errors.New("unknown endianness")
 	}
@@ -118,7 +122,8 @@ func (r *ringBufferEvent) TimeDelta() (uint32, error) {
 		// Get all but the lower 5 bits
 		return uint32(r.Bitfield >> typeLenSize), nil
 	case binary.BigEndian:
-		return 0, errors.New("big endian is not supported")
+		// Get all but the upper 5 bits
+		return uint32(r.Bitfield & ((1 << timeDeltaSize) - 1)), nil
 	default:
 		return 0, errors.New("unknown endianness")
 	}
@@ -214,12 +219,23 @@ func (r *ringBufferEvent) Length() (uint32, error) {
  * #################################################################################################
  */
 
+const (
+	// rbMissedEvents is set in the commit field of a page header if events were lost before the
+	// events on the page.
+	rbMissedEvents = 1 << 31
+	// rbMissedStored is set in the commit field of a page header if the number of lost events is
+	// stored after the end of the page data.
+	rbMissedStored = 1 << 30
+)
+
 // ringBufferPageHeader represents the header of a page
 type ringBufferPageHeader interface {
 	Timestamp() uint64
 	Commit() []byte
 	Overwrite() uint8
 	Size() uint64
+	MissedEvents() bool
+	MissedEventsStored() bool
 	SetEndianness(e binary.ByteOrder)
 	Endianness() binary.ByteOrder
 	Data() interface{}
@@ -261,6 +277,16 @@ func (r *ringBufferPageHeader64) Size() uint64 {
 	return r.data.Commit & 0xfffff
 }
 
+// MissedEvents returns true if events were lost before the events on this page
+func (r *ringBufferPageHeader64) MissedEvents() bool {
+	return r.data.Commit&rbMissedEvents != 0
+}
+
+// MissedEventsStored returns true if the number of lost events is stored after the page data
+func (r *ringBufferPageHeader64) MissedEventsStored() bool {
+	return r.data.Commit&rbMissedStored != 0
+}
+
 func (r *ringBufferPageHeader64) SetEndianness(e binary.ByteOrder) {
 	r.endianness = e
 }
@@ -309,6 +335,16 @@ func (r *ringBufferPageHeader32) Size() uint64 {
 	return uint64(r.data.Commit & 0xfffff)
 }
 
+// MissedEvents returns true if events were lost before the events on this page
+func (r *ringBufferPageHeader32) MissedEvents() bool {
+	return r.data.Commit&rbMissedEvents != 0
+}
+
+// MissedEventsStored returns true if the number of lost events is stored after the page data
+func (r *ringBufferPageHeader32) MissedEventsStored() bool {
+	return r.data.Commit&rbMissedStored != 0
+}
+
 func (r *ringBufferPageHeader32) SetEndianness(e binary.ByteOrder) {
 	r.endianness = e
 }
@@ -0,0 +1,458 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+// text_parser contains a parser for the textual output of FTrace, as read from the trace and
+// trace_pipe files in TraceFS.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/util/util"
+)
+
+var (
+	// textEventRe matches a single event line, such as:
+	//           <idle>-0     [001] d..2  1234.567890: sched_switch: prev_comm=swapper/1 prev_pid=0 ...
+	// The TGID column, e.g. "bash-1234  ( 1234) [000]", and the flags column are optional.
+	// The groups are: comm, pid, cpu, flags, timestamp, event name and the event's fields.
+	textEventRe = regexp.MustCompile(`^\s*(.*)-(\d+)\s+(?:\(\s*(?:\d+|-+)\)\s+)?\[(\d+)\]\s+(?:([^\s\d][^\s]*)\s+)?(\d+(?:\.\d+)?):\s+(\w+):\s?(.*)$`)
+	// textFieldRe matches the start of a key=value pair in an event's fields.
+	textFieldRe = regexp.MustCompile(`(?:^|\s)(\w+)=`)
+	// textLostEventsRe matches the marker that FTrace prints when events were lost on a CPU, such as:
+	// CPU:3 [LOST 123 EVENTS]
+	textLostEventsRe = regexp.MustCompile(`^\s*CPU:(\d+)\s+\[LOST\s+\d+\s+EVENTS\]`)
+	// textEntriesRe matches the header line that describes how many events are in the buffer, and how
+	// many were written, such as:
+	// # entries-in-buffer/entries-written: 1234/5678   #P:8
+	textEntriesRe = regexp.MustCompile(`^#\s*entries-in-buffer/entries-written:\s*(\d+)/(\d+)`)
+)
+
+// textTaskStates maps the letters used to print sched_switch's prev_state field to the bits they
+// represent.
+var textTaskStates = map[rune]int64{
+	'S': 0x01,
+	'D': 0x02,
+	'T': 0x04,
+	't': 0x08,
+	'X': 0x10,
+	'Z': 0x20,
+	'P': 0x40,
+	'I': 0x80,
+}
+
+// textTaskStatePreempted is the bit that FTrace prints as a "+" after sched_switch's prev_state.
+const textTaskStatePreempted = 0x100
+
+// The bits of common_flags, as printed in the flags column of the textual trace format.
+const (
+	textFlagIrqsOff        = 0x01
+	textFlagIrqsNoSupport  = 0x02
+	textFlagNeedResched    = 0x04
+	textFlagHardirq        = 0x08
+	textFlagSoftirq        = 0x10
+	textFlagPreemptResched = 0x20
+	textFlagNMI            = 0x40
+)
+
+// TextTraceParser parses the textual output of FTrace, as read from the trace or trace_pipe files,
+// into an EventSet. Each event line has the form:
+//     comm-pid [cpu] flags timestamp: event_name: field1=value1 field2=value2 ...
+// If a TraceParser is provided, its formats are used to type the fields of each event, and the
+// resulting EventSet has the same event descriptors as one produced by parsing the binary trace
+// with that TraceParser. Otherwise, a format is inferred for each event from the first line on
+// which the event appears: fields whose values are numbers are typed as numbers, and all other
+// fields as text.
+type TextTraceParser struct {
+	tp                       *TraceParser
+	failOnUnknownEventFormat bool
+	formatsByName            map[string]*EventFormat
+	esb                      *EventSetBuilder
+	filter                   *EventFilter
+	overflowedCPUs           map[int64]struct{}
+	seenCPUs                 map[int64]struct{}
+	entriesLost              bool
+}
+
+// NewTextTraceParser creates a new TextTraceParser. tp may be nil if the format files of the
+// trace are not available.
+func NewTextTraceParser(tp *TraceParser) *TextTraceParser {
+	ttp := &TextTraceParser{
+		tp:             tp,
+		formatsByName:  make(map[string]*EventFormat),
+		esb:            NewEventSetBuilder(tp),
+		overflowedCPUs: make(map[int64]struct{}),
+		seenCPUs:       make(map[int64]struct{}),
+	}
+	if tp != nil {
+		ttp.failOnUnknownEventFormat = tp.failOnUnknownEventFormat
+		for _, format := range tp.Formats {
+			ttp.formatsByName[format.Name] = format
+		}
+	}
+	return ttp
+}
+
+// SetEventFilter configures the TextTraceParser to drop the events that don't pass filter. Formats
+// are not inferred for dropped events. This must be called before Parse.
+func (ttp *TextTraceParser) SetEventFilter(filter *EventFilter) {
+	ttp.filter = filter
+	ttp.esb.SetEventFilter(filter)
+}
+
+// Parse reads all of the lines from reader, and returns the events they contain as an EventSet.
+// Comment lines, and lines that are not events (such as the output of the function tracer) are
+// skipped.
+func (ttp *TextTraceParser) Parse(reader io.Reader) (*pb.EventSet, error) {
+	scanner := bufio.NewScanner(reader)
+	// Lines can be longer than the default 64KiB limit when events contain large dynamic arrays.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if err := ttp.parseLine(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("error parsing line %d: %s", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read trace. caused by: %s", err)
+	}
+
+	// If the buffer overflowed, we can't tell which CPUs lost events, so treat all of them as
+	// overflowed.
+	if ttp.entriesLost {
+		for cpu := range ttp.seenCPUs {
+			ttp.overflowedCPUs[cpu] = struct{}{}
+		}
+	}
+	ttp.esb.SetOverflowedCPUs(ttp.overflowedCPUs)
+	return ttp.esb.Finalize()
+}
+
+func (ttp *TextTraceParser) parseLine(line string) error {
+	if strings.HasPrefix(line, "#") {
+		if matches := textEntriesRe.FindStringSubmatch(line); matches != nil {
+			inBuffer, err := strconv.ParseUint(matches[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			written, err := strconv.ParseUint(matches[2], 10, 64)
+			if err != nil {
+				return err
+			}
+			ttp.entriesLost = written > inBuffer
+		}
+		return nil
+	}
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	if matches := textLostEventsRe.FindStringSubmatch(line); matches != nil {
+		cpu, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		ttp.overflowedCPUs[cpu] = struct{}{}
+		return nil
+	}
+	matches := textEventRe.FindStringSubmatch(line)
+	if matches == nil {
+		util.LogWarnfEveryNTime(time.Second, "skipping line that does not contain an event: %q", line)
+		return nil
+	}
+	pid, err := strconv.ParseInt(matches[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid pid %q: %s", matches[2], err)
+	}
+	cpu, err := strconv.ParseInt(matches[3], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid cpu %q: %s", matches[3], err)
+	}
+	timestamp, err := parseTextTimestamp(matches[5])
+	if err != nil {
+		return err
+	}
+	eventName := matches[6]
+	if !ttp.filter.Keep(eventName, cpu, timestamp) {
+		return nil
+	}
+	fieldNames, fields := parseTextFields(matches[7])
+
+	eventFormat, ok := ttp.formatsByName[eventName]
+	if !ok {
+		if ttp.tp != nil {
+			if ttp.failOnUnknownEventFormat {
+				return fmt.Errorf("could not find format for event %q", eventName)
+			}
+			return nil
+		}
+		eventFormat = ttp.inferFormat(eventName, fieldNames, fields)
+	}
+	ttp.seenCPUs[cpu] = struct{}{}
+
+	commonFlags, preemptCount := parseTextFlags(matches[4])
+	traceEvent := NewTraceEvent(cpu)
+	traceEvent.Timestamp = timestamp
+	traceEvent.FormatID = eventFormat.ID
+	for _, field := range eventFormat.Format.CommonFields {
+		switch field.Name {
+		case "common_type":
+			setTextNumberField(traceEvent, field, int64(eventFormat.ID))
+		case "common_flags":
+			setTextNumberField(traceEvent, field, commonFlags)
+		case "common_preempt_count":
+			setTextNumberField(traceEvent, field, preemptCount)
+		case "common_pid":
+			setTextNumberField(traceEvent, field, pid)
+		}
+	}
+	for _, field := range eventFormat.Format.Fields {
+		value, ok := fields[field.Name]
+		if !ok {
+			continue
+		}
+		if field.IsDynamicNumericArray() {
+			vals, ok := parseTextNumberList(value)
+			if !ok {
+				util.LogWarnfEveryNTime(time.Second, "unable to parse value %q of field %q of event %q as a list of numbers", value, field.Name, eventName)
+				continue
+			}
+			traceEvent.NumberListProperties[field.Name] = vals
+			continue
+		}
+		if field.ProtoType == "string" {
+			traceEvent.TextProperties[field.Name] = value
+			continue
+		}
+		num, ok := parseTextNumber(eventName, field.Name, value)
+		if !ok {
+			util.LogWarnfEveryNTime(time.Second, "unable to parse value %q of field %q of event %q as a number", value, field.Name, eventName)
+			continue
+		}
+		traceEvent.NumberProperties[field.Name] = num
+	}
+	return ttp.esb.AddTraceEvent(traceEvent)
+}
+
+// inferFormat creates and registers a format for an event whose format is unknown, based on the
+// fields of its first occurrence.
+func (ttp *TextTraceParser) inferFormat(eventName string, fieldNames []string, fields map[string]string) *EventFormat {
+	eventFormat := &EventFormat{
+		Name: eventName,
+		// IDs are only used to identify formats within the EventSetBuilder, so they can be arbitrary.
+		ID: uint16(len(ttp.formatsByName) + 1),
+		Format: Format{
+			CommonFields: []*FormatField{
+				{FieldType: "unsigned short common_type", Name: "common_type", ProtoType: "int64", Size: 2, NumElements: 1, ElementSize: 2},
+				{FieldType: "unsigned char common_flags", Name: "common_flags", ProtoType: "int64", Offset: 2, Size: 1, NumElements: 1, ElementSize: 1},
+				{FieldType: "unsigned char common_preempt_count", Name: "common_preempt_count", ProtoType: "int64", Offset: 3, Size: 1, NumElements: 1, ElementSize: 1},
+				{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+			},
+		},
+	}
+	for _, name := range fieldNames {
+		field := &FormatField{Name: name, ProtoType: "string", NumElements: 1}
+		if _, ok := parseTextNumber(eventName, name, fields[name]); ok {
+			field.ProtoType = "int64"
+			field.Signed = true
+		}
+		eventFormat.Format.Fields = append(eventFormat.Format.Fields, field)
+	}
+	ttp.formatsByName[eventName] = eventFormat
+	ttp.esb.AddFormat(eventFormat)
+	return eventFormat
+}
+
+// setTextNumberField stores a number into a field of a TraceEvent. Fields that are typed as
+// strings, such as single char fields in some formats, are stored the same way that the binary
+// parser stores them.
+func setTextNumberField(traceEvent *TraceEvent, field *FormatField, value int64) {
+	if field.ProtoType == "string" {
+		traceEvent.TextProperties[field.Name] = strings.Split(string([]byte{byte(value)}), "\x00")[0]
+		return
+	}
+	traceEvent.NumberProperties[field.Name] = value
+}
+
+// parseTextTimestamp converts a timestamp in seconds, such as 1234.567890, into nanoseconds.
+// Timestamps without a fractional part, which are printed for clocks like counter, are returned
+// as-is.
+func parseTextTimestamp(timestamp string) (uint64, error) {
+	parts := strings.SplitN(timestamp, ".", 2)
+	secs, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q: %s", timestamp, err)
+	}
+	if len(parts) == 1 {
+		return secs, nil
+	}
+	frac := parts[1]
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	fracNs, err := strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q: %s", timestamp, err)
+	}
+	return secs*uint64(time.Second) + fracNs, nil
+}
+
+// parseTextFields splits the fields of an event into a map from field name to value, and also
+// returns the field names in the order they were printed in. Fields are expected to be printed as
+// key=value pairs separated by whitespace. Values may contain whitespace.
+func parseTextFields(fieldsText string) ([]string, map[string]string) {
+	var names []string
+	fields := make(map[string]string)
+	indices := textFieldRe.FindAllStringSubmatchIndex(fieldsText, -1)
+	for i, idx := range indices {
+		end := len(fieldsText)
+		if i+1 < len(indices) {
+			end = indices[i+1][0]
+		}
+		value := strings.TrimSpace(fieldsText[idx[1]:end])
+		// sched_switch separates the previous and next tasks with "==>".
+		value = strings.TrimSpace(strings.TrimSuffix(value, "==>"))
+		name := fieldsText[idx[2]:idx[3]]
+		if _, ok := fields[name]; !ok {
+			names = append(names, name)
+		}
+		fields[name] = value
+	}
+	return names, fields
+}
+
+// parseTextNumber parses a number printed in an event's fields. Numbers may be printed in decimal,
+// or in hexadecimal with a 0x prefix. The prev_state field of sched_switch is printed as a set of
+// task state letters, which are converted back to the task state bits.
+func parseTextNumber(eventName, fieldName, value string) (int64, bool) {
+	if eventName == "sched_switch" && fieldName == "prev_state" {
+		if state, ok := ParseTextTaskState(value); ok {
+			return state, true
+		}
+	}
+	base := 10
+	digits := value
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		base = 16
+		digits = digits[2:]
+	}
+	if num, err := strconv.ParseInt(digits, base, 64); err == nil {
+		return num, true
+	}
+	// Large unsigned values are stored in their two's complement form.
+	if num, err := strconv.ParseUint(digits, base, 64); err == nil {
+		return int64(num), true
+	}
+	return 0, false
+}
+
+// parseTextNumberList parses a dynamic array of numbers printed in an event's fields, such as
+// {0x1,0x2} as printed by __print_array. The braces are optional, and an empty list may be printed
+// as {} or not at all.
+func parseTextNumberList(value string) ([]int64, bool) {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(value, "{"), "}"))
+	vals := []int64{}
+	if value == "" {
+		return vals, true
+	}
+	for _, elem := range strings.Split(value, ",") {
+		num, ok := parseTextNumber("", "", strings.TrimSpace(elem))
+		if !ok {
+			return nil, false
+		}
+		vals = append(vals, num)
+	}
+	return vals, true
+}
+
+// ParseTextTaskState converts a task state printed by sched_switch, such as "R", "S", "D|W" or
+// "R+", into the task state bits.
+func ParseTextTaskState(value string) (int64, bool) {
+	var state int64
+	if strings.HasSuffix(value, "+") {
+		state |= textTaskStatePreempted
+		value = strings.TrimSuffix(value, "+")
+	}
+	if value == "R" {
+		return state, true
+	}
+	if value == "" {
+		return 0, false
+	}
+	for _, letter := range strings.Split(value, "|") {
+		if len(letter) != 1 {
+			return 0, false
+		}
+		bit, ok := textTaskStates[rune(letter[0])]
+		if !ok {
+			return 0, false
+		}
+		state |= bit
+	}
+	return state, true
+}
+
+// parseTextFlags converts the flags column of an event line, such as "dNh2", into the values of
+// the common_flags and common_preempt_count fields.
+func parseTextFlags(flags string) (int64, int64) {
+	var commonFlags, preemptCount int64
+	for i, c := range flags {
+		switch i {
+		case 0:
+			switch c {
+			case 'd', 'D':
+				commonFlags |= textFlagIrqsOff
+			case 'X':
+				commonFlags |= textFlagIrqsNoSupport
+			}
+		case 1:
+			switch c {
+			case 'N':
+				commonFlags |= textFlagNeedResched | textFlagPreemptResched
+			case 'n':
+				commonFlags |= textFlagNeedResched
+			case 'p':
+				commonFlags |= textFlagPreemptResched
+			}
+		case 2:
+			switch c {
+			case 'Z':
+				commonFlags |= textFlagNMI | textFlagHardirq
+			case 'z':
+				commonFlags |= textFlagNMI
+			case 'H':
+				commonFlags |= textFlagHardirq | textFlagSoftirq
+			case 'h':
+				commonFlags |= textFlagHardirq
+			case 's':
+				commonFlags |= textFlagSoftirq
+			}
+		case 3:
+			if depth, err := strconv.ParseInt(string(c), 16, 64); err == nil {
+				preemptCount = depth
+			}
+		}
+	}
+	return commonFlags, preemptCount
+}
@@ -0,0 +1,249 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testTextTrace = `# tracer: nop
+#
+# entries-in-buffer/entries-written: 3/3   #P:2
+#
+#                              _-----=> irqs-off
+#                             / _----=> need-resched
+#                            | / _---=> hardirq/softirq
+#                            || / _--=> preempt-depth
+#                            ||| /     delay
+#           TASK-PID   CPU#  ||||    TIMESTAMP  FUNCTION
+#              | |       |   ||||       |         |
+          <idle>-0     [001] d..2  1040.483711: sched_switch: prev_comm=swapper/1 prev_pid=0 prev_prio=120 prev_state=R ==> next_comm=Web Content next_pid=166549 next_prio=120
+     Web Content-166549 [001] dNh1  1040.483712: sched_wakeup: comm=kworker/1:1-events pid=42 prio=-51 target_cpu=000
+      kworker/0:1-12   (   12) [000] .... 1040.483713: sched_switch: prev_comm=kworker/0:1 prev_pid=12 prev_prio=120 prev_state=D|W ==> next_comm=swapper/0 next_pid=0 next_prio=120
+      kworker/0:1-12   [000] ....  1040.483714: function: schedule <-worker_thread
+`
+
+func TestTextTraceParser(t *testing.T) {
+	_ = tp.SetLittleEndian()
+	esb := NewEventSetBuilder(tp)
+	for _, traceEvent := range []*TraceEvent{
+		{
+			Timestamp:        1040483711000,
+			CPU:              1,
+			TextProperties:   map[string]string{"common_flags": "\x01", "common_preempt_count": "\x02", "prev_comm": "swapper/1", "next_comm": "Web Content"},
+			NumberProperties: map[string]int64{"common_type": 297, "common_pid": 0, "prev_pid": 0, "prev_prio": 120, "prev_state": 0, "next_pid": 166549, "next_prio": 120},
+			FormatID:         297,
+		},
+		{
+			Timestamp:        1040483712000,
+			CPU:              1,
+			TextProperties:   map[string]string{"common_flags": "\x2d", "common_preempt_count": "\x01", "comm": "kworker/1:1-events"},
+			NumberProperties: map[string]int64{"common_type": 299, "common_pid": 166549, "pid": 42, "prio": -51, "target_cpu": 0},
+			FormatID:         299,
+		},
+		{
+			Timestamp:        1040483713000,
+			CPU:              0,
+			TextProperties:   map[string]string{"common_flags": "", "common_preempt_count": "", "prev_comm": "kworker/0:1", "next_comm": "swapper/0"},
+			NumberProperties: map[string]int64{"common_type": 297, "common_pid": 12, "prev_pid": 12, "prev_prio": 120, "next_pid": 0, "next_prio": 120},
+			FormatID:         297,
+		},
+	} {
+		if err := esb.AddTraceEvent(traceEvent); err != nil {
+			t.Fatalf("error in AddTraceEvent: %s", err)
+		}
+	}
+	want, err := esb.Finalize()
+	if err != nil {
+		t.Fatalf("unexpected error finalizing events: %s", err)
+	}
+
+	// The function event is unknown, so ignore it rather than failing.
+	tp.SetFailOnUnknownEventFormat(false)
+	defer tp.SetFailOnUnknownEventFormat(true)
+	// The prev_state of the second sched_switch event, D|W, contains a task state that isn't known,
+	// so it should be left unset.
+	got, err := NewTextTraceParser(tp).Parse(strings.NewReader(testTextTrace))
+	if err != nil {
+		t.Fatalf("unexpected error parsing text trace: %s", err)
+	}
+	if diff := cmp.Diff(proto.MarshalTextString(want), proto.MarshalTextString(got)); diff != "" {
+		t.Fatalf("TestTextTraceParser: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestTextTraceParser_UnknownEvent(t *testing.T) {
+	if _, err := NewTextTraceParser(tp).Parse(strings.NewReader(testTextTrace)); err == nil {
+		t.Fatalf("expected an error parsing an event without a format")
+	}
+}
+
+func TestTextTraceParser_InferredFormats(t *testing.T) {
+	got, err := NewTextTraceParser(nil).Parse(strings.NewReader(testTextTrace))
+	if err != nil {
+		t.Fatalf("unexpected error parsing text trace: %s", err)
+	}
+
+	gotDescriptors := map[string][]string{}
+	for _, ed := range got.EventDescriptor {
+		var props []string
+		for _, pd := range ed.PropertyDescriptor {
+			props = append(props, fmt.Sprintf("%s:%s", got.StringTable[pd.Name], pd.Type))
+		}
+		gotDescriptors[got.StringTable[ed.Name]] = props
+	}
+	common := []string{"common_type:NUMBER", "common_flags:NUMBER", "common_preempt_count:NUMBER", "common_pid:NUMBER"}
+	wantDescriptors := map[string][]string{
+		"sched_switch": append(append([]string{}, common...),
+			"prev_comm:TEXT", "prev_pid:NUMBER", "prev_prio:NUMBER", "prev_state:NUMBER", "next_comm:TEXT", "next_pid:NUMBER", "next_prio:NUMBER"),
+		"sched_wakeup": append(append([]string{}, common...),
+			"comm:TEXT", "pid:NUMBER", "prio:NUMBER", "target_cpu:NUMBER"),
+		"function": common,
+	}
+	if diff := cmp.Diff(wantDescriptors, gotDescriptors); diff != "" {
+		t.Fatalf("event descriptors: Diff -want +got:\n%s", diff)
+	}
+
+	if len(got.Event) != 4 {
+		t.Fatalf("expected 4 events, but got %d", len(got.Event))
+	}
+	// Check the wakeup event: common_type, common_flags, common_preempt_count, common_pid, comm,
+	// pid, prio, target_cpu.
+	wakeup := got.Event[1]
+	if diff := cmp.Diff([]int64{2, 0x2d, 1, 166549}, wakeup.Property[:4]); diff != "" {
+		t.Fatalf("wakeup common properties: Diff -want +got:\n%s", diff)
+	}
+	if got.StringTable[wakeup.Property[4]] != "kworker/1:1-events" {
+		t.Fatalf("wakeup comm: got %q", got.StringTable[wakeup.Property[4]])
+	}
+	if diff := cmp.Diff([]int64{42, -51, 0}, wakeup.Property[5:]); diff != "" {
+		t.Fatalf("wakeup properties: Diff -want +got:\n%s", diff)
+	}
+}
+
+func TestTextTraceParser_LostEvents(t *testing.T) {
+	tests := []struct {
+		trace       string
+		wantClipped []bool
+	}{
+		{
+			trace: `# entries-in-buffer/entries-written: 2/2   #P:2
+  a-1 [000] .... 1.000001: e: x=1
+  b-2 [001] .... 1.000002: e: x=2
+`,
+			wantClipped: []bool{false, false},
+		},
+		{
+			trace: `# entries-in-buffer/entries-written: 2/5   #P:2
+  a-1 [000] .... 1.000001: e: x=1
+  b-2 [001] .... 1.000002: e: x=2
+`,
+			wantClipped: []bool{true, false},
+		},
+		{
+			trace: `  a-1 [000] .... 1.000001: e: x=1
+CPU:1 [LOST 5 EVENTS]
+  b-2 [001] .... 1.000002: e: x=2
+  b-2 [001] .... 1.000003: e: x=3
+`,
+			wantClipped: []bool{true, false, false},
+		},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestTextTraceParser_LostEvents Case: %d", i), func(t *testing.T) {
+			got, err := NewTextTraceParser(nil).Parse(strings.NewReader(test.trace))
+			if err != nil {
+				t.Fatalf("unexpected error parsing text trace: %s", err)
+			}
+			var gotClipped []bool
+			for _, event := range got.Event {
+				gotClipped = append(gotClipped, event.Clipped)
+			}
+			if diff := cmp.Diff(test.wantClipped, gotClipped); diff != "" {
+				t.Fatalf("clipped events: Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseTextTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"1040.483711", 1040483711000},
+		{"1040.483711123", 1040483711123},
+		{"1040.4837111234", 1040483711123},
+		{"12345", 12345},
+	}
+	for _, test := range tests {
+		got, err := parseTextTimestamp(test.in)
+		if err != nil {
+			t.Fatalf("parseTextTimestamp(%q) returned error: %s", test.in, err)
+		}
+		if got != test.want {
+			t.Errorf("parseTextTimestamp(%q) = %d, want %d", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseTextTaskState(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   int64
+		wantOk bool
+	}{
+		{"R", 0, true},
+		{"R+", 0x100, true},
+		{"S", 0x01, true},
+		{"D|K", 0, false},
+		{"T|Z", 0x24, true},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := ParseTextTaskState(test.in)
+		if got != test.want || ok != test.wantOk {
+			t.Errorf("ParseTextTaskState(%q) = (%d, %t), want (%d, %t)", test.in, got, ok, test.want, test.wantOk)
+		}
+	}
+}
+
+
+func TestParseTextNumberList(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   []int64
+		wantOk bool
+	}{
+		{"{0x1,0x2,0xffff}", []int64{1, 2, 0xffff}, true},
+		{"1, -2", []int64{1, -2}, true},
+		{"{}", []int64{}, true},
+		{"", []int64{}, true},
+		{"{1,x}", nil, false},
+	}
+	for _, test := range tests {
+		got, ok := parseTextNumberList(test.in)
+		if diff := cmp.Diff(test.want, got); diff != "" || ok != test.wantOk {
+			t.Errorf("parseTextNumberList(%q) = (%v, %t), want (%v, %t)", test.in, got, ok, test.want, test.wantOk)
+		}
+	}
+}
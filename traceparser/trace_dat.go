@@ -0,0 +1,850 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+// trace_dat contains a reader for the trace.dat files produced by trace-cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+	"github.com/google/schedviz/util/util"
+)
+
+// traceDatMagic is the sequence of bytes that every trace.dat file starts with.
+var traceDatMagic = []byte{0x17, 0x08, 0x44, 't', 'r', 'a', 'c', 'i', 'n', 'g'}
+
+// TraceDatMagicSize is the number of bytes needed by IsTraceDat to identify a trace.dat file.
+var TraceDatMagicSize = len(traceDatMagic)
+
+// trace-cmd option and section IDs.
+// See https://git.kernel.org/pub/scm/utils/trace-cmd/trace-cmd.git/tree/Documentation/trace-cmd/trace-cmd.dat.v7.5.txt
+const (
+	traceDatOptionDone         = 0
+	traceDatOptionCPUStat      = 2
+	traceDatOptionBuffer       = 3
+	traceDatOptionTraceClock   = 4
+	traceDatOptionCPUCount     = 8
+	traceDatOptionTSC2NSec     = 14
+	traceDatOptionHeaderInfo   = 16
+	traceDatOptionFtraceEvents = 17
+	traceDatOptionEventFormats = 18
+	traceDatOptionKallsyms     = 19
+	traceDatOptionPrintk       = 20
+	traceDatOptionCmdlines     = 21
+	traceDatOptionBufferText   = 22
+)
+
+const (
+	// traceDatSectionCompressed is set in a v7 section's flags if its contents are compressed.
+	traceDatSectionCompressed = 1
+	// traceDatLabelSize is the size of the labels preceding the v6 data sections.
+	traceDatLabelSize = 10
+)
+
+// TraceDat holds the metadata of a trace-cmd trace.dat file (versions 6 and 7), as well as
+// the location of the raw ring buffer data for each CPU.
+// Use ReadTraceDat() to construct one, NewTraceParser() to build a TraceParser from its formats,
+// and WalkPerCPUBuffers() to read the ring buffer data of each CPU.
+type TraceDat struct {
+	// The file format version. Either 6 or 7.
+	Version int
+	// The byte order of the host that recorded the trace.
+	Endianness binary.ByteOrder
+	// The size in bytes of a long on the host that recorded the trace.
+	LongSize uint8
+	// The size in bytes of a ring buffer page.
+	PageSize uint32
+	// The contents of the header_page format file.
+	HeaderPage string
+	// The contents of the header_event format file.
+	HeaderEvent string
+	// The format files of the ftrace internal events (function, print, ...).
+	FtraceFormats []string
+	// The format files of all other events, such as sched/sched_switch/format.
+	EventFormats []string
+	// The contents of /proc/kallsyms at recording time, if recorded.
+	Kallsyms string
+	// The contents of printk_formats at recording time, if recorded.
+	PrintkFormats string
+	// The contents of saved_cmdlines at recording time, if recorded.
+	Cmdlines string
+	// The contents of the trace_clock file at recording time, if recorded.
+	TraceClock string
+	// The parameters used to convert TSC timestamps to ns, as (tsc * TSCMult) >> TSCShift, if
+	// recorded.
+	TSCMult  uint32
+	TSCShift uint32
+	// The number of CPUs that were traced.
+	NumCPUs int
+	// A mapping of CPU to its per_cpu stats file, converted to a map by statsBufferToMap.
+	CPUStats map[int64]map[string]string
+
+	reader     io.ReaderAt
+	cpuBuffers []traceDatCPUBuffer
+}
+
+// traceDatCPUBuffer is the location of a single CPU's ring buffer data in a trace.dat file.
+type traceDatCPUBuffer struct {
+	cpu    int64
+	offset int64
+	size   int64
+}
+
+// IsTraceDat returns true if the provided bytes begin with the trace.dat magic.
+// At least TraceDatMagicSize bytes are needed for a positive result.
+func IsTraceDat(header []byte) bool {
+	return bytes.HasPrefix(header, traceDatMagic)
+}
+
+// ReadTraceDat reads the metadata of a trace.dat file.
+// The ring buffer data is not read until WalkPerCPUBuffers() is called, so reader should remain
+// open until then.
+func ReadTraceDat(reader io.ReaderAt) (*TraceDat, error) {
+	r := &traceDatReader{reader: reader, size: readerSize(reader)}
+
+	magic, err := r.readBytes(len(traceDatMagic))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trace.dat magic. caused by: %s", err)
+	}
+	if !IsTraceDat(magic) {
+		return nil, errors.New("not a trace.dat file: magic does not match")
+	}
+	versionStr, err := r.readString()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trace.dat version. caused by: %s", err)
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse trace.dat version %q. caused by: %s", versionStr, err)
+	}
+
+	td := &TraceDat{
+		Version:  version,
+		CPUStats: make(map[int64]map[string]string),
+		reader:   reader,
+	}
+
+	endian, err := r.readBytes(1)
+	if err != nil {
+		return nil, err
+	}
+	switch endian[0] {
+	case 0:
+		td.Endianness = binary.LittleEndian
+	case 1:
+		td.Endianness = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unknown endianness marker: %d", endian[0])
+	}
+	r.endianness = td.Endianness
+
+	longSize, err := r.readBytes(1)
+	if err != nil {
+		return nil, err
+	}
+	td.LongSize = longSize[0]
+	if td.PageSize, err = r.readUint32(); err != nil {
+		return nil, err
+	}
+
+	switch version {
+	case 6:
+		err = td.readV6(r)
+	case 7:
+		err = td.readV7(r)
+	default:
+		err = fmt.Errorf("unsupported trace.dat version %d. only versions 6 and 7 are supported", version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+// NewTraceParser creates a TraceParser from the formats stored in the trace.dat file.
+func (td *TraceDat) NewTraceParser() (TraceParser, error) {
+	formats := append(append([]string{}, td.FtraceFormats...), td.EventFormats...)
+	tp, err := New(td.HeaderPage, formats)
+	if err != nil {
+		return TraceParser{}, err
+	}
+	tp.Endianness = td.Endianness
+	if td.PrintkFormats != "" {
+		printkFormats, err := ParsePrintkFormats(td.PrintkFormats)
+		if err != nil {
+			return TraceParser{}, err
+		}
+		tp.SetPrintkFormats(printkFormats)
+	}
+	if td.Kallsyms != "" {
+		kallsyms, err := ParseKallsyms(td.Kallsyms)
+		if err != nil {
+			return TraceParser{}, err
+		}
+		tp.SetKallsyms(kallsyms)
+	}
+	return tp, nil
+}
+
+// OverflowedCPUs returns the set of CPUs whose recorded stats show that events were lost.
+func (td *TraceDat) OverflowedCPUs() map[int64]struct{} {
+	overflowed := make(map[int64]struct{})
+	for cpu, stats := range td.CPUStats {
+		if CPUOverflowedByMap(stats) {
+			overflowed[cpu] = struct{}{}
+		}
+	}
+	return overflowed
+}
+
+// Clock returns the trace clock that produced the trace's timestamps, or nil if it wasn't recorded.
+func (td *TraceDat) Clock() *pb.TraceClock {
+	name := selectedTraceClock(td.TraceClock)
+	if name == "" {
+		return nil
+	}
+	return &pb.TraceClock{
+		Name:  name,
+		Mult:  td.TSCMult,
+		Shift: td.TSCShift,
+	}
+}
+
+// selectedTraceClock returns the name of the selected clock in the contents of FTrace's trace_clock
+// file, which lists the available clocks with the selected one in brackets, i.e.
+// "local [global] counter". contents may also hold just the name of the selected clock.
+func selectedTraceClock(contents string) string {
+	clocks := strings.Fields(contents)
+	for _, clock := range clocks {
+		if strings.HasPrefix(clock, "[") && strings.HasSuffix(clock, "]") {
+			return strings.Trim(clock, "[]")
+		}
+	}
+	if len(clocks) == 1 {
+		return clocks[0]
+	}
+	return ""
+}
+
+// WalkPerCPUBuffers calls process with a bufio.Reader over the raw ring buffer data of each CPU
+// recorded in the trace.dat file, in increasing CPU order.
+func (td *TraceDat) WalkPerCPUBuffers(process func(reader *bufio.Reader, cpu int64) error) error {
+	for _, buf := range td.cpuBuffers {
+		if buf.size == 0 {
+			continue
+		}
+		reader := bufio.NewReader(io.NewSectionReader(td.reader, buf.offset, buf.size))
+		if err := process(reader, buf.cpu); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EventSet parses the ring buffer data of every CPU in the trace.dat file, and returns the parsed
+// events as an EventSet. CPUs whose stats show that events were lost are clipped. The EventSet
+// records the trace clock, so that its timestamps can be converted to ns.
+// Events that don't pass filter are dropped; filter may be nil to keep every event.
+func (td *TraceDat) EventSet(failOnUnknownEventFormat bool, filter *EventFilter) (*pb.EventSet, error) {
+	traceParser, err := td.NewTraceParser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse formats: %s", err)
+	}
+	traceParser.SetFailOnUnknownEventFormat(failOnUnknownEventFormat)
+
+	eventSetBuilder := NewEventSetBuilder(&traceParser)
+	eventSetBuilder.SetOverflowedCPUs(td.OverflowedCPUs())
+	eventSetBuilder.SetEventFilter(filter)
+
+	if err := traceParser.ParseTracesInParallel(td.WalkPerCPUBuffers, eventSetBuilder, 0 /*=parallelism*/); err != nil {
+		return nil, fmt.Errorf("failed to read trace.dat buffers: %s", err)
+	}
+	es, err := eventSetBuilder.Finalize()
+	if err != nil {
+		return nil, err
+	}
+	es.TraceClock = td.Clock()
+	return es, nil
+}
+
+// readV6 reads the remainder of a version 6 trace.dat file, in which all sections are laid out
+// sequentially, in a fixed order.
+func (td *TraceDat) readV6(r *traceDatReader) error {
+	if err := td.readHeaderInfo(r); err != nil {
+		return err
+	}
+	if err := td.readFtraceEvents(r); err != nil {
+		return err
+	}
+	if err := td.readEventFormats(r); err != nil {
+		return err
+	}
+	var err error
+	if td.Kallsyms, err = r.readSizedString(4); err != nil {
+		return fmt.Errorf("unable to read kallsyms. caused by: %s", err)
+	}
+	if td.PrintkFormats, err = r.readSizedString(4); err != nil {
+		return fmt.Errorf("unable to read printk formats. caused by: %s", err)
+	}
+	if td.Cmdlines, err = r.readSizedString(8); err != nil {
+		return fmt.Errorf("unable to read cmdlines. caused by: %s", err)
+	}
+	numCPUs, err := r.readUint32()
+	if err != nil {
+		return err
+	}
+	td.NumCPUs = int(numCPUs)
+
+	for {
+		label, err := r.readBytes(traceDatLabelSize)
+		if err != nil {
+			return fmt.Errorf("unable to read data section label. caused by: %s", err)
+		}
+		switch string(label) {
+		case "options  \x00":
+			if err := td.readV6Options(r); err != nil {
+				return err
+			}
+		case "flyrecord\x00":
+			for cpu := 0; cpu < td.NumCPUs; cpu++ {
+				offset, err := r.readUint64()
+				if err != nil {
+					return err
+				}
+				size, err := r.readUint64()
+				if err != nil {
+					return err
+				}
+				td.cpuBuffers = append(td.cpuBuffers, traceDatCPUBuffer{
+					cpu:    int64(cpu),
+					offset: int64(offset),
+					size:   int64(size),
+				})
+			}
+			return nil
+		case "latency  \x00":
+			return errors.New("latency traces are not supported. record the trace with flyrecord instead")
+		default:
+			return fmt.Errorf("unknown data section label %q", label)
+		}
+	}
+}
+
+// readV6Options reads a version 6 options list, which is terminated by an option with ID 0.
+func (td *TraceDat) readV6Options(r *traceDatReader) error {
+	for {
+		id, err := r.readUint16()
+		if err != nil {
+			return err
+		}
+		if id == traceDatOptionDone {
+			return nil
+		}
+		size, err := r.readUint32()
+		if err != nil {
+			return err
+		}
+		data, err := r.readSizedBytes(uint64(size))
+		if err != nil {
+			return err
+		}
+		if err := td.handleOption(id, data); err != nil {
+			return err
+		}
+	}
+}
+
+// readV7 reads the remainder of a version 7 trace.dat file, in which all data lives in sections
+// that are referenced from one or more chained options sections.
+func (td *TraceDat) readV7(r *traceDatReader) error {
+	compression, err := r.readString()
+	if err != nil {
+		return err
+	}
+	// The compression algorithm's version.
+	if _, err := r.readString(); err != nil {
+		return err
+	}
+	if compression != "" && compression != "none" {
+		return fmt.Errorf("compressed trace.dat files are not supported. compression algorithm: %q", compression)
+	}
+	optionsOffset, err := r.readUint64()
+	if err != nil {
+		return err
+	}
+
+	// Guard against option sections that point at each other.
+	visited := map[uint64]struct{}{}
+	for optionsOffset != 0 {
+		if _, ok := visited[optionsOffset]; ok {
+			return fmt.Errorf("options section at offset %d was already read", optionsOffset)
+		}
+		visited[optionsOffset] = struct{}{}
+		data, err := r.readSection(optionsOffset, traceDatOptionDone)
+		if err != nil {
+			return fmt.Errorf("unable to read options section. caused by: %s", err)
+		}
+		if optionsOffset, err = td.readV7Options(r.sub(data)); err != nil {
+			return err
+		}
+	}
+	if td.HeaderPage == "" {
+		return errors.New("trace.dat file is missing its header_page")
+	}
+	return nil
+}
+
+// readV7Options reads the options in a single version 7 options section, and returns the offset
+// of the next options section, or 0 if there is none.
+func (td *TraceDat) readV7Options(r *traceDatReader) (uint64, error) {
+	for {
+		id, err := r.readUint16()
+		if err != nil {
+			return 0, err
+		}
+		size, err := r.readUint32()
+		if err != nil {
+			return 0, err
+		}
+		data, err := r.readSizedBytes(uint64(size))
+		if err != nil {
+			return 0, err
+		}
+		if id == traceDatOptionDone {
+			if len(data) < 8 {
+				return 0, nil
+			}
+			return td.Endianness.Uint64(data), nil
+		}
+		if err := td.handleOption(id, data); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// handleOption interprets a single option. Unknown options are ignored.
+func (td *TraceDat) handleOption(id uint16, data []byte) error {
+	r := (&traceDatReader{endianness: td.Endianness}).sub(data)
+	switch id {
+	case traceDatOptionCPUStat:
+		return td.readCPUStat(string(data))
+	case traceDatOptionTraceClock:
+		td.TraceClock = strings.TrimRight(string(data), "\x00")
+	case traceDatOptionTSC2NSec:
+		// The multiplier and shift are followed by an offset, which only matters when comparing
+		// timestamps with other machines' traces, so it is ignored.
+		var err error
+		if td.TSCMult, err = r.readUint32(); err != nil {
+			return err
+		}
+		if td.TSCShift, err = r.readUint32(); err != nil {
+			return err
+		}
+	case traceDatOptionCPUCount:
+		numCPUs, err := r.readUint32()
+		if err != nil {
+			return err
+		}
+		td.NumCPUs = int(numCPUs)
+	case traceDatOptionBuffer:
+		return td.readBufferOption(r)
+	case traceDatOptionBufferText:
+		return errors.New("latency traces are not supported. record the trace with flyrecord instead")
+	case traceDatOptionHeaderInfo, traceDatOptionFtraceEvents, traceDatOptionEventFormats,
+		traceDatOptionKallsyms, traceDatOptionPrintk, traceDatOptionCmdlines:
+		if td.Version < 7 {
+			// In version 6 files, these are stored inline instead of in options.
+			return nil
+		}
+		offset, err := r.readUint64()
+		if err != nil {
+			return err
+		}
+		return td.readV7DataSection(id, offset)
+	}
+	return nil
+}
+
+// readV7DataSection reads the section with the provided ID at the provided offset.
+func (td *TraceDat) readV7DataSection(id uint16, offset uint64) error {
+	base := &traceDatReader{reader: td.reader, size: readerSize(td.reader), endianness: td.Endianness}
+	data, err := base.readSection(offset, id)
+	if err != nil {
+		return err
+	}
+	r := base.sub(data)
+	switch id {
+	case traceDatOptionHeaderInfo:
+		return td.readHeaderInfo(r)
+	case traceDatOptionFtraceEvents:
+		return td.readFtraceEvents(r)
+	case traceDatOptionEventFormats:
+		return td.readEventFormats(r)
+	case traceDatOptionKallsyms:
+		td.Kallsyms, err = r.readSizedString(4)
+	case traceDatOptionPrintk:
+		td.PrintkFormats, err = r.readSizedString(4)
+	case traceDatOptionCmdlines:
+		td.Cmdlines, err = r.readSizedString(8)
+	}
+	return err
+}
+
+// readBufferOption reads the location of ring buffer data. Only the top level buffer, which has
+// an empty name, is read; other trace instances are ignored.
+func (td *TraceDat) readBufferOption(r *traceDatReader) error {
+	// The offset of the buffer section (version 7) or of the instance's flyrecord (version 6).
+	if _, err := r.readUint64(); err != nil {
+		return err
+	}
+	name, err := r.readString()
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		util.LogWarnfEveryNTime(100*time.Microsecond, "ignoring buffer for trace instance %q. only the top level buffer is read", name)
+		return nil
+	}
+	if td.Version < 7 {
+		// In version 6 files, the top level buffer is described by the flyrecord section.
+		return nil
+	}
+	clock, err := r.readString()
+	if err != nil {
+		return err
+	}
+	if td.TraceClock == "" {
+		td.TraceClock = clock
+	}
+	pageSize, err := r.readUint32()
+	if err != nil {
+		return err
+	}
+	if pageSize != td.PageSize {
+		return fmt.Errorf("buffer page size %d does not match the file's page size %d", pageSize, td.PageSize)
+	}
+	numCPUs, err := r.readUint32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < numCPUs; i++ {
+		cpu, err := r.readUint32()
+		if err != nil {
+			return err
+		}
+		offset, err := r.readUint64()
+		if err != nil {
+			return err
+		}
+		size, err := r.readUint64()
+		if err != nil {
+			return err
+		}
+		td.cpuBuffers = append(td.cpuBuffers, traceDatCPUBuffer{
+			cpu:    int64(cpu),
+			offset: int64(offset),
+			size:   int64(size),
+		})
+	}
+	sort.Slice(td.cpuBuffers, func(i, j int) bool {
+		return td.cpuBuffers[i].cpu < td.cpuBuffers[j].cpu
+	})
+	return nil
+}
+
+// readCPUStat reads a CPUSTAT option, which contains the CPU number followed by the contents of
+// that CPU's stats file:
+/**
+CPU: 0
+entries: 1945
+overrun: 0
+... (the rest of the stats file)
+*/
+func (td *TraceDat) readCPUStat(stat string) error {
+	stat = strings.TrimRight(stat, "\x00\n")
+	lines := strings.SplitN(stat, "\n", 2)
+	if !strings.HasPrefix(lines[0], "CPU: ") {
+		return fmt.Errorf("malformed cpu stats: %q", lines[0])
+	}
+	cpu, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(lines[0], "CPU: ")), 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed cpu stats: %s", err)
+	}
+	stats := map[string]string{}
+	if len(lines) > 1 {
+		if stats, err = statsBufferToMap(bufio.NewReader(strings.NewReader(lines[1]))); err != nil {
+			return err
+		}
+	}
+	td.CPUStats[cpu] = stats
+	return nil
+}
+
+// readHeaderInfo reads the header_page and header_event format files.
+func (td *TraceDat) readHeaderInfo(r *traceDatReader) error {
+	var err error
+	if td.HeaderPage, err = r.readLabeledString("header_page"); err != nil {
+		return err
+	}
+	td.HeaderEvent, err = r.readLabeledString("header_event")
+	return err
+}
+
+// readFtraceEvents reads the format files of the ftrace internal events.
+func (td *TraceDat) readFtraceEvents(r *traceDatReader) error {
+	count, err := r.readUint32()
+	if err != nil {
+		return fmt.Errorf("unable to read ftrace event formats. caused by: %s", err)
+	}
+	for i := uint32(0); i < count; i++ {
+		format, err := r.readSizedString(8)
+		if err != nil {
+			return fmt.Errorf("unable to read ftrace event format. caused by: %s", err)
+		}
+		td.FtraceFormats = append(td.FtraceFormats, format)
+	}
+	return nil
+}
+
+// readEventFormats reads the format files of all event systems.
+func (td *TraceDat) readEventFormats(r *traceDatReader) error {
+	numSystems, err := r.readUint32()
+	if err != nil {
+		return fmt.Errorf("unable to read event formats. caused by: %s", err)
+	}
+	for i := uint32(0); i < numSystems; i++ {
+		system, err := r.readString()
+		if err != nil {
+			return fmt.Errorf("unable to read event system name. caused by: %s", err)
+		}
+		count, err := r.readUint32()
+		if err != nil {
+			return fmt.Errorf("unable to read event count for system %s. caused by: %s", system, err)
+		}
+		for j := uint32(0); j < count; j++ {
+			format, err := r.readSizedString(8)
+			if err != nil {
+				return fmt.Errorf("unable to read event format for system %s. caused by: %s", system, err)
+			}
+			td.EventFormats = append(td.EventFormats, format)
+		}
+	}
+	return nil
+}
+
+// traceDatReader reads numbers and strings from a trace.dat file at an advancing offset.
+type traceDatReader struct {
+	reader io.ReaderAt
+	// The length of the data read by reader, or -1 if it isn't known.
+	size       int64
+	offset     int64
+	endianness binary.ByteOrder
+}
+
+// readerSize returns the length of the data read by reader, or -1 if it can't be determined.
+func readerSize(reader io.ReaderAt) int64 {
+	switch r := reader.(type) {
+	case interface{ Size() int64 }:
+		return r.Size()
+	case interface{ Stat() (os.FileInfo, error) }:
+		if fi, err := r.Stat(); err == nil {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// sub returns a new traceDatReader over data, with the same endianness as the receiver.
+func (r *traceDatReader) sub(data []byte) *traceDatReader {
+	return &traceDatReader{reader: bytes.NewReader(data), size: int64(len(data)), endianness: r.endianness}
+}
+
+// readBytes reads n bytes. Since n may come from a corrupt file, it is checked against the bytes
+// remaining before any buffer is allocated; if those aren't known, the buffer grows only as data
+// is actually read.
+func (r *traceDatReader) readBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid read size: %d", n)
+	}
+	if r.size < 0 {
+		var buf bytes.Buffer
+		read, err := buf.ReadFrom(io.NewSectionReader(r.reader, r.offset, int64(n)))
+		r.offset += read
+		if err != nil {
+			return nil, err
+		}
+		if read != int64(n) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return buf.Bytes(), nil
+	}
+	if remaining := r.size - r.offset; int64(n) > remaining {
+		return nil, fmt.Errorf("read of %d bytes at offset %d overruns the end of the file at %d: %s", n, r.offset, r.size, io.ErrUnexpectedEOF)
+	}
+	buf := make([]byte, n)
+	read, err := r.reader.ReadAt(buf, r.offset)
+	r.offset += int64(read)
+	if read != n {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readSizedBytes reads size bytes, where size was itself read from the file.
+func (r *traceDatReader) readSizedBytes(size uint64) ([]byte, error) {
+	if size > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid read size: %d", size)
+	}
+	return r.readBytes(int(size))
+}
+
+func (r *traceDatReader) readUint16() (uint16, error) {
+	buf, err := r.readBytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return r.endianness.Uint16(buf), nil
+}
+
+func (r *traceDatReader) readUint32() (uint32, error) {
+	buf, err := r.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return r.endianness.Uint32(buf), nil
+}
+
+func (r *traceDatReader) readUint64() (uint64, error) {
+	buf, err := r.readBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return r.endianness.Uint64(buf), nil
+}
+
+// readString reads a NUL-terminated string.
+func (r *traceDatReader) readString() (string, error) {
+	var sb strings.Builder
+	buf := make([]byte, 1)
+	for {
+		if _, err := r.reader.ReadAt(buf, r.offset); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		r.offset++
+		if buf[0] == 0 {
+			return sb.String(), nil
+		}
+		sb.WriteByte(buf[0])
+	}
+}
+
+// readSizedString reads a string prefixed with its length, which is sizeLen bytes long.
+func (r *traceDatReader) readSizedString(sizeLen int) (string, error) {
+	var size uint64
+	switch sizeLen {
+	case 4:
+		s, err := r.readUint32()
+		if err != nil {
+			return "", err
+		}
+		size = uint64(s)
+	case 8:
+		s, err := r.readUint64()
+		if err != nil {
+			return "", err
+		}
+		size = s
+	default:
+		return "", fmt.Errorf("unsupported size length: %d", sizeLen)
+	}
+	buf, err := r.readSizedBytes(size)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readLabeledString reads a NUL-terminated label, which must match the provided one, followed by
+// a string prefixed with its 8 byte length.
+func (r *traceDatReader) readLabeledString(label string) (string, error) {
+	got, err := r.readString()
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s. caused by: %s", label, err)
+	}
+	if got != label {
+		return "", fmt.Errorf("expected %q, but got %q instead", label, got)
+	}
+	str, err := r.readSizedString(8)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s. caused by: %s", label, err)
+	}
+	return str, nil
+}
+
+// readSection reads the version 7 section at the provided offset, checks that it has the
+// expected ID, and returns its contents.
+// A section header looks like this:
+/**
+id: 2 bytes
+flags: 2 bytes
+description string offset: 4 bytes
+size: 8 bytes
+*/
+func (r *traceDatReader) readSection(offset uint64, wantID uint16) ([]byte, error) {
+	r.offset = int64(offset)
+	id, err := r.readUint16()
+	if err != nil {
+		return nil, err
+	}
+	if id != wantID {
+		return nil, fmt.Errorf("expected section with id %d at offset %d, but found id %d", wantID, offset, id)
+	}
+	flags, err := r.readUint16()
+	if err != nil {
+		return nil, err
+	}
+	if flags&traceDatSectionCompressed != 0 {
+		return nil, fmt.Errorf("section %d is compressed. compressed sections are not supported", id)
+	}
+	// The offset of the section's description in the strings section.
+	if _, err := r.readUint32(); err != nil {
+		return nil, err
+	}
+	size, err := r.readUint64()
+	if err != nil {
+		return nil, err
+	}
+	return r.readSizedBytes(size)
+}
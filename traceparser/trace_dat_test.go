@@ -0,0 +1,405 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/schedviz/testhelpers/testhelpers"
+	pb "github.com/google/schedviz/tracedata/schedviz_events_go_proto"
+)
+
+const testHeaderPage = `	field: u64 timestamp;	offset:0;	size:8;	signed:0;
+	field: local_t commit;	offset:8;	size:8;	signed:1;
+	field: int overwrite;	offset:8;	size:1;	signed:1;
+	field: char data;	offset:16;	size:4080;	signed:1;
+`
+
+const testCommonFields = `	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+`
+
+// The formats of the events in testdata/input/cpu0.
+var testEventFormats = []string{
+	`name: sched_migrate_task
+ID: 296
+format:
+` + testCommonFields + `
+	field:char comm[16];	offset:8;	size:16;	signed:1;
+	field:pid_t pid;	offset:24;	size:4;	signed:1;
+	field:int prio;	offset:28;	size:4;	signed:1;
+	field:int orig_cpu;	offset:32;	size:4;	signed:1;
+	field:int dest_cpu;	offset:36;	size:4;	signed:1;
+
+print fmt: "comm=%s pid=%d prio=%d orig_cpu=%d dest_cpu=%d"
+`,
+	`name: sched_switch
+ID: 297
+format:
+` + testCommonFields + `
+	field:char prev_comm[16];	offset:8;	size:16;	signed:1;
+	field:pid_t prev_pid;	offset:24;	size:4;	signed:1;
+	field:int prev_prio;	offset:28;	size:4;	signed:1;
+	field:long prev_state;	offset:32;	size:8;	signed:1;
+	field:char next_comm[16];	offset:40;	size:16;	signed:1;
+	field:pid_t next_pid;	offset:56;	size:4;	signed:1;
+	field:int next_prio;	offset:60;	size:4;	signed:1;
+
+print fmt: "prev_comm=%s prev_pid=%d prev_prio=%d prev_state=%s%s ==> next_comm=%s next_pid=%d next_prio=%d"
+`,
+	`name: sched_wakeup_new
+ID: 298
+format:
+` + testCommonFields + `
+	field:char comm[16];	offset:8;	size:16;	signed:1;
+	field:pid_t pid;	offset:24;	size:4;	signed:1;
+	field:int prio;	offset:28;	size:4;	signed:1;
+	field:int success;	offset:32;	size:4;	signed:1;
+	field:int target_cpu;	offset:36;	size:4;	signed:1;
+
+print fmt: "comm=%s pid=%d prio=%d target_cpu=%03d"
+`,
+	`name: sched_wakeup
+ID: 299
+format:
+` + testCommonFields + `
+	field:char comm[16];	offset:8;	size:16;	signed:1;
+	field:pid_t pid;	offset:24;	size:4;	signed:1;
+	field:int prio;	offset:28;	size:4;	signed:1;
+	field:int success;	offset:32;	size:4;	signed:1;
+	field:int target_cpu;	offset:36;	size:4;	signed:1;
+
+print fmt: "comm=%s pid=%d prio=%d target_cpu=%03d"
+`,
+}
+
+const testPageSize = 4096
+
+const testOverflowedStats = `CPU: 1
+entries: 1945
+overrun: 12
+commit overrun: 0
+bytes: 128768
+oldest event ts: 2698497.198903
+now ts: 2698499.259470
+dropped events: 0
+read events: 2404
+`
+
+// traceDatWriter assembles trace.dat files for testing.
+type traceDatWriter struct {
+	buf   bytes.Buffer
+	order binary.ByteOrder
+}
+
+func (w *traceDatWriter) u16(v uint16) {
+	b := make([]byte, 2)
+	w.order.PutUint16(b, v)
+	w.buf.Write(b)
+}
+
+func (w *traceDatWriter) u32(v uint32) {
+	b := make([]byte, 4)
+	w.order.PutUint32(b, v)
+	w.buf.Write(b)
+}
+
+// u64 writes v, and returns its position so that it may be patched later.
+func (w *traceDatWriter) u64(v uint64) int {
+	pos := w.buf.Len()
+	b := make([]byte, 8)
+	w.order.PutUint64(b, v)
+	w.buf.Write(b)
+	return pos
+}
+
+func (w *traceDatWriter) patchU64(pos int, v uint64) {
+	w.order.PutUint64(w.buf.Bytes()[pos:pos+8], v)
+}
+
+func (w *traceDatWriter) str(s string) {
+	w.buf.WriteString(s)
+	w.buf.WriteByte(0)
+}
+
+func (w *traceDatWriter) sized8(s string) {
+	w.u64(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *traceDatWriter) alignToPage() {
+	for w.buf.Len()%testPageSize != 0 {
+		w.buf.WriteByte(0)
+	}
+}
+
+func (w *traceDatWriter) preamble(version string) {
+	w.buf.Write(traceDatMagic)
+	w.str(version)
+	w.buf.WriteByte(0) // little endian
+	w.buf.WriteByte(8) // long size
+	w.u32(testPageSize)
+}
+
+func (w *traceDatWriter) headerInfo() {
+	w.str("header_page")
+	w.sized8(testHeaderPage)
+	w.str("header_event")
+	w.sized8("# compressed entry header\n")
+}
+
+func (w *traceDatWriter) eventFormats() {
+	w.u32(1)
+	w.str("sched")
+	w.u32(uint32(len(testEventFormats)))
+	for _, format := range testEventFormats {
+		w.sized8(format)
+	}
+}
+
+func (w *traceDatWriter) option(id uint16, data []byte) {
+	w.u16(id)
+	w.u32(uint32(len(data)))
+	w.buf.Write(data)
+}
+
+func (w *traceDatWriter) section(id uint16, contents func()) int {
+	pos := w.buf.Len()
+	w.u16(id)
+	w.u16(0) // flags
+	w.u32(0) // description
+	sizePos := w.u64(0)
+	contents()
+	w.patchU64(sizePos, uint64(w.buf.Len()-sizePos-8))
+	return pos
+}
+
+// makeTraceDatV6 creates a version 6 trace.dat file with two CPUs, each with cpuData as its
+// ring buffer contents.
+func makeTraceDatV6(cpuData []byte) []byte {
+	w := &traceDatWriter{order: binary.LittleEndian}
+	w.preamble("6")
+	w.headerInfo()
+	w.u32(0) // ftrace events
+	w.eventFormats()
+	w.u32(0) // kallsyms
+	w.u32(0) // printk formats
+	w.u64(0) // cmdlines
+	w.u32(2) // cpus
+	w.buf.WriteString("options  \x00")
+	w.option(traceDatOptionTraceClock, []byte("[local] global counter\n"))
+	w.option(traceDatOptionCPUStat, []byte(testOverflowedStats))
+	w.u16(traceDatOptionDone)
+	w.buf.WriteString("flyrecord\x00")
+	var offsetPositions []int
+	for cpu := 0; cpu < 2; cpu++ {
+		offsetPositions = append(offsetPositions, w.u64(0))
+		w.u64(uint64(len(cpuData)))
+	}
+	for _, pos := range offsetPositions {
+		w.alignToPage()
+		w.patchU64(pos, uint64(w.buf.Len()))
+		w.buf.Write(cpuData)
+	}
+	return w.buf.Bytes()
+}
+
+// makeTraceDatV6WithSizes creates the start of a version 6 trace.dat file, up to and including
+// the provided kallsyms and cmdlines sizes, with the data they describe missing.
+func makeTraceDatV6WithSizes(kallsymsSize uint32, cmdlinesSize uint64) []byte {
+	w := &traceDatWriter{order: binary.LittleEndian}
+	w.preamble("6")
+	w.headerInfo()
+	w.u32(0) // ftrace events
+	w.eventFormats()
+	w.u32(kallsymsSize)
+	if kallsymsSize == 0 {
+		w.u32(0) // printk formats
+		w.u64(cmdlinesSize)
+	}
+	return w.buf.Bytes()
+}
+
+// makeTraceDatV7 creates a version 7 trace.dat file with two CPUs, each with cpuData as its
+// ring buffer contents.
+func makeTraceDatV7(cpuData []byte) []byte {
+	w := &traceDatWriter{order: binary.LittleEndian}
+	w.preamble("7")
+	w.str("none")
+	w.str("")
+	optionsPos := w.u64(0)
+
+	headerInfo := w.section(traceDatOptionHeaderInfo, w.headerInfo)
+	eventFormats := w.section(traceDatOptionEventFormats, w.eventFormats)
+
+	var offsetPositions []int
+	w.patchU64(optionsPos, uint64(w.buf.Len()))
+	w.section(traceDatOptionDone, func() {
+		offset := make([]byte, 8)
+		binary.LittleEndian.PutUint64(offset, uint64(headerInfo))
+		w.option(traceDatOptionHeaderInfo, offset)
+		offset = make([]byte, 8)
+		binary.LittleEndian.PutUint64(offset, uint64(eventFormats))
+		w.option(traceDatOptionEventFormats, offset)
+		w.option(traceDatOptionCPUStat, []byte(testOverflowedStats))
+		tsc2nsec := make([]byte, 16)
+		binary.LittleEndian.PutUint32(tsc2nsec[0:], 3)
+		binary.LittleEndian.PutUint32(tsc2nsec[4:], 1)
+		w.option(traceDatOptionTSC2NSec, tsc2nsec)
+
+		w.u16(traceDatOptionBuffer)
+		sizePos := w.buf.Len()
+		w.u32(0)
+		w.u64(0) // buffer section offset
+		w.str("")
+		w.str("x86-tsc")
+		w.u32(testPageSize)
+		w.u32(2)
+		for cpu := 0; cpu < 2; cpu++ {
+			w.u32(uint32(cpu))
+			offsetPositions = append(offsetPositions, w.u64(0))
+			w.u64(uint64(len(cpuData)))
+		}
+		binary.LittleEndian.PutUint32(w.buf.Bytes()[sizePos:sizePos+4], uint32(w.buf.Len()-sizePos-4))
+
+		w.option(traceDatOptionDone, make([]byte, 8))
+	})
+	for _, pos := range offsetPositions {
+		w.alignToPage()
+		w.patchU64(pos, uint64(w.buf.Len()))
+		w.buf.Write(cpuData)
+	}
+	return w.buf.Bytes()
+}
+
+func TestReadTraceDat(t *testing.T) {
+	cpuData, err := ioutil.ReadFile(path.Join(testhelpers.GetRunFilesPath(), "traceparser", "testdata", "input", "cpu0"))
+	if err != nil {
+		t.Fatalf("error reading test trace file. caused by: %s", err)
+	}
+
+	// Parse the raw per-CPU data directly to get the expected events.
+	wantParser, err := New(testHeaderPage, testEventFormats)
+	if err != nil {
+		t.Fatalf("error parsing formats: %s", err)
+	}
+	_ = wantParser.SetLittleEndian()
+	var want []TraceEvent
+	for cpu := int64(0); cpu < 2; cpu++ {
+		if err := wantParser.ParseTrace(bufio.NewReader(bytes.NewReader(cpuData)), cpu, func(event *TraceEvent) (bool, error) {
+			want = append(want, *event)
+			return true, nil
+		}); err != nil {
+			t.Fatalf("error during ParseTrace(): %s", err)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		traceDat       []byte
+		wantVersion    int
+		wantTraceClock string
+		wantClock      *pb.TraceClock
+	}{
+		{name: "v6", traceDat: makeTraceDatV6(cpuData), wantVersion: 6, wantTraceClock: "[local] global counter\n", wantClock: &pb.TraceClock{Name: "local"}},
+		{name: "v7", traceDat: makeTraceDatV7(cpuData), wantVersion: 7, wantTraceClock: "x86-tsc", wantClock: &pb.TraceClock{Name: "x86-tsc", Mult: 3, Shift: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !IsTraceDat(test.traceDat) {
+				t.Fatalf("IsTraceDat() = false, want true")
+			}
+			td, err := ReadTraceDat(bytes.NewReader(test.traceDat))
+			if err != nil {
+				t.Fatalf("ReadTraceDat() returned unexpected error: %s", err)
+			}
+			if td.Version != test.wantVersion {
+				t.Errorf("Version = %d, want %d", td.Version, test.wantVersion)
+			}
+			if td.TraceClock != test.wantTraceClock {
+				t.Errorf("TraceClock = %q, want %q", td.TraceClock, test.wantTraceClock)
+			}
+			if diff := cmp.Diff(map[int64]struct{}{1: {}}, td.OverflowedCPUs()); diff != "" {
+				t.Errorf("OverflowedCPUs() Diff -want +got:\n%s", diff)
+			}
+
+			gotParser, err := td.NewTraceParser()
+			if err != nil {
+				t.Fatalf("NewTraceParser() returned unexpected error: %s", err)
+			}
+			var got []TraceEvent
+			if err := td.WalkPerCPUBuffers(func(reader *bufio.Reader, cpu int64) error {
+				return gotParser.ParseTrace(reader, cpu, func(event *TraceEvent) (bool, error) {
+					got = append(got, *event)
+					return true, nil
+				})
+			}); err != nil {
+				t.Fatalf("WalkPerCPUBuffers() returned unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("parsed events Diff -want +got:\n%s", diff)
+			}
+
+			es, err := td.EventSet(true, nil /*=filter*/)
+			if err != nil {
+				t.Fatalf("EventSet() returned unexpected error: %s", err)
+			}
+			if len(es.Event) != len(want) {
+				t.Errorf("EventSet() returned %d events, want %d", len(es.Event), len(want))
+			}
+			if diff := cmp.Diff(test.wantClock, es.GetTraceClock(), cmp.Comparer(proto.Equal)); diff != "" {
+				t.Errorf("EventSet() trace clock Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadTraceDatErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		traceDat []byte
+	}{
+		{name: "bad magic", traceDat: []byte("not a trace.dat file")},
+		{name: "unsupported version", traceDat: append(append([]byte{}, traceDatMagic...), '5', 0, 0, 8, 0, 16, 0, 0)},
+		{name: "truncated", traceDat: makeTraceDatV6(nil)[:100]},
+		{name: "corrupt 4 byte size", traceDat: makeTraceDatV6WithSizes(math.MaxUint32, 0)},
+		{name: "corrupt 8 byte size", traceDat: makeTraceDatV6WithSizes(0, 1<<40)},
+		{name: "overflowing 8 byte size", traceDat: makeTraceDatV6WithSizes(0, math.MaxUint64)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ReadTraceDat(bytes.NewReader(test.traceDat)); err == nil {
+				t.Errorf("ReadTraceDat() returned no error, want an error")
+			}
+			// Readers whose size isn't known must fail the same way.
+			if _, err := ReadTraceDat(struct{ io.ReaderAt }{bytes.NewReader(test.traceDat)}); err == nil {
+				t.Errorf("ReadTraceDat() of a reader without a size returned no error, want an error")
+			}
+		})
+	}
+}
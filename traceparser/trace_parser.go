@@ -26,6 +26,7 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/google/schedviz/tracedata/clipping"
 	"github.com/google/schedviz/util/util"
 )
 
@@ -35,6 +36,8 @@ type TraceParser struct {
 	Formats                  map[uint16]*EventFormat
 	Endianness               binary.ByteOrder
 	failOnUnknownEventFormat bool
+	printkFormats            PrintkFormats
+	kallsyms                 *Kallsyms
 }
 
 // New parses trace formats and returns a new TraceParser.
@@ -64,7 +67,7 @@ func (tp *TraceParser) SetFailOnUnknownEventFormat(option bool) {
 }
 
 // SetNativeEndian makes the TraceParser parse binary data in the native endian byte order
-// of this machine. Currently only little endian is supported.
+// of this machine.
 func (tp *TraceParser) SetNativeEndian() error {
 	// From https://github.com/tensorflow/tensorflow/blob/fe5e1f39590f5847a384dcccb33956a5c2606d16/tensorflow/go/tensor.go#L488-L505
 	var nativeEndian binary.ByteOrder
@@ -86,7 +89,6 @@ func (tp *TraceParser) SetNativeEndian() error {
 }
 
 // SetBigEndian makes the TraceParser parse binary data in the big endian byte order
-// Big endian is not currently supported.
 func (tp *TraceParser) SetBigEndian() error {
 	tp.Endianness = binary.BigEndian
 	// Return a nil error for consistency with SetNativeEndian()
@@ -119,11 +121,19 @@ type TraceReader interface {
 // comment.
 type AddEventCallback = func(*TraceEvent) (bool, error)
 
+// LostEventsFormatID is the Format ID of the synthetic TraceEvents that ParseTrace emits when the
+// kernel reports that events were lost. The kernel never assigns this ID to a format.
+// Lost event TraceEvents have a single number property, clipping.LostEventsCountProperty, holding
+// the number of events that were lost, or -1 if the kernel didn't record it.
+const LostEventsFormatID uint16 = 0
+
 // ParseTrace accepts a TraceReader (such as a bufio.Reader) from which raw trace data may be read,
 // the number of the CPU whose buffer is being read, and a callback that will take TraceEvents
 // parsed from that raw trace data.  If the callback returns false or has a non-nil error,
 // ParseTrace will return. If an error is returned by ParseTrace, the raw trace should be considered
 // to be corrupted.
+// If a page records that events were lost before it, a TraceEvent with the LostEventsFormatID and
+// the page's timestamp is passed to the callback before the page's events.
 func (tp *TraceParser) ParseTrace(reader TraceReader, cpu int64, callback AddEventCallback) error {
 	if tp.Endianness == nil {
 		if err := tp.SetNativeEndian(); err != nil {
@@ -161,6 +171,39 @@ func (tp *TraceParser) ParseTrace(reader TraceReader, cpu int64, callback AddEve
 			return nil
 		}
 
+		bytesRead := pageHeader.Size()
+		if pageHeader.MissedEvents() {
+			// The kernel dropped events before this page. If there was room, it stored the number of
+			// dropped events, as an unsigned long, right after the page data.
+			count := int64(-1)
+			if pageHeader.MissedEventsStored() {
+				countBuf, err := tp.readPageData(reader, commitSize)
+				if err != nil {
+					if err != io.EOF {
+						err = addParseErrorContext(err.Error(), cpu, numPagesRead, pageHeader.Timestamp(), 0, -1, nil)
+						return fmt.Errorf("failed to read lost event count. caused by: %s", err)
+					}
+					return nil
+				}
+				if commitSize == 4 {
+					count = int64(tp.Endianness.Uint32(countBuf))
+				} else {
+					count = int64(tp.Endianness.Uint64(countBuf))
+				}
+				bytesRead += commitSize
+			}
+			lostEvent := NewTraceEvent(cpu)
+			lostEvent.FormatID = LostEventsFormatID
+			lostEvent.Timestamp = pageHeader.Timestamp()
+			lostEvent.NumberProperties[clipping.LostEventsCountProperty] = count
+			if cont, err := callback(lostEvent); !cont {
+				err := addParseErrorContext(
+					fmt.Sprintf("Callback error: %s\nParsed Event: %v\n", err, lostEvent),
+					cpu, numPagesRead, pageHeader.Timestamp(), 0, 0, nil)
+				return err
+			}
+		}
+
 		timeStamp := pageHeader.Timestamp()
 
 		numEventsReadOnPage := uint64(0)
@@ -203,7 +246,11 @@ func (tp *TraceParser) ParseTrace(reader TraceReader, cpu int64, callback AddEve
 				continue
 			}
 
-			eventData := rbEvent.Array
+			eventData, err := rbEvent.DataFromArray()
+			if err != nil {
+				err := addParseErrorContext(err.Error(), cpu, numPagesRead, pageHeader.Timestamp(), numEventsReadOnPage, -1, &rbEvent)
+				return err
+			}
 
 			// The format ID is the first two bytes in eventData
 			id := tp.Endianness.Uint16(eventData)
@@ -234,30 +281,38 @@ func (tp *TraceParser) ParseTrace(reader TraceReader, cpu int64, callback AddEve
 			// Read in each field using the offset and size we got from the format files.
 			for fieldIdx, field := range append(eFormat.CommonFields[1:], eFormat.Fields...) {
 				buf := eventData[field.Offset:(field.Offset + field.Size)]
-				if err := traceEvent.SaveFieldValue(field, buf, tp.Endianness); err != nil {
-					err := addParseErrorContext(err.Error(), cpu, numPagesRead, pageHeader.Timestamp(), numEventsReadOnPage, fieldIdx, &rbEvent)
-					return err
-				}
 				if field.IsDynamicArray {
 					if field.Size != 4 {
 						err := fmt.Sprintf("field %q is used as a dynamic array, but its structure does not appear to match one. Size should be 4 bytes, but was %d bytes. skipping reading the array", field.Name, field.Size)
 						util.LogWarnEveryNTime(100*time.Microsecond, err)
 						continue
 					}
-					offset := tp.Endianness.Uint16(buf[:2])
-					length := tp.Endianness.Uint16(buf[2:4])
-					dynArrBuf := eventData[offset:(offset + length)]
-					dynArrField := &FormatField{
-						Name:           "__data_loc_" + field.Name,
-						IsDynamicArray: true,
-					}
-					if err := traceEvent.SaveFieldValue(dynArrField, dynArrBuf, tp.Endianness); err != nil {
-						err := addParseErrorContext(err.Error(), cpu, numPagesRead, pageHeader.Timestamp(), numEventsReadOnPage, fieldIdx, &rbEvent)
+					// The lower 16 bits of the __data_loc word hold the offset of the array, and the upper 16
+					// bits hold its length.
+					dataLoc := tp.Endianness.Uint32(buf)
+					offset := dataLoc & 0xffff
+					length := dataLoc >> 16
+					if int(offset+length) > len(eventData) {
+						err := addParseErrorContext(
+							fmt.Sprintf("dynamic array %q (offset: %d, length: %d) extends past the end of the event", field.Name, offset, length),
+							cpu, numPagesRead, pageHeader.Timestamp(), numEventsReadOnPage, fieldIdx, &rbEvent)
 						return err
 					}
+					buf = eventData[offset:(offset + length)]
+				}
+				if err := traceEvent.SaveFieldValue(field, buf, tp.Endianness); err != nil {
+					err := addParseErrorContext(err.Error(), cpu, numPagesRead, pageHeader.Timestamp(), numEventsReadOnPage, fieldIdx, &rbEvent)
+					return err
 				}
 			}
 
+			if tp.printkFormats != nil {
+				tp.savePrintkMessage(evtFmt, eventData, traceEvent)
+			}
+			if tp.kallsyms != nil {
+				tp.saveSymbols(eFormat, traceEvent)
+			}
+
 			if cont, err := callback(traceEvent); !cont {
 				err := addParseErrorContext(
 					fmt.Sprintf("Callback error: %s\nParsed Event: %v\n", err, traceEvent),
@@ -269,7 +324,7 @@ func (tp *TraceParser) ParseTrace(reader TraceReader, cpu int64, callback AddEve
 
 		// If there weren't enough events to fill up this page, and we aren't done reading all the
 		// pages, then skip to the next page.
-		if err = tp.skipToNextPage(reader, tp.HeaderFormat, pageHeader.Size()); err != nil {
+		if err = tp.skipToNextPage(reader, tp.HeaderFormat, bytesRead); err != nil {
 			if err != io.EOF {
 				return err
 			}
@@ -333,7 +388,11 @@ func (tp *TraceParser) readEvent(buf *[]byte) (ringBufferEvent, error) {
 }
 
 func (tp *TraceParser) skipToNextPage(reader TraceReader, headerFormat Format, bytesRead uint64) error {
-	numRemainingBytes := int(headerFormat.Fields[3].Size - bytesRead)
+	dataSize, err := pageDataSize(headerFormat)
+	if err != nil {
+		return err
+	}
+	numRemainingBytes := int(dataSize) - int(bytesRead)
 	if numRemainingBytes > 0 {
 		discarded, err := reader.Discard(numRemainingBytes)
 		if discarded != numRemainingBytes {
@@ -346,6 +405,17 @@ func (tp *TraceParser) skipToNextPage(reader TraceReader, headerFormat Format, b
 	return nil
 }
 
+// pageDataSize returns the size of the data section of a ring buffer page, as described by the
+// "data" field of the header_page format.
+func pageDataSize(headerFormat Format) (uint64, error) {
+	for _, field := range headerFormat.Fields {
+		if field.Name == "data" {
+			return field.Size, nil
+		}
+	}
+	return 0, errors.New("header page format is missing the data field")
+}
+
 func addParseErrorContext(message string, cpu int64, pageIndex, timestamp, eventIndex uint64, fieldIndex int, event *ringBufferEvent) error {
 	errStr := fmt.Sprintf(
 		"%s\nCPU: %d, Page: %d Page Timestamp: %d Event Index: %d ",
@@ -18,6 +18,8 @@ package traceparser
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"fmt"
 	"os"
@@ -142,8 +144,8 @@ var tp32 = &TraceParser{
 					{FieldType: "unsigned char common_flags", Name: "common_flags", ProtoType: "string", Offset: 2, Size: 1, NumElements: 1, ElementSize: 1},
 					{FieldType: "unsigned char common_preempt_count", Name: "common_preempt_count", ProtoType: "string", Offset: 3, Size: 1, NumElements: 1, ElementSize: 1},
 					{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
-					{FieldType: "unsigned short common_migrate_disable", Name: "common_migrate_disable", ProtoType: "int64", Offset: 8, Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
-					{FieldType: "unsigned short common_padding", Name: "common_padding", ProtoType: "int64", Offset: 10, Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
+					{FieldType: "unsigned short common_migrate_disable", Name: "common_migrate_disable", ProtoType: "int64", Offset: 8, Size: 2, NumElements: 1, ElementSize: 2},
+					{FieldType: "unsigned short common_padding", Name: "common_padding", ProtoType: "int64", Offset: 10, Size: 2, NumElements: 1, ElementSize: 2},
 				},
 				Fields: []*FormatField{
 					{FieldType: "char comm[16]", Name: "comm", ProtoType: "string", Offset: 16, Size: 16, NumElements: 16, ElementSize: 1, Signed: true},
@@ -163,8 +165,8 @@ var tp32 = &TraceParser{
 					{FieldType: "unsigned char common_flags", Name: "common_flags", ProtoType: "string", Offset: 2, Size: 1, NumElements: 1, ElementSize: 1},
 					{FieldType: "unsigned char common_preempt_count", Name: "common_preempt_count", ProtoType: "string", Offset: 3, Size: 1, NumElements: 1, ElementSize: 1},
 					{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
-					{FieldType: "unsigned short common_migrate_disable", Name: "common_migrate_disable", ProtoType: "int64", Offset: 8, Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
-					{FieldType: "unsigned short common_padding", Name: "common_padding", ProtoType: "int64", Offset: 10, Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
+					{FieldType: "unsigned short common_migrate_disable", Name: "common_migrate_disable", ProtoType: "int64", Offset: 8, Size: 2, NumElements: 1, ElementSize: 2},
+					{FieldType: "unsigned short common_padding", Name: "common_padding", ProtoType: "int64", Offset: 10, Size: 2, NumElements: 1, ElementSize: 2},
 				},
 				Fields: []*FormatField{
 					{FieldType: "char prev_comm[16]", Name: "prev_comm", ProtoType: "string", Offset: 16, Size: 16, NumElements: 16, ElementSize: 1, Signed: true},
@@ -186,8 +188,8 @@ var tp32 = &TraceParser{
 					{FieldType: "unsigned char common_flags", Name: "common_flags", ProtoType: "string", Offset: 2, Size: 1, NumElements: 1, ElementSize: 1},
 					{FieldType: "unsigned char common_preempt_count", Name: "common_preempt_count", ProtoType: "string", Offset: 3, Size: 1, NumElements: 1, ElementSize: 1},
 					{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
-					{FieldType: "unsigned short common_migrate_disable", Name: "common_migrate_disable", ProtoType: "int64", Offset: 8, Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
-					{FieldType: "unsigned short common_padding", Name: "common_padding", ProtoType: "int64", Offset: 10, Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
+					{FieldType: "unsigned short common_migrate_disable", Name: "common_migrate_disable", ProtoType: "int64", Offset: 8, Size: 2, NumElements: 1, ElementSize: 2},
+					{FieldType: "unsigned short common_padding", Name: "common_padding", ProtoType: "int64", Offset: 10, Size: 2, NumElements: 1, ElementSize: 2},
 				},
 				Fields: []*FormatField{
 					{FieldType: "char comm[16]", Name: "comm", ProtoType: "string", Offset: 16, Size: 16, NumElements: 16, ElementSize: 1, Signed: true},
@@ -207,15 +209,15 @@ var tp32 = &TraceParser{
 					{FieldType: "unsigned char common_flags", Name: "common_flags", ProtoType: "string", Offset: 2, Size: 1, NumElements: 1, ElementSize: 1},
 					{FieldType: "unsigned char common_preempt_count", Name: "common_preempt_count", ProtoType: "string", Offset: 3, Size: 1, NumElements: 1, ElementSize: 1},
 					{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
-					{FieldType: "unsigned short common_migrate_disable", Name: "common_migrate_disable", ProtoType: "int64", Offset: 8, Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
-					{FieldType: "unsigned short common_padding", Name: "common_padding", ProtoType: "int64", Offset: 10, Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
+					{FieldType: "unsigned short common_migrate_disable", Name: "common_migrate_disable", ProtoType: "int64", Offset: 8, Size: 2, NumElements: 1, ElementSize: 2},
+					{FieldType: "unsigned short common_padding", Name: "common_padding", ProtoType: "int64", Offset: 10, Size: 2, NumElements: 1, ElementSize: 2},
 				},
 				Fields: []*FormatField{
-					{FieldType: "char comm[16]", Name: "comm", ProtoType: "string", Offset: 8, Size: 16, NumElements: 16, ElementSize: 1, Signed: true},
-					{FieldType: "pid_t pid", Name: "pid", ProtoType: "int64", Offset: 24, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
-					{FieldType: "int prio", Name: "prio", ProtoType: "int64", Offset: 28, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
-					{FieldType: "int success", Name: "success", ProtoType: "int64", Offset: 32, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
-					{FieldType: "int target_cpu", Name: "target_cpu", ProtoType: "int64", Offset: 36, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+					{FieldType: "char comm[16]", Name: "comm", ProtoType: "string", Offset: 16, Size: 16, NumElements: 16, ElementSize: 1, Signed: true},
+					{FieldType: "pid_t pid", Name: "pid", ProtoType: "int64", Offset: 32, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+					{FieldType: "int prio", Name: "prio", ProtoType: "int64", Offset: 36, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+					{FieldType: "int success", Name: "success", ProtoType: "int64", Offset: 40, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+					{FieldType: "int target_cpu", Name: "target_cpu", ProtoType: "int64", Offset: 44, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
 				},
 			},
 		},
@@ -238,13 +240,18 @@ func readGob(t *testing.T, filePath string, obj interface{}) error {
 func TestParseTrace(t *testing.T) {
 	runFiles := testhelpers.GetRunFilesPath()
 
+	// The big endian inputs contain the same events as their little endian counterparts, so they share
+	// expected output files.
 	tests := []struct {
 		tp          *TraceParser
 		gobFileName string
 		cpuFileName string
+		bigEndian   bool
 	}{
 		{tp: tp, gobFileName: "trace.gob", cpuFileName: "cpu0"},
 		{tp: tp32, gobFileName: "trace-32.gob", cpuFileName: "cpu0-32"},
+		{tp: tp, gobFileName: "trace.gob", cpuFileName: "cpu0-be", bigEndian: true},
+		{tp: tp32, gobFileName: "trace-32.gob", cpuFileName: "cpu0-32-be", bigEndian: true},
 	}
 
 	for i, test := range tests {
@@ -256,8 +263,12 @@ func TestParseTrace(t *testing.T) {
 
 			reader := bufio.NewReader(cpuFile)
 
-			// Ignore error as it will never be thrown
-			_ = test.tp.SetLittleEndian()
+			// Ignore errors as they will never be thrown
+			if test.bigEndian {
+				_ = test.tp.SetBigEndian()
+			} else {
+				_ = test.tp.SetLittleEndian()
+			}
 
 			var got = []TraceEvent{}
 			if err := test.tp.ParseTrace(reader, 0 /*=cpu*/, func(event *TraceEvent) (bool, error) {
@@ -272,7 +283,7 @@ func TestParseTrace(t *testing.T) {
 				t.Fatalf("TestParseTrace: error readed expected output file: %s", err)
 			}
 
-			if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(TraceParser{})); diff != "" {
+			if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(TraceParser{}), cmpopts.EquateEmpty()); diff != "" {
 				t.Fatalf("TestParseTrace: Diff -want +got:\n%s", diff)
 			}
 		})
@@ -280,6 +291,203 @@ func TestParseTrace(t *testing.T) {
 
 }
 
+// makeDynamicArrayPage returns a single ring buffer page, laid out according to tp.HeaderFormat,
+// containing one event of format 1000, which has a dynamic string and a dynamic array of u16s.
+func makeDynamicArrayPage(endianness binary.ByteOrder) []byte {
+	event := make([]byte, 32)
+	endianness.PutUint16(event[0:], 1000)
+	endianness.PutUint32(event[4:], 42)
+	// The __data_loc words: filename is 8 bytes at offset 16, and ids is 6 bytes at offset 24.
+	endianness.PutUint32(event[8:], 8<<16|16)
+	endianness.PutUint32(event[12:], 6<<16|24)
+	copy(event[16:], "/bin/ls\x00")
+	endianness.PutUint16(event[24:], 1)
+	endianness.PutUint16(event[26:], 2)
+	endianness.PutUint16(event[28:], 0xffff)
+
+	page := make([]byte, 4096)
+	endianness.PutUint64(page[0:], 1000)
+	endianness.PutUint64(page[8:], uint64(4+len(event)))
+	typeLen := uint32(len(event) / 4)
+	if endianness == binary.BigEndian {
+		endianness.PutUint32(page[16:], typeLen<<timeDeltaSize|5)
+	} else {
+		endianness.PutUint32(page[16:], 5<<typeLenSize|typeLen)
+	}
+	copy(page[20:], event)
+	return page
+}
+
+// makeTestPage returns a single ring buffer page, laid out according to tp.HeaderFormat, holding
+// the provided events. Each event's length must be a multiple of 4, and at most 112 bytes.
+func makeTestPage(endianness binary.ByteOrder, events [][]byte) []byte {
+	page := make([]byte, 4096)
+	endianness.PutUint64(page[0:], 1000)
+	pos := 16
+	for _, event := range events {
+		typeLen := uint32(len(event) / 4)
+		if endianness == binary.BigEndian {
+			endianness.PutUint32(page[pos:], typeLen<<timeDeltaSize|5)
+		} else {
+			endianness.PutUint32(page[pos:], 5<<typeLenSize|typeLen)
+		}
+		copy(page[pos+4:], event)
+		pos += 4 + len(event)
+	}
+	endianness.PutUint64(page[8:], uint64(pos-16))
+	return page
+}
+
+func TestParseTrace_DynamicArrays(t *testing.T) {
+	for _, endianness := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(endianness.String(), func(t *testing.T) {
+			dynTp := &TraceParser{
+				HeaderFormat: tp.HeaderFormat,
+				Formats: map[uint16]*EventFormat{
+					1000: {
+						Name: "dyn_event",
+						ID:   1000,
+						Format: Format{
+							CommonFields: []*FormatField{
+								{FieldType: "unsigned short common_type", Name: "common_type", ProtoType: "int64", Size: 2, NumElements: 1, ElementSize: 2},
+								{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+							},
+							Fields: []*FormatField{
+								{FieldType: "__data_loc char[] filename", Name: "filename", ProtoType: "string", Offset: 8, Size: 4, NumElements: 1, ElementSize: 1, IsDynamicArray: true},
+								{FieldType: "__data_loc u16[] ids", Name: "ids", ProtoType: "string", Offset: 12, Size: 4, NumElements: 1, ElementSize: 2, IsDynamicArray: true},
+							},
+						},
+					},
+				},
+				Endianness:               endianness,
+				failOnUnknownEventFormat: true,
+			}
+			var got []*TraceEvent
+			if err := dynTp.ParseTrace(bufio.NewReader(bytes.NewReader(makeDynamicArrayPage(endianness))), 0 /*=cpu*/, func(event *TraceEvent) (bool, error) {
+				got = append(got, event)
+				return true, nil
+			}); err != nil {
+				t.Fatalf("error during ParseTrace(): %s", err)
+			}
+			want := []*TraceEvent{
+				{
+					Timestamp:            1005,
+					TextProperties:       map[string]string{"filename": "/bin/ls"},
+					NumberProperties:     map[string]int64{"common_pid": 42},
+					NumberListProperties: map[string][]int64{"ids": {1, 2, 0xffff}},
+					FormatID:             1000,
+				},
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("TestParseTrace_DynamicArrays: Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseTrace_LostEvents(t *testing.T) {
+	for _, endianness := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(endianness.String(), func(t *testing.T) {
+			lostTp := &TraceParser{
+				HeaderFormat: tp.HeaderFormat,
+				Formats: map[uint16]*EventFormat{
+					1000: {
+						Name: "simple_event",
+						ID:   1000,
+						Format: Format{
+							CommonFields: []*FormatField{
+								{FieldType: "unsigned short common_type", Name: "common_type", ProtoType: "int64", Size: 2, NumElements: 1, ElementSize: 2},
+								{FieldType: "int common_pid", Name: "common_pid", ProtoType: "int64", Offset: 4, Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+							},
+						},
+					},
+				},
+				Endianness:               endianness,
+				failOnUnknownEventFormat: true,
+			}
+			// makePage creates a page with a single event from pid, whose header records the provided
+			// flags, and, if it isn't negative, the number of lost events.
+			makePage := func(timestamp uint64, pid uint32, flags uint64, lostCount int64) []byte {
+				event := make([]byte, 8)
+				endianness.PutUint16(event[0:], 1000)
+				endianness.PutUint32(event[4:], pid)
+				page := makeTestPage(endianness, [][]byte{event})
+				endianness.PutUint64(page[0:], timestamp)
+				size := endianness.Uint64(page[8:])
+				endianness.PutUint64(page[8:], size|flags)
+				if lostCount >= 0 {
+					endianness.PutUint64(page[16+size:], uint64(lostCount))
+				}
+				return page
+			}
+			var trace []byte
+			trace = append(trace, makePage(1000, 1, 0, -1)...)
+			trace = append(trace, makePage(2000, 2, rbMissedEvents|rbMissedStored, 42)...)
+			trace = append(trace, makePage(3000, 3, rbMissedEvents, -1)...)
+
+			esb := NewEventSetBuilder(lostTp)
+			// Filtering events by name must not drop the record of lost events.
+			filteredEsb := NewEventSetBuilder(lostTp)
+			filteredEsb.SetEventFilter(mustNewEventFilter(t, 0, 0, []string{"simple_event"}, nil, nil))
+			var got []*TraceEvent
+			if err := lostTp.ParseTrace(bufio.NewReader(bytes.NewReader(trace)), 0 /*=cpu*/, func(event *TraceEvent) (bool, error) {
+				got = append(got, event)
+				if err := esb.AddTraceEvent(event); err != nil {
+					return false, err
+				}
+				return true, filteredEsb.AddTraceEvent(event)
+			}); err != nil {
+				t.Fatalf("error during ParseTrace(): %s", err)
+			}
+			simpleEvent := func(timestamp uint64, pid int64) *TraceEvent {
+				return &TraceEvent{
+					Timestamp:            timestamp,
+					TextProperties:       map[string]string{},
+					NumberProperties:     map[string]int64{"common_pid": pid},
+					NumberListProperties: map[string][]int64{},
+					FormatID:             1000,
+				}
+			}
+			lostEvent := func(timestamp uint64, count int64) *TraceEvent {
+				return &TraceEvent{
+					Timestamp:            timestamp,
+					TextProperties:       map[string]string{},
+					NumberProperties:     map[string]int64{"count": count},
+					NumberListProperties: map[string][]int64{},
+					FormatID:             LostEventsFormatID,
+				}
+			}
+			want := []*TraceEvent{
+				simpleEvent(1005, 1),
+				lostEvent(2000, 42),
+				simpleEvent(2005, 2),
+				lostEvent(3000, -1),
+				simpleEvent(3005, 3),
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("TestParseTrace_LostEvents: Diff -want +got:\n%s", diff)
+			}
+
+			for _, b := range []*EventSetBuilder{esb, filteredEsb} {
+				es, err := b.Finalize()
+				if err != nil {
+					t.Fatalf("Finalize() yielded unexpected error %s", err)
+				}
+				var gotCounts []int64
+				for _, ev := range es.GetEvent() {
+					ed := es.GetEventDescriptor()[ev.GetEventDescriptor()]
+					if es.GetStringTable()[ed.GetName()] == "lost_events" {
+						gotCounts = append(gotCounts, ev.GetProperty()[0])
+					}
+				}
+				if diff := cmp.Diff([]int64{42, -1}, gotCounts); diff != "" {
+					t.Errorf("TestParseTrace_LostEvents: lost event counts Diff -want +got:\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
 type Stats struct {
 	overrun, commitOverrun, droppedEvents string
 }
@@ -30,10 +30,13 @@ type TraceEvent struct {
 	// The CPU that this event was recorded on
 	CPU int64
 	// A mapping of text property names to property values, normally strings.
-	// If there is a dynamic array field, it will be stored as a binary blob in TextProperties.
+	// Dynamic char arrays are stored here as strings. Dynamic arrays whose element type is unknown
+	// are stored here as binary blobs.
 	TextProperties map[string]string
 	// A mapping of numeric properties to property values.
 	NumberProperties map[string]int64
+	// A mapping of property names to the values of dynamic arrays of numbers.
+	NumberListProperties map[string][]int64
 	// The Format ID of this event. Should be an event ID defined in a loaded format file.
 	FormatID uint16
 	// True if this Event fell outside of the known-valid range of a trace which
@@ -46,18 +49,40 @@ type TraceEvent struct {
 // NewTraceEvent creates a new TraceEvent
 func NewTraceEvent(cpu int64) *TraceEvent {
 	return &TraceEvent{
-		CPU:              cpu,
-		TextProperties:   make(map[string]string),
-		NumberProperties: make(map[string]int64),
+		CPU:                  cpu,
+		TextProperties:       make(map[string]string),
+		NumberProperties:     make(map[string]int64),
+		NumberListProperties: make(map[string][]int64),
 	}
 }
 
 // SaveFieldValue saves a byte array into a TraceEvent's field.
 // The byte array will be converted to the type specified in the field definition.
+// For dynamic arrays, buf should contain the array itself rather than its __data_loc word.
 func (t *TraceEvent) SaveFieldValue(field *FormatField, buf []byte, endianness binary.ByteOrder) error {
+	if field.IsDynamicString() {
+		// Dynamic strings are NUL-terminated.
+		t.TextProperties[field.Name] = strings.Split(string(buf), "\x00")[0]
+		return nil
+	}
 	if field.IsDynamicArray {
-		// Store binary blob as a string, which is allowed in Go.
-		t.TextProperties[field.Name] = string(buf)
+		if field.ElementSize == 0 {
+			// The element type isn't known, so store binary blob as a string, which is allowed in Go.
+			t.TextProperties[field.Name] = string(buf)
+			return nil
+		}
+		if uint64(len(buf))%field.ElementSize != 0 {
+			return fmt.Errorf("dynamic array %q is %d bytes long, which is not a multiple of its element size, %d", field.Name, len(buf), field.ElementSize)
+		}
+		vals := make([]int64, 0, uint64(len(buf))/field.ElementSize)
+		for i := uint64(0); i < uint64(len(buf)); i += field.ElementSize {
+			val, err := readNumber(buf[i:i+field.ElementSize], field.Signed, endianness)
+			if err != nil {
+				return err
+			}
+			vals = append(vals, val)
+		}
+		t.NumberListProperties[field.Name] = vals
 		return nil
 	}
 
@@ -65,22 +90,65 @@ func (t *TraceEvent) SaveFieldValue(field *FormatField, buf []byte, endianness b
 		// Convert to string and remove extra trailing null bytes
 		t.TextProperties[field.Name] = strings.Split(string(buf), "\x00")[0]
 	} else if field.ProtoType == "int64" {
-		// Pad to 8 bytes
-		if len(buf) < 8 {
-			padding := [8]byte{}
-			switch endianness {
-			case binary.LittleEndian:
-				copy(padding[:(8-len(buf))], buf)
-			case binary.BigEndian:
-				return errors.New("big endian is not supported")
-			default:
-				return errors.New("unknown endianness")
+		if field.IsNumericArray() {
+			// Store each element of a fixed-size array as its own property, i.e. foo[0]..foo[n-1].
+			if uint64(len(buf)) < field.NumElements*field.ElementSize {
+				return fmt.Errorf("field %q should contain %d elements of %d bytes, but only has %d bytes",
+					field.Name, field.NumElements, field.ElementSize, len(buf))
 			}
-			buf = padding[:]
+			for i := uint64(0); i < field.NumElements; i++ {
+				elem := buf[i*field.ElementSize : (i+1)*field.ElementSize]
+				val, err := readNumber(elem, field.Signed, endianness)
+				if err != nil {
+					return err
+				}
+				t.NumberProperties[field.ElementName(i)] = val
+			}
+			return nil
+		}
+		val, err := readNumber(buf, field.Signed, endianness)
+		if err != nil {
+			return err
 		}
-		t.NumberProperties[field.Name] = int64(endianness.Uint64(buf))
+		t.NumberProperties[field.Name] = val
 	} else {
 		return fmt.Errorf("unknown field type %s. only string and int64 are supported", field.ProtoType)
 	}
 	return nil
 }
+
+// readNumber converts a buffer containing an integer in the provided byte order into an int64.
+// If signed is true, values narrower than 8 bytes are sign-extended.
+func readNumber(buf []byte, signed bool, endianness binary.ByteOrder) (int64, error) {
+	val, err := readUnsigned(buf, endianness)
+	if err != nil {
+		return 0, err
+	}
+	if signed && len(buf) < 8 {
+		// Shift the sign bit of the value into the sign bit of an int64, then shift it back.
+		shift := uint(64 - 8*len(buf))
+		return int64(val<<shift) >> shift, nil
+	}
+	return int64(val), nil
+}
+
+// readUnsigned converts a buffer containing an unsigned integer in the provided byte order into a
+// uint64. Buffers shorter than 8 bytes are zero-extended, and buffers longer than 8 bytes are
+// truncated to their first 8 bytes.
+func readUnsigned(buf []byte, endianness binary.ByteOrder) (uint64, error) {
+	if len(buf) >= 8 {
+		return endianness.Uint64(buf[:8]), nil
+	}
+	padding := [8]byte{}
+	switch endianness {
+	case binary.LittleEndian:
+		// The least significant bytes come first, so pad at the end.
+		copy(padding[:len(buf)], buf)
+	case binary.BigEndian:
+		// The most significant bytes come first, so pad at the start.
+		copy(padding[8-len(buf):], buf)
+	default:
+		return 0, errors.New("unknown endianness")
+	}
+	return endianness.Uint64(padding[:]), nil
+}
@@ -0,0 +1,163 @@
+//
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS-IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+package traceparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSaveFieldValue(t *testing.T) {
+	tests := []struct {
+		field      FormatField
+		buf        []byte
+		endianness binary.ByteOrder
+		want       map[string]int64
+	}{
+		{
+			field:      FormatField{Name: "prio", ProtoType: "int64", Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+			buf:        []byte{0xfe, 0xff, 0xff, 0xff},
+			endianness: binary.LittleEndian,
+			want:       map[string]int64{"prio": -2},
+		},
+		{
+			field:      FormatField{Name: "prio", ProtoType: "int64", Size: 4, NumElements: 1, ElementSize: 4, Signed: true},
+			buf:        []byte{0xff, 0xff, 0xff, 0xfe},
+			endianness: binary.BigEndian,
+			want:       map[string]int64{"prio": -2},
+		},
+		{
+			field:      FormatField{Name: "flags", ProtoType: "int64", Size: 4, NumElements: 1, ElementSize: 4},
+			buf:        []byte{0xfe, 0xff, 0xff, 0xff},
+			endianness: binary.LittleEndian,
+			want:       map[string]int64{"flags": 0xfffffffe},
+		},
+		{
+			field:      FormatField{Name: "ret", ProtoType: "int64", Size: 2, NumElements: 1, ElementSize: 2, Signed: true},
+			buf:        []byte{0xff, 0xf2},
+			endianness: binary.BigEndian,
+			want:       map[string]int64{"ret": -14},
+		},
+		{
+			field:      FormatField{Name: "delta", ProtoType: "int64", Size: 1, NumElements: 1, ElementSize: 1, Signed: true},
+			buf:        []byte{0x80},
+			endianness: binary.LittleEndian,
+			want:       map[string]int64{"delta": -128},
+		},
+		{
+			field:      FormatField{Name: "ts", ProtoType: "int64", Size: 8, NumElements: 1, ElementSize: 8, Signed: true},
+			buf:        []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			endianness: binary.LittleEndian,
+			want:       map[string]int64{"ts": -1},
+		},
+		{
+			field:      FormatField{Name: "foo", ProtoType: "int64", Size: 8, NumElements: 4, ElementSize: 2, Signed: true},
+			buf:        []byte{0x01, 0x00, 0xff, 0xff, 0x00, 0x01, 0x00, 0x80},
+			endianness: binary.LittleEndian,
+			want:       map[string]int64{"foo[0]": 1, "foo[1]": -1, "foo[2]": 256, "foo[3]": -32768},
+		},
+		{
+			field:      FormatField{Name: "foo", ProtoType: "int64", Size: 8, NumElements: 2, ElementSize: 4},
+			buf:        []byte{0x00, 0x00, 0x00, 0x01, 0xff, 0xff, 0xff, 0xff},
+			endianness: binary.BigEndian,
+			want:       map[string]int64{"foo[0]": 1, "foo[1]": 0xffffffff},
+		},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestSaveFieldValue Case: %d", i), func(t *testing.T) {
+			event := NewTraceEvent(0 /*=cpu*/)
+			if err := event.SaveFieldValue(&test.field, test.buf, test.endianness); err != nil {
+				t.Fatalf("error in SaveFieldValue: %s", err)
+			}
+			if diff := cmp.Diff(test.want, event.NumberProperties); diff != "" {
+				t.Fatalf("Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSaveFieldValue_ShortArray(t *testing.T) {
+	field := &FormatField{Name: "foo", ProtoType: "int64", Size: 16, NumElements: 4, ElementSize: 4}
+	event := NewTraceEvent(0 /*=cpu*/)
+	if err := event.SaveFieldValue(field, make([]byte, 8), binary.LittleEndian); err == nil {
+		t.Fatalf("expected an error when the buffer is smaller than the array")
+	}
+}
+
+func TestSaveFieldValue_DynamicArrays(t *testing.T) {
+	tests := []struct {
+		field           FormatField
+		buf             []byte
+		endianness      binary.ByteOrder
+		wantText        map[string]string
+		wantNumberLists map[string][]int64
+	}{
+		{
+			field:           FormatField{FieldType: "__data_loc char[] filename", Name: "filename", ProtoType: "string", Size: 4, NumElements: 1, ElementSize: 1, IsDynamicArray: true},
+			buf:             []byte("/bin/ls\x00"),
+			endianness:      binary.LittleEndian,
+			wantText:        map[string]string{"filename": "/bin/ls"},
+			wantNumberLists: map[string][]int64{},
+		},
+		{
+			field:           FormatField{FieldType: "__data_loc s16[] vals", Name: "vals", ProtoType: "string", Size: 4, NumElements: 1, ElementSize: 2, Signed: true, IsDynamicArray: true},
+			buf:             []byte{0x00, 0x01, 0xff, 0xfe},
+			endianness:      binary.BigEndian,
+			wantText:        map[string]string{},
+			wantNumberLists: map[string][]int64{"vals": {1, -2}},
+		},
+		{
+			field:           FormatField{FieldType: "__data_loc u32[] vals", Name: "vals", ProtoType: "string", Size: 4, NumElements: 1, ElementSize: 4, IsDynamicArray: true},
+			buf:             []byte{},
+			endianness:      binary.LittleEndian,
+			wantText:        map[string]string{},
+			wantNumberLists: map[string][]int64{"vals": {}},
+		},
+		{
+			field:           FormatField{FieldType: "__data_loc unknown_t[] blob", Name: "blob", ProtoType: "string", Size: 4, NumElements: 1, IsDynamicArray: true},
+			buf:             []byte{0x00, 0x01, 0x02},
+			endianness:      binary.LittleEndian,
+			wantText:        map[string]string{"blob": "\x00\x01\x02"},
+			wantNumberLists: map[string][]int64{},
+		},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestSaveFieldValue_DynamicArrays Case: %d", i), func(t *testing.T) {
+			event := NewTraceEvent(0 /*=cpu*/)
+			if err := event.SaveFieldValue(&test.field, test.buf, test.endianness); err != nil {
+				t.Fatalf("error in SaveFieldValue: %s", err)
+			}
+			if diff := cmp.Diff(test.wantText, event.TextProperties); diff != "" {
+				t.Fatalf("TextProperties: Diff -want +got:\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantNumberLists, event.NumberListProperties); diff != "" {
+				t.Fatalf("NumberListProperties: Diff -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSaveFieldValue_PartialDynamicArrayElement(t *testing.T) {
+	field := &FormatField{FieldType: "__data_loc u32[] vals", Name: "vals", ProtoType: "string", Size: 4, NumElements: 1, ElementSize: 4, IsDynamicArray: true}
+	event := NewTraceEvent(0 /*=cpu*/)
+	if err := event.SaveFieldValue(field, make([]byte, 6), binary.LittleEndian); err == nil {
+		t.Fatalf("expected an error when the array ends partway through an element")
+	}
+}
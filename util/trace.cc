@@ -2,13 +2,18 @@
 
 #include <errno.h>
 #include <fcntl.h>
+#include <linux/perf_event.h>
 #include <stdio.h>
 #include <stdlib.h>
+#include <sys/mman.h>
+#include <sys/syscall.h>
 #include <unistd.h>
 
+#include <cstdint>
 #include <filesystem>
 #include <fstream>
 #include <iostream>
+#include <iterator>
 #include <string>
 #include <utility>
 #include <vector>
@@ -34,6 +39,10 @@ ABSL_FLAG(std::vector<std::string>, events,
               "sched:sched_wakeup",
               "sched:sched_wakeup_new",
               "sched:sched_migrate_task",
+              "sched:sched_process_fork",
+              "sched:sched_process_exec",
+              "sched:sched_process_exit",
+              "sched:sched_process_free",
           }),
           "Comma separated list of FTrace events to collect. Defaults to the "
           "scheduling events.");
@@ -61,6 +70,13 @@ static constexpr const auto kUSAGE =
     "filesystem. Default '/sys/devices'"
     "\n";
 
+/**
+ * The events that trace_printk() records. Their formats are always copied so
+ * that their messages can be rendered.
+ */
+static constexpr const char* kPrintkEvents[] = {"ftrace:bprint",
+                                               "ftrace:bputs"};
+
 /**
  * Regex for matching a CPU name in a SysFS path.
  */
@@ -71,6 +87,56 @@ static constexpr const LazyRE2 kCPURegex = {"(cpu\\d+$)"};
  */
 static constexpr const LazyRE2 kNodeRegex = {"(node\\d+$)"};
 
+/**
+ * Regex for matching the selected clock in the trace_clock file.
+ */
+static constexpr const LazyRE2 kTraceClockRegex = {"\\[([^\\]]+)\\]"};
+
+/**
+ * Regex for matching a PID in a ProcFS path.
+ */
+static constexpr const LazyRE2 kPIDRegex = {"(\\d+)"};
+
+/**
+ * Reads the kernel's conversion of TSC cycles to ns, as published in the mmap
+ * page of a perf event, into mult and shift, so that
+ * ns = (cycles * mult) >> shift.  This is the conversion that trace-cmd's
+ * TSC2NSEC option uses, and, unlike tsc_freq_khz, it is available on stock
+ * kernels.  Returns false if the kernel doesn't publish it.
+ */
+static bool ReadPerfTimeConversion(uint32_t* mult, uint32_t* shift) {
+  struct perf_event_attr attr = {};
+  attr.size = sizeof(attr);
+  attr.type = PERF_TYPE_SOFTWARE;
+  attr.config = PERF_COUNT_SW_DUMMY;
+  attr.exclude_kernel = 1;
+  const int fd = syscall(SYS_perf_event_open, &attr, 0 /* pid */, -1 /* cpu */,
+                         -1 /* group_fd */, 0 /* flags */);
+  if (fd < 0) {
+    return false;
+  }
+  const long page_size = sysconf(_SC_PAGESIZE);
+  void* page = mmap(nullptr, page_size, PROT_READ, MAP_SHARED, fd, 0);
+  close(fd);
+  if (page == MAP_FAILED) {
+    return false;
+  }
+  const auto* pc = static_cast<volatile perf_event_mmap_page*>(page);
+  bool ok;
+  uint32_t seq;
+  // The kernel may update the page while it is read; retry if it did.
+  do {
+    seq = pc->lock;
+    __sync_synchronize();
+    ok = pc->cap_user_time;
+    *mult = pc->time_mult;
+    *shift = pc->time_shift;
+    __sync_synchronize();
+  } while (pc->lock != seq);
+  munmap(page, page_size);
+  return ok;
+}
+
 int main(int argc, char** argv) {
   absl::ParseCommandLine(argc, argv);
 
@@ -153,9 +219,7 @@ Status FTraceTracer::Trace(int capture_seconds) {
   }
 
   Status status;
-  // Write metadata file
-  status = WriteString(temp_path_ / "metadata.textproto",
-                       "trace_type: FTRACE\nrecorder: \"trace.cc\"\n");
+  status = WriteMetadata();
   if (!status.ok()) {
     return status;
   }
@@ -190,6 +254,21 @@ Status FTraceTracer::Trace(int capture_seconds) {
     return status;
   }
 
+  status = CopyPrintkFormats();
+  if (!status.ok()) {
+    return status;
+  }
+
+  status = CopyKallsyms();
+  if (!status.ok()) {
+    return status;
+  }
+
+  status = CopyThreadGroups();
+  if (!status.ok()) {
+    return status;
+  }
+
   status = CreateTar("trace.tar.gz");
   if (!status.ok()) {
     return status;
@@ -311,7 +390,10 @@ Status FTraceTracer::CopyFormats() {
   }
   const auto& out = temp_path_ / "formats";
   const std::filesystem::path& formats_root = kernel_trace_root_ / "events";
-  for (const auto& event_type : events_) {
+  std::vector<std::string> event_types = events_;
+  event_types.insert(event_types.end(), std::begin(kPrintkEvents),
+                     std::end(kPrintkEvents));
+  for (const auto& event_type : event_types) {
     std::filesystem::path event_format_path;
     const auto& event_type_parts = absl::StrSplit(event_type, ':');
     for (const auto& part : event_type_parts) {
@@ -339,6 +421,84 @@ Status FTraceTracer::CopyFormats() {
   return Status::OkStatus();
 }
 
+Status FTraceTracer::WriteMetadata() {
+  std::string metadata = "trace_type: FTRACE\nrecorder: \"trace.cc\"\n";
+  // Record the trace clock, along with the TSC frequency if it is needed to
+  // convert timestamps to ns.
+  std::ifstream clock_file(kernel_trace_root_ / "trace_clock");
+  std::string clocks((std::istreambuf_iterator<char>(clock_file)),
+                     std::istreambuf_iterator<char>());
+  std::string clock;
+  if (RE2::PartialMatch(clocks, *kTraceClockRegex, &clock)) {
+    absl::StrAppend(&metadata, "trace_clock { name: \"", clock, "\"");
+    if (clock == "x86-tsc") {
+      // Only some patched kernels export the TSC frequency, so fall back to
+      // the kernel's own conversion.
+      std::ifstream tsc_freq_file(kernel_devices_root_ / "system" / "cpu" /
+                                  "cpu0" / "tsc_freq_khz");
+      uint64_t tsc_freq_khz;
+      uint32_t mult, shift;
+      if (tsc_freq_file >> tsc_freq_khz) {
+        absl::StrAppend(&metadata, " frequency_khz: ", tsc_freq_khz);
+      } else if (ReadPerfTimeConversion(&mult, &shift)) {
+        absl::StrAppend(&metadata, " mult: ", mult, " shift: ", shift);
+      } else {
+        std::cerr << "Couldn't find the TSC frequency; add it to "
+                     "metadata.textproto to load the trace"
+                  << std::endl;
+      }
+    }
+    absl::StrAppend(&metadata, " }\n");
+  }
+  return WriteString(temp_path_ / "metadata.textproto", metadata);
+}
+
+Status FTraceTracer::CopyPrintkFormats() {
+  if (is_tracing_) {
+    return Status::InternalError("Still Tracing");
+  }
+  return CopyFakeFile(kernel_trace_root_ / "printk_formats",
+                      temp_path_ / "printk_formats");
+}
+
+Status FTraceTracer::CopyKallsyms() {
+  if (is_tracing_) {
+    return Status::InternalError("Still Tracing");
+  }
+  return CopyFakeFile("/proc/kallsyms", temp_path_ / "kallsyms");
+}
+
+Status FTraceTracer::CopyThreadGroups() {
+  if (is_tracing_) {
+    return Status::InternalError("Still Tracing");
+  }
+  std::string thread_groups;
+  std::error_code ec;
+  // Processes may exit while they're listed, so errors are skipped.
+  for (const auto& process_entry :
+       std::filesystem::directory_iterator("/proc", ec)) {
+    std::string tgid;
+    if (!RE2::FullMatch(process_entry.path().filename().string(), *kPIDRegex,
+                        &tgid)) {
+      continue;
+    }
+    std::error_code task_ec;
+    for (const auto& task_entry : std::filesystem::directory_iterator(
+             process_entry.path() / "task", task_ec)) {
+      std::string pid;
+      if (RE2::FullMatch(task_entry.path().filename().string(), *kPIDRegex,
+                         &pid)) {
+        absl::StrAppend(&thread_groups, tgid, " ", pid, "\n");
+      }
+    }
+  }
+  if (ec) {
+    return Status::InternalError(
+        absl::StrCat("Unable to list processes: ", ec.message()));
+  }
+  return WriteString(temp_path_ / "thread_groups", thread_groups);
+}
+
 Status FTraceTracer::CopySystemTopology() {
   if (is_tracing_) {
     return Status::InternalError("Already Tracing");
@@ -62,6 +62,13 @@ class FTraceTracer {
    */
   Status StopTrace(bool final_copy);
 
+  /**
+   * Writes the metadata file, recording the trace clock, to the temp
+   * directory.
+   * @return Status if successful or not.
+   */
+  Status WriteMetadata();
+
   /**
    * Copies the trace options to the temp directory.
    * @return Status if successful or not.
@@ -69,11 +76,36 @@ class FTraceTracer {
   Status CopyOptions();
 
   /**
-   * Copies the format files for the provided events to the temp directory.
+   * Copies the format files for the provided events, and for the events
+   * recorded by trace_printk(), to the temp directory.
    * @return Status if successful or not.
    */
   Status CopyFormats();
 
+  /**
+   * Copies the addresses and contents of the format strings used by
+   * trace_printk() to the temp directory. Must be called after tracing, as
+   * modules loaded while tracing may add format strings.
+   * @return Status if successful or not.
+   */
+  Status CopyPrintkFormats();
+
+  /**
+   * Copies the kernel's symbols to the temp directory, so that fields holding
+   * kernel addresses can be symbolized. Must be called after tracing, as
+   * modules loaded while tracing add symbols.
+   * @return Status if successful or not.
+   */
+  Status CopyKallsyms();
+
+  /**
+   * Copies the thread group, or process, of each thread alive on the system
+   * to the temp directory, so that threads can be aggregated by process. Must
+   * be called after tracing, as threads are created while tracing.
+   * @return Status if successful or not.
+   */
+  Status CopyThreadGroups();
+
   /**
    * Copies the system topology files for this machine to the temp directory.
    * @return Status if successful or not.
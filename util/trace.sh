@@ -23,7 +23,10 @@ USAGE="Usage: ${PROG} -out 'Path to directory to save trace in' \
 [-buffer_size 'Size of the trace buffer in KB. Default 4096'] \
 [-copy_timeout 'Time to wait for copying to finish. Default 5.']"
 
-declare -a events=("sched:sched_switch" "sched:sched_wakeup" "sched:sched_wakeup_new" "sched:sched_migrate_task")
+declare -a events=("sched:sched_switch" "sched:sched_wakeup" "sched:sched_wakeup_new" "sched:sched_migrate_task" "sched:sched_process_fork" "sched:sched_process_exec" "sched:sched_process_exit" "sched:sched_process_free")
+# The events recorded by trace_printk(). They can't be enabled through set_event,
+# but their formats are saved so that their messages can be rendered.
+declare -a printk_events=("ftrace:bprint" "ftrace:bputs")
 
 if [[ "$(whoami)" != "root" ]]; then
   echo "The trace collector must be run as root in order to access TraceFS"
@@ -117,11 +120,35 @@ mkdir "${TMP}/options"
 # Save the metadata file
 echo -e "trace_type: FTRACE\nrecorder: \"trace.sh\"" > "${TMP}/metadata.textproto"
 
+# Record the trace clock, along with the TSC frequency if it is needed to
+# convert timestamps to ns.
+trace_clock=$(grep -o '\[[^]]*\]' /sys/kernel/debug/tracing/trace_clock | tr -d '[]')
+if [[ -n "${trace_clock}" ]]; then
+  clock_config="trace_clock { name: \"${trace_clock}\""
+  if [[ "${trace_clock}" == "x86-tsc" ]]; then
+    tsc_freq_file=/sys/devices/system/cpu/cpu0/tsc_freq_khz
+    if [[ -f "${tsc_freq_file}" ]]; then
+      tsc_freq_khz=$(cat "${tsc_freq_file}")
+    else
+      # Only some patched kernels export the TSC frequency, but all log it at
+      # boot, in MHz to three decimal places.  The refined calibration, if any,
+      # is logged last.
+      tsc_freq_khz=$(dmesg | grep -oP 'tsc: (Refined TSC clocksource calibration: |Detected )\K[0-9]+\.[0-9]{3}(?= MHz)' | tail -n 1 | tr -d .)
+    fi
+    if [[ -n "${tsc_freq_khz}" ]]; then
+      clock_config+=" frequency_khz: ${tsc_freq_khz}"
+    else
+      echo "Couldn't find the TSC frequency; add it to metadata.textproto to load the trace"
+    fi
+  fi
+  echo "${clock_config} }" >> "${TMP}/metadata.textproto"
+fi
+
 # Copy the trace options
 cp /sys/kernel/debug/tracing/options/* "${TMP}/options/."
 
 # Save the event formats
-for event in "${events[@]}"
+for event in "${events[@]}" "${printk_events[@]}"
 do
   event_format_path=$(echo "${event}" | sed -r 's/:/\//')
   mkdir -p "${TMP}/formats/${event_format_path}"
@@ -135,6 +162,19 @@ rm -rf sys/
 
 cat "/sys/kernel/debug/tracing/events/header_page" > "${TMP}/formats/header_page"
 
+# Save the trace_printk() format strings
+cat "/sys/kernel/debug/tracing/printk_formats" > "${TMP}/printk_formats"
+
+# Save the kernel symbols, used to resolve fields holding kernel addresses
+cat /proc/kallsyms > "${TMP}/kallsyms"
+
+# Save the thread group, or process, of each thread, as '<tgid> <pid>' lines
+for task in /proc/[0-9]*/task/[0-9]*
+do
+  t="${task#/proc/}"
+  echo "${t%%/*} ${t##*/}"
+done > "${TMP}/thread_groups" 2> /dev/null
+
 for cf in /sys/kernel/debug/tracing/per_cpu/cpu*
 do
   cpuname="${cf##*/}"